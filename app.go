@@ -1,10 +1,25 @@
 package littlejohn
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/iliyaisd/littlejohn/internal/analytics"
 	"github.com/iliyaisd/littlejohn/internal/api"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/backtest"
+	"github.com/iliyaisd/littlejohn/internal/cash"
+	"github.com/iliyaisd/littlejohn/internal/clock"
 	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/internal/idempotency"
+	"github.com/iliyaisd/littlejohn/internal/jobs"
+	"github.com/iliyaisd/littlejohn/internal/margin"
+	"github.com/iliyaisd/littlejohn/internal/plans"
+	"github.com/iliyaisd/littlejohn/internal/ratelimit"
+	"github.com/iliyaisd/littlejohn/internal/rebalance"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/internal/trading"
 )
 
 // Data source constants are used as just a demo of how actual different data source can be used in real project,
@@ -14,26 +29,203 @@ const (
 	DataSourceYahoo = "yahoo"
 )
 
+// Rate limit groups: every route belongs to exactly one of them, and each group has its own limit.
+const (
+	RateLimitGroupPublic     = "public"
+	RateLimitGroupRestricted = "restricted"
+	//RateLimitGroupAnalytics contains the routes generating price series, which are the most expensive ones.
+	RateLimitGroupAnalytics = "analytics"
+	//RateLimitGroupFailedLogins is not a group of routes: it limits the failed authentications of the restricted
+	//ones, both per client IP and per username, much more strictly than the requests themselves.
+	RateLimitGroupFailedLogins = "failed_logins"
+)
+
+// DefaultPlansRunInterval is how often the investment plans are checked for the runs which are due.
+// Schedules have the resolution of a minute, so there's no point in checking more often.
+const DefaultPlansRunInterval = time.Minute
+
+// OrdersRunInterval is how often the open orders are checked against the market, so that they're filled
+// in time for the other jobs, which read the ledger. It's the step of the simulated intraday prices.
+const OrdersRunInterval = time.Minute
+
+// InterestRunInterval is how often the interest of the months which are over is paid. The payments are made once
+// per month, so the job only has to run soon enough after the month ends.
+const InterestRunInterval = time.Hour
+
+// MarginRunInterval is how often the borrow fees of the days which are over are charged, and the margin
+// of the accounts is checked for margin calls.
+const MarginRunInterval = time.Hour
+
+// BacktestParallelism is how many backtests run at once. The others wait in the queue.
+const BacktestParallelism = 2
+
+// DefaultIdempotencyKeyTTL is how long the responses to the requests with an idempotency key are replayed.
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
 type Config struct {
 	Port       int
 	DataSource string
+	//RateLimits are keyed by the rate limit group. A group without a limit is not limited.
+	RateLimits map[string]ratelimit.Limit
+	//AuditLogPath is the file the audit trail is appended to. When empty, the trail is kept in memory.
+	AuditLogPath string
+	//Simulator configures the paper trading execution: slippage, commissions and liquidity.
+	Simulator trading.SimulatorConfig
+	//RiskLimits are enforced on every order before it's accepted.
+	RiskLimits risk.Limits
+	//IdempotencyKeyTTL is how long the idempotency keys are kept since their first use.
+	IdempotencyKeyTTL time.Duration
+	//PlansRunInterval is how often the job runner places the orders of the investment plans which are due.
+	PlansRunInterval time.Duration
+	//Cash configures the cash accounts: the currencies they can be held in, and the interest paid on them.
+	Cash cash.Config
+	//Analytics configures the portfolio analytics: the benchmark and the risk-free rate they're measured against.
+	Analytics analytics.Config
+}
+
+func DefaultRateLimits() map[string]ratelimit.Limit {
+	return map[string]ratelimit.Limit{
+		RateLimitGroupPublic:     {Requests: 10, Period: time.Minute},
+		RateLimitGroupRestricted: {Requests: 120, Period: time.Minute, Burst: 30},
+		RateLimitGroupAnalytics:  {Requests: 30, Period: time.Minute, Burst: 10},
+		//5 a minute are 7200 guesses a day per IP and per username, so a one-time code, which is only checked
+		//after the password, is still unlikely to be guessed
+		RateLimitGroupFailedLogins: {Requests: 5, Period: time.Minute},
+	}
 }
 
 type App struct {
 	MainHandler http.Handler
+	//Jobs runs the background jobs, and has to be started together with serving the handler.
+	Jobs *jobs.Runner
 }
 
-func BuildApp() (App, error) {
+func BuildApp(config Config) (App, error) {
 	dataSource := datasource.NewLocalDatasource()
 
-	authorizer := api.NewAPIKeyAuthorizer(dataSource)
+	tokenStorage := datasource.NewLocalTokenStorage()
+	orderStorage := datasource.NewLocalOrderStorage()
+	planStorage := datasource.NewLocalPlanStorage()
+	marginStorage := datasource.NewLocalMarginStorage()
+	targetStorage := datasource.NewLocalTargetStorage()
+
+	auditLogger, err := buildAuditLogger(config.AuditLogPath)
+	if err != nil {
+		return App{}, err
+	}
+
+	authorizer := api.NewAPIKeyAuthorizer(dataSource, tokenStorage)
+
+	simulator, err := trading.NewSimulator(dataSource, config.Simulator)
+	if err != nil {
+		return App{}, fmt.Errorf("cannot create execution simulator: %w", err)
+	}
+	riskChecker := risk.NewChecker(dataSource, orderStorage, dataSource, config.RiskLimits,
+		config.Simulator)
+	orderService := trading.NewOrderService(orderStorage, dataSource, simulator, riskChecker, dataSource,
+		clock.Real())
+	planService := plans.NewService(planStorage, dataSource, dataSource, orderService, clock.Real())
+	cashService, err := cash.NewService(dataSource, dataSource, riskChecker, dataSource, dataSource, clock.Real(),
+		config.Cash)
+	if err != nil {
+		return App{}, fmt.Errorf("cannot create cash service: %w", err)
+	}
+	analyticsService, err := analytics.NewService(dataSource, dataSource, dataSource, clock.Real(), config.Analytics)
+	if err != nil {
+		return App{}, fmt.Errorf("cannot create analytics service: %w", err)
+	}
+	rebalanceService := rebalance.NewService(targetStorage, dataSource, riskChecker, dataSource, dataSource,
+		orderService, clock.Real(), config.Simulator)
+	backtestRunner, err := backtest.NewRunner(dataSource, clock.Real(), BacktestParallelism, config.Simulator)
+	if err != nil {
+		return App{}, fmt.Errorf("cannot create backtest runner: %w", err)
+	}
+	marginService := margin.NewService(dataSource, dataSource, riskChecker, dataSource, dataSource, marginStorage,
+		marginStorage, clock.Real())
 
-	portfolioController := api.NewPortfolioController(dataSource)
-	router := NewRouter(Controllers{
-		portfolioController: portfolioController,
-	}, authorizer)
+	jobRunner := jobs.NewRunner(clock.Real())
+	err = jobRunner.Register(jobs.Job{Name: "orders", Interval: OrdersRunInterval, Run: orderService.EvaluateOpenOrders})
+	if err != nil {
+		return App{}, fmt.Errorf("cannot register orders job: %w", err)
+	}
+	err = jobRunner.Register(jobs.Job{Name: "plans", Interval: config.PlansRunInterval, Run: planService.RunDuePlans})
+	if err != nil {
+		return App{}, fmt.Errorf("cannot register plans job: %w", err)
+	}
+	err = jobRunner.Register(jobs.Job{Name: "interest", Interval: InterestRunInterval, Run: cashService.PayInterest})
+	if err != nil {
+		return App{}, fmt.Errorf("cannot register interest job: %w", err)
+	}
+	err = jobRunner.Register(jobs.Job{Name: "margin", Interval: MarginRunInterval, Run: marginService.Run})
+	if err != nil {
+		return App{}, fmt.Errorf("cannot register margin job: %w", err)
+	}
+
+	portfolioController := api.NewPortfolioController(dataSource, auditLogger)
+	userController := api.NewUserController(dataSource, auditLogger)
+	twoFactorController := api.NewTwoFactorController(dataSource, auditLogger)
+	authController := api.NewAuthController(tokenStorage, auditLogger)
+	auditController := api.NewAuditController(auditLogger)
+	orderController := api.NewOrderController(orderService, auditLogger)
+	accountController := api.NewAccountController(riskChecker)
+	planController := api.NewPlanController(planService, auditLogger)
+	instrumentController := api.NewInstrumentController(dataSource)
+	cashController := api.NewCashController(cashService, auditLogger)
+	optionController := api.NewOptionController(dataSource)
+	marginController := api.NewMarginController(marginService, marginStorage)
+	analyticsController := api.NewAnalyticsController(analyticsService, auditLogger)
+	rebalanceController := api.NewRebalanceController(rebalanceService, auditLogger)
+	backtestController := api.NewBacktestController(backtestRunner, auditLogger)
+	controllers := Controllers{
+		portfolioController:  portfolioController,
+		userController:       userController,
+		twoFactorController:  twoFactorController,
+		authController:       authController,
+		auditController:      auditController,
+		orderController:      orderController,
+		accountController:    accountController,
+		planController:       planController,
+		instrumentController: instrumentController,
+		cashController:       cashController,
+		optionController:     optionController,
+		marginController:     marginController,
+		analyticsController:  analyticsController,
+		rebalanceController:  rebalanceController,
+		backtestController:   backtestController,
+	}
+
+	rateLimiters := make(map[string]*ratelimit.Limiter, len(config.RateLimits))
+	for group, limit := range config.RateLimits {
+		limiter, err := ratelimit.NewLimiter(limit)
+		if err != nil {
+			return App{}, fmt.Errorf("cannot create rate limiter for group [%s]: %w", group, err)
+		}
+		rateLimiters[group] = limiter
+	}
+	idempotencyStore, err := idempotency.NewStore(config.IdempotencyKeyTTL)
+	if err != nil {
+		return App{}, fmt.Errorf("cannot create idempotency key store: %w", err)
+	}
+	router := NewRouter(controllers, authorizer, rateLimiters, idempotencyStore, auditLogger)
 
 	return App{
 		MainHandler: router.PrepareHandler(),
+		Jobs:        jobRunner,
 	}, nil
 }
+
+func buildAuditLogger(path string) (*audit.Logger, error) {
+	var sink audit.Sink = audit.NewMemorySink()
+	if len(path) > 0 {
+		fileSink, err := audit.NewFileSink(path)
+		if err != nil {
+			return nil, err
+		}
+		sink = fileSink
+	}
+	logger, err := audit.NewLogger(sink)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create audit logger: %w", err)
+	}
+	return logger, nil
+}
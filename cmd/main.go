@@ -6,23 +6,40 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/iliyaisd/littlejohn"
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/internal/cash"
+	"github.com/iliyaisd/littlejohn/internal/ratelimit"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/shopspring/decimal"
 )
 
+// rateLimitEnvVars map rate limit groups to env vars overriding their default limits, in form requests/period[/burst].
+var rateLimitEnvVars = map[string]string{
+	littlejohn.RateLimitGroupPublic:       "RATE_LIMIT_PUBLIC",
+	littlejohn.RateLimitGroupRestricted:   "RATE_LIMIT_RESTRICTED",
+	littlejohn.RateLimitGroupAnalytics:    "RATE_LIMIT_ANALYTICS",
+	littlejohn.RateLimitGroupFailedLogins: "RATE_LIMIT_FAILED_LOGINS",
+}
+
 func main() {
 	config, err := prepareConfig()
 	if err != nil {
 		log.Fatalf("Cannot prepare configuration: %s", err)
 	}
 
-	app, err := littlejohn.BuildApp()
+	app, err := littlejohn.BuildApp(config)
 	if err != nil {
 		log.Fatalf("Cannot initialize Portfolio API: %s", err)
 	}
 
 	log.Printf("Portfolio API initialized\n")
 
+	app.Jobs.Start()
+
 	err = http.ListenAndServe(fmt.Sprintf(":%d", config.Port), app.MainHandler)
 	if err != nil {
 		log.Fatalf("Cannot listen and serve: %s\n", err.Error())
@@ -43,5 +60,65 @@ func prepareConfig() (littlejohn.Config, error) {
 		config.DataSource = littlejohn.DataSourceLocal
 	}
 
+	config.AuditLogPath = os.Getenv("AUDIT_LOG_PATH")
+
+	config.RateLimits = littlejohn.DefaultRateLimits()
+	for group, envVar := range rateLimitEnvVars {
+		value := os.Getenv(envVar)
+		if len(value) == 0 {
+			continue
+		}
+		config.RateLimits[group], err = ratelimit.ParseLimit(value)
+		if err != nil {
+			return littlejohn.Config{}, fmt.Errorf("cannot parse %s: %w", envVar, err)
+		}
+	}
+
+	config.IdempotencyKeyTTL = littlejohn.DefaultIdempotencyKeyTTL
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); len(value) > 0 {
+		config.IdempotencyKeyTTL, err = time.ParseDuration(value)
+		if err != nil {
+			return littlejohn.Config{}, fmt.Errorf("cannot parse IDEMPOTENCY_KEY_TTL: %w", err)
+		}
+	}
+
+	config.PlansRunInterval = littlejohn.DefaultPlansRunInterval
+	if value := os.Getenv("PLANS_RUN_INTERVAL"); len(value) > 0 {
+		config.PlansRunInterval, err = time.ParseDuration(value)
+		if err != nil {
+			return littlejohn.Config{}, fmt.Errorf("cannot parse PLANS_RUN_INTERVAL: %w", err)
+		}
+	}
+
+	config.Cash = cash.DefaultConfig()
+	if value := os.Getenv("CASH_INTEREST_RATES"); len(value) > 0 {
+		config.Cash.InterestRates, err = cash.ParseInterestRates(value)
+		if err != nil {
+			return littlejohn.Config{}, fmt.Errorf("cannot parse CASH_INTEREST_RATES: %w", err)
+		}
+	}
+
+	config.Analytics = analytics.DefaultConfig()
+	if value := os.Getenv("RISK_BENCHMARK"); len(value) > 0 {
+		config.Analytics.Benchmark = value
+	}
+	if value := os.Getenv("RISK_FREE_RATE"); len(value) > 0 {
+		config.Analytics.RiskFreeRate, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return littlejohn.Config{}, fmt.Errorf("cannot parse RISK_FREE_RATE: %w", err)
+		}
+	}
+
+	config.RiskLimits = risk.DefaultLimits()
+	config.RiskLimits.AllowShortSelling = os.Getenv("ALLOW_SHORT_SELLING") == "true"
+
+	config.Simulator = trading.DefaultSimulatorConfig()
+	if value := os.Getenv("SIMULATOR_SLIPPAGE_BPS"); len(value) > 0 {
+		config.Simulator.SlippageBps, err = decimal.NewFromString(value)
+		if err != nil {
+			return littlejohn.Config{}, fmt.Errorf("cannot parse SIMULATOR_SLIPPAGE_BPS: %w", err)
+		}
+	}
+
 	return config, nil
 }
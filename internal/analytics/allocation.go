@@ -0,0 +1,216 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// AllocationDimension is the attribute of the instruments' reference data the holdings are grouped by.
+type AllocationDimension string
+
+const (
+	AllocationBySector     AllocationDimension = "sector"
+	AllocationByIndustry   AllocationDimension = "industry"
+	AllocationByCountry    AllocationDimension = "country"
+	AllocationByCurrency   AllocationDimension = "currency"
+	AllocationByAssetClass AllocationDimension = "asset_class"
+)
+
+const (
+	// DefaultAllocationTop is the number of the largest groups whose weight is reported by default.
+	DefaultAllocationTop = 3
+	// unclassified is the group of the holdings whose reference data doesn't have the attribute.
+	unclassified = "unclassified"
+)
+
+// AllocationRequest is the attribute to group the holdings by, sector by default, and the number of the largest
+// groups whose total weight is reported as the concentration.
+type AllocationRequest struct {
+	By  AllocationDimension
+	Top int
+}
+
+// AllocationGroup is the holdings which have the same value of the attribute, with their value and weight
+// in the portfolio.
+type AllocationGroup struct {
+	Name    string
+	Value   decimal.Decimal
+	Weight  decimal.Decimal
+	Tickers []string
+}
+
+func (g AllocationGroup) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name    string   `json:"name"`
+		Value   string   `json:"value"`
+		Weight  string   `json:"weight"`
+		Tickers []string `json:"tickers"`
+	}{
+		Name:    g.Name,
+		Value:   g.Value.StringFixed(ljlib.CashPlaces),
+		Weight:  g.Weight.String(),
+		Tickers: g.Tickers,
+	})
+}
+
+// Concentration measures how much of the portfolio is in few of the groups: the total weight of the Top largest
+// ones, and the Herfindahl index, which is the sum of the squared weights of all of them, from 1/N for N groups
+// of the same weight to 1 for a single one.
+type Concentration struct {
+	Top        int
+	TopWeight  decimal.Decimal
+	Herfindahl decimal.Decimal
+}
+
+func (c Concentration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Top        int    `json:"top"`
+		TopWeight  string `json:"top_weight"`
+		Herfindahl string `json:"herfindahl"`
+	}{
+		Top:        c.Top,
+		TopWeight:  c.TopWeight.String(),
+		Herfindahl: c.Herfindahl.String(),
+	})
+}
+
+// Allocation is the breakdown of the holdings at the end of the day, the largest groups first. The short positions
+// count by their absolute value, so the value and the weights are of the gross exposure.
+type Allocation struct {
+	AsOf          time.Time
+	By            AllocationDimension
+	Value         decimal.Decimal
+	Groups        []AllocationGroup
+	Concentration Concentration
+}
+
+func (a Allocation) MarshalJSON() ([]byte, error) {
+	groups := a.Groups
+	if groups == nil {
+		groups = []AllocationGroup{}
+	}
+	return json.Marshal(struct {
+		AsOf          string              `json:"as_of"`
+		By            AllocationDimension `json:"by"`
+		Value         string              `json:"value"`
+		Groups        []AllocationGroup   `json:"groups"`
+		Concentration Concentration       `json:"concentration"`
+	}{
+		AsOf:          a.AsOf.Format(time.DateOnly),
+		By:            a.By,
+		Value:         a.Value.StringFixed(ljlib.CashPlaces),
+		Groups:        groups,
+		Concentration: a.Concentration,
+	})
+}
+
+// GetAllocation groups the holdings of the user's portfolio today by the attribute of their reference data.
+func (s *Service) GetAllocation(userID uuid.UUID, request AllocationRequest) (Allocation, error) {
+	request, err := normalizeAllocationRequest(request)
+	if err != nil {
+		return Allocation{}, err
+	}
+	today := startOfDay(s.clock.Now())
+	transactions, err := s.getTransactions(userID)
+	if err != nil {
+		return Allocation{}, err
+	}
+	valuations, err := s.getDailyValuations(transactions, today, today)
+	if err != nil {
+		return Allocation{}, err
+	}
+
+	allocation := Allocation{AsOf: today, By: request.By, Concentration: Concentration{Top: request.Top}}
+	groups := make(map[string]*AllocationGroup)
+	for ticker, holding := range valuations[len(valuations)-1].holdings {
+		if holding.quantity.IsZero() {
+			continue
+		}
+		instrument, err := s.instruments.GetInstrument(ticker)
+		if err != nil {
+			return Allocation{}, fmt.Errorf("cannot get instrument [%s]: %w", ticker, err)
+		}
+		name := allocationGroupName(instrument, request.By)
+		group, ok := groups[name]
+		if !ok {
+			group = &AllocationGroup{Name: name}
+			groups[name] = group
+		}
+		group.Value = group.Value.Add(holding.value.Abs())
+		group.Tickers = append(group.Tickers, ticker)
+		allocation.Value = allocation.Value.Add(holding.value.Abs())
+	}
+
+	for _, group := range groups {
+		sort.Strings(group.Tickers)
+		if allocation.Value.IsPositive() {
+			group.Weight = group.Value.Div(allocation.Value).Round(ReturnPlaces)
+		}
+		allocation.Groups = append(allocation.Groups, *group)
+	}
+	sort.Slice(allocation.Groups, func(i, j int) bool {
+		if !allocation.Groups[i].Value.Equal(allocation.Groups[j].Value) {
+			return allocation.Groups[i].Value.GreaterThan(allocation.Groups[j].Value)
+		}
+		return allocation.Groups[i].Name < allocation.Groups[j].Name
+	})
+
+	if !allocation.Value.IsPositive() {
+		return allocation, nil
+	}
+	var top, herfindahl decimal.Decimal
+	for i, group := range allocation.Groups {
+		weight := group.Value.Div(allocation.Value)
+		herfindahl = herfindahl.Add(weight.Mul(weight))
+		if i < request.Top {
+			top = top.Add(group.Value)
+		}
+	}
+	allocation.Concentration.TopWeight = top.Div(allocation.Value).Round(ReturnPlaces)
+	allocation.Concentration.Herfindahl = herfindahl.Round(ReturnPlaces)
+	return allocation, nil
+}
+
+func normalizeAllocationRequest(request AllocationRequest) (AllocationRequest, error) {
+	switch request.By {
+	case "":
+		request.By = AllocationBySector
+	case AllocationBySector, AllocationByIndustry, AllocationByCountry, AllocationByCurrency, AllocationByAssetClass:
+	default:
+		return AllocationRequest{}, ljlib.NewIllegalArgumentError("by must be one of %s, %s, %s, %s and %s",
+			AllocationBySector, AllocationByIndustry, AllocationByCountry, AllocationByCurrency, AllocationByAssetClass)
+	}
+	if request.Top == 0 {
+		request.Top = DefaultAllocationTop
+	}
+	if request.Top < 0 {
+		return AllocationRequest{}, ljlib.NewIllegalArgumentError("top must be positive")
+	}
+	return request, nil
+}
+
+func allocationGroupName(instrument ljlib.Instrument, by AllocationDimension) string {
+	var name string
+	switch by {
+	case AllocationBySector:
+		name = instrument.Sector
+	case AllocationByIndustry:
+		name = instrument.Industry
+	case AllocationByCountry:
+		name = instrument.Country
+	case AllocationByCurrency:
+		name = instrument.Currency
+	case AllocationByAssetClass:
+		name = instrument.AssetClass
+	}
+	if len(name) == 0 {
+		return unclassified
+	}
+	return name
+}
@@ -0,0 +1,89 @@
+package analytics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_GetAllocation(t *testing.T) {
+	at := testStart.Add(10 * time.Hour)
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 10000, at),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, at),
+		trade(ljlib.TransactionTypeBuy, "AMZN", 2, 100, at),
+		trade(ljlib.TransactionTypeBuy, "MSFT", 3, 100, at),
+		//the short position counts by its absolute value
+		trade(ljlib.TransactionTypeSell, "TSLA", 1, 100, at),
+		//the closed position is not held
+		trade(ljlib.TransactionTypeBuy, "NFLX", 1, 100, at),
+		trade(ljlib.TransactionTypeSell, "NFLX", 1, 100, at.Add(time.Hour)),
+	}
+	testCases := map[string]struct {
+		request            analytics.AllocationRequest
+		expectedGroups     []analytics.AllocationGroup
+		expectedTopWeight  string
+		expectedHerfindahl string
+		expectedError      error
+	}{
+		"it should group the holdings by sector by default": {
+			//the mock sector is the first letter of the ticker, and every price is 110 today
+			expectedGroups: []analytics.AllocationGroup{
+				{Name: "A", Tickers: []string{"AAPL", "AMZN"}},
+				{Name: "M", Tickers: []string{"MSFT"}},
+				{Name: "T", Tickers: []string{"TSLA"}},
+			},
+			//770, 330 and 110 of 1210
+			expectedTopWeight:  "1",
+			expectedHerfindahl: "0.487603",
+		},
+		"it should report the weight of the top groups": {
+			request: analytics.AllocationRequest{By: analytics.AllocationBySector, Top: 2},
+			expectedGroups: []analytics.AllocationGroup{
+				{Name: "A", Tickers: []string{"AAPL", "AMZN"}},
+				{Name: "M", Tickers: []string{"MSFT"}},
+				{Name: "T", Tickers: []string{"TSLA"}},
+			},
+			expectedTopWeight:  "0.909091",
+			expectedHerfindahl: "0.487603",
+		},
+		"it should group the holdings by country": {
+			request: analytics.AllocationRequest{By: analytics.AllocationByCountry},
+			expectedGroups: []analytics.AllocationGroup{
+				{Name: "US", Tickers: []string{"AAPL", "AMZN", "MSFT", "TSLA"}},
+			},
+			expectedTopWeight:  "1",
+			expectedHerfindahl: "1",
+		},
+		"it should return IllegalArgumentError for an unknown attribute": {
+			request:       analytics.AllocationRequest{By: "color"},
+			expectedError: ljlib.IllegalArgumentError{},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			service := newTestService(t, ledger, &mockPriceSource{}, testStart.AddDate(0, 0, 10).Add(time.Hour), 4)
+
+			allocation, err := service.GetAllocation(testUserID, testCase.request)
+			if testCase.expectedError != nil {
+				assert.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, testStart.AddDate(0, 0, 10), allocation.AsOf)
+			assert.Equal(t, "1210", allocation.Value.String())
+			require.Len(t, allocation.Groups, len(testCase.expectedGroups))
+			for i, group := range allocation.Groups {
+				assert.Equal(t, testCase.expectedGroups[i].Name, group.Name)
+				assert.Equal(t, testCase.expectedGroups[i].Tickers, group.Tickers)
+			}
+			assert.Equal(t, testCase.expectedTopWeight, allocation.Concentration.TopWeight.String())
+			assert.Equal(t, testCase.expectedHerfindahl, allocation.Concentration.Herfindahl.String())
+		})
+	}
+}
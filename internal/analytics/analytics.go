@@ -0,0 +1,132 @@
+// Package analytics computes the analytics of the users' portfolios, replaying their ledgers against
+// the price history.
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type Ledger interface {
+	GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error)
+}
+
+type PriceHistorySource interface {
+	GetHistoricalPrices(ticker string, dateFrom time.Time, dateTo time.Time) ([]ljlib.HistoricalPrice, error)
+}
+
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+}
+
+// Config configures the analytics.
+type Config struct {
+	//Parallelism is the number of tickers whose price history is loaded at the same time.
+	Parallelism int
+	//RiskFreeRate is the annual rate the excess returns are measured against, e.g. in the Sharpe ratio.
+	RiskFreeRate float64
+	//Benchmark is the ticker the portfolio and the tickers are measured against by default, e.g. for the beta.
+	Benchmark string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Parallelism:  4,
+		RiskFreeRate: 0.04,
+		Benchmark:    "SPY",
+	}
+}
+
+type Service struct {
+	ledger      Ledger
+	prices      PriceHistorySource
+	instruments InstrumentSource
+	clock       clock.Clock
+	config      Config
+}
+
+func NewService(ledger Ledger, prices PriceHistorySource, instruments InstrumentSource, clock clock.Clock,
+	config Config) (*Service, error) {
+	if config.Parallelism < 1 {
+		return nil, fmt.Errorf("parallelism must be positive, got %d", config.Parallelism)
+	}
+	if len(config.Benchmark) == 0 {
+		return nil, fmt.Errorf("benchmark must be set")
+	}
+	return &Service{
+		ledger:      ledger,
+		prices:      prices,
+		instruments: instruments,
+		clock:       clock,
+		config:      config,
+	}, nil
+}
+
+// getTransactions returns the user's ledger in the order of execution.
+func (s *Service) getTransactions(userID uuid.UUID) ([]ljlib.Transaction, error) {
+	transactions, err := s.ledger.GetTransactions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get transactions: %w", err)
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].ExecutedAt.Before(transactions[j].ExecutedAt)
+	})
+	return transactions, nil
+}
+
+// priceHistory is the daily prices of a ticker, keyed by the date as YYYY-MM-DD.
+type priceHistory map[string]decimal.Decimal
+
+// getPriceHistories loads the daily prices of the tickers from the source, by at most config.Parallelism tickers
+// at the same time.
+func (s *Service) getPriceHistories(tickers []string, from time.Time, to time.Time) (map[string]priceHistory, error) {
+	type result struct {
+		ticker string
+		prices []ljlib.HistoricalPrice
+		err    error
+	}
+	queue := make(chan string)
+	results := make(chan result, len(tickers))
+	var workers sync.WaitGroup
+	for i := 0; i < s.config.Parallelism && i < len(tickers); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for ticker := range queue {
+				prices, err := s.prices.GetHistoricalPrices(ticker, from, to)
+				results <- result{ticker: ticker, prices: prices, err: err}
+			}
+		}()
+	}
+	for _, ticker := range tickers {
+		queue <- ticker
+	}
+	close(queue)
+	workers.Wait()
+	close(results)
+
+	histories := make(map[string]priceHistory, len(tickers))
+	for result := range results {
+		if result.err != nil {
+			return nil, fmt.Errorf("cannot get historical prices for ticker [%s]: %w", result.ticker, result.err)
+		}
+		history := make(priceHistory, len(result.prices))
+		for _, price := range result.prices {
+			history[price.Date.Format(time.DateOnly)] = price.Price
+		}
+		histories[result.ticker] = history
+	}
+	return histories, nil
+}
+
+func startOfDay(at time.Time) time.Time {
+	year, month, day := at.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
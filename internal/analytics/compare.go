@@ -0,0 +1,202 @@
+package analytics
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// rebasedBase is the value both series of a comparison start at.
+	rebasedBase = 100
+	// rebasedPlaces is the precision the rebased values are rounded to.
+	rebasedPlaces = 4
+)
+
+// TrackingError is the annualized standard deviation of the active returns: the differences between the returns
+// and the returns of the benchmark, over the dates both have a return for.
+func TrackingError(returns []DailyReturn, benchmark []DailyReturn) (float64, bool) {
+	deviation, ok := standardDeviation(activeReturns(returns, benchmark))
+	if !ok {
+		return 0, false
+	}
+	return deviation * math.Sqrt(PeriodsPerYear), true
+}
+
+// InformationRatio is the annualized mean of the active returns per unit of the tracking error.
+func InformationRatio(returns []DailyReturn, benchmark []DailyReturn) (float64, bool) {
+	trackingError, ok := TrackingError(returns, benchmark)
+	if !ok || trackingError == 0 {
+		return 0, false
+	}
+	return mean(activeReturns(returns, benchmark)) * PeriodsPerYear / trackingError, true
+}
+
+func activeReturns(returns []DailyReturn, benchmark []DailyReturn) []DailyReturn {
+	aligned, alignedBenchmark := alignReturns(returns, benchmark)
+	active := make([]DailyReturn, 0, len(aligned))
+	for i := range aligned {
+		active = append(active, DailyReturn{Date: aligned[i].Date, Value: aligned[i].Value - alignedBenchmark[i].Value})
+	}
+	return active
+}
+
+// ComparisonPoint is the value of the ticker, or of the portfolio, and of the benchmark at the end of the day,
+// both rebased to 100 on the first day of the comparison.
+type ComparisonPoint struct {
+	Date      time.Time
+	Value     decimal.Decimal
+	Benchmark decimal.Decimal
+}
+
+func (p ComparisonPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Date      string `json:"date"`
+		Value     string `json:"value"`
+		Benchmark string `json:"benchmark"`
+	}{
+		Date:      p.Date.Format(time.DateOnly),
+		Value:     p.Value.Round(rebasedPlaces).String(),
+		Benchmark: p.Benchmark.Round(rebasedPlaces).String(),
+	})
+}
+
+// Comparison is the performance of a ticker, or of the portfolio, against the benchmark over the range.
+// The comparison starts with the first day both have a price, or the portfolio has anything invested,
+// and the returns and the ratios are not set when there are no such days.
+type Comparison struct {
+	Ticker           string
+	From             time.Time
+	To               time.Time
+	Benchmark        string
+	Points           []ComparisonPoint
+	Return           decimal.NullDecimal
+	BenchmarkReturn  decimal.NullDecimal
+	ExcessReturn     decimal.NullDecimal
+	TrackingError    decimal.NullDecimal
+	InformationRatio decimal.NullDecimal
+}
+
+func (c Comparison) MarshalJSON() ([]byte, error) {
+	points := c.Points
+	if points == nil {
+		points = []ComparisonPoint{}
+	}
+	return json.Marshal(struct {
+		Ticker           string            `json:"ticker,omitempty"`
+		From             string            `json:"from"`
+		To               string            `json:"to"`
+		Benchmark        string            `json:"benchmark"`
+		Return           *string           `json:"return"`
+		BenchmarkReturn  *string           `json:"benchmark_return"`
+		ExcessReturn     *string           `json:"excess_return"`
+		TrackingError    *string           `json:"tracking_error"`
+		InformationRatio *string           `json:"information_ratio"`
+		Points           []ComparisonPoint `json:"points"`
+	}{
+		Ticker:           c.Ticker,
+		From:             c.From.Format(time.DateOnly),
+		To:               c.To.Format(time.DateOnly),
+		Benchmark:        c.Benchmark,
+		Return:           optionalReturn(c.Return),
+		BenchmarkReturn:  optionalReturn(c.BenchmarkReturn),
+		ExcessReturn:     optionalReturn(c.ExcessReturn),
+		TrackingError:    optionalReturn(c.TrackingError),
+		InformationRatio: optionalReturn(c.InformationRatio),
+		Points:           points,
+	})
+}
+
+// Compare rebases the prices and the prices of the benchmark, in any order, to 100 on the first date both have
+// a positive price, and computes the returns and the active risk over the dates both have a price for.
+func Compare(prices []ljlib.HistoricalPrice, benchmark []ljlib.HistoricalPrice) Comparison {
+	benchmarkByDate := make(map[string]decimal.Decimal, len(benchmark))
+	for _, price := range benchmark {
+		benchmarkByDate[price.Date.Format(time.DateOnly)] = price.Price
+	}
+	var comparison Comparison
+	var base, benchmarkBase decimal.Decimal
+	for _, price := range sortedPrices(prices) {
+		benchmarkPrice, ok := benchmarkByDate[price.Date.Format(time.DateOnly)]
+		if !ok {
+			continue
+		}
+		if len(comparison.Points) == 0 {
+			if !price.Price.IsPositive() || !benchmarkPrice.IsPositive() {
+				continue
+			}
+			base, benchmarkBase = price.Price, benchmarkPrice
+		}
+		comparison.Points = append(comparison.Points, ComparisonPoint{
+			Date:      price.Date,
+			Value:     rebase(price.Price, base),
+			Benchmark: rebase(benchmarkPrice, benchmarkBase),
+		})
+	}
+	if len(comparison.Points) == 0 {
+		return comparison
+	}
+
+	last := comparison.Points[len(comparison.Points)-1]
+	total := last.Value.Div(decimal.NewFromInt(rebasedBase)).Sub(decimal.NewFromInt(1))
+	benchmarkTotal := last.Benchmark.Div(decimal.NewFromInt(rebasedBase)).Sub(decimal.NewFromInt(1))
+	comparison.Return = decimal.NewNullDecimal(total.Round(ReturnPlaces))
+	comparison.BenchmarkReturn = decimal.NewNullDecimal(benchmarkTotal.Round(ReturnPlaces))
+	comparison.ExcessReturn = decimal.NewNullDecimal(total.Sub(benchmarkTotal).Round(ReturnPlaces))
+
+	rebased := make([]ljlib.HistoricalPrice, 0, len(comparison.Points))
+	rebasedBenchmark := make([]ljlib.HistoricalPrice, 0, len(comparison.Points))
+	for _, point := range comparison.Points {
+		rebased = append(rebased, ljlib.HistoricalPrice{Date: point.Date, Price: point.Value})
+		rebasedBenchmark = append(rebasedBenchmark, ljlib.HistoricalPrice{Date: point.Date, Price: point.Benchmark})
+	}
+	returns, benchmarkReturns := DailyReturns(rebased), DailyReturns(rebasedBenchmark)
+	comparison.TrackingError = nullRatio(TrackingError(returns, benchmarkReturns))
+	comparison.InformationRatio = nullRatio(InformationRatio(returns, benchmarkReturns))
+	return comparison
+}
+
+// GetTickerComparison compares the prices of the ticker with the benchmark over the range.
+func (s *Service) GetTickerComparison(ticker string, request BenchmarkRequest) (Comparison, error) {
+	request, err := s.normalizeBenchmarkRequest(request)
+	if err != nil {
+		return Comparison{}, err
+	}
+	prices, benchmark, err := s.getPricesWithBenchmark(ticker, request)
+	if err != nil {
+		return Comparison{}, err
+	}
+	comparison := Compare(prices, benchmark)
+	comparison.Ticker = ticker
+	comparison.From, comparison.To, comparison.Benchmark = request.From, request.To, request.Benchmark
+	return comparison, nil
+}
+
+// GetPortfolioComparison compares the user's portfolio with the benchmark over the range. The portfolio is
+// the chain-linked index of its daily values, like in GetPortfolioRisk, so the deposits and the withdrawals
+// don't count as its returns.
+func (s *Service) GetPortfolioComparison(userID uuid.UUID, request BenchmarkRequest) (Comparison, error) {
+	request, err := s.normalizeBenchmarkRequest(request)
+	if err != nil {
+		return Comparison{}, err
+	}
+	index, _, err := s.getPortfolioIndex(userID, request.From, request.To)
+	if err != nil {
+		return Comparison{}, err
+	}
+	_, benchmark, err := s.getPricesWithBenchmark("", request)
+	if err != nil {
+		return Comparison{}, err
+	}
+	comparison := Compare(index, benchmark)
+	comparison.From, comparison.To, comparison.Benchmark = request.From, request.To, request.Benchmark
+	return comparison, nil
+}
+
+func rebase(price decimal.Decimal, base decimal.Decimal) decimal.Decimal {
+	return price.Mul(decimal.NewFromInt(rebasedBase)).Div(base)
+}
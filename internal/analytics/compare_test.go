@@ -0,0 +1,92 @@
+package analytics_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	//the benchmark has no price on the second day, and the ticker none on the last one
+	prices := pricesFrom([]float64{50, 52, 55, 54, 60})[:4]
+	benchmark := pricesFrom([]float64{200, 0, 210, 206, 230})
+	benchmark = append(benchmark[:1], benchmark[2:]...)
+
+	comparison := analytics.Compare(reversed(prices), benchmark)
+
+	require.Len(t, comparison.Points, 3)
+	expected := []struct {
+		date             time.Time
+		value, benchmark string
+	}{
+		{testStart, "100", "100"},
+		{testStart.AddDate(0, 0, 2), "110", "105"},
+		{testStart.AddDate(0, 0, 3), "108", "103"},
+	}
+	for i, point := range comparison.Points {
+		assert.Equal(t, expected[i].date, point.Date)
+		assert.Equal(t, expected[i].value, point.Value.String())
+		assert.Equal(t, expected[i].benchmark, point.Benchmark.String())
+	}
+	assert.Equal(t, "0.08", comparison.Return.Decimal.String())
+	assert.Equal(t, "0.03", comparison.BenchmarkReturn.Decimal.String())
+	assert.Equal(t, "0.05", comparison.ExcessReturn.Decimal.String())
+	require.True(t, comparison.TrackingError.Valid)
+	require.True(t, comparison.InformationRatio.Valid)
+
+	empty := analytics.Compare(prices, nil)
+	assert.Empty(t, empty.Points)
+	assert.False(t, empty.Return.Valid)
+	assert.False(t, empty.TrackingError.Valid)
+}
+
+func TestTrackingError(t *testing.T) {
+	returns := dailyReturns([]float64{0.02, 0.00, 0.03})
+	benchmark := dailyReturns([]float64{0.01, 0.01, 0.01})
+
+	//the active returns are 1%, -1% and 2%, with the mean of 2/3%
+	deviation := math.Sqrt((math.Pow(0.01/3, 2) + math.Pow(0.05/3, 2) + math.Pow(0.04/3, 2)) / 2)
+	trackingError, ok := analytics.TrackingError(returns, benchmark)
+	require.True(t, ok)
+	assert.InDelta(t, deviation*math.Sqrt(365), trackingError, 1e-9)
+
+	ratio, ok := analytics.InformationRatio(returns, benchmark)
+	require.True(t, ok)
+	assert.InDelta(t, 0.02/3*365/trackingError, ratio, 1e-9)
+
+	_, ok = analytics.InformationRatio(returns, returns)
+	assert.False(t, ok, "the information ratio is not defined without any tracking error")
+}
+
+func TestService_GetPortfolioComparison(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		cashTransaction(ljlib.TransactionTypeWithdrawal, 100, testStart.AddDate(0, 0, 3).Add(10*time.Hour)),
+	}
+	service := newTestService(t, ledger, &mockPriceSource{unknown: "UNKNOWN"}, testStart.AddDate(0, 3, 0), 4)
+
+	comparison, err := service.GetPortfolioComparison(testUserID, analytics.BenchmarkRequest{
+		From: testStart.AddDate(0, 0, -5),
+		To:   testStart.AddDate(0, 0, 4),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, analytics.DefaultConfig().Benchmark, comparison.Benchmark)
+	//the days before the deposit are skipped
+	require.Len(t, comparison.Points, 5)
+	assert.Equal(t, testStart, comparison.Points[0].Date)
+	//the benchmark is priced at 100 on testStart, growing by 1 a day
+	assert.Equal(t, "104", comparison.Points[4].Benchmark.String())
+	//1004 / 1000, 1009 / 1004, (914 + 100) / 1009 with the withdrawal at the end of the day, and 919 / 914
+	assert.Equal(t, "0.019547", comparison.Return.Decimal.String())
+	assert.Equal(t, "-0.020453", comparison.ExcessReturn.Decimal.String())
+
+	_, err = service.GetPortfolioComparison(testUserID, analytics.BenchmarkRequest{Benchmark: "UNKNOWN"})
+	assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+}
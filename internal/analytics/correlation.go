@@ -0,0 +1,225 @@
+package analytics
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultCorrelationWindow is the number of the daily returns the correlations are computed from by default,
+	// roughly a year of trading days.
+	DefaultCorrelationWindow = 252
+	// MaxCorrelationWindow limits the window, roughly 10 years of trading days.
+	MaxCorrelationWindow = 2520
+	// CovariancePlaces is the precision the covariances are rounded to, finer than the returns, since
+	// the covariances of the daily returns are small.
+	CovariancePlaces = 10
+)
+
+// CorrelationRequest is the number of the most recent daily returns the correlations are computed from.
+type CorrelationRequest struct {
+	Window int
+}
+
+// CorrelationMatrix is the pairwise correlation and covariance of the daily returns of the tickers, as the matrices
+// with the rows and the columns in the order of the tickers. Observations is the number of the returns each pair
+// was computed from. The figures which are not defined, e.g. for a pair with less than two returns, are not set.
+type CorrelationMatrix struct {
+	AsOf         time.Time
+	Window       int
+	Tickers      []string
+	Correlations [][]decimal.NullDecimal
+	Covariances  [][]decimal.NullDecimal
+	Observations [][]int
+}
+
+func (m CorrelationMatrix) MarshalJSON() ([]byte, error) {
+	tickers, observations := m.Tickers, m.Observations
+	if tickers == nil {
+		tickers = []string{}
+	}
+	if observations == nil {
+		observations = [][]int{}
+	}
+	return json.Marshal(struct {
+		AsOf         string      `json:"as_of"`
+		Window       int         `json:"window"`
+		Tickers      []string    `json:"tickers"`
+		Correlations [][]*string `json:"correlations"`
+		Covariances  [][]*string `json:"covariances"`
+		Observations [][]int     `json:"observations"`
+	}{
+		AsOf:         m.AsOf.Format(time.DateOnly),
+		Window:       m.Window,
+		Tickers:      tickers,
+		Correlations: optionalMatrix(m.Correlations),
+		Covariances:  optionalMatrix(m.Covariances),
+		Observations: observations,
+	})
+}
+
+// Correlate computes the correlation matrix of the prices of the tickers, in any order. Each pair is aligned
+// on the dates both tickers have a price for, and the returns are from one such date to the next, so a date
+// missing from either series doesn't shift the other. Only the last window returns of each pair are used.
+func Correlate(prices map[string][]ljlib.HistoricalPrice, window int) CorrelationMatrix {
+	matrix := CorrelationMatrix{Window: window}
+	byDate := make(map[string]map[string]float64, len(prices))
+	for ticker, tickerPrices := range prices {
+		matrix.Tickers = append(matrix.Tickers, ticker)
+		byDate[ticker] = make(map[string]float64, len(tickerPrices))
+		for _, price := range tickerPrices {
+			if price.Price.IsPositive() {
+				byDate[ticker][price.Date.Format(time.DateOnly)] = price.Price.InexactFloat64()
+			}
+		}
+	}
+	sort.Strings(matrix.Tickers)
+
+	size := len(matrix.Tickers)
+	matrix.Correlations = make([][]decimal.NullDecimal, size)
+	matrix.Covariances = make([][]decimal.NullDecimal, size)
+	matrix.Observations = make([][]int, size)
+	for i := range matrix.Tickers {
+		matrix.Correlations[i] = make([]decimal.NullDecimal, size)
+		matrix.Covariances[i] = make([]decimal.NullDecimal, size)
+		matrix.Observations[i] = make([]int, size)
+	}
+	for i, ticker := range matrix.Tickers {
+		for j := i; j < size; j++ {
+			returns, other := pairReturns(byDate[ticker], byDate[matrix.Tickers[j]], window)
+			covariance, correlation, ok := covariance(returns, other)
+			for _, cell := range [][2]int{{i, j}, {j, i}} {
+				matrix.Observations[cell[0]][cell[1]] = len(returns)
+				if ok {
+					matrix.Covariances[cell[0]][cell[1]] = nullRound(covariance, CovariancePlaces)
+					matrix.Correlations[cell[0]][cell[1]] = nullRatio(correlation, !math.IsNaN(correlation))
+				}
+			}
+		}
+	}
+	return matrix
+}
+
+// pairReturns returns the last window returns of both series from one date they both have a price for
+// to the next one.
+func pairReturns(prices map[string]float64, other map[string]float64, window int) ([]float64, []float64) {
+	var dates []string
+	for date := range prices {
+		if _, ok := other[date]; ok {
+			dates = append(dates, date)
+		}
+	}
+	//the dates as YYYY-MM-DD sort chronologically
+	sort.Strings(dates)
+	if len(dates) > window+1 {
+		dates = dates[len(dates)-window-1:]
+	}
+	var returns, otherReturns []float64
+	for i := 1; i < len(dates); i++ {
+		returns = append(returns, prices[dates[i]]/prices[dates[i-1]]-1)
+		otherReturns = append(otherReturns, other[dates[i]]/other[dates[i-1]]-1)
+	}
+	return returns, otherReturns
+}
+
+// covariance returns the sample covariance of the returns, and their correlation, which is not a number when
+// either has no variance. Neither is defined for less than two returns.
+func covariance(returns []float64, other []float64) (float64, float64, bool) {
+	if len(returns) < 2 {
+		return 0, 0, false
+	}
+	var mean, otherMean float64
+	for i := range returns {
+		mean += returns[i] / float64(len(returns))
+		otherMean += other[i] / float64(len(returns))
+	}
+	var products, squares, otherSquares float64
+	for i := range returns {
+		products += (returns[i] - mean) * (other[i] - otherMean)
+		squares += (returns[i] - mean) * (returns[i] - mean)
+		otherSquares += (other[i] - otherMean) * (other[i] - otherMean)
+	}
+	if squares == 0 || otherSquares == 0 {
+		return products / float64(len(returns)-1), math.NaN(), true
+	}
+	//the rounding errors may put the correlation of a series with itself slightly above 1
+	correlation := math.Max(-1, math.Min(1, products/math.Sqrt(squares*otherSquares)))
+	return products / float64(len(returns)-1), correlation, true
+}
+
+// GetCorrelations returns the correlation matrix of the daily returns of the holdings of the user's portfolio
+// at the end of today, over the last window returns. The correlations are of the instruments' returns, so they
+// don't depend on the holding being long or short.
+func (s *Service) GetCorrelations(userID uuid.UUID, request CorrelationRequest) (CorrelationMatrix, error) {
+	if request.Window == 0 {
+		request.Window = DefaultCorrelationWindow
+	}
+	if request.Window < 2 || request.Window > MaxCorrelationWindow {
+		return CorrelationMatrix{}, ljlib.NewIllegalArgumentError("window must be between 2 and %d",
+			MaxCorrelationWindow)
+	}
+	today := startOfDay(s.clock.Now())
+	transactions, err := s.getTransactions(userID)
+	if err != nil {
+		return CorrelationMatrix{}, err
+	}
+	valuations, err := s.getDailyValuations(transactions, today, today)
+	if err != nil {
+		return CorrelationMatrix{}, err
+	}
+	var tickers []string
+	for ticker, holding := range valuations[len(valuations)-1].holdings {
+		if !holding.quantity.IsZero() {
+			tickers = append(tickers, ticker)
+		}
+	}
+
+	histories, err := s.getPriceHistories(tickers, returnsHistoryStart(today, request.Window), today)
+	if err != nil {
+		return CorrelationMatrix{}, err
+	}
+	prices := make(map[string][]ljlib.HistoricalPrice, len(histories))
+	for ticker, history := range histories {
+		for date, price := range history {
+			day, err := time.Parse(time.DateOnly, date)
+			if err != nil {
+				return CorrelationMatrix{}, err
+			}
+			prices[ticker] = append(prices[ticker], ljlib.HistoricalPrice{Date: day, Price: price})
+		}
+	}
+	for _, ticker := range tickers {
+		if _, ok := prices[ticker]; !ok {
+			prices[ticker] = nil
+		}
+	}
+
+	matrix := Correlate(prices, request.Window)
+	matrix.AsOf = today
+	return matrix, nil
+}
+
+func nullRound(value float64, places int32) decimal.NullDecimal {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return decimal.NullDecimal{}
+	}
+	return decimal.NewNullDecimal(decimal.NewFromFloat(value).Round(places))
+}
+
+func optionalMatrix(matrix [][]decimal.NullDecimal) [][]*string {
+	result := make([][]*string, 0, len(matrix))
+	for _, row := range matrix {
+		values := make([]*string, 0, len(row))
+		for _, value := range row {
+			values = append(values, optionalReturn(value))
+		}
+		result = append(result, values)
+	}
+	return result
+}
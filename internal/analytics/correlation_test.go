@@ -0,0 +1,173 @@
+package analytics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelate(t *testing.T) {
+	testCases := map[string]struct {
+		prices               map[string][]ljlib.HistoricalPrice
+		window               int
+		expectedCorrelation  string
+		expectedObservations int
+	}{
+		"it should return the correlation of the prices moving together": {
+			prices: map[string][]ljlib.HistoricalPrice{
+				"A": pricesFrom([]float64{100, 101, 99, 103, 104}),
+				"B": pricesFrom([]float64{200, 202, 198, 206, 208}),
+			},
+			window:               10,
+			expectedCorrelation:  "1",
+			expectedObservations: 4,
+		},
+		"it should return the correlation of the prices moving apart": {
+			prices: map[string][]ljlib.HistoricalPrice{
+				"A": pricesFromReturns([]float64{0.01, -0.02, 0.03}),
+				"B": pricesFromReturns([]float64{-0.01, 0.02, -0.03}),
+			},
+			window:               10,
+			expectedCorrelation:  "-1",
+			expectedObservations: 3,
+		},
+		"it should align the prices on the dates both have": {
+			prices: map[string][]ljlib.HistoricalPrice{
+				"A": pricesFrom([]float64{100, 101, 99, 103, 104}),
+				"B": withoutDay(pricesFrom([]float64{100, 101, 50, 103, 104}), 2),
+			},
+			window:               10,
+			expectedCorrelation:  "1",
+			expectedObservations: 3,
+		},
+		"it should only use the last returns of the window": {
+			prices: map[string][]ljlib.HistoricalPrice{
+				"A": pricesFromReturns([]float64{0.05, -0.05, 0.01, -0.02, 0.03}),
+				"B": pricesFromReturns([]float64{-0.05, 0.05, 0.01, -0.02, 0.03}),
+			},
+			window:               3,
+			expectedCorrelation:  "1",
+			expectedObservations: 3,
+		},
+		"it should not set the correlation with a price which never changes": {
+			prices: map[string][]ljlib.HistoricalPrice{
+				"A": pricesFrom([]float64{100, 101, 99, 103}),
+				"B": pricesFrom([]float64{50, 50, 50, 50}),
+			},
+			window:               10,
+			expectedObservations: 3,
+		},
+		"it should not set the correlation without enough returns": {
+			prices: map[string][]ljlib.HistoricalPrice{
+				"A": pricesFrom([]float64{100, 101, 99, 103}),
+				"B": pricesFrom([]float64{50}),
+			},
+			window:               10,
+			expectedObservations: 0,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			matrix := analytics.Correlate(testCase.prices, testCase.window)
+
+			require.Equal(t, []string{"A", "B"}, matrix.Tickers)
+			assert.Equal(t, testCase.expectedObservations, matrix.Observations[0][1])
+			assert.Equal(t, matrix.Observations[0][1], matrix.Observations[1][0])
+			assert.Equal(t, matrix.Correlations[0][1], matrix.Correlations[1][0])
+			assert.Equal(t, matrix.Covariances[0][1], matrix.Covariances[1][0])
+			if len(testCase.expectedCorrelation) == 0 {
+				assert.False(t, matrix.Correlations[0][1].Valid)
+				return
+			}
+			require.True(t, matrix.Correlations[0][1].Valid)
+			assert.Equal(t, testCase.expectedCorrelation, matrix.Correlations[0][1].Decimal.String())
+			assert.Equal(t, "1", matrix.Correlations[0][0].Decimal.String())
+			assert.True(t, matrix.Covariances[0][0].Decimal.IsPositive())
+		})
+	}
+}
+
+func TestCorrelate_Covariance(t *testing.T) {
+	returns := []float64{0.01, -0.01, 0.01, -0.01}
+	matrix := analytics.Correlate(map[string][]ljlib.HistoricalPrice{
+		"A": pricesFromReturns(returns),
+		"B": pricesFromReturns([]float64{0.02, -0.02, 0.02, -0.02}),
+	}, analytics.DefaultCorrelationWindow)
+
+	//the covariance with the returns twice as large is twice the variance
+	variance := standardDeviation(returns) * standardDeviation(returns)
+	assert.InDelta(t, variance, matrix.Covariances[0][0].Decimal.InexactFloat64(), 1e-10)
+	assert.InDelta(t, 2*variance, matrix.Covariances[0][1].Decimal.InexactFloat64(), 1e-10)
+	assert.InDelta(t, 4*variance, matrix.Covariances[1][1].Decimal.InexactFloat64(), 1e-10)
+}
+
+func TestService_GetCorrelations(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 10000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "MSFT", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "GOOG", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeSell, "GOOG", 5, 100, testStart.AddDate(0, 0, 2).Add(10*time.Hour)),
+	}
+	testCases := map[string]struct {
+		window               int
+		expectedWindow       int
+		expectedObservations int
+		expectedError        bool
+	}{
+		"it should return the correlations over the window": {
+			window:               30,
+			expectedWindow:       30,
+			expectedObservations: 30,
+		},
+		"it should use the default window": {
+			expectedWindow:       analytics.DefaultCorrelationWindow,
+			expectedObservations: analytics.DefaultCorrelationWindow,
+		},
+		"it should return IllegalArgumentError for a window which is too short": {
+			window:        1,
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for a window which is too long": {
+			window:        analytics.MaxCorrelationWindow + 1,
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			service := newTestService(t, ledger, &mockPriceSource{}, testStart.AddDate(1, 0, 0), 4)
+
+			matrix, err := service.GetCorrelations(testUserID, analytics.CorrelationRequest{Window: testCase.window})
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, testStart.AddDate(1, 0, 0), matrix.AsOf)
+			assert.Equal(t, testCase.expectedWindow, matrix.Window)
+			//the closed position is not included
+			require.Equal(t, []string{"AAPL", "MSFT"}, matrix.Tickers)
+			assert.Equal(t, testCase.expectedObservations, matrix.Observations[0][1])
+			//the mock prices grow by the same amount for every ticker
+			assert.Equal(t, "1", matrix.Correlations[0][1].Decimal.String())
+		})
+	}
+}
+
+// pricesFromReturns returns the prices starting at 100 on testStart with the daily returns.
+func pricesFromReturns(returns []float64) []ljlib.HistoricalPrice {
+	values := []float64{100}
+	for _, r := range returns {
+		values = append(values, values[len(values)-1]*(1+r))
+	}
+	return pricesFrom(values)
+}
+
+func withoutDay(prices []ljlib.HistoricalPrice, day int) []ljlib.HistoricalPrice {
+	return append(append([]ljlib.HistoricalPrice{}, prices[:day]...), prices[day+1:]...)
+}
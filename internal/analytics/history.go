@@ -0,0 +1,288 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type Interval string
+
+const (
+	IntervalDay   Interval = "day"
+	IntervalWeek  Interval = "week"
+	IntervalMonth Interval = "month"
+)
+
+const (
+	//DefaultHistoryDays is the range of the history when its start is not set.
+	DefaultHistoryDays = 365
+	//MaxHistoryDays limits the range of the history, roughly 10 years.
+	MaxHistoryDays = 10 * 365
+)
+
+// HistoryRequest is the range of the portfolio history, by dates. The zero To is today, and the zero From is
+// DefaultHistoryDays before To.
+type HistoryRequest struct {
+	From     time.Time
+	To       time.Time
+	Interval Interval
+}
+
+// HistoryPoint is the portfolio at the end of the day: the holdings valued at the price of the day, the cash,
+// and the contributions, which are the deposits less the withdrawals made so far. The amounts are
+// in the base currency.
+type HistoryPoint struct {
+	Date            time.Time
+	SecuritiesValue decimal.Decimal
+	Cash            decimal.Decimal
+	TotalValue      decimal.Decimal
+	Contributions   decimal.Decimal
+}
+
+func (p HistoryPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Date            string `json:"date"`
+		SecuritiesValue string `json:"securities_value"`
+		Cash            string `json:"cash"`
+		TotalValue      string `json:"total_value"`
+		Contributions   string `json:"contributions"`
+	}{
+		Date:            p.Date.Format(time.DateOnly),
+		SecuritiesValue: p.SecuritiesValue.StringFixed(ljlib.CashPlaces),
+		Cash:            p.Cash.StringFixed(ljlib.CashPlaces),
+		TotalValue:      p.TotalValue.StringFixed(ljlib.CashPlaces),
+		Contributions:   p.Contributions.StringFixed(ljlib.CashPlaces),
+	})
+}
+
+// History is the value of the portfolio over time, the oldest point first.
+type History struct {
+	From     time.Time
+	To       time.Time
+	Interval Interval
+	Points   []HistoryPoint
+}
+
+func (h History) MarshalJSON() ([]byte, error) {
+	points := h.Points
+	if points == nil {
+		points = []HistoryPoint{}
+	}
+	return json.Marshal(struct {
+		From     string         `json:"from"`
+		To       string         `json:"to"`
+		Interval Interval       `json:"interval"`
+		Points   []HistoryPoint `json:"points"`
+	}{
+		From:     h.From.Format(time.DateOnly),
+		To:       h.To.Format(time.DateOnly),
+		Interval: h.Interval,
+		Points:   points,
+	})
+}
+
+// GetHistory replays the user's ledger over the range, valuing the holdings at the end of each day. With the week
+// and the month intervals, the points are the last days of the periods, while the first and the last day
+// of the range are always included.
+func (s *Service) GetHistory(userID uuid.UUID, request HistoryRequest) (History, error) {
+	request, err := s.normalizeHistoryRequest(request)
+	if err != nil {
+		return History{}, err
+	}
+	points, err := s.getDailyHistory(userID, request.From, request.To)
+	if err != nil {
+		return History{}, err
+	}
+
+	history := History{From: request.From, To: request.To, Interval: request.Interval}
+	for i, point := range points {
+		if i == 0 || i == len(points)-1 || isPeriodEnd(point.Date, request.Interval) {
+			history.Points = append(history.Points, point)
+		}
+	}
+	return history, nil
+}
+
+func (s *Service) normalizeHistoryRequest(request HistoryRequest) (HistoryRequest, error) {
+	switch request.Interval {
+	case "":
+		request.Interval = IntervalDay
+	case IntervalDay, IntervalWeek, IntervalMonth:
+	default:
+		return HistoryRequest{}, ljlib.NewIllegalArgumentError("interval must be one of %s, %s and %s",
+			IntervalDay, IntervalWeek, IntervalMonth)
+	}
+	var err error
+	request.From, request.To, err = s.normalizeRange(request.From, request.To)
+	if err != nil {
+		return HistoryRequest{}, err
+	}
+	return request, nil
+}
+
+// normalizeRange returns the range by dates, ending today at the latest, and DefaultHistoryDays long
+// when its start is not set.
+func (s *Service) normalizeRange(from time.Time, to time.Time) (time.Time, time.Time, error) {
+	today := startOfDay(s.clock.Now())
+	if to.IsZero() || to.After(today) {
+		to = today
+	}
+	to = startOfDay(to)
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -DefaultHistoryDays)
+	}
+	from = startOfDay(from)
+	if from.After(to) {
+		return time.Time{}, time.Time{}, ljlib.NewIllegalArgumentError("from cannot be after to")
+	}
+	if to.Sub(from) > MaxHistoryDays*24*time.Hour {
+		return time.Time{}, time.Time{}, ljlib.NewIllegalArgumentError("the range cannot be longer than %d days",
+			MaxHistoryDays)
+	}
+	return from, to, nil
+}
+
+// dailyValuation is the portfolio at the end of a day, together with its positions.
+type dailyValuation struct {
+	HistoryPoint
+	//holdings are the positions held at the end of the day, or traded during it, by ticker.
+	holdings map[string]holdingValuation
+}
+
+// holdingValuation is a position at the end of a day, with the cash paid for the buys and received for the sells
+// during the day.
+type holdingValuation struct {
+	quantity decimal.Decimal
+	value    decimal.Decimal
+	inflow   decimal.Decimal
+	outflow  decimal.Decimal
+}
+
+// getDailyHistory returns the point of every day of the range, the oldest first.
+func (s *Service) getDailyHistory(userID uuid.UUID, from time.Time, to time.Time) ([]HistoryPoint, error) {
+	transactions, err := s.getTransactions(userID)
+	if err != nil {
+		return nil, err
+	}
+	valuations, err := s.getDailyValuations(transactions, from, to)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]HistoryPoint, 0, len(valuations))
+	for _, valuation := range valuations {
+		points = append(points, valuation.HistoryPoint)
+	}
+	return points, nil
+}
+
+// getDailyValuations replays the ledger, in the order of execution, over the range, valuing the portfolio
+// at the end of every day of it.
+func (s *Service) getDailyValuations(transactions []ljlib.Transaction, from time.Time,
+	to time.Time) ([]dailyValuation, error) {
+	prices, err := s.getPriceHistories(heldTickers(transactions, from, to), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var valuations []dailyValuation
+	quantities := make(map[string]decimal.Decimal)
+	var cash, contributions decimal.Decimal
+	next := 0
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		endOfDay := day.AddDate(0, 0, 1)
+		holdings := make(map[string]holdingValuation)
+		for ; next < len(transactions) && transactions[next].ExecutedAt.Before(endOfDay); next++ {
+			tx := transactions[next]
+			if tx.IsTrade() {
+				quantities[tx.Ticker] = quantities[tx.Ticker].Add(tx.SignedQuantity())
+				//the flows before the range are part of the value the range starts with
+				if !tx.ExecutedAt.Before(day) {
+					holding := holdings[tx.Ticker]
+					if tx.Type == ljlib.TransactionTypeBuy {
+						holding.inflow = holding.inflow.Sub(tx.CashFlow())
+					} else {
+						holding.outflow = holding.outflow.Add(tx.CashFlow())
+					}
+					holdings[tx.Ticker] = holding
+				}
+			}
+			//like the buying power, the history is in the base currency only
+			if tx.CashCurrency() != ljlib.BaseCurrency {
+				continue
+			}
+			cash = cash.Add(tx.CashFlow())
+			if tx.Type == ljlib.TransactionTypeDeposit || tx.Type == ljlib.TransactionTypeWithdrawal {
+				contributions = contributions.Add(tx.CashFlow())
+			}
+		}
+
+		valuation := dailyValuation{
+			HistoryPoint: HistoryPoint{Date: day, Cash: cash, Contributions: contributions},
+			holdings:     holdings,
+		}
+		for ticker, quantity := range quantities {
+			if quantity.IsZero() {
+				continue
+			}
+			price, ok := prices[ticker][day.Format(time.DateOnly)]
+			if !ok {
+				return nil, fmt.Errorf("no price for ticker [%s] on %s", ticker, day.Format(time.DateOnly))
+			}
+			holding := holdings[ticker]
+			holding.quantity = quantity
+			holding.value = quantity.Mul(price).Mul(ljlib.ContractMultiplier(ticker)).Round(ljlib.CashPlaces)
+			holdings[ticker] = holding
+			valuation.SecuritiesValue = valuation.SecuritiesValue.Add(holding.value)
+		}
+		valuation.TotalValue = valuation.Cash.Add(valuation.SecuritiesValue)
+		valuations = append(valuations, valuation)
+	}
+	return valuations, nil
+}
+
+// heldTickers returns the tickers held at any time within the range: the positions open at its start,
+// and the ones traded within it. The transactions are in the order of their execution.
+func heldTickers(transactions []ljlib.Transaction, from time.Time, to time.Time) []string {
+	quantities := make(map[string]decimal.Decimal)
+	held := make(map[string]bool)
+	endOfRange := to.AddDate(0, 0, 1)
+	for _, tx := range transactions {
+		if !tx.IsTrade() || !tx.ExecutedAt.Before(endOfRange) {
+			continue
+		}
+		if tx.ExecutedAt.Before(from) {
+			quantities[tx.Ticker] = quantities[tx.Ticker].Add(tx.SignedQuantity())
+		} else {
+			held[tx.Ticker] = true
+		}
+	}
+	for ticker, quantity := range quantities {
+		if !quantity.IsZero() {
+			held[ticker] = true
+		}
+	}
+	tickers := make([]string, 0, len(held))
+	for ticker := range held {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	return tickers
+}
+
+// isPeriodEnd tells whether the day is the last one of its period: Sunday for the weeks, and the last day
+// of the month for the months.
+func isPeriodEnd(day time.Time, interval Interval) bool {
+	switch interval {
+	case IntervalWeek:
+		return day.Weekday() == time.Sunday
+	case IntervalMonth:
+		return day.AddDate(0, 0, 1).Day() == 1
+	}
+	return true
+}
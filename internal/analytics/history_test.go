@@ -0,0 +1,215 @@
+package analytics_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+	//testStart is Sunday
+	testStart = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func TestService_GetHistory(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		cashTransaction(ljlib.TransactionTypeWithdrawal, 100, testStart.AddDate(0, 0, 3).Add(10*time.Hour)),
+		//deposits in other currencies are not part of the history
+		{Type: ljlib.TransactionTypeDeposit, Amount: decimal.NewFromInt(500), Currency: "EUR", ExecutedAt: testStart},
+	}
+	service := newTestService(t, ledger, &mockPriceSource{}, testStart.AddDate(0, 1, 0), 4)
+
+	history, err := service.GetHistory(testUserID, analytics.HistoryRequest{
+		From: testStart,
+		To:   testStart.AddDate(0, 0, 4),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, analytics.IntervalDay, history.Interval)
+	require.Len(t, history.Points, 5)
+	expected := []struct {
+		securities, cash, total, contributions string
+	}{
+		{"0", "1000", "1000", "1000"},
+		//5 shares at 101, bought for 500 and the commission of 1
+		{"505", "499", "1004", "1000"},
+		{"510", "499", "1009", "1000"},
+		{"515", "399", "914", "900"},
+		{"520", "399", "919", "900"},
+	}
+	for i, point := range history.Points {
+		assert.Equal(t, testStart.AddDate(0, 0, i), point.Date)
+		assert.Equal(t, expected[i].securities, point.SecuritiesValue.String(), "day %d", i)
+		assert.Equal(t, expected[i].cash, point.Cash.String(), "day %d", i)
+		assert.Equal(t, expected[i].total, point.TotalValue.String(), "day %d", i)
+		assert.Equal(t, expected[i].contributions, point.Contributions.String(), "day %d", i)
+	}
+}
+
+func TestService_GetHistory_Interval(t *testing.T) {
+	testCases := map[string]struct {
+		request       analytics.HistoryRequest
+		expectedDates []string
+		expectedError bool
+	}{
+		"it should return the ends of the weeks": {
+			request: analytics.HistoryRequest{From: testStart, To: testStart.AddDate(0, 0, 16),
+				Interval: analytics.IntervalWeek},
+			expectedDates: []string{"2023-01-01", "2023-01-08", "2023-01-15", "2023-01-17"},
+		},
+		"it should return the ends of the months": {
+			request: analytics.HistoryRequest{From: testStart.AddDate(0, 0, 4), To: testStart.AddDate(0, 2, 9),
+				Interval: analytics.IntervalMonth},
+			expectedDates: []string{"2023-01-05", "2023-01-31", "2023-02-28", "2023-03-10"},
+		},
+		"it should end the history today": {
+			request:       analytics.HistoryRequest{From: testStart.AddDate(0, 2, 28), To: testStart.AddDate(1, 0, 0)},
+			expectedDates: []string{"2023-03-29", "2023-03-30", "2023-03-31", "2023-04-01"},
+		},
+		"it should return IllegalArgumentError for an unknown interval": {
+			request:       analytics.HistoryRequest{Interval: "year"},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError when from is after to": {
+			request:       analytics.HistoryRequest{From: testStart.AddDate(0, 0, 2), To: testStart},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for a range which is too long": {
+			request:       analytics.HistoryRequest{From: testStart.AddDate(-11, 0, 0), To: testStart},
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ledger := mockLedger{cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart)}
+			service := newTestService(t, ledger, &mockPriceSource{}, testStart.AddDate(0, 3, 0), 4)
+
+			history, err := service.GetHistory(testUserID, testCase.request)
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+				return
+			}
+			require.NoError(t, err)
+			var dates []string
+			for _, point := range history.Points {
+				dates = append(dates, point.Date.Format(time.DateOnly))
+			}
+			assert.Equal(t, testCase.expectedDates, dates)
+		})
+	}
+}
+
+func TestService_GetHistory_Parallelism(t *testing.T) {
+	var ledger mockLedger
+	for _, ticker := range []string{"AAPL", "MSFT", "GOOG", "AMZN", "META", "TSLA"} {
+		ledger = append(ledger, trade(ljlib.TransactionTypeBuy, ticker, 1, 100, testStart))
+	}
+	prices := &mockPriceSource{delay: 10 * time.Millisecond}
+	service := newTestService(t, ledger, prices, testStart.AddDate(0, 1, 0), 2)
+
+	_, err := service.GetHistory(testUserID, analytics.HistoryRequest{From: testStart})
+	require.NoError(t, err)
+	assert.Equal(t, 6, prices.calls)
+	assert.LessOrEqual(t, prices.maxInFlight, 2)
+}
+
+func newTestService(t *testing.T, ledger mockLedger, prices *mockPriceSource, now time.Time,
+	parallelism int) *analytics.Service {
+	config := analytics.DefaultConfig()
+	config.Parallelism = parallelism
+	service, err := analytics.NewService(ledger, prices, mockInstrumentSource{}, clock.NewVirtual(now), config)
+	require.NoError(t, err)
+	return service
+}
+
+func cashTransaction(txType ljlib.TransactionType, amount int64, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Type:       txType,
+		Amount:     decimal.NewFromInt(amount),
+		ExecutedAt: at,
+	}
+}
+
+func trade(txType ljlib.TransactionType, ticker string, quantity int64, price int64, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Type:       txType,
+		Ticker:     ticker,
+		Quantity:   decimal.NewFromInt(quantity),
+		Price:      decimal.NewFromInt(price),
+		Commission: decimal.NewFromInt(1),
+		ExecutedAt: at,
+	}
+}
+
+type mockLedger []ljlib.Transaction
+
+func (m mockLedger) GetTransactions(uuid.UUID) ([]ljlib.Transaction, error) {
+	return append([]ljlib.Transaction{}, m...), nil
+}
+
+// mockPriceSource prices every ticker but the unknown one at 100 on testStart, growing by 1 a day, and tracks
+// the concurrent calls.
+type mockPriceSource struct {
+	unknown     string
+	delay       time.Duration
+	mu          sync.Mutex
+	calls       int
+	inFlight    int
+	maxInFlight int
+}
+
+func (m *mockPriceSource) GetHistoricalPrices(ticker string, dateFrom time.Time,
+	dateTo time.Time) ([]ljlib.HistoricalPrice, error) {
+	m.mu.Lock()
+	m.calls++
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+	time.Sleep(m.delay)
+	if ticker == m.unknown {
+		return nil, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+
+	var prices []ljlib.HistoricalPrice
+	for day := dateTo; !day.Before(dateFrom); day = day.AddDate(0, 0, -1) {
+		days := int64(day.Sub(testStart).Hours() / 24)
+		prices = append(prices, ljlib.HistoricalPrice{Date: day, Price: decimal.NewFromInt(100 + days)})
+	}
+	return prices, nil
+}
+
+// mockInstrumentSource classifies every ticker as a US equity, in the sector named after its first letter.
+type mockInstrumentSource struct{}
+
+func (m mockInstrumentSource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	return ljlib.Instrument{
+		Ticker:     ticker,
+		AssetClass: ljlib.AssetClassEquity,
+		Sector:     ticker[:1],
+		Industry:   ticker,
+		Country:    "US",
+		Currency:   ljlib.BaseCurrency,
+	}, nil
+}
@@ -0,0 +1,282 @@
+package analytics
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type Period string
+
+const (
+	Period1D        Period = "1D"
+	Period1W        Period = "1W"
+	Period1M        Period = "1M"
+	PeriodYTD       Period = "YTD"
+	Period1Y        Period = "1Y"
+	PeriodInception Period = "inception"
+)
+
+// performancePeriods are the periods the performance is reported for, the shortest first.
+var performancePeriods = []Period{Period1D, Period1W, Period1M, PeriodYTD, Period1Y, PeriodInception}
+
+// ReturnPlaces is the precision the returns are rounded to.
+const ReturnPlaces = 6
+
+// PeriodReturn is the performance over the period, from the end of the From day to the end of the To day.
+// The time-weighted return is chain-linked across the cash flows, so it doesn't depend on their timing or size,
+// while the money-weighted return is the internal rate of return of the flows. Both are over the whole period,
+// and the latter also annualized. The money-weighted returns are not set when the flows have no rate of return.
+type PeriodReturn struct {
+	Period                        Period
+	From                          time.Time
+	To                            time.Time
+	StartValue                    decimal.Decimal
+	EndValue                      decimal.Decimal
+	NetContributions              decimal.Decimal
+	Gain                          decimal.Decimal
+	TimeWeightedReturn            decimal.Decimal
+	MoneyWeightedReturn           decimal.NullDecimal
+	AnnualizedMoneyWeightedReturn decimal.NullDecimal
+}
+
+func (r PeriodReturn) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Period                        Period  `json:"period"`
+		From                          string  `json:"from"`
+		To                            string  `json:"to"`
+		StartValue                    string  `json:"start_value"`
+		EndValue                      string  `json:"end_value"`
+		NetContributions              string  `json:"net_contributions"`
+		Gain                          string  `json:"gain"`
+		TimeWeightedReturn            string  `json:"time_weighted_return"`
+		MoneyWeightedReturn           *string `json:"money_weighted_return"`
+		AnnualizedMoneyWeightedReturn *string `json:"annualized_money_weighted_return"`
+	}{
+		Period:                        r.Period,
+		From:                          r.From.Format(time.DateOnly),
+		To:                            r.To.Format(time.DateOnly),
+		StartValue:                    r.StartValue.StringFixed(ljlib.CashPlaces),
+		EndValue:                      r.EndValue.StringFixed(ljlib.CashPlaces),
+		NetContributions:              r.NetContributions.StringFixed(ljlib.CashPlaces),
+		Gain:                          r.Gain.StringFixed(ljlib.CashPlaces),
+		TimeWeightedReturn:            r.TimeWeightedReturn.String(),
+		MoneyWeightedReturn:           optionalReturn(r.MoneyWeightedReturn),
+		AnnualizedMoneyWeightedReturn: optionalReturn(r.AnnualizedMoneyWeightedReturn),
+	})
+}
+
+func optionalReturn(value decimal.NullDecimal) *string {
+	if !value.Valid {
+		return nil
+	}
+	formatted := value.Decimal.String()
+	return &formatted
+}
+
+// HoldingPerformance is the performance of a position held today. Its contributions are the cash paid
+// for the buys, less the cash received for the sells.
+type HoldingPerformance struct {
+	Ticker   string
+	Quantity decimal.Decimal
+	Value    decimal.Decimal
+	Periods  []PeriodReturn
+}
+
+func (h HoldingPerformance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Ticker   string         `json:"ticker"`
+		Quantity string         `json:"quantity"`
+		Value    string         `json:"value"`
+		Periods  []PeriodReturn `json:"periods"`
+	}{
+		Ticker:   h.Ticker,
+		Quantity: h.Quantity.String(),
+		Value:    h.Value.StringFixed(ljlib.CashPlaces),
+		Periods:  h.Periods,
+	})
+}
+
+// Performance is the performance of the portfolio as a whole, where the contributions are the deposits less
+// the withdrawals, and of each of its holdings.
+type Performance struct {
+	AsOf      time.Time
+	Inception time.Time
+	Periods   []PeriodReturn
+	Holdings  []HoldingPerformance
+}
+
+func (p Performance) MarshalJSON() ([]byte, error) {
+	periods, holdings := p.Periods, p.Holdings
+	if periods == nil {
+		periods = []PeriodReturn{}
+	}
+	if holdings == nil {
+		holdings = []HoldingPerformance{}
+	}
+	return json.Marshal(struct {
+		AsOf      string               `json:"as_of"`
+		Inception string               `json:"inception"`
+		Periods   []PeriodReturn       `json:"periods"`
+		Holdings  []HoldingPerformance `json:"holdings"`
+	}{
+		AsOf:      p.AsOf.Format(time.DateOnly),
+		Inception: p.Inception.Format(time.DateOnly),
+		Periods:   periods,
+		Holdings:  holdings,
+	})
+}
+
+// GetPerformance returns the returns of the user's portfolio and its holdings over the standard periods ending
+// today. The periods starting before the first transaction start with nothing invested, like the one
+// since inception.
+func (s *Service) GetPerformance(userID uuid.UUID) (Performance, error) {
+	today := startOfDay(s.clock.Now())
+	transactions, err := s.getTransactions(userID)
+	if err != nil {
+		return Performance{}, err
+	}
+	inception := today
+	if len(transactions) > 0 && transactions[0].ExecutedAt.Before(today) {
+		inception = startOfDay(transactions[0].ExecutedAt)
+	}
+	//the history starts with the day before the inception, when nothing was invested yet
+	start := inception.AddDate(0, 0, -1)
+	if oldest := today.AddDate(0, 0, -MaxHistoryDays); start.Before(oldest) {
+		start = oldest
+	}
+	valuations, err := s.getDailyValuations(transactions, start, today)
+	if err != nil {
+		return Performance{}, err
+	}
+
+	performance := Performance{AsOf: today, Inception: inception}
+	portfolio := portfolioSeries(valuations)
+	for _, period := range performancePeriods {
+		performance.Periods = append(performance.Periods, periodReturn(period, portfolio, today))
+	}
+
+	current := valuations[len(valuations)-1].holdings
+	tickers := make([]string, 0, len(current))
+	for ticker, holding := range current {
+		if !holding.quantity.IsZero() {
+			tickers = append(tickers, ticker)
+		}
+	}
+	sort.Strings(tickers)
+	for _, ticker := range tickers {
+		holding := HoldingPerformance{
+			Ticker:   ticker,
+			Quantity: current[ticker].quantity,
+			Value:    current[ticker].value,
+		}
+		series := holdingSeries(valuations, ticker)
+		for _, period := range performancePeriods {
+			holding.Periods = append(holding.Periods, periodReturn(period, series, today))
+		}
+		performance.Holdings = append(performance.Holdings, holding)
+	}
+	return performance, nil
+}
+
+// valuePoint is the value of a portfolio, or a holding, at the end of the day, with the flows into
+// and out of it during the day.
+type valuePoint struct {
+	date    time.Time
+	value   decimal.Decimal
+	inflow  decimal.Decimal
+	outflow decimal.Decimal
+}
+
+// portfolioSeries is the total value of the portfolio, with the deposits and withdrawals as the flows.
+func portfolioSeries(valuations []dailyValuation) []valuePoint {
+	series := make([]valuePoint, 0, len(valuations))
+	for i, valuation := range valuations {
+		point := valuePoint{date: valuation.Date, value: valuation.TotalValue}
+		if i > 0 {
+			//deposits and withdrawals on the same day are netted
+			net := valuation.Contributions.Sub(valuations[i-1].Contributions)
+			point.inflow = decimal.Max(net, decimal.Zero)
+			point.outflow = decimal.Max(net.Neg(), decimal.Zero)
+		}
+		series = append(series, point)
+	}
+	return series
+}
+
+// holdingSeries is the value of the position, with its trades as the flows.
+func holdingSeries(valuations []dailyValuation, ticker string) []valuePoint {
+	series := make([]valuePoint, 0, len(valuations))
+	for _, valuation := range valuations {
+		holding := valuation.holdings[ticker]
+		series = append(series, valuePoint{
+			date:    valuation.Date,
+			value:   holding.value,
+			inflow:  holding.inflow,
+			outflow: holding.outflow,
+		})
+	}
+	return series
+}
+
+// periodStart is the day whose end the period starts from, or the zero time for the period since inception.
+func periodStart(period Period, today time.Time) time.Time {
+	switch period {
+	case Period1D:
+		return today.AddDate(0, 0, -1)
+	case Period1W:
+		return today.AddDate(0, 0, -7)
+	case Period1M:
+		return today.AddDate(0, -1, 0)
+	case PeriodYTD:
+		return time.Date(today.Year(), 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	case Period1Y:
+		return today.AddDate(-1, 0, 0)
+	}
+	return time.Time{}
+}
+
+// periodReturn computes the returns of the daily series over the period ending with the last day of the series.
+func periodReturn(period Period, series []valuePoint, today time.Time) PeriodReturn {
+	first := 0
+	if start := periodStart(period, today); start.After(series[0].date) {
+		first = int(start.Sub(series[0].date).Hours() / 24)
+	}
+	series = series[first:]
+	start, end := series[0], series[len(series)-1]
+
+	result := PeriodReturn{
+		Period:     period,
+		From:       start.date,
+		To:         end.date,
+		StartValue: start.value,
+		EndValue:   end.value,
+	}
+	linked := newLinkedReturn()
+	flows := []CashFlow{{Date: start.date, Amount: -start.value.InexactFloat64()}}
+	for i := 1; i < len(series); i++ {
+		point := series[i]
+		linked.add(series[i-1].value.InexactFloat64(), point.value.InexactFloat64(), point.inflow.InexactFloat64(),
+			point.outflow.InexactFloat64())
+		result.NetContributions = result.NetContributions.Add(point.inflow).Sub(point.outflow)
+		if net := point.outflow.Sub(point.inflow); !net.IsZero() {
+			flows = append(flows, CashFlow{Date: point.date, Amount: net.InexactFloat64()})
+		}
+	}
+	flows = append(flows, CashFlow{Date: end.date, Amount: end.value.InexactFloat64()})
+	result.Gain = result.EndValue.Sub(result.StartValue).Sub(result.NetContributions)
+	result.TimeWeightedReturn = decimal.NewFromFloat(linked.value()).Round(ReturnPlaces)
+
+	if rate, err := XIRR(flows); err == nil {
+		years := end.date.Sub(start.date).Hours() / 24 / 365
+		result.AnnualizedMoneyWeightedReturn = decimal.NewNullDecimal(decimal.NewFromFloat(rate).Round(ReturnPlaces))
+		result.MoneyWeightedReturn = decimal.NewNullDecimal(
+			decimal.NewFromFloat(math.Pow(1+rate, years) - 1).Round(ReturnPlaces))
+	}
+	return result
+}
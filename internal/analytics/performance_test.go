@@ -0,0 +1,125 @@
+package analytics_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_GetPerformance(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		cashTransaction(ljlib.TransactionTypeDeposit, 500, testStart.AddDate(0, 0, 5).Add(10*time.Hour)),
+	}
+	today := testStart.AddDate(0, 0, 10)
+	service := newTestService(t, ledger, &mockPriceSource{}, today.Add(12*time.Hour), 4)
+
+	performance, err := service.GetPerformance(testUserID)
+	require.NoError(t, err)
+
+	assert.Equal(t, today, performance.AsOf)
+	assert.Equal(t, testStart, performance.Inception)
+	periods := make(map[analytics.Period]analytics.PeriodReturn)
+	for _, period := range performance.Periods {
+		periods[period.Period] = period
+	}
+	require.Len(t, periods, 6)
+
+	inception := periods[analytics.PeriodInception]
+	assert.Equal(t, "0", inception.StartValue.String())
+	//cash of 999, and 5 shares at 110
+	assert.Equal(t, "1549", inception.EndValue.String())
+	assert.Equal(t, "1500", inception.NetContributions.String())
+	assert.Equal(t, "49", inception.Gain.String())
+	//1019 / 1000 until the second deposit, 1524 / (1019 + 500) on its day, and 1549 / 1524 after it
+	assert.Equal(t, "0.039125", inception.TimeWeightedReturn.String())
+	require.True(t, inception.MoneyWeightedReturn.Valid)
+	assert.True(t, inception.MoneyWeightedReturn.Decimal.IsPositive())
+	//the periods starting before the inception are the same as the one since inception
+	assert.Equal(t, inception.TimeWeightedReturn, periods[analytics.Period1M].TimeWeightedReturn)
+
+	day := periods[analytics.Period1D]
+	assert.Equal(t, today.AddDate(0, 0, -1), day.From)
+	assert.Equal(t, "0.003238", day.TimeWeightedReturn.String())
+
+	require.Len(t, performance.Holdings, 1)
+	holding := performance.Holdings[0]
+	assert.Equal(t, "AAPL", holding.Ticker)
+	assert.Equal(t, "550", holding.Value.String())
+	for _, period := range holding.Periods {
+		switch period.Period {
+		case analytics.PeriodInception:
+			//bought for 501, commission included
+			assert.Equal(t, "501", period.NetContributions.String())
+			assert.Equal(t, "0.097804", period.TimeWeightedReturn.String())
+		case analytics.Period1W:
+			assert.Equal(t, "515", period.StartValue.String())
+			assert.Equal(t, "0.067961", period.TimeWeightedReturn.String())
+			//without flows, both returns are the same
+			assert.Equal(t, period.TimeWeightedReturn, period.MoneyWeightedReturn.Decimal)
+		}
+	}
+}
+
+func TestXIRR(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		flows         []analytics.CashFlow
+		expectedRate  float64
+		expectedError bool
+	}{
+		"it should return the rate of a single investment": {
+			flows: []analytics.CashFlow{
+				{Date: start, Amount: -1000},
+				{Date: start.AddDate(0, 0, 365), Amount: 1100},
+			},
+			expectedRate: 0.1,
+		},
+		"it should weigh the flows by the time they were invested for": {
+			flows: []analytics.CashFlow{
+				{Date: start, Amount: -1000},
+				{Date: start.AddDate(0, 0, 365), Amount: -1000},
+				{Date: start.AddDate(0, 0, 730), Amount: 2310},
+			},
+			expectedRate: 0.1,
+		},
+		"it should return a loss": {
+			flows: []analytics.CashFlow{
+				{Date: start, Amount: -1000},
+				{Date: start.AddDate(0, 0, 365), Amount: 500},
+			},
+			expectedRate: -0.5,
+		},
+		"it should annualize the return of a few days": {
+			flows: []analytics.CashFlow{
+				{Date: start, Amount: -1000},
+				{Date: start.AddDate(0, 0, 1), Amount: 1050},
+			},
+			expectedRate: math.Pow(1.05, 365) - 1,
+		},
+		"it should return ErrNoRate when nothing is received": {
+			flows: []analytics.CashFlow{
+				{Date: start, Amount: -1000},
+				{Date: start.AddDate(0, 0, 365), Amount: -100},
+			},
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			rate, err := analytics.XIRR(testCase.flows)
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, analytics.ErrNoRate)
+				return
+			}
+			require.NoError(t, err)
+			assert.InEpsilon(t, testCase.expectedRate, rate, 1e-6)
+		})
+	}
+}
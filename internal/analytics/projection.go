@@ -0,0 +1,518 @@
+package analytics
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// ProjectionMethod is how the daily returns of the simulated paths are drawn from the historical ones.
+type ProjectionMethod string
+
+const (
+	// ProjectionBootstrap draws the returns of all the holdings of a whole historical day at a time, so that
+	// the correlations, and the fat tails, are kept as they were.
+	ProjectionBootstrap ProjectionMethod = "bootstrap"
+	// ProjectionNormal draws the returns from the multivariate normal distribution with the historical means
+	// and covariances.
+	ProjectionNormal ProjectionMethod = "normal"
+)
+
+const (
+	// DefaultProjectionHorizon is the number of the days projected by default.
+	DefaultProjectionHorizon = 365
+	// MaxProjectionHorizon limits the horizon, roughly 10 years.
+	MaxProjectionHorizon = 3650
+	// DefaultProjectionPaths is the number of the paths simulated by default.
+	DefaultProjectionPaths = 1000
+	// MaxProjectionPaths limits the number of the paths.
+	MaxProjectionPaths = 10000
+	// MaxProjectionSteps limits the number of the simulated days of all the paths together.
+	MaxProjectionSteps = 5_000_000
+	// DefaultProjectionWindow is the number of the historical daily returns the paths are drawn from by default.
+	DefaultProjectionWindow = 252
+	// MaxProjectionWindow limits the window, roughly 10 years of trading days.
+	MaxProjectionWindow = 2520
+	// maxPickedSeed keeps the picked seeds exact in the JSON clients, which read the numbers as doubles.
+	maxPickedSeed = 1 << 53
+)
+
+// ProjectionRequest configures the simulation of the portfolio's value over the horizon, in days from today.
+// The contribution is added at the end of every contribution interval, and invested like the holdings are
+// today. The same seed gives the same paths, and one is picked when it's zero. The goal is optional.
+type ProjectionRequest struct {
+	Method               ProjectionMethod
+	Horizon              int
+	Paths                int
+	Seed                 int64
+	Window               int
+	Contribution         decimal.Decimal
+	ContributionInterval Interval
+	Goal                 decimal.NullDecimal
+}
+
+// ProjectionPoint is the 5th, 25th, 50th, 75th and 95th percentiles of the simulated values of the portfolio
+// at the end of the day, together with the contributions made so far.
+type ProjectionPoint struct {
+	Date          time.Time
+	Contributions decimal.Decimal
+	P5            decimal.Decimal
+	P25           decimal.Decimal
+	P50           decimal.Decimal
+	P75           decimal.Decimal
+	P95           decimal.Decimal
+}
+
+func (p ProjectionPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Date          string `json:"date"`
+		Contributions string `json:"contributions"`
+		P5            string `json:"p5"`
+		P25           string `json:"p25"`
+		P50           string `json:"p50"`
+		P75           string `json:"p75"`
+		P95           string `json:"p95"`
+	}{
+		Date:          p.Date.Format(time.DateOnly),
+		Contributions: p.Contributions.StringFixed(ljlib.CashPlaces),
+		P5:            p.P5.StringFixed(ljlib.CashPlaces),
+		P25:           p.P25.StringFixed(ljlib.CashPlaces),
+		P50:           p.P50.StringFixed(ljlib.CashPlaces),
+		P75:           p.P75.StringFixed(ljlib.CashPlaces),
+		P95:           p.P95.StringFixed(ljlib.CashPlaces),
+	})
+}
+
+// Projection is the simulated value of the portfolio over the horizon: a point per day for up to 3 months,
+// per week for up to 2 years and per month for longer, with the last day always included. Observations is
+// the number of the historical days the returns were drawn from. GoalProbability is the share of the paths
+// which reached the goal at any time, and GoalProbabilityAtEnd the share of the ones above it at the end.
+type Projection struct {
+	AsOf                 time.Time
+	Method               ProjectionMethod
+	Horizon              int
+	Paths                int
+	Seed                 int64
+	Window               int
+	Observations         int
+	Value                decimal.Decimal
+	Contribution         decimal.Decimal
+	ContributionInterval Interval
+	Goal                 decimal.NullDecimal
+	GoalProbability      decimal.NullDecimal
+	GoalProbabilityAtEnd decimal.NullDecimal
+	Points               []ProjectionPoint
+}
+
+func (p Projection) MarshalJSON() ([]byte, error) {
+	points := p.Points
+	if points == nil {
+		points = []ProjectionPoint{}
+	}
+	return json.Marshal(struct {
+		AsOf                 string            `json:"as_of"`
+		Method               ProjectionMethod  `json:"method"`
+		Horizon              int               `json:"horizon"`
+		Paths                int               `json:"paths"`
+		Seed                 int64             `json:"seed"`
+		Window               int               `json:"window"`
+		Observations         int               `json:"observations"`
+		Value                string            `json:"value"`
+		Contribution         string            `json:"contribution"`
+		ContributionInterval Interval          `json:"contribution_interval,omitempty"`
+		Goal                 *string           `json:"goal"`
+		GoalProbability      *string           `json:"goal_probability"`
+		GoalProbabilityAtEnd *string           `json:"goal_probability_at_end"`
+		Points               []ProjectionPoint `json:"points"`
+	}{
+		AsOf:                 p.AsOf.Format(time.DateOnly),
+		Method:               p.Method,
+		Horizon:              p.Horizon,
+		Paths:                p.Paths,
+		Seed:                 p.Seed,
+		Window:               p.Window,
+		Observations:         p.Observations,
+		Value:                p.Value.StringFixed(ljlib.CashPlaces),
+		Contribution:         p.Contribution.StringFixed(ljlib.CashPlaces),
+		ContributionInterval: p.ContributionInterval,
+		Goal:                 optionalAmount(p.Goal),
+		GoalProbability:      optionalReturn(p.GoalProbability),
+		GoalProbabilityAtEnd: optionalReturn(p.GoalProbabilityAtEnd),
+		Points:               points,
+	})
+}
+
+// GetProjection simulates the value of the user's portfolio over the horizon, drawing the daily returns
+// of the holdings from their returns over the window. The cash doesn't earn anything, and the holdings
+// are not rebalanced, so they drift apart like they would without trading.
+func (s *Service) GetProjection(userID uuid.UUID, request ProjectionRequest) (Projection, error) {
+	request, err := normalizeProjectionRequest(request)
+	if err != nil {
+		return Projection{}, err
+	}
+	today := startOfDay(s.clock.Now())
+	if request.Seed == 0 {
+		request.Seed = s.clock.Now().UnixNano() % maxPickedSeed
+	}
+	transactions, err := s.getTransactions(userID)
+	if err != nil {
+		return Projection{}, err
+	}
+	valuations, err := s.getDailyValuations(transactions, today, today)
+	if err != nil {
+		return Projection{}, err
+	}
+	valuation := valuations[len(valuations)-1]
+	var tickers []string
+	for ticker, holding := range valuation.holdings {
+		if !holding.quantity.IsZero() {
+			tickers = append(tickers, ticker)
+		}
+	}
+	sort.Strings(tickers)
+
+	histories, err := s.getPriceHistories(tickers, returnsHistoryStart(today, request.Window), today)
+	if err != nil {
+		return Projection{}, err
+	}
+	returns := jointReturns(histories, tickers, request.Window)
+	if len(tickers) > 0 && len(returns) < 2 {
+		return Projection{}, ljlib.NewIllegalArgumentError("the holdings don't have enough price history " +
+			"in common for the window")
+	}
+	holdings := make([]float64, 0, len(tickers))
+	for _, ticker := range tickers {
+		holdings = append(holdings, valuation.holdings[ticker].value.InexactFloat64())
+	}
+
+	simulation := simulation{
+		request:  request,
+		start:    today,
+		cash:     valuation.Cash.InexactFloat64(),
+		holdings: holdings,
+		returns:  returns,
+		random:   rand.New(rand.NewSource(request.Seed)),
+	}
+	projection := simulation.run()
+	projection.AsOf = today
+	projection.Observations = len(returns)
+	projection.Value = valuation.TotalValue
+	return projection, nil
+}
+
+func normalizeProjectionRequest(request ProjectionRequest) (ProjectionRequest, error) {
+	switch request.Method {
+	case "":
+		request.Method = ProjectionBootstrap
+	case ProjectionBootstrap, ProjectionNormal:
+	default:
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("method must be one of %s and %s",
+			ProjectionBootstrap, ProjectionNormal)
+	}
+	if request.Horizon == 0 {
+		request.Horizon = DefaultProjectionHorizon
+	}
+	if request.Horizon < 1 || request.Horizon > MaxProjectionHorizon {
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("horizon must be between 1 and %d days",
+			MaxProjectionHorizon)
+	}
+	if request.Paths == 0 {
+		request.Paths = DefaultProjectionPaths
+	}
+	if request.Paths < 1 || request.Paths > MaxProjectionPaths {
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("paths must be between 1 and %d",
+			MaxProjectionPaths)
+	}
+	if request.Paths*request.Horizon > MaxProjectionSteps {
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("paths times horizon cannot be more than %d",
+			MaxProjectionSteps)
+	}
+	if request.Window == 0 {
+		request.Window = DefaultProjectionWindow
+	}
+	if request.Window < 2 || request.Window > MaxProjectionWindow {
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("window must be between 2 and %d",
+			MaxProjectionWindow)
+	}
+	if request.Contribution.IsNegative() {
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("contribution cannot be negative")
+	}
+	if request.Contribution.IsZero() {
+		request.ContributionInterval = ""
+	} else {
+		switch request.ContributionInterval {
+		case "":
+			request.ContributionInterval = IntervalMonth
+		case IntervalDay, IntervalWeek, IntervalMonth:
+		default:
+			return ProjectionRequest{}, ljlib.NewIllegalArgumentError("contribution interval must be one of "+
+				"%s, %s and %s", IntervalDay, IntervalWeek, IntervalMonth)
+		}
+	}
+	if request.Goal.Valid && !request.Goal.Decimal.IsPositive() {
+		return ProjectionRequest{}, ljlib.NewIllegalArgumentError("goal must be positive")
+	}
+	return request, nil
+}
+
+// jointReturns returns the daily returns of the tickers, in their order, over the last window dates all of them
+// have a price for, the oldest first.
+func jointReturns(histories map[string]priceHistory, tickers []string, window int) [][]float64 {
+	if len(tickers) == 0 {
+		return nil
+	}
+	var dates []string
+	for date, price := range histories[tickers[0]] {
+		common := price.IsPositive()
+		for _, ticker := range tickers[1:] {
+			other, ok := histories[ticker][date]
+			common = common && ok && other.IsPositive()
+		}
+		if common {
+			dates = append(dates, date)
+		}
+	}
+	//the dates as YYYY-MM-DD sort chronologically
+	sort.Strings(dates)
+	if len(dates) > window+1 {
+		dates = dates[len(dates)-window-1:]
+	}
+	returns := make([][]float64, 0, len(dates))
+	for i := 1; i < len(dates); i++ {
+		row := make([]float64, 0, len(tickers))
+		for _, ticker := range tickers {
+			history := histories[ticker]
+			row = append(row, history[dates[i]].InexactFloat64()/history[dates[i-1]].InexactFloat64()-1)
+		}
+		returns = append(returns, row)
+	}
+	return returns
+}
+
+// simulation runs the paths of a projection. The holdings are the values of the positions, negative for
+// the short ones, and the returns are the historical daily returns of all of them, a row per day.
+type simulation struct {
+	request  ProjectionRequest
+	start    time.Time
+	cash     float64
+	holdings []float64
+	returns  [][]float64
+	random   *rand.Rand
+	//means and cholesky are the parameters of the normal distribution of the returns
+	means    []float64
+	cholesky [][]float64
+}
+
+func (s *simulation) run() Projection {
+	request := s.request
+	projection := Projection{
+		Method:               request.Method,
+		Horizon:              request.Horizon,
+		Paths:                request.Paths,
+		Seed:                 request.Seed,
+		Window:               request.Window,
+		Contribution:         request.Contribution,
+		ContributionInterval: request.ContributionInterval,
+		Goal:                 request.Goal,
+	}
+	if request.Method == ProjectionNormal && len(s.holdings) > 0 {
+		s.means, s.cholesky = normalParameters(s.returns)
+	}
+
+	//the contributions are invested like the long holdings are today, or kept in cash without any
+	weights := make([]float64, len(s.holdings))
+	var long float64
+	for _, value := range s.holdings {
+		long += math.Max(value, 0)
+	}
+	for i, value := range s.holdings {
+		if long > 0 {
+			weights[i] = math.Max(value, 0) / long
+		}
+	}
+
+	interval := projectionPointInterval(request.Horizon)
+	var dates []time.Time
+	var contributions []decimal.Decimal
+	var contributed decimal.Decimal
+	//the contributions and the points by the day of the horizon, so the paths don't deal with the dates
+	contributionDays := make([]bool, request.Horizon+1)
+	pointDays := make([]bool, request.Horizon+1)
+	for day := 1; day <= request.Horizon; day++ {
+		date := s.start.AddDate(0, 0, day)
+		if len(request.ContributionInterval) > 0 && isPeriodEnd(date, request.ContributionInterval) {
+			contributionDays[day] = true
+			contributed = contributed.Add(request.Contribution)
+		}
+		if day == request.Horizon || isPeriodEnd(date, interval) {
+			pointDays[day] = true
+			dates = append(dates, date)
+			contributions = append(contributions, contributed)
+		}
+	}
+
+	contribution := request.Contribution.InexactFloat64()
+	goal := request.Goal.Decimal.InexactFloat64()
+	values := make([][]float64, len(dates))
+	var reached, reachedAtEnd int
+	holdings := make([]float64, len(s.holdings))
+	for path := 0; path < request.Paths; path++ {
+		copy(holdings, s.holdings)
+		cash := s.cash
+		point := 0
+		pathReached := false
+		for day := 1; day <= request.Horizon; day++ {
+			dayReturns := s.draw()
+			value := cash
+			for i := range holdings {
+				holdings[i] *= 1 + dayReturns[i]
+				if contributionDays[day] && long > 0 {
+					holdings[i] += contribution * weights[i]
+				}
+				value += holdings[i]
+			}
+			if contributionDays[day] {
+				if long == 0 {
+					cash += contribution
+				}
+				value += contribution
+			}
+			pathReached = pathReached || value >= goal
+			if pointDays[day] {
+				values[point] = append(values[point], value)
+				point++
+			}
+		}
+		if pathReached {
+			reached++
+		}
+		if values[len(values)-1][path] >= goal {
+			reachedAtEnd++
+		}
+	}
+
+	for i, date := range dates {
+		sort.Float64s(values[i])
+		projection.Points = append(projection.Points, ProjectionPoint{
+			Date:          date,
+			Contributions: contributions[i],
+			P5:            percentile(values[i], 5),
+			P25:           percentile(values[i], 25),
+			P50:           percentile(values[i], 50),
+			P75:           percentile(values[i], 75),
+			P95:           percentile(values[i], 95),
+		})
+	}
+	if request.Goal.Valid {
+		paths := decimal.NewFromInt(int64(request.Paths))
+		projection.GoalProbability = decimal.NewNullDecimal(decimal.NewFromInt(int64(reached)).Div(paths).
+			Round(ReturnPlaces))
+		projection.GoalProbabilityAtEnd = decimal.NewNullDecimal(decimal.NewFromInt(int64(reachedAtEnd)).Div(paths).
+			Round(ReturnPlaces))
+	}
+	return projection
+}
+
+// draw returns the daily returns of the holdings for the next simulated day. A return can't lose more than
+// everything.
+func (s *simulation) draw() []float64 {
+	if len(s.holdings) == 0 {
+		return nil
+	}
+	if s.request.Method == ProjectionBootstrap {
+		return s.returns[s.random.Intn(len(s.returns))]
+	}
+	normals := make([]float64, len(s.means))
+	for i := range normals {
+		normals[i] = s.random.NormFloat64()
+	}
+	returns := make([]float64, len(s.means))
+	for i := range returns {
+		returns[i] = s.means[i]
+		for j := 0; j <= i; j++ {
+			returns[i] += s.cholesky[i][j] * normals[j]
+		}
+		returns[i] = math.Max(returns[i], -1)
+	}
+	return returns
+}
+
+// normalParameters returns the means of the returns, and the Cholesky decomposition of their sample covariance
+// matrix. The covariance matrix of the holdings which move together is only semi-definite, so the dependent
+// columns are left out.
+func normalParameters(returns [][]float64) ([]float64, [][]float64) {
+	size := len(returns[0])
+	means := make([]float64, size)
+	for _, row := range returns {
+		for i, value := range row {
+			means[i] += value / float64(len(returns))
+		}
+	}
+	covariances := make([][]float64, size)
+	for i := range covariances {
+		covariances[i] = make([]float64, size)
+		for j := range covariances[i] {
+			for _, row := range returns {
+				covariances[i][j] += (row[i] - means[i]) * (row[j] - means[j])
+			}
+			covariances[i][j] /= float64(len(returns) - 1)
+		}
+	}
+
+	cholesky := make([][]float64, size)
+	for i := range cholesky {
+		cholesky[i] = make([]float64, size)
+	}
+	for j := 0; j < size; j++ {
+		diagonal := covariances[j][j]
+		for k := 0; k < j; k++ {
+			diagonal -= cholesky[j][k] * cholesky[j][k]
+		}
+		if diagonal <= 1e-14*math.Max(covariances[j][j], 1e-300) {
+			continue
+		}
+		cholesky[j][j] = math.Sqrt(diagonal)
+		for i := j + 1; i < size; i++ {
+			value := covariances[i][j]
+			for k := 0; k < j; k++ {
+				value -= cholesky[i][k] * cholesky[j][k]
+			}
+			cholesky[i][j] = value / cholesky[j][j]
+		}
+	}
+	return means, cholesky
+}
+
+// projectionPointInterval is the interval of the points of a projection over the horizon.
+func projectionPointInterval(horizon int) Interval {
+	switch {
+	case horizon <= 92:
+		return IntervalDay
+	case horizon <= 731:
+		return IntervalWeek
+	}
+	return IntervalMonth
+}
+
+// percentile interpolates the percentile between the closest ranks of the sorted values.
+func percentile(sorted []float64, p float64) decimal.Decimal {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	value := sorted[len(sorted)-1]
+	if lower+1 < len(sorted) {
+		value = sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+	}
+	return decimal.NewFromFloat(value).Round(ljlib.CashPlaces)
+}
+
+// returnsHistoryStart is the first day of the price history loaded for the window of the daily returns ending
+// today. The history may miss the days the market was closed, so it's loaded with a margin for them.
+func returnsHistoryStart(today time.Time, window int) time.Time {
+	return today.AddDate(0, 0, -window*7/5-7)
+}
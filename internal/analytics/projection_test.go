@@ -0,0 +1,174 @@
+package analytics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_GetProjection(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "MSFT", 2, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+	}
+	testCases := map[string]struct {
+		method         analytics.ProjectionMethod
+		horizon        int
+		expectedPoints int
+	}{
+		"it should project the daily points of a short horizon by resampling the historical days": {
+			horizon:        30,
+			expectedPoints: 30,
+		},
+		"it should project the weekly points of a longer horizon": {
+			horizon: 365,
+			//the 52 Sundays and the last day
+			expectedPoints: 53,
+		},
+		"it should project the portfolio with the normal returns": {
+			method:         analytics.ProjectionNormal,
+			horizon:        30,
+			expectedPoints: 30,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			now := testStart.AddDate(1, 0, 0)
+			service := newTestService(t, ledger, &mockPriceSource{}, now, 4)
+
+			projection, err := service.GetProjection(testUserID, analytics.ProjectionRequest{
+				Method:  testCase.method,
+				Horizon: testCase.horizon,
+				Paths:   200,
+				Seed:    42,
+				Window:  60,
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, 60, projection.Observations)
+			//the AAPL and MSFT at 465, and the cash left after the commissions
+			assert.Equal(t, "3553", projection.Value.String())
+			require.Len(t, projection.Points, testCase.expectedPoints)
+			last := projection.Points[len(projection.Points)-1]
+			assert.Equal(t, now.AddDate(0, 0, testCase.horizon), last.Date)
+			for _, point := range projection.Points {
+				assert.True(t, point.P5.LessThanOrEqual(point.P25), point.Date)
+				assert.True(t, point.P25.LessThanOrEqual(point.P50), point.Date)
+				assert.True(t, point.P50.LessThanOrEqual(point.P75), point.Date)
+				assert.True(t, point.P75.LessThanOrEqual(point.P95), point.Date)
+			}
+			//the mock prices have only grown
+			assert.True(t, last.P5.GreaterThan(projection.Value))
+			assert.False(t, projection.GoalProbability.Valid)
+		})
+	}
+}
+
+func TestService_GetProjection_Seed(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+	}
+	now := testStart.AddDate(1, 0, 0)
+	service := newTestService(t, ledger, &mockPriceSource{}, now, 4)
+	request := analytics.ProjectionRequest{Horizon: 90, Paths: 100, Seed: 7, Window: 30}
+
+	projection, err := service.GetProjection(testUserID, request)
+	require.NoError(t, err)
+	replayed, err := service.GetProjection(testUserID, request)
+	require.NoError(t, err)
+	assert.Equal(t, projection, replayed, "the same seed should give the same paths")
+
+	request.Seed = 0
+	picked, err := service.GetProjection(testUserID, request)
+	require.NoError(t, err)
+	assert.Equal(t, now.UnixNano()%(1<<53), picked.Seed)
+}
+
+func TestService_GetProjection_Contributions(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+	}
+	testCases := map[string]struct {
+		goal                      string
+		expectedGoalProbability   string
+		expectedProbabilityAtEnd  string
+		expectedFinalContribution string
+	}{
+		"it should reach the goal below the final value": {
+			goal:                      "1300",
+			expectedGoalProbability:   "1",
+			expectedProbabilityAtEnd:  "1",
+			expectedFinalContribution: "300",
+		},
+		"it should not reach the goal above the final value": {
+			goal:                      "1300.01",
+			expectedGoalProbability:   "0",
+			expectedProbabilityAtEnd:  "0",
+			expectedFinalContribution: "300",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			//the projection starts on 2023-12-31, so it covers the ends of January, February and March
+			service := newTestService(t, ledger, &mockPriceSource{}, testStart.AddDate(0, 0, 364), 4)
+
+			projection, err := service.GetProjection(testUserID, analytics.ProjectionRequest{
+				Horizon:      91,
+				Paths:        10,
+				Seed:         1,
+				Contribution: decimal.NewFromInt(100),
+				Goal:         decimal.NewNullDecimal(decimal.RequireFromString(testCase.goal)),
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, analytics.IntervalMonth, projection.ContributionInterval)
+			last := projection.Points[len(projection.Points)-1]
+			assert.Equal(t, testCase.expectedFinalContribution, last.Contributions.String())
+			//the cash doesn't earn anything, so every path is the cash and the contributions
+			assert.Equal(t, "1300", last.P5.String())
+			assert.Equal(t, "1300", last.P95.String())
+			assert.Equal(t, testCase.expectedGoalProbability, projection.GoalProbability.Decimal.String())
+			assert.Equal(t, testCase.expectedProbabilityAtEnd, projection.GoalProbabilityAtEnd.Decimal.String())
+		})
+	}
+}
+
+func TestService_GetProjection_InvalidRequest(t *testing.T) {
+	testCases := map[string]analytics.ProjectionRequest{
+		"it should return IllegalArgumentError for an unknown method": {Method: "historical"},
+		"it should return IllegalArgumentError for a horizon which is too long": {
+			Horizon: analytics.MaxProjectionHorizon + 1,
+		},
+		"it should return IllegalArgumentError for too many paths": {Paths: analytics.MaxProjectionPaths + 1},
+		"it should return IllegalArgumentError for too many simulated days": {
+			Horizon: analytics.MaxProjectionHorizon,
+			Paths:   analytics.MaxProjectionPaths,
+		},
+		"it should return IllegalArgumentError for a window which is too short": {Window: 1},
+		"it should return IllegalArgumentError for a negative contribution": {
+			Contribution: decimal.NewFromInt(-1),
+		},
+		"it should return IllegalArgumentError for an unknown contribution interval": {
+			Contribution:         decimal.NewFromInt(1),
+			ContributionInterval: "year",
+		},
+		"it should return IllegalArgumentError for a goal which isn't positive": {
+			Goal: decimal.NewNullDecimal(decimal.Zero),
+		},
+	}
+	for testName, request := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			service := newTestService(t, nil, &mockPriceSource{}, testStart.AddDate(1, 0, 0), 4)
+
+			_, err := service.GetProjection(testUserID, request)
+			assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	xirrTolerance     = 1e-9
+	xirrMaxIterations = 200
+	//xirrMaxRate bounds the search of the rate: an annual return of 1e12 is only reached by annualizing
+	//an extreme return over a few days.
+	xirrMaxRate = 1e12
+)
+
+// ErrNoRate is returned by XIRR when the cash flows have no rate of return, e.g. they're all of the same sign.
+var ErrNoRate = errors.New("cash flows have no rate of return")
+
+// CashFlow is an amount invested into the portfolio (negative) or received from it (positive) on the date.
+// The value of the portfolio at the start of a period counts as invested, and at its end as received.
+type CashFlow struct {
+	Date   time.Time
+	Amount float64
+}
+
+// XIRR solves the annual rate at which the net present value of the cash flows is zero: the money-weighted
+// return. Newton's method is tried first, falling back to bisection when it doesn't converge.
+func XIRR(flows []CashFlow) (float64, error) {
+	var hasInvested, hasReceived bool
+	for _, flow := range flows {
+		hasInvested = hasInvested || flow.Amount < 0
+		hasReceived = hasReceived || flow.Amount > 0
+	}
+	if !hasInvested || !hasReceived {
+		return 0, ErrNoRate
+	}
+	start := flows[0].Date
+	for _, flow := range flows {
+		if flow.Date.Before(start) {
+			start = flow.Date
+		}
+	}
+	npv := func(rate float64) (value float64, derivative float64) {
+		for _, flow := range flows {
+			years := flow.Date.Sub(start).Hours() / 24 / 365
+			discount := math.Pow(1+rate, years)
+			value += flow.Amount / discount
+			derivative -= years * flow.Amount / (discount * (1 + rate))
+		}
+		return value, derivative
+	}
+
+	rate := 0.1
+	for i := 0; i < xirrMaxIterations; i++ {
+		value, derivative := npv(rate)
+		if math.Abs(value) < xirrTolerance {
+			return rate, nil
+		}
+		if derivative == 0 || math.IsNaN(value) {
+			break
+		}
+		next := rate - value/derivative
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < xirrTolerance {
+			return next, nil
+		}
+		rate = next
+	}
+	return bisectXIRR(func(rate float64) float64 {
+		value, _ := npv(rate)
+		return value
+	})
+}
+
+// bisectXIRR looks for the root of the net present value between -100% and xirrMaxRate.
+func bisectXIRR(npv func(rate float64) float64) (float64, error) {
+	low, high := -1+xirrTolerance, 1.0
+	for npv(low)*npv(high) > 0 {
+		if high >= xirrMaxRate {
+			return 0, ErrNoRate
+		}
+		high *= 10
+	}
+	for i := 0; i < xirrMaxIterations*5 && high-low > xirrTolerance*math.Max(1, math.Abs(low)); i++ {
+		middle := (low + high) / 2
+		if npv(low)*npv(middle) <= 0 {
+			high = middle
+		} else {
+			low = middle
+		}
+	}
+	return (low + high) / 2, nil
+}
+
+// linkedReturn chain-links the daily returns of a series of values: the flows into it are assumed to happen
+// at the start of the day, and the flows out of it at the end, so that neither of them counts as a return.
+// Days starting with nothing invested are skipped.
+type linkedReturn struct {
+	growth float64
+}
+
+func newLinkedReturn() linkedReturn {
+	return linkedReturn{growth: 1}
+}
+
+func (l *linkedReturn) add(previousValue float64, value float64, inflow float64, outflow float64) {
+	invested := previousValue + inflow
+	if invested <= 0 {
+		return
+	}
+	l.growth *= (value + outflow) / invested
+}
+
+func (l linkedReturn) value() float64 {
+	return l.growth - 1
+}
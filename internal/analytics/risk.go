@@ -0,0 +1,508 @@
+package analytics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// PeriodsPerYear annualizes the daily figures: the price history has a price for every calendar day.
+const PeriodsPerYear = 365
+
+// VaRConfidenceLevels are the confidence levels the Value-at-Risk is reported at.
+var VaRConfidenceLevels = []float64{0.95, 0.99}
+
+// DailyReturn is the return from the price of the previous day to the price of the date.
+type DailyReturn struct {
+	Date  time.Time
+	Value float64
+}
+
+// DailyReturns returns the daily returns of the prices, in any order, the oldest first. The returns
+// from a price which is not positive are skipped.
+func DailyReturns(prices []ljlib.HistoricalPrice) []DailyReturn {
+	sorted := sortedPrices(prices)
+	var returns []DailyReturn
+	for i := 1; i < len(sorted); i++ {
+		previous := sorted[i-1].Price.InexactFloat64()
+		if previous <= 0 {
+			continue
+		}
+		returns = append(returns, DailyReturn{
+			Date:  sorted[i].Date,
+			Value: sorted[i].Price.InexactFloat64()/previous - 1,
+		})
+	}
+	return returns
+}
+
+// Volatility is the annualized standard deviation of the daily returns. It's not defined for less than
+// two returns.
+func Volatility(returns []DailyReturn) (float64, bool) {
+	deviation, ok := standardDeviation(returns)
+	if !ok {
+		return 0, false
+	}
+	return deviation * math.Sqrt(PeriodsPerYear), true
+}
+
+// Drawdown is a fall of the price from its peak to the following trough, as a ratio of the peak. Recovery is
+// the first date the price got back to the peak after the trough, and it's zero when it hasn't recovered yet.
+type Drawdown struct {
+	Depth    float64
+	Peak     time.Time
+	Trough   time.Time
+	Recovery time.Time
+}
+
+// MaxDrawdown returns the largest drawdown of the prices, in any order. The depth is zero when the prices
+// have never fallen.
+func MaxDrawdown(prices []ljlib.HistoricalPrice) Drawdown {
+	sorted := sortedPrices(prices)
+	var drawdown Drawdown
+	var peak ljlib.HistoricalPrice
+	for i, price := range sorted {
+		if i == 0 || price.Price.GreaterThan(peak.Price) {
+			peak = price
+			continue
+		}
+		if !peak.Price.IsPositive() {
+			continue
+		}
+		depth := 1 - price.Price.InexactFloat64()/peak.Price.InexactFloat64()
+		if depth > drawdown.Depth {
+			drawdown = Drawdown{Depth: depth, Peak: peak.Date, Trough: price.Date}
+		}
+	}
+	if drawdown.Depth == 0 {
+		return Drawdown{}
+	}
+	var peakPrice decimal.Decimal
+	for _, price := range sorted {
+		if price.Date.Equal(drawdown.Peak) {
+			peakPrice = price.Price
+		}
+		if price.Date.After(drawdown.Trough) && !price.Price.LessThan(peakPrice) {
+			drawdown.Recovery = price.Date
+			break
+		}
+	}
+	return drawdown
+}
+
+// SharpeRatio is the annualized mean of the daily returns in excess of the risk-free rate, per unit
+// of their volatility.
+func SharpeRatio(returns []DailyReturn, riskFreeRate float64) (float64, bool) {
+	deviation, ok := standardDeviation(returns)
+	if !ok || deviation == 0 {
+		return 0, false
+	}
+	return (mean(returns) - riskFreeRate/PeriodsPerYear) / deviation * math.Sqrt(PeriodsPerYear), true
+}
+
+// SortinoRatio is like the Sharpe ratio, but only penalizes the volatility of the returns below
+// the risk-free rate (the downside deviation).
+func SortinoRatio(returns []DailyReturn, riskFreeRate float64) (float64, bool) {
+	if len(returns) < 2 {
+		return 0, false
+	}
+	target := riskFreeRate / PeriodsPerYear
+	var squares float64
+	for _, r := range returns {
+		if shortfall := math.Min(r.Value-target, 0); shortfall < 0 {
+			squares += shortfall * shortfall
+		}
+	}
+	downside := math.Sqrt(squares / float64(len(returns)))
+	if downside == 0 {
+		return 0, false
+	}
+	return (mean(returns) - target) / downside * math.Sqrt(PeriodsPerYear), true
+}
+
+// Beta is the sensitivity of the returns to the returns of the benchmark: their covariance divided by
+// the variance of the benchmark, over the dates both have a return for.
+func Beta(returns []DailyReturn, benchmark []DailyReturn) (float64, bool) {
+	aligned, alignedBenchmark := alignReturns(returns, benchmark)
+	if len(aligned) < 2 {
+		return 0, false
+	}
+	mean, benchmarkMean := mean(aligned), mean(alignedBenchmark)
+	var covariance, variance float64
+	for i := range aligned {
+		covariance += (aligned[i].Value - mean) * (alignedBenchmark[i].Value - benchmarkMean)
+		variance += (alignedBenchmark[i].Value - benchmarkMean) * (alignedBenchmark[i].Value - benchmarkMean)
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return covariance / variance, true
+}
+
+// HistoricalVaR is the one-day loss, as a ratio, which the daily returns have exceeded only with the probability
+// of 1 - confidence, e.g. in 5% of the days for the confidence of 0.95.
+func HistoricalVaR(returns []DailyReturn, confidence float64) (float64, bool) {
+	if len(returns) == 0 {
+		return 0, false
+	}
+	values := make([]float64, 0, len(returns))
+	for _, r := range returns {
+		values = append(values, r.Value)
+	}
+	sort.Float64s(values)
+	index := int(math.Floor((1 - confidence) * float64(len(values))))
+	if index >= len(values) {
+		index = len(values) - 1
+	}
+	return math.Max(-values[index], 0), true
+}
+
+// ParametricVaR is the one-day loss at the confidence, assuming the daily returns are normally distributed
+// with their mean and standard deviation.
+func ParametricVaR(returns []DailyReturn, confidence float64) (float64, bool) {
+	deviation, ok := standardDeviation(returns)
+	if !ok {
+		return 0, false
+	}
+	z := math.Sqrt2 * math.Erfinv(2*confidence-1)
+	return math.Max(z*deviation-mean(returns), 0), true
+}
+
+// ValueAtRisk is the one-day loss at the confidence level, as a ratio and, for the portfolio,
+// as the amount of its current value.
+type ValueAtRisk struct {
+	Confidence       float64
+	Historical       decimal.NullDecimal
+	Parametric       decimal.NullDecimal
+	HistoricalAmount decimal.NullDecimal
+	ParametricAmount decimal.NullDecimal
+}
+
+func (v ValueAtRisk) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Confidence       float64 `json:"confidence"`
+		Historical       *string `json:"historical"`
+		Parametric       *string `json:"parametric"`
+		HistoricalAmount *string `json:"historical_amount,omitempty"`
+		ParametricAmount *string `json:"parametric_amount,omitempty"`
+	}{
+		Confidence:       v.Confidence,
+		Historical:       optionalReturn(v.Historical),
+		Parametric:       optionalReturn(v.Parametric),
+		HistoricalAmount: optionalAmount(v.HistoricalAmount),
+		ParametricAmount: optionalAmount(v.ParametricAmount),
+	})
+}
+
+// RiskMetrics are the risk figures of a price series. The ratios which are not defined for the series,
+// e.g. the Sharpe ratio of a series without volatility, are not set.
+type RiskMetrics struct {
+	Observations int
+	Volatility   decimal.NullDecimal
+	MaxDrawdown  Drawdown
+	SharpeRatio  decimal.NullDecimal
+	SortinoRatio decimal.NullDecimal
+	Beta         decimal.NullDecimal
+	ValueAtRisk  []ValueAtRisk
+}
+
+// ComputeRisk computes the risk figures of the prices, with the beta against the prices of the benchmark.
+func ComputeRisk(prices []ljlib.HistoricalPrice, benchmark []ljlib.HistoricalPrice, riskFreeRate float64) RiskMetrics {
+	returns := DailyReturns(prices)
+	metrics := RiskMetrics{
+		Observations: len(returns),
+		Volatility:   nullRatio(Volatility(returns)),
+		MaxDrawdown:  MaxDrawdown(prices),
+		SharpeRatio:  nullRatio(SharpeRatio(returns, riskFreeRate)),
+		SortinoRatio: nullRatio(SortinoRatio(returns, riskFreeRate)),
+		Beta:         nullRatio(Beta(returns, DailyReturns(benchmark))),
+	}
+	for _, confidence := range VaRConfidenceLevels {
+		metrics.ValueAtRisk = append(metrics.ValueAtRisk, ValueAtRisk{
+			Confidence: confidence,
+			Historical: nullRatio(HistoricalVaR(returns, confidence)),
+			Parametric: nullRatio(ParametricVaR(returns, confidence)),
+		})
+	}
+	return metrics
+}
+
+// BenchmarkRequest is the range of the prices, like in HistoryRequest, and the benchmark to measure them against,
+// e.g. for the beta. The config's benchmark is used when it's not set.
+type BenchmarkRequest struct {
+	From      time.Time
+	To        time.Time
+	Benchmark string
+}
+
+// RiskReport is the risk of a ticker, or of the portfolio, over the range. The portfolio also has its current
+// value, which the Value-at-Risk amounts are computed from.
+type RiskReport struct {
+	Ticker    string
+	From      time.Time
+	To        time.Time
+	Benchmark string
+	Value     decimal.NullDecimal
+	Metrics   RiskMetrics
+}
+
+func (r RiskReport) MarshalJSON() ([]byte, error) {
+	valueAtRisk := r.Metrics.ValueAtRisk
+	if valueAtRisk == nil {
+		valueAtRisk = []ValueAtRisk{}
+	}
+	type drawdown struct {
+		Depth    string  `json:"depth"`
+		Peak     *string `json:"peak_date"`
+		Trough   *string `json:"trough_date"`
+		Recovery *string `json:"recovery_date"`
+	}
+	return json.Marshal(struct {
+		Ticker       string        `json:"ticker,omitempty"`
+		From         string        `json:"from"`
+		To           string        `json:"to"`
+		Benchmark    string        `json:"benchmark"`
+		Value        *string       `json:"value,omitempty"`
+		Observations int           `json:"observations"`
+		Volatility   *string       `json:"volatility"`
+		MaxDrawdown  drawdown      `json:"max_drawdown"`
+		SharpeRatio  *string       `json:"sharpe_ratio"`
+		SortinoRatio *string       `json:"sortino_ratio"`
+		Beta         *string       `json:"beta"`
+		ValueAtRisk  []ValueAtRisk `json:"value_at_risk"`
+	}{
+		Ticker:       r.Ticker,
+		From:         r.From.Format(time.DateOnly),
+		To:           r.To.Format(time.DateOnly),
+		Benchmark:    r.Benchmark,
+		Value:        optionalAmount(r.Value),
+		Observations: r.Metrics.Observations,
+		Volatility:   optionalReturn(r.Metrics.Volatility),
+		MaxDrawdown: drawdown{
+			Depth:    decimal.NewFromFloat(r.Metrics.MaxDrawdown.Depth).Round(ReturnPlaces).String(),
+			Peak:     optionalDate(r.Metrics.MaxDrawdown.Peak),
+			Trough:   optionalDate(r.Metrics.MaxDrawdown.Trough),
+			Recovery: optionalDate(r.Metrics.MaxDrawdown.Recovery),
+		},
+		SharpeRatio:  optionalReturn(r.Metrics.SharpeRatio),
+		SortinoRatio: optionalReturn(r.Metrics.SortinoRatio),
+		Beta:         optionalReturn(r.Metrics.Beta),
+		ValueAtRisk:  valueAtRisk,
+	})
+}
+
+// GetTickerRisk returns the risk of the ticker over the range, computed from its daily prices.
+func (s *Service) GetTickerRisk(ticker string, request BenchmarkRequest) (RiskReport, error) {
+	request, err := s.normalizeBenchmarkRequest(request)
+	if err != nil {
+		return RiskReport{}, err
+	}
+	prices, benchmark, err := s.getPricesWithBenchmark(ticker, request)
+	if err != nil {
+		return RiskReport{}, err
+	}
+	return RiskReport{
+		Ticker:    ticker,
+		From:      request.From,
+		To:        request.To,
+		Benchmark: request.Benchmark,
+		Metrics:   ComputeRisk(prices, benchmark, s.config.RiskFreeRate),
+	}, nil
+}
+
+// GetPortfolioRisk returns the risk of the user's portfolio over the range. The daily values are chain-linked
+// into an index, like the time-weighted return, so that the deposits and withdrawals don't count
+// as the returns, and the days before anything was invested are skipped.
+func (s *Service) GetPortfolioRisk(userID uuid.UUID, request BenchmarkRequest) (RiskReport, error) {
+	request, err := s.normalizeBenchmarkRequest(request)
+	if err != nil {
+		return RiskReport{}, err
+	}
+	index, value, err := s.getPortfolioIndex(userID, request.From, request.To)
+	if err != nil {
+		return RiskReport{}, err
+	}
+	_, benchmark, err := s.getPricesWithBenchmark("", request)
+	if err != nil {
+		return RiskReport{}, err
+	}
+
+	metrics := ComputeRisk(index, benchmark, s.config.RiskFreeRate)
+	for i, valueAtRisk := range metrics.ValueAtRisk {
+		metrics.ValueAtRisk[i].HistoricalAmount = riskAmount(valueAtRisk.Historical, value)
+		metrics.ValueAtRisk[i].ParametricAmount = riskAmount(valueAtRisk.Parametric, value)
+	}
+	return RiskReport{
+		From:      request.From,
+		To:        request.To,
+		Benchmark: request.Benchmark,
+		Value:     decimal.NewNullDecimal(value),
+		Metrics:   metrics,
+	}, nil
+}
+
+func (s *Service) normalizeBenchmarkRequest(request BenchmarkRequest) (BenchmarkRequest, error) {
+	var err error
+	request.From, request.To, err = s.normalizeRange(request.From, request.To)
+	if err != nil {
+		return BenchmarkRequest{}, err
+	}
+	if len(request.Benchmark) == 0 {
+		request.Benchmark = s.config.Benchmark
+	}
+	return request, nil
+}
+
+// getPricesWithBenchmark loads the prices of the ticker, unless it's empty, and of the benchmark.
+func (s *Service) getPricesWithBenchmark(ticker string, request BenchmarkRequest) ([]ljlib.HistoricalPrice,
+	[]ljlib.HistoricalPrice, error) {
+	var prices, benchmark []ljlib.HistoricalPrice
+	var tickerErr, benchmarkErr error
+	done := make(chan struct{})
+	if len(ticker) > 0 {
+		go func() {
+			defer close(done)
+			prices, tickerErr = s.prices.GetHistoricalPrices(ticker, request.From, request.To)
+		}()
+	} else {
+		close(done)
+	}
+	benchmark, benchmarkErr = s.prices.GetHistoricalPrices(request.Benchmark, request.From, request.To)
+	<-done
+
+	//the range is validated already, so the source can only reject the ticker
+	if errors.Is(tickerErr, ljlib.IllegalArgumentError{}) {
+		return nil, nil, ljlib.NewNotFoundError("ticker [%s] not found", ticker)
+	}
+	if tickerErr != nil {
+		return nil, nil, fmt.Errorf("cannot get historical prices for ticker [%s]: %w", ticker, tickerErr)
+	}
+	if errors.Is(benchmarkErr, ljlib.IllegalArgumentError{}) {
+		return nil, nil, ljlib.NewIllegalArgumentError("invalid benchmark [%s]", request.Benchmark)
+	}
+	if benchmarkErr != nil {
+		return nil, nil, fmt.Errorf("cannot get historical prices for benchmark [%s]: %w", request.Benchmark,
+			benchmarkErr)
+	}
+	return prices, benchmark, nil
+}
+
+// portfolioIndexBase is the value the portfolio index starts at.
+const portfolioIndexBase = 100
+
+// getPortfolioIndex returns the chain-linked index of the portfolio's daily values over the range, together
+// with its value at the end of the range.
+func (s *Service) getPortfolioIndex(userID uuid.UUID, from time.Time, to time.Time) ([]ljlib.HistoricalPrice,
+	decimal.Decimal, error) {
+	transactions, err := s.getTransactions(userID)
+	if err != nil {
+		return nil, decimal.Decimal{}, err
+	}
+	valuations, err := s.getDailyValuations(transactions, from, to)
+	if err != nil {
+		return nil, decimal.Decimal{}, err
+	}
+	series := portfolioSeries(valuations)
+
+	var index []ljlib.HistoricalPrice
+	level := float64(portfolioIndexBase)
+	for i, point := range series {
+		if len(index) == 0 {
+			if point.value.IsPositive() {
+				index = append(index, ljlib.HistoricalPrice{Date: point.date, Price: decimal.NewFromFloat(level)})
+			}
+			continue
+		}
+		linked := newLinkedReturn()
+		linked.add(series[i-1].value.InexactFloat64(), point.value.InexactFloat64(), point.inflow.InexactFloat64(),
+			point.outflow.InexactFloat64())
+		level *= 1 + linked.value()
+		index = append(index, ljlib.HistoricalPrice{Date: point.date, Price: decimal.NewFromFloat(level)})
+	}
+	return index, series[len(series)-1].value, nil
+}
+
+func sortedPrices(prices []ljlib.HistoricalPrice) []ljlib.HistoricalPrice {
+	sorted := append([]ljlib.HistoricalPrice{}, prices...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	return sorted
+}
+
+// alignReturns returns the returns of both series on the dates they both have a return for.
+func alignReturns(returns []DailyReturn, other []DailyReturn) ([]DailyReturn, []DailyReturn) {
+	byDate := make(map[string]DailyReturn, len(other))
+	for _, r := range other {
+		byDate[r.Date.Format(time.DateOnly)] = r
+	}
+	var aligned, alignedOther []DailyReturn
+	for _, r := range returns {
+		if o, ok := byDate[r.Date.Format(time.DateOnly)]; ok {
+			aligned = append(aligned, r)
+			alignedOther = append(alignedOther, o)
+		}
+	}
+	return aligned, alignedOther
+}
+
+func mean(returns []DailyReturn) float64 {
+	if len(returns) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, r := range returns {
+		sum += r.Value
+	}
+	return sum / float64(len(returns))
+}
+
+// standardDeviation is the sample standard deviation of the returns.
+func standardDeviation(returns []DailyReturn) (float64, bool) {
+	if len(returns) < 2 {
+		return 0, false
+	}
+	mean := mean(returns)
+	var squares float64
+	for _, r := range returns {
+		squares += (r.Value - mean) * (r.Value - mean)
+	}
+	return math.Sqrt(squares / float64(len(returns)-1)), true
+}
+
+func nullRatio(value float64, ok bool) decimal.NullDecimal {
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return decimal.NullDecimal{}
+	}
+	return decimal.NewNullDecimal(decimal.NewFromFloat(value).Round(ReturnPlaces))
+}
+
+func riskAmount(ratio decimal.NullDecimal, value decimal.Decimal) decimal.NullDecimal {
+	if !ratio.Valid {
+		return decimal.NullDecimal{}
+	}
+	return decimal.NewNullDecimal(ratio.Decimal.Mul(value).Round(ljlib.CashPlaces))
+}
+
+func optionalAmount(value decimal.NullDecimal) *string {
+	if !value.Valid {
+		return nil
+	}
+	formatted := value.Decimal.StringFixed(ljlib.CashPlaces)
+	return &formatted
+}
+
+func optionalDate(date time.Time) *string {
+	if date.IsZero() {
+		return nil
+	}
+	formatted := date.Format(time.DateOnly)
+	return &formatted
+}
@@ -0,0 +1,263 @@
+package analytics_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxDrawdown(t *testing.T) {
+	day := func(days int) time.Time {
+		return testStart.AddDate(0, 0, days)
+	}
+	testCases := map[string]struct {
+		prices   []float64
+		expected analytics.Drawdown
+	}{
+		"it should return the largest fall with its recovery": {
+			prices:   []float64{100, 120, 90, 110, 125},
+			expected: analytics.Drawdown{Depth: 0.25, Peak: day(1), Trough: day(2), Recovery: day(4)},
+		},
+		"it should not set the recovery when the price hasn't got back to the peak": {
+			prices:   []float64{100, 80, 90},
+			expected: analytics.Drawdown{Depth: 0.2, Peak: day(0), Trough: day(1)},
+		},
+		"it should prefer the deepest of the drawdowns": {
+			prices:   []float64{100, 95, 100, 150, 105, 160},
+			expected: analytics.Drawdown{Depth: 0.3, Peak: day(3), Trough: day(4), Recovery: day(5)},
+		},
+		"it should return no drawdown when the price never falls": {
+			prices:   []float64{100, 100, 101},
+			expected: analytics.Drawdown{},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			drawdown := analytics.MaxDrawdown(reversed(pricesFrom(testCase.prices)))
+			assert.InDelta(t, testCase.expected.Depth, drawdown.Depth, 1e-9)
+			assert.Equal(t, testCase.expected.Peak, drawdown.Peak)
+			assert.Equal(t, testCase.expected.Trough, drawdown.Trough)
+			assert.Equal(t, testCase.expected.Recovery, drawdown.Recovery)
+		})
+	}
+}
+
+func TestValueAtRisk(t *testing.T) {
+	var skewed []float64
+	skewed = append(skewed, -0.05, -0.04)
+	for i := 0; i < 18; i++ {
+		skewed = append(skewed, 0.01)
+	}
+	//the sample standard deviation of the alternating returns
+	deviation := math.Sqrt(4 * 0.0001 / 3)
+
+	testCases := map[string]struct {
+		returns            []float64
+		confidence         float64
+		expectedHistorical float64
+		expectedParametric float64
+	}{
+		"it should return the loss exceeded in 5% of the days": {
+			returns:            skewed,
+			confidence:         0.95,
+			expectedHistorical: 0.04,
+			expectedParametric: 1.6448536*standardDeviation(skewed) - 0.0045,
+		},
+		"it should return the loss exceeded in 1% of the days": {
+			returns:            skewed,
+			confidence:         0.99,
+			expectedHistorical: 0.05,
+			expectedParametric: 2.3263479*standardDeviation(skewed) - 0.0045,
+		},
+		"it should return the parametric loss of the normal distribution": {
+			returns:            []float64{0.01, -0.01, 0.01, -0.01},
+			confidence:         0.95,
+			expectedHistorical: 0.01,
+			expectedParametric: 1.6448536 * deviation,
+		},
+		"it should return no loss when the returns are all positive": {
+			returns:            []float64{0.01, 0.011, 0.012},
+			confidence:         0.95,
+			expectedHistorical: 0,
+			expectedParametric: 0,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			returns := dailyReturns(testCase.returns)
+			historical, ok := analytics.HistoricalVaR(returns, testCase.confidence)
+			require.True(t, ok)
+			assert.InDelta(t, testCase.expectedHistorical, historical, 1e-9)
+			parametric, ok := analytics.ParametricVaR(returns, testCase.confidence)
+			require.True(t, ok)
+			assert.InDelta(t, testCase.expectedParametric, parametric, 1e-6)
+		})
+	}
+}
+
+func TestRatios(t *testing.T) {
+	returns := dailyReturns([]float64{0.01, -0.01, 0.01, -0.01})
+	deviation := math.Sqrt(4 * 0.0001 / 3)
+
+	volatility, ok := analytics.Volatility(returns)
+	require.True(t, ok)
+	assert.InDelta(t, deviation*math.Sqrt(365), volatility, 1e-9)
+
+	sharpe, ok := analytics.SharpeRatio(returns, 0.0365)
+	require.True(t, ok)
+	assert.InDelta(t, -0.0001/deviation*math.Sqrt(365), sharpe, 1e-9)
+
+	//the shortfalls below 0.01% a day are -1.01% twice, over the 4 days
+	sortino, ok := analytics.SortinoRatio(returns, 0.0365)
+	require.True(t, ok)
+	assert.InDelta(t, -0.0001/math.Sqrt(2*0.0101*0.0101/4)*math.Sqrt(365), sortino, 1e-9)
+
+	_, ok = analytics.SharpeRatio(dailyReturns([]float64{0.01, 0.01, 0.01}), 0.04)
+	assert.False(t, ok, "the Sharpe ratio is not defined without volatility")
+	_, ok = analytics.SortinoRatio(dailyReturns([]float64{0.01, 0.02, 0.01}), 0.04)
+	assert.False(t, ok, "the Sortino ratio is not defined without returns below the risk-free rate")
+	_, ok = analytics.Volatility(dailyReturns([]float64{0.01}))
+	assert.False(t, ok, "the volatility is not defined for a single return")
+}
+
+func TestBeta(t *testing.T) {
+	benchmark := dailyReturns([]float64{0.01, -0.02, 0.03, 0.01})
+	//the returns move twice as much as the benchmark, and the one it doesn't have is ignored
+	returns := dailyReturns([]float64{0.02, -0.04, 0.06, 0.02, 0.5})
+
+	beta, ok := analytics.Beta(returns, benchmark)
+	require.True(t, ok)
+	assert.InDelta(t, 2, beta, 1e-9)
+
+	_, ok = analytics.Beta(returns, dailyReturns([]float64{0.01, 0.01, 0.01}))
+	assert.False(t, ok, "the beta is not defined when the benchmark has no variance")
+}
+
+func TestService_GetTickerRisk(t *testing.T) {
+	testCases := map[string]struct {
+		ticker        string
+		benchmark     string
+		expectedError error
+	}{
+		"it should return the risk against the default benchmark": {
+			ticker: "AAPL",
+		},
+		"it should return the risk against the requested benchmark": {
+			ticker:    "AAPL",
+			benchmark: "QQQ",
+		},
+		"it should return NotFoundError for an unknown ticker": {
+			ticker:        "UNKNOWN",
+			expectedError: ljlib.NotFoundError{},
+		},
+		"it should return IllegalArgumentError for an unknown benchmark": {
+			ticker:        "AAPL",
+			benchmark:     "UNKNOWN",
+			expectedError: ljlib.IllegalArgumentError{},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			prices := &mockPriceSource{unknown: "UNKNOWN"}
+			service := newTestService(t, nil, prices, testStart.AddDate(0, 3, 0), 4)
+
+			report, err := service.GetTickerRisk(testCase.ticker, analytics.BenchmarkRequest{
+				From:      testStart,
+				To:        testStart.AddDate(0, 0, 30),
+				Benchmark: testCase.benchmark,
+			})
+			if testCase.expectedError != nil {
+				assert.ErrorIs(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			expectedBenchmark := testCase.benchmark
+			if len(expectedBenchmark) == 0 {
+				expectedBenchmark = analytics.DefaultConfig().Benchmark
+			}
+			assert.Equal(t, expectedBenchmark, report.Benchmark)
+			assert.Equal(t, 30, report.Metrics.Observations)
+			//the mock prices only grow, by the same amount for every ticker
+			assert.Zero(t, report.Metrics.MaxDrawdown.Depth)
+			assert.Equal(t, "1", report.Metrics.Beta.Decimal.String())
+			assert.True(t, report.Metrics.Volatility.Decimal.IsPositive())
+			assert.False(t, report.Metrics.SortinoRatio.Valid)
+			require.Len(t, report.Metrics.ValueAtRisk, 2)
+			assert.Equal(t, "0", report.Metrics.ValueAtRisk[0].Historical.Decimal.String())
+		})
+	}
+}
+
+func TestService_GetPortfolioRisk(t *testing.T) {
+	ledger := mockLedger{
+		cashTransaction(ljlib.TransactionTypeDeposit, 1000, testStart.Add(10*time.Hour)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testStart.AddDate(0, 0, 1).Add(10*time.Hour)),
+		cashTransaction(ljlib.TransactionTypeWithdrawal, 100, testStart.AddDate(0, 0, 3).Add(10*time.Hour)),
+	}
+	service := newTestService(t, ledger, &mockPriceSource{}, testStart.AddDate(0, 3, 0), 4)
+
+	report, err := service.GetPortfolioRisk(testUserID, analytics.BenchmarkRequest{
+		From: testStart.AddDate(0, 0, -5),
+		To:   testStart.AddDate(0, 0, 4),
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, report.Ticker)
+	assert.Equal(t, "919", report.Value.Decimal.String())
+	//the days before the deposit are skipped
+	assert.Equal(t, 4, report.Metrics.Observations)
+	//the withdrawal is not a loss
+	assert.Zero(t, report.Metrics.MaxDrawdown.Depth)
+	require.Len(t, report.Metrics.ValueAtRisk, 2)
+	for _, valueAtRisk := range report.Metrics.ValueAtRisk {
+		assert.True(t, valueAtRisk.HistoricalAmount.Valid)
+		assert.True(t, valueAtRisk.ParametricAmount.Valid)
+	}
+}
+
+// pricesFrom returns the prices for consecutive days from testStart.
+func pricesFrom(values []float64) []ljlib.HistoricalPrice {
+	prices := make([]ljlib.HistoricalPrice, 0, len(values))
+	for i, value := range values {
+		prices = append(prices, ljlib.HistoricalPrice{
+			Date:  testStart.AddDate(0, 0, i),
+			Price: decimal.NewFromFloat(value),
+		})
+	}
+	return prices
+}
+
+func reversed(prices []ljlib.HistoricalPrice) []ljlib.HistoricalPrice {
+	result := make([]ljlib.HistoricalPrice, 0, len(prices))
+	for i := len(prices) - 1; i >= 0; i-- {
+		result = append(result, prices[i])
+	}
+	return result
+}
+
+// dailyReturns returns the returns for consecutive days from testStart.
+func dailyReturns(values []float64) []analytics.DailyReturn {
+	returns := make([]analytics.DailyReturn, 0, len(values))
+	for i, value := range values {
+		returns = append(returns, analytics.DailyReturn{Date: testStart.AddDate(0, 0, i+1), Value: value})
+	}
+	return returns
+}
+
+func standardDeviation(values []float64) float64 {
+	var mean, squares float64
+	for _, value := range values {
+		mean += value / float64(len(values))
+	}
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(squares / float64(len(values)-1))
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+type AccountController struct {
+	accounts AccountProvider
+}
+
+type AccountProvider interface {
+	GetAccount(userID uuid.UUID, at time.Time) (risk.Account, error)
+}
+
+func NewAccountController(accounts AccountProvider) AccountController {
+	return AccountController{
+		accounts: accounts,
+	}
+}
+
+// GetAccount returns the user's cash, buying power and equity, and whether the user is a pattern day trader.
+func (c AccountController) GetAccount(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	account, err := c.accounts.GetAccount(user.ID, time.Now())
+	if err != nil {
+		log.Printf("Cannot get account of user [%s]: %s", user.ID, err)
+		ljlib.ResponseHTTPError(w, "Cannot get account")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, account)
+}
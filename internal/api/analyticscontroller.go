@@ -0,0 +1,349 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type AnalyticsController struct {
+	analytics   PortfolioAnalytics
+	auditLogger AuditLogger
+}
+
+type PortfolioAnalytics interface {
+	GetHistory(userID uuid.UUID, request analytics.HistoryRequest) (analytics.History, error)
+	GetPerformance(userID uuid.UUID) (analytics.Performance, error)
+	GetTickerRisk(ticker string, request analytics.BenchmarkRequest) (analytics.RiskReport, error)
+	GetPortfolioRisk(userID uuid.UUID, request analytics.BenchmarkRequest) (analytics.RiskReport, error)
+	GetTickerComparison(ticker string, request analytics.BenchmarkRequest) (analytics.Comparison, error)
+	GetPortfolioComparison(userID uuid.UUID, request analytics.BenchmarkRequest) (analytics.Comparison, error)
+	GetAllocation(userID uuid.UUID, request analytics.AllocationRequest) (analytics.Allocation, error)
+	GetCorrelations(userID uuid.UUID, request analytics.CorrelationRequest) (analytics.CorrelationMatrix, error)
+	GetProjection(userID uuid.UUID, request analytics.ProjectionRequest) (analytics.Projection, error)
+}
+
+func NewAnalyticsController(analytics PortfolioAnalytics, auditLogger AuditLogger) AnalyticsController {
+	return AnalyticsController{
+		analytics:   analytics,
+		auditLogger: auditLogger,
+	}
+}
+
+// GetPortfolioHistory returns the value of the user's portfolio over the range in the from and to parameters,
+// as YYYY-MM-DD, with a point per day, week or month, depending on the interval parameter.
+func (c AnalyticsController) GetPortfolioHistory(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	query := r.URL.Query()
+	from, err := parseDateParam(query.Get("from"), "from")
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	to, err := parseDateParam(query.Get("to"), "to")
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	history, err := c.analytics.GetHistory(user.ID, analytics.HistoryRequest{
+		From:     from,
+		To:       to,
+		Interval: analytics.Interval(query.Get("interval")),
+	})
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get portfolio history")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "history", audit.OutcomeSuccess,
+		map[string]string{"from": history.From.Format(time.DateOnly), "to": history.To.Format(time.DateOnly)})
+	ljlib.ResponseHTTP(w, http.StatusOK, history)
+}
+
+// GetPortfolioPerformance returns the time-weighted and the money-weighted returns of the user's portfolio
+// and its holdings over the standard periods ending today.
+func (c AnalyticsController) GetPortfolioPerformance(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	performance, err := c.analytics.GetPerformance(user.ID)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get portfolio performance")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "performance", audit.OutcomeSuccess, nil)
+	ljlib.ResponseHTTP(w, http.StatusOK, performance)
+}
+
+// GetTickerRisk returns the volatility, the max drawdown, the Sharpe and the Sortino ratios, the beta against
+// the benchmark parameter and the Value-at-Risk of the ticker over the range in the from and to parameters.
+func (c AnalyticsController) GetTickerRisk(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	request, err := parseBenchmarkRequest(r)
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	ticker := mux.Vars(r)["ticker"]
+	report, err := c.analytics.GetTickerRisk(ticker, request)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get ticker risk")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "risk", audit.OutcomeSuccess,
+		map[string]string{"ticker": ticker, "benchmark": report.Benchmark})
+	ljlib.ResponseHTTP(w, http.StatusOK, report)
+}
+
+// GetPortfolioRisk returns the same risk figures as GetTickerRisk for the user's portfolio, with the Value-at-Risk
+// also as the amount of its current value.
+func (c AnalyticsController) GetPortfolioRisk(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	request, err := parseBenchmarkRequest(r)
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	report, err := c.analytics.GetPortfolioRisk(user.ID, request)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get portfolio risk")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "risk", audit.OutcomeSuccess,
+		map[string]string{"benchmark": report.Benchmark})
+	ljlib.ResponseHTTP(w, http.StatusOK, report)
+}
+
+// GetTickerComparison returns the prices of the ticker and of the benchmark parameter over the range, rebased to 100,
+// with the excess return, the tracking error and the information ratio of the ticker.
+func (c AnalyticsController) GetTickerComparison(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	request, err := parseBenchmarkRequest(r)
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	ticker := mux.Vars(r)["ticker"]
+	comparison, err := c.analytics.GetTickerComparison(ticker, request)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot compare ticker")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "compare", audit.OutcomeSuccess,
+		map[string]string{"ticker": ticker, "benchmark": comparison.Benchmark})
+	ljlib.ResponseHTTP(w, http.StatusOK, comparison)
+}
+
+// GetPortfolioComparison is GetTickerComparison for the user's portfolio.
+func (c AnalyticsController) GetPortfolioComparison(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	request, err := parseBenchmarkRequest(r)
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	comparison, err := c.analytics.GetPortfolioComparison(user.ID, request)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot compare portfolio")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "compare", audit.OutcomeSuccess,
+		map[string]string{"benchmark": comparison.Benchmark})
+	ljlib.ResponseHTTP(w, http.StatusOK, comparison)
+}
+
+// GetPortfolioAllocation returns the holdings of the user's portfolio grouped by the attribute in the by parameter,
+// with the concentration in the number of the largest groups in the top parameter.
+func (c AnalyticsController) GetPortfolioAllocation(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	query := r.URL.Query()
+	request := analytics.AllocationRequest{By: analytics.AllocationDimension(query.Get("by"))}
+	if value := query.Get("top"); len(value) > 0 {
+		top, err := strconv.Atoi(value)
+		if err != nil || top < 1 {
+			ljlib.ResponseHTTPBadRequest(w, "invalid top, expected a positive number")
+			return
+		}
+		request.Top = top
+	}
+	allocation, err := c.analytics.GetAllocation(user.ID, request)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get portfolio allocation")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "allocation", audit.OutcomeSuccess,
+		map[string]string{"by": string(allocation.By)})
+	ljlib.ResponseHTTP(w, http.StatusOK, allocation)
+}
+
+// GetPortfolioCorrelations returns the pairwise correlations and covariances of the daily returns of the holdings
+// of the user's portfolio, over the number of the most recent returns in the window parameter.
+func (c AnalyticsController) GetPortfolioCorrelations(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request analytics.CorrelationRequest
+	if value := r.URL.Query().Get("window"); len(value) > 0 {
+		window, err := strconv.Atoi(value)
+		if err != nil || window < 1 {
+			ljlib.ResponseHTTPBadRequest(w, "invalid window, expected a positive number")
+			return
+		}
+		request.Window = window
+	}
+	matrix, err := c.analytics.GetCorrelations(user.ID, request)
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get portfolio correlations")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "correlations", audit.OutcomeSuccess,
+		map[string]string{"window": strconv.Itoa(matrix.Window)})
+	ljlib.ResponseHTTP(w, http.StatusOK, matrix)
+}
+
+type projectionRequest struct {
+	Method               analytics.ProjectionMethod `json:"method"`
+	Horizon              int                        `json:"horizon"`
+	Paths                int                        `json:"paths"`
+	Seed                 int64                      `json:"seed"`
+	Window               int                        `json:"window"`
+	Contribution         decimal.Decimal            `json:"contribution"`
+	ContributionInterval analytics.Interval         `json:"contribution_interval"`
+	Goal                 decimal.NullDecimal        `json:"goal"`
+}
+
+// GetPortfolioProjection simulates the value of the user's portfolio over the horizon, with the periodic
+// contributions, returning its percentile bands and the probability of reaching the goal.
+func (c AnalyticsController) GetPortfolioProjection(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request projectionRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	projection, err := c.analytics.GetProjection(user.ID, analytics.ProjectionRequest{
+		Method:               request.Method,
+		Horizon:              request.Horizon,
+		Paths:                request.Paths,
+		Seed:                 request.Seed,
+		Window:               request.Window,
+		Contribution:         request.Contribution,
+		ContributionInterval: request.ContributionInterval,
+		Goal:                 request.Goal,
+	})
+	if err != nil {
+		c.responseAnalyticsError(w, err, "Cannot get portfolio projection")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionAnalyticsRead, "projection", audit.OutcomeSuccess,
+		map[string]string{"method": string(projection.Method), "horizon": strconv.Itoa(projection.Horizon),
+			"paths": strconv.Itoa(projection.Paths)})
+	ljlib.ResponseHTTP(w, http.StatusOK, projection)
+}
+
+func parseBenchmarkRequest(r *http.Request) (analytics.BenchmarkRequest, error) {
+	query := r.URL.Query()
+	from, err := parseDateParam(query.Get("from"), "from")
+	if err != nil {
+		return analytics.BenchmarkRequest{}, err
+	}
+	to, err := parseDateParam(query.Get("to"), "to")
+	if err != nil {
+		return analytics.BenchmarkRequest{}, err
+	}
+	return analytics.BenchmarkRequest{From: from, To: to, Benchmark: query.Get("benchmark")}, nil
+}
+
+func (c AnalyticsController) responseAnalyticsError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.IllegalArgumentError{}):
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+	case errors.Is(err, ljlib.NotFoundError{}):
+		ljlib.ResponseHTTPNotFound(w, err.Error())
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
+
+// parseDateParam parses the optional query parameter as YYYY-MM-DD, returning the zero time when it's not set.
+func parseDateParam(value string, name string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s, expected YYYY-MM-DD", name)
+	}
+	return date, nil
+}
@@ -1,43 +1,130 @@
 package api
 
 import (
-	"context"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/auth"
 	"github.com/iliyaisd/littlejohn/ljlib"
 )
 
-const mockPassword = ""
+const (
+	mockPassword = ""
+	//OTPHeader carries the TOTP or recovery code for users with 2FA enabled, when they authenticate with a password.
+	OTPHeader    = "X-OTP-Code"
+	bearerPrefix = "Bearer "
+)
 
 type APIKeyAuthorizer struct {
-	userRepository UserRepository
+	userRepository  UserRepository
+	tokenRepository TokenRepository
 }
 
 type UserRepository interface {
 	GetUserByUsername(username string) (*ljlib.User, error)
+	GetUserByID(userID uuid.UUID) (*ljlib.User, error)
+	SecondFactorStorage
+}
+
+// SecondFactorStorage uses up the one-time codes atomically, without writing back the whole user,
+// so a code is accepted only once even by concurrent requests.
+type SecondFactorStorage interface {
+	UseTOTPCounter(userID uuid.UUID, counter int64) error
+	UseRecoveryCode(userID uuid.UUID, codeHash string) error
+}
+
+type TokenRepository interface {
+	GetTokenByHash(tokenHash string) (*ljlib.APIToken, error)
 }
 
-func NewAPIKeyAuthorizer(repository UserRepository) APIKeyAuthorizer {
+func NewAPIKeyAuthorizer(repository UserRepository, tokenRepository TokenRepository) APIKeyAuthorizer {
 	return APIKeyAuthorizer{
-		userRepository: repository,
+		userRepository:  repository,
+		tokenRepository: tokenRepository,
 	}
 }
 
+// Authorize accepts either a bearer token issued on login, or HTTP basic credentials.
+// With basic credentials, users with 2FA enabled have to provide a one-time code in OTPHeader as well.
 func (a APIKeyAuthorizer) Authorize(r *http.Request) (*http.Request, error) {
+	var principal auth.Principal
+	var err error
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, bearerPrefix) {
+		principal, err = a.authorizeToken(strings.TrimPrefix(authHeader, bearerPrefix))
+	} else {
+		principal, err = a.authorizeBasic(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), principal)), nil
+}
+
+func (a APIKeyAuthorizer) authorizeBasic(r *http.Request) (auth.Principal, error) {
 	username, password, ok := r.BasicAuth()
 	if !ok || len(username) == 0 {
-		return nil, fmt.Errorf("wrong basic auth credentials provided")
+		return auth.Principal{}, fmt.Errorf("wrong basic auth credentials provided")
 	}
 
 	user, err := a.userRepository.GetUserByUsername(username)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get user by username [%s]: %w", username, err)
+		return auth.Principal{}, fmt.Errorf("cannot get user by username [%s]: %w", username, err)
+	}
+
+	if user.Username != username || !a.passwordMatches(user, password) {
+		return auth.Principal{}, fmt.Errorf("wrong username or password for username [%s]", user.Username)
+	}
+
+	if !user.IsActive() {
+		return auth.Principal{}, fmt.Errorf("user [%s] is disabled or deleted", user.Username)
+	}
+
+	if user.TOTPEnabled {
+		ok, err := verifySecondFactor(a.userRepository, *user, r.Header.Get(OTPHeader), time.Now())
+		if err != nil {
+			return auth.Principal{}, fmt.Errorf("cannot store 2FA state for username [%s]: %w", user.Username, err)
+		}
+		if !ok {
+			return auth.Principal{}, fmt.Errorf("missing or invalid one-time code for username [%s]", user.Username)
+		}
+	}
+
+	return auth.NewPrincipal(user, auth.MethodPassword, ""), nil
+}
+
+func (a APIKeyAuthorizer) authorizeToken(secret string) (auth.Principal, error) {
+	if a.tokenRepository == nil || len(secret) == 0 {
+		return auth.Principal{}, fmt.Errorf("wrong bearer token provided")
+	}
+
+	token, err := a.tokenRepository.GetTokenByHash(hashAPIToken(secret))
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("cannot get token: %w", err)
+	}
+	if !token.IsValid(time.Now()) {
+		return auth.Principal{}, fmt.Errorf("token [%s] is expired or revoked", token.ID)
 	}
 
-	if user.Username != username || password != mockPassword {
-		return nil, fmt.Errorf("wrong username or password for username [%s]", user.Username)
+	user, err := a.userRepository.GetUserByID(token.UserID)
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("cannot get user for token [%s]: %w", token.ID, err)
+	}
+	if !user.IsActive() {
+		return auth.Principal{}, fmt.Errorf("user [%s] is disabled or deleted", user.Username)
 	}
 
-	return r.WithContext(context.WithValue(r.Context(), "user", user)), nil
+	return auth.NewPrincipal(user, auth.MethodToken, token.ID.String()), nil
+}
+
+// passwordMatches checks the password against the stored hash. Mocked users don't have a hash
+// and keep authenticating with the mock password.
+func (a APIKeyAuthorizer) passwordMatches(user *ljlib.User, password string) bool {
+	if len(user.PasswordHash) == 0 {
+		return password == mockPassword
+	}
+	return verifyPassword(password, user.PasswordHash)
 }
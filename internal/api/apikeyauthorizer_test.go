@@ -1,18 +1,35 @@
 package api_test
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/iliyaisd/littlejohn/internal/api"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/internal/totp"
 	"github.com/iliyaisd/littlejohn/ljlib"
 	"github.com/stretchr/testify/require"
 )
 
-const testUsername = "johndoe"
+const (
+	testUsername         = "johndoe"
+	testDisabledUsername = "disabled"
+	testHashedUsername   = "hashed"
+	testHashedPassword   = "secret123"
+	testTOTPUsername     = "totp"
+	testTOTPSecret       = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	testValidToken       = "lj_valid"
+	testRevokedToken     = "lj_revoked"
+)
 
 func TestAPIKeyAuthorizer_Authorize(t *testing.T) {
 	testCases := map[string]struct {
@@ -40,12 +57,29 @@ func TestAPIKeyAuthorizer_Authorize(t *testing.T) {
 			username:         "johndoe",
 			password:         "",
 		},
+		"it should not authorize a disabled user": {
+			authHeaderExists: true,
+			username:         testDisabledUsername,
+			password:         "",
+			expectedError:    true,
+		},
+		"it should not authorize a user with a password hash when the password is wrong": {
+			authHeaderExists: true,
+			username:         testHashedUsername,
+			password:         "",
+			expectedError:    true,
+		},
+		"it should authorize a user with a password hash when the password matches": {
+			authHeaderExists: true,
+			username:         testHashedUsername,
+			password:         testHashedPassword,
+		},
 	}
 
 	for testName, testCase := range testCases {
 		t.Run(testName, func(t *testing.T) {
 			userRepository := mockUserRepository{}
-			authorizer := api.NewAPIKeyAuthorizer(userRepository)
+			authorizer := api.NewAPIKeyAuthorizer(userRepository, mockTokenRepository{})
 			r, err := http.NewRequest(http.MethodGet, "", nil)
 			require.NoError(t, err)
 			if testCase.authHeaderExists {
@@ -57,20 +91,182 @@ func TestAPIKeyAuthorizer_Authorize(t *testing.T) {
 				require.Error(t, err)
 			} else {
 				require.NoError(t, err)
-				user, ok := r.Context().Value("user").(*ljlib.User)
+				principal, ok := auth.PrincipalFrom(r.Context())
 				require.True(t, ok)
-				require.NotNil(t, user)
-				require.Equal(t, testUsername, user.Username)
+				require.NotNil(t, principal.User)
+				require.Equal(t, testCase.username, principal.User.Username)
+				require.Equal(t, auth.MethodPassword, principal.Method)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthorizer_AuthorizeBearerToken(t *testing.T) {
+	testCases := map[string]struct {
+		token         string
+		expectedError bool
+	}{
+		"it should not authorize an unknown token": {
+			token:         "lj_unknown",
+			expectedError: true,
+		},
+		"it should not authorize a revoked token": {
+			token:         testRevokedToken,
+			expectedError: true,
+		},
+		"it should authorize a valid token and set its owner to context": {
+			token: testValidToken,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			authorizer := api.NewAPIKeyAuthorizer(mockUserRepository{}, mockTokenRepository{})
+			r, err := http.NewRequest(http.MethodGet, "", nil)
+			require.NoError(t, err)
+			r.Header.Add("Authorization", "Bearer "+testCase.token)
+			r, err = authorizer.Authorize(r)
+			if testCase.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				principal, ok := auth.PrincipalFrom(r.Context())
+				require.True(t, ok)
+				require.Equal(t, testUsername, principal.User.Username)
+				require.Equal(t, auth.MethodToken, principal.Method)
+				require.Equal(t, "5e2b9a51-96f5-4f8e-9b3a-52a8d1ce0f1a", principal.KeyID)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthorizer_AuthorizeTwoFactor(t *testing.T) {
+	validCode, err := totp.Code(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+	expiredCode, err := totp.Code(testTOTPSecret, time.Now().Add(-10*totp.StepPeriod))
+	require.NoError(t, err)
+
+	testCases := map[string]struct {
+		code          string
+		expectedError bool
+	}{
+		"it should not authorize a 2FA user without a one-time code": {
+			expectedError: true,
+		},
+		"it should not authorize a 2FA user with an expired one-time code": {
+			code:          expiredCode,
+			expectedError: true,
+		},
+		"it should authorize a 2FA user with a valid one-time code": {
+			code: validCode,
+		},
+	}
+
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			authorizer := api.NewAPIKeyAuthorizer(mockUserRepository{}, mockTokenRepository{})
+			r, err := http.NewRequest(http.MethodGet, "", nil)
+			require.NoError(t, err)
+			r.SetBasicAuth(testTOTPUsername, "")
+			if len(testCase.code) > 0 {
+				r.Header.Set(api.OTPHeader, testCase.code)
+			}
+			_, err = authorizer.Authorize(r)
+			if testCase.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
 			}
 		})
 	}
 }
 
+func TestAPIKeyAuthorizer_AuthorizeTwoFactor_Replay(t *testing.T) {
+	storage := datasource.NewLocalUserStorage()
+	user := ljlib.User{ID: uuid.New(), Username: testTOTPUsername, TOTPSecret: testTOTPSecret, TOTPEnabled: true}
+	require.NoError(t, storage.CreateUser(user))
+	code, err := totp.Code(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+	authorizer := api.NewAPIKeyAuthorizer(storage, mockTokenRepository{})
+
+	const requests = 10
+	var authorized int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		r, err := http.NewRequest(http.MethodGet, "", nil)
+		require.NoError(t, err)
+		r.SetBasicAuth(testTOTPUsername, "")
+		r.Header.Set(api.OTPHeader, code)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := authorizer.Authorize(r); err == nil {
+				atomic.AddInt32(&authorized, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	//concurrent requests with the same code can't both pass the replay check
+	require.Equal(t, int32(1), authorized)
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	require.True(t, stored.TOTPEnabled)
+	require.Positive(t, stored.TOTPLastCounter)
+}
+
 type mockUserRepository struct{}
 
 func (m mockUserRepository) GetUserByUsername(username string) (*ljlib.User, error) {
-	if username == testUsername {
+	switch username {
+	case testUsername:
 		return &ljlib.User{ID: uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063"), Username: testUsername}, nil
+	case testDisabledUsername:
+		return &ljlib.User{ID: uuid.MustParse("0b5c6f0e-4f57-4a43-9f58-3c4a2e54a9b1"), Username: testDisabledUsername,
+			Disabled: true}, nil
+	case testHashedUsername:
+		//pbkdf2-sha256 hash of testHashedPassword with a single iteration, to keep the test fast
+		return &ljlib.User{ID: uuid.MustParse("7d0f4b36-6a3e-4f5f-a2a5-2a6f0f3f2d8e"), Username: testHashedUsername,
+			PasswordHash: "pbkdf2-sha256$1$c2FsdHNhbHRzYWx0c2FsdA$h/xVNgW5Geqolb12x+8xmA8oRZZ510ZW7+ZZolcttXk"}, nil
+	case testTOTPUsername:
+		return &ljlib.User{ID: uuid.MustParse("3c3c9d2f-2b1e-4f4c-8b6f-0d9b6c1a7e55"), Username: testTOTPUsername,
+			TOTPSecret: testTOTPSecret, TOTPEnabled: true}, nil
 	}
 	return nil, fmt.Errorf("user not found")
 }
+
+func (m mockUserRepository) GetUserByID(userID uuid.UUID) (*ljlib.User, error) {
+	return m.GetUserByUsername(testUsername)
+}
+
+func (m mockUserRepository) UseTOTPCounter(userID uuid.UUID, counter int64) error {
+	return nil
+}
+
+func (m mockUserRepository) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	return ljlib.NewConflictError("recovery code is unknown")
+}
+
+type mockTokenRepository struct{}
+
+func (m mockTokenRepository) GetTokenByHash(tokenHash string) (*ljlib.APIToken, error) {
+	token := ljlib.APIToken{
+		ID:        uuid.MustParse("5e2b9a51-96f5-4f8e-9b3a-52a8d1ce0f1a"),
+		UserID:    uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	switch tokenHash {
+	case sha256Hex(testValidToken):
+		return &token, nil
+	case sha256Hex(testRevokedToken):
+		revokedAt := time.Now()
+		token.RevokedAt = &revokedAt
+		return &token, nil
+	}
+	return nil, ljlib.NewNotFoundError("token not found")
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
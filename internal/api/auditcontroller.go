@@ -0,0 +1,152 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+type AuditLogger interface {
+	Record(event audit.Event) error
+}
+
+type AuditLog interface {
+	Query(filter audit.Filter) ([]audit.Event, error)
+	VerifyChain() (int, error)
+}
+
+type AuditController struct {
+	auditLog AuditLog
+}
+
+func NewAuditController(auditLog AuditLog) AuditController {
+	return AuditController{
+		auditLog: auditLog,
+	}
+}
+
+type auditVerificationResponse struct {
+	Valid  bool   `json:"valid"`
+	Events int    `json:"events"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GetEvents returns the audit events matching the query, the most recent first.
+func (c AuditController) GetEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := c.extractFilter(r)
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	events, err := c.auditLog.Query(filter)
+	if err != nil {
+		log.Printf("cannot query audit log: %s", err)
+		ljlib.ResponseHTTPError(w, "Cannot query audit log")
+		return
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, events)
+}
+
+func (c AuditController) VerifyChain(w http.ResponseWriter, r *http.Request) {
+	count, err := c.auditLog.VerifyChain()
+	response := auditVerificationResponse{Valid: err == nil, Events: count}
+	if err != nil {
+		log.Printf("audit log verification failed: %s", err)
+		response.Error = err.Error()
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, response)
+}
+
+func (c AuditController) extractFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		ActorID: query.Get("actor_id"),
+		Action:  query.Get("action"),
+		Outcome: query.Get("outcome"),
+		Limit:   defaultAuditQueryLimit,
+	}
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		return audit.Filter{}, err
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		return audit.Filter{}, err
+	}
+	if limitStr := query.Get("limit"); len(limitStr) > 0 {
+		filter.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxAuditQueryLimit {
+			return audit.Filter{}, ljlib.NewIllegalArgumentError("limit must be between 1 and %d", maxAuditQueryLimit)
+		}
+	}
+	return filter, nil
+}
+
+// parseTimeParam accepts both RFC 3339 timestamps and plain dates; an empty value yields zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, ljlib.NewIllegalArgumentError("invalid time [%s], expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+func recordAuditEvent(logger AuditLogger, r *http.Request, user *ljlib.User, action string, resource string,
+	outcome string, details map[string]string) {
+	event := audit.Event{
+		Action:   action,
+		Resource: resource,
+		Outcome:  outcome,
+		SourceIP: ljlib.ClientIP(r),
+		Details:  details,
+	}
+	if user != nil {
+		event.ActorID = user.ID.String()
+		event.Actor = user.Username
+	}
+	appendAuditEvent(logger, event)
+}
+
+// RecordFailedLogin is used by the router, as authorization failures never reach the controllers.
+// The actor is the username the client claimed to be.
+func RecordFailedLogin(logger AuditLogger, r *http.Request, reason error) {
+	username, _, _ := r.BasicAuth()
+	appendAuditEvent(logger, audit.Event{
+		Actor:    username,
+		Action:   audit.ActionLogin,
+		Resource: r.URL.Path,
+		Outcome:  audit.OutcomeFailure,
+		SourceIP: ljlib.ClientIP(r),
+		Details:  map[string]string{"reason": reason.Error()},
+	})
+}
+
+// appendAuditEvent never fails the request: an audit sink failure is logged instead.
+func appendAuditEvent(logger AuditLogger, event audit.Event) {
+	if logger == nil {
+		return
+	}
+	if err := logger.Record(event); err != nil {
+		log.Printf("cannot record audit event [%s]: %s", event.Action, err)
+	}
+}
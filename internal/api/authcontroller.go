@@ -0,0 +1,150 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+const (
+	apiTokenPrefix   = "lj_"
+	apiTokenBytes    = 32
+	apiTokenLifetime = 24 * time.Hour
+)
+
+// AuthController issues and revokes bearer tokens. Issuing a token is the login flow:
+// it requires username and password, plus a one-time code for users with 2FA enabled (checked by the authorizer).
+type AuthController struct {
+	tokenStorage TokenStorage
+	auditLogger  AuditLogger
+}
+
+type TokenStorage interface {
+	CreateToken(token ljlib.APIToken) error
+	GetTokenByID(tokenID uuid.UUID) (*ljlib.APIToken, error)
+	UpdateToken(token ljlib.APIToken) error
+}
+
+func NewAuthController(tokenStorage TokenStorage, auditLogger AuditLogger) AuthController {
+	return AuthController{
+		tokenStorage: tokenStorage,
+		auditLogger:  auditLogger,
+	}
+}
+
+type issuedTokenResponse struct {
+	ID        string `json:"id"`
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func (c AuthController) IssueToken(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+	//a token must not be able to prolong itself, otherwise a leaked token would never expire
+	if principal.Method != auth.MethodPassword {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionTokenIssue, "", audit.OutcomeFailure,
+			map[string]string{"reason": "token issued with another token"})
+		ljlib.ResponseHTTPForbidden(w, "Tokens can only be issued with username and password")
+		return
+	}
+
+	secret, err := generateAPIToken()
+	if err != nil {
+		log.Printf("cannot generate token for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot issue token")
+		return
+	}
+
+	now := time.Now().UTC()
+	token := ljlib.APIToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: hashAPIToken(secret),
+		CreatedAt: now,
+		ExpiresAt: now.Add(apiTokenLifetime),
+	}
+	if err := c.tokenStorage.CreateToken(token); err != nil {
+		log.Printf("cannot store token for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot issue token")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionLogin, "", audit.OutcomeSuccess,
+		map[string]string{"two_factor": strconv.FormatBool(user.TOTPEnabled)})
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionTokenIssue, token.ID.String(), audit.OutcomeSuccess, nil)
+	ljlib.ResponseHTTP(w, http.StatusCreated, issuedTokenResponse{
+		ID:        token.ID.String(),
+		Token:     secret,
+		ExpiresAt: token.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+func (c AuthController) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	tokenID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid token id")
+		return
+	}
+
+	token, err := c.tokenStorage.GetTokenByID(tokenID)
+	if err != nil || token.UserID != user.ID {
+		if err != nil && !errors.Is(err, ljlib.NotFoundError{}) {
+			log.Printf("cannot get token [%s]: %s", tokenID, err)
+			ljlib.ResponseHTTPError(w, "Cannot revoke token")
+			return
+		}
+		ljlib.ResponseHTTPNotFound(w, "Token not found")
+		return
+	}
+
+	if token.RevokedAt == nil {
+		revokedAt := time.Now().UTC()
+		token.RevokedAt = &revokedAt
+		if err := c.tokenStorage.UpdateToken(*token); err != nil {
+			log.Printf("cannot revoke token [%s]: %s", tokenID, err)
+			ljlib.ResponseHTTPError(w, "Cannot revoke token")
+			return
+		}
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionTokenRevoke, token.ID.String(), audit.OutcomeSuccess, nil)
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, token)
+}
+
+func generateAPIToken() (string, error) {
+	raw := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("cannot read random bytes: %w", err)
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
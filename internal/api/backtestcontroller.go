@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/backtest"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type BacktestController struct {
+	runner      BacktestRunner
+	auditLogger AuditLogger
+}
+
+type BacktestRunner interface {
+	Submit(userID uuid.UUID, request backtest.Request) (backtest.Job, error)
+	GetJob(userID uuid.UUID, id uuid.UUID) (backtest.Job, error)
+	ListJobs(userID uuid.UUID) []backtest.Job
+}
+
+func NewBacktestController(runner BacktestRunner, auditLogger AuditLogger) BacktestController {
+	return BacktestController{
+		runner:      runner,
+		auditLogger: auditLogger,
+	}
+}
+
+type createBacktestRequest struct {
+	Strategy    string              `json:"strategy"`
+	Parameters  map[string]int      `json:"parameters"`
+	Tickers     []string            `json:"tickers"`
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	InitialCash decimal.Decimal     `json:"initial_cash"`
+	SlippageBps decimal.NullDecimal `json:"slippage_bps"`
+}
+
+// CreateBacktest queues the backtest of a strategy, which runs in the background. The job it returns is polled
+// for the report.
+func (c BacktestController) CreateBacktest(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request createBacktestRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	from, err := parseDateParam(request.From, "from")
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	to, err := parseDateParam(request.To, "to")
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	job, err := c.runner.Submit(user.ID, backtest.Request{
+		Strategy:    request.Strategy,
+		Parameters:  request.Parameters,
+		Tickers:     request.Tickers,
+		From:        from,
+		To:          to,
+		InitialCash: request.InitialCash,
+		SlippageBps: request.SlippageBps,
+	})
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionBacktestCreate, user.ID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responseBacktestError(w, err, "Cannot create backtest")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionBacktestCreate, job.ID.String(), audit.OutcomeSuccess,
+		map[string]string{"strategy": job.Strategy, "tickers": strings.Join(job.Config.Tickers, ",")})
+	ljlib.ResponseHTTP(w, http.StatusAccepted, job)
+}
+
+// GetBacktest returns the user's backtest, with its report once it's completed.
+func (c BacktestController) GetBacktest(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid backtest id")
+		return
+	}
+
+	job, err := c.runner.GetJob(user.ID, id)
+	if err != nil {
+		c.responseBacktestError(w, err, "Cannot get backtest")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, job)
+}
+
+// ListBacktests returns the user's backtests, the most recent first, without their reports.
+func (c BacktestController) ListBacktests(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	ljlib.ResponseHTTP(w, http.StatusOK, c.runner.ListJobs(user.ID))
+}
+
+func (c BacktestController) responseBacktestError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.IllegalArgumentError{}):
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+	case errors.Is(err, ljlib.NotFoundError{}):
+		ljlib.ResponseHTTPNotFound(w, "Backtest not found")
+	case errors.Is(err, ljlib.ConflictError{}):
+		ljlib.ResponseHTTPConflict(w, err.Error())
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/cash"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type CashController struct {
+	cashManager CashManager
+	auditLogger AuditLogger
+}
+
+type CashManager interface {
+	GetSummary(userID uuid.UUID) (cash.Summary, error)
+	Deposit(userID uuid.UUID, request cash.TransferRequest) (cash.Account, error)
+	Withdraw(userID uuid.UUID, request cash.TransferRequest) (cash.Account, error)
+}
+
+func NewCashController(cashManager CashManager, auditLogger AuditLogger) CashController {
+	return CashController{
+		cashManager: cashManager,
+		auditLogger: auditLogger,
+	}
+}
+
+type transferRequest struct {
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// GetCash returns the user's cash accounts, and the totals of the cash and the portfolio per currency.
+func (c CashController) GetCash(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	summary, err := c.cashManager.GetSummary(user.ID)
+	if err != nil {
+		c.responseCashError(w, err, "Cannot get cash")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, summary)
+}
+
+func (c CashController) Deposit(w http.ResponseWriter, r *http.Request) {
+	c.transfer(w, r, audit.ActionCashDeposit, c.cashManager.Deposit)
+}
+
+func (c CashController) Withdraw(w http.ResponseWriter, r *http.Request) {
+	c.transfer(w, r, audit.ActionCashWithdraw, c.cashManager.Withdraw)
+}
+
+func (c CashController) transfer(w http.ResponseWriter, r *http.Request, action string,
+	transfer func(userID uuid.UUID, request cash.TransferRequest) (cash.Account, error)) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request transferRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	account, err := transfer(user.ID, cash.TransferRequest{
+		Amount:   request.Amount,
+		Currency: request.Currency,
+	})
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, action, request.Currency, audit.OutcomeFailure,
+			map[string]string{"amount": request.Amount.String(), "reason": err.Error()})
+		c.responseCashError(w, err, "Cannot transfer cash")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, action, account.Currency, audit.OutcomeSuccess,
+		map[string]string{"amount": request.Amount.String()})
+	ljlib.ResponseHTTP(w, http.StatusCreated, account)
+}
+
+func (c CashController) responseCashError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.IllegalArgumentError{}):
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+type InstrumentController struct {
+	instruments InstrumentSource
+}
+
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+	GetBenchmarks() ([]ljlib.Benchmark, error)
+}
+
+func NewInstrumentController(instruments InstrumentSource) InstrumentController {
+	return InstrumentController{
+		instruments: instruments,
+	}
+}
+
+// GetInstrument returns the reference data of the ticker, e.g. the precision of the quantities it's traded in.
+func (c InstrumentController) GetInstrument(w http.ResponseWriter, r *http.Request) {
+	ticker := strings.ToUpper(mux.Vars(r)["ticker"])
+
+	instrument, err := c.instruments.GetInstrument(ticker)
+	if err != nil {
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			ljlib.ResponseHTTPNotFound(w, "Instrument not found")
+			return
+		}
+		log.Printf("Cannot get instrument [%s]: %s", ticker, err)
+		ljlib.ResponseHTTPError(w, "Cannot get instrument")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, instrument)
+}
+
+// GetBenchmarks returns the market indices the portfolio and the tickers can be compared against.
+func (c InstrumentController) GetBenchmarks(w http.ResponseWriter, r *http.Request) {
+	benchmarks, err := c.instruments.GetBenchmarks()
+	if err != nil {
+		log.Printf("Cannot get benchmarks: %s", err)
+		ljlib.ResponseHTTPError(w, "Cannot get benchmarks")
+		return
+	}
+	if benchmarks == nil {
+		benchmarks = []ljlib.Benchmark{}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, benchmarks)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+type MarginController struct {
+	marginCalls   MarginCallProvider
+	notifications NotificationProvider
+}
+
+type MarginCallProvider interface {
+	ListMarginCalls(userID uuid.UUID) ([]ljlib.MarginCall, error)
+}
+
+type NotificationProvider interface {
+	ListNotifications(userID uuid.UUID) ([]ljlib.Notification, error)
+}
+
+func NewMarginController(marginCalls MarginCallProvider, notifications NotificationProvider) MarginController {
+	return MarginController{
+		marginCalls:   marginCalls,
+		notifications: notifications,
+	}
+}
+
+// GetMarginCalls returns the user's margin calls, the most recent first.
+func (c MarginController) GetMarginCalls(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	calls, err := c.marginCalls.ListMarginCalls(user.ID)
+	if err != nil {
+		log.Printf("Cannot get margin calls of user [%s]: %s", user.ID, err)
+		ljlib.ResponseHTTPError(w, "Cannot get margin calls")
+		return
+	}
+	if calls == nil {
+		calls = []ljlib.MarginCall{}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, calls)
+}
+
+// GetNotifications returns the user's notifications, the most recent first.
+func (c MarginController) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	notifications, err := c.notifications.ListNotifications(user.ID)
+	if err != nil {
+		log.Printf("Cannot get notifications of user [%s]: %s", user.ID, err)
+		ljlib.ResponseHTTPError(w, "Cannot get notifications")
+		return
+	}
+	if notifications == nil {
+		notifications = []ljlib.Notification{}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, notifications)
+}
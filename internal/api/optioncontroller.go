@@ -0,0 +1,71 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+type OptionController struct {
+	options OptionSource
+}
+
+type OptionSource interface {
+	GetOptionExpiries(underlying string, at time.Time) ([]time.Time, error)
+	GetOptionChain(underlying string, expiryDate time.Time, at time.Time) (ljlib.OptionChain, error)
+}
+
+func NewOptionController(options OptionSource) OptionController {
+	return OptionController{
+		options: options,
+	}
+}
+
+// GetOptionChain returns the options of the ticker expiring on the date in the expiry parameter, as YYYY-MM-DD,
+// or on the nearest expiry when it's not set, with their theoretical prices and Greeks.
+func (c OptionController) GetOptionChain(w http.ResponseWriter, r *http.Request) {
+	ticker := strings.ToUpper(mux.Vars(r)["ticker"])
+	now := time.Now()
+
+	expiries, err := c.options.GetOptionExpiries(ticker, now)
+	if err != nil {
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			ljlib.ResponseHTTPNotFound(w, "Ticker not found")
+			return
+		}
+		log.Printf("Cannot get option expiries of [%s]: %s", ticker, err)
+		ljlib.ResponseHTTPError(w, "Cannot get option chain")
+		return
+	}
+	if len(expiries) == 0 {
+		ljlib.ResponseHTTPNotFound(w, "No options listed")
+		return
+	}
+
+	expiry := expiries[0]
+	if value := r.URL.Query().Get("expiry"); len(value) > 0 {
+		expiry, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			ljlib.ResponseHTTPBadRequest(w, "Invalid expiry, expected YYYY-MM-DD")
+			return
+		}
+	}
+
+	chain, err := c.options.GetOptionChain(ticker, expiry, now)
+	if err != nil {
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			ljlib.ResponseHTTPBadRequest(w, err.Error())
+			return
+		}
+		log.Printf("Cannot get option chain of [%s]: %s", ticker, err)
+		ljlib.ResponseHTTPError(w, "Cannot get option chain")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, chain)
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type OrderController struct {
+	orderManager OrderManager
+	auditLogger  AuditLogger
+}
+
+type OrderManager interface {
+	PlaceOrder(userID uuid.UUID, request trading.PlaceOrderRequest) (ljlib.Order, error)
+	CancelOrder(userID uuid.UUID, orderID uuid.UUID) (ljlib.Order, error)
+	AmendOrder(userID uuid.UUID, orderID uuid.UUID, request trading.AmendOrderRequest) (ljlib.Order, error)
+	GetOrder(userID uuid.UUID, orderID uuid.UUID) (ljlib.Order, error)
+	ListOrders(userID uuid.UUID) ([]ljlib.Order, error)
+}
+
+func NewOrderController(orderManager OrderManager, auditLogger AuditLogger) OrderController {
+	return OrderController{
+		orderManager: orderManager,
+		auditLogger:  auditLogger,
+	}
+}
+
+type placeOrderRequest struct {
+	Ticker      string          `json:"ticker"`
+	Side        string          `json:"side"`
+	Type        string          `json:"type"`
+	TimeInForce string          `json:"time_in_force"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	LimitPrice  decimal.Decimal `json:"limit_price"`
+	StopPrice   decimal.Decimal `json:"stop_price"`
+}
+
+// orderRejectionHTTP tells why the order failed the pre-trade risk checks. The rejected order is stored anyway.
+type orderRejectionHTTP struct {
+	Message string      `json:"message"`
+	Reason  string      `json:"reason"`
+	Order   ljlib.Order `json:"order"`
+}
+
+func (c OrderController) PlaceOrder(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request placeOrderRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	order, err := c.orderManager.PlaceOrder(user.ID, trading.PlaceOrderRequest{
+		Ticker:      request.Ticker,
+		Side:        ljlib.OrderSide(request.Side),
+		Type:        ljlib.OrderType(request.Type),
+		TimeInForce: ljlib.TimeInForce(request.TimeInForce),
+		Quantity:    request.Quantity,
+		LimitPrice:  request.LimitPrice,
+		StopPrice:   request.StopPrice,
+	})
+	var rejection ljlib.RejectionError
+	if errors.As(err, &rejection) {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderPlace, order.ID.String(), audit.OutcomeFailure,
+			map[string]string{"ticker": order.Ticker, "reason": rejection.Reason()})
+		ljlib.ResponseHTTP(w, http.StatusUnprocessableEntity, orderRejectionHTTP{
+			Message: rejection.Error(),
+			Reason:  rejection.Reason(),
+			Order:   order,
+		})
+		return
+	}
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderPlace, request.Ticker, audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responseOrderError(w, err, "Cannot place order")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderPlace, order.ID.String(), audit.OutcomeSuccess,
+		map[string]string{"ticker": order.Ticker, "side": string(order.Side), "quantity": order.Quantity.String(),
+			"status": string(order.Status)})
+	setETag(w, order)
+	ljlib.ResponseHTTP(w, http.StatusCreated, order)
+}
+
+// GetOrders returns the user's orders, the most recent first, optionally filtered by status.
+// Status "open" matches both accepted and partially filled orders.
+func (c OrderController) GetOrders(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	orders, err := c.orderManager.ListOrders(user.ID)
+	if err != nil {
+		c.responseOrderError(w, err, "Cannot get orders")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	filtered := make([]ljlib.Order, 0, len(orders))
+	for _, o := range orders {
+		if len(status) == 0 || string(o.Status) == status || (status == "open" && o.IsOpen()) {
+			filtered = append(filtered, o)
+		}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, filtered)
+}
+
+func (c OrderController) GetOrder(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid order id")
+		return
+	}
+
+	order, err := c.orderManager.GetOrder(user.ID, orderID)
+	if err != nil {
+		c.responseOrderError(w, err, "Cannot get order")
+		return
+	}
+
+	setETag(w, order)
+	ljlib.ResponseHTTP(w, http.StatusOK, order)
+}
+
+// GetOrderEvents returns the revision history of the order, the oldest first.
+func (c OrderController) GetOrderEvents(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid order id")
+		return
+	}
+
+	order, err := c.orderManager.GetOrder(user.ID, orderID)
+	if err != nil {
+		c.responseOrderError(w, err, "Cannot get order events")
+		return
+	}
+
+	setETag(w, order)
+	ljlib.ResponseHTTP(w, http.StatusOK, order.Events)
+}
+
+type amendOrderRequest struct {
+	Version    *int64          `json:"version"`
+	Quantity   decimal.Decimal `json:"quantity"`
+	LimitPrice decimal.Decimal `json:"limit_price"`
+	StopPrice  decimal.Decimal `json:"stop_price"`
+}
+
+// AmendOrder changes the quantity or the prices of an open order. The version the amendment is based on
+// is taken from the If-Match header, holding the ETag of the order, or from the version in the body.
+func (c OrderController) AmendOrder(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid order id")
+		return
+	}
+
+	var request amendOrderRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	var version int64
+	switch ifMatch := r.Header.Get("If-Match"); {
+	case len(ifMatch) > 0:
+		version, err = parseETag(ifMatch)
+		if err != nil {
+			ljlib.ResponseHTTPBadRequest(w, "Invalid If-Match header")
+			return
+		}
+	case request.Version != nil:
+		version = *request.Version
+	default:
+		ljlib.ResponseHTTPPreconditionRequired(w, "If-Match header or version is required")
+		return
+	}
+
+	order, err := c.orderManager.AmendOrder(user.ID, orderID, trading.AmendOrderRequest{
+		Version:    version,
+		Quantity:   request.Quantity,
+		LimitPrice: request.LimitPrice,
+		StopPrice:  request.StopPrice,
+	})
+	var rejection ljlib.RejectionError
+	if errors.As(err, &rejection) {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderAmend, order.ID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": rejection.Reason()})
+		setETag(w, order)
+		ljlib.ResponseHTTP(w, http.StatusUnprocessableEntity, orderRejectionHTTP{
+			Message: rejection.Error(),
+			Reason:  rejection.Reason(),
+			Order:   order,
+		})
+		return
+	}
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderAmend, orderID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responseOrderError(w, err, "Cannot amend order")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderAmend, order.ID.String(), audit.OutcomeSuccess,
+		map[string]string{"quantity": order.Quantity.String(), "version": strconv.FormatInt(order.Version, 10)})
+	setETag(w, order)
+	ljlib.ResponseHTTP(w, http.StatusOK, order)
+}
+
+func (c OrderController) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid order id")
+		return
+	}
+
+	order, err := c.orderManager.CancelOrder(user.ID, orderID)
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderCancel, orderID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responseOrderError(w, err, "Cannot cancel order")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionOrderCancel, order.ID.String(), audit.OutcomeSuccess, nil)
+	ljlib.ResponseHTTP(w, http.StatusOK, order)
+}
+
+func (c OrderController) responseOrderError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.IllegalArgumentError{}):
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+	case errors.Is(err, ljlib.NotFoundError{}):
+		ljlib.ResponseHTTPNotFound(w, "Order not found")
+	case errors.Is(err, ljlib.ConflictError{}):
+		ljlib.ResponseHTTPConflict(w, err.Error())
+	case errors.Is(err, ljlib.PreconditionFailedError{}):
+		ljlib.ResponseHTTPPreconditionFailed(w, err.Error())
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
+
+// setETag tags the response with the version of the order, to be sent back in If-Match when amending it.
+func setETag(w http.ResponseWriter, order ljlib.Order) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(order.Version, 10)))
+}
+
+func parseETag(value string) (int64, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		unquoted = value
+	}
+	return strconv.ParseInt(unquoted, 10, 64)
+}
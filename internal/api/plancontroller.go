@@ -0,0 +1,267 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/plans"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type PlanController struct {
+	planManager PlanManager
+	auditLogger AuditLogger
+}
+
+type PlanManager interface {
+	CreatePlan(userID uuid.UUID, request plans.CreatePlanRequest) (ljlib.Plan, error)
+	GetPlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error)
+	ListPlans(userID uuid.UUID) ([]ljlib.Plan, error)
+	UpdatePlan(userID uuid.UUID, planID uuid.UUID, request plans.UpdatePlanRequest) (ljlib.Plan, error)
+	DeletePlan(userID uuid.UUID, planID uuid.UUID) error
+	PausePlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error)
+	ResumePlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error)
+	SkipNextRun(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error)
+}
+
+func NewPlanController(planManager PlanManager, auditLogger AuditLogger) PlanController {
+	return PlanController{
+		planManager: planManager,
+		auditLogger: auditLogger,
+	}
+}
+
+type createPlanRequest struct {
+	Ticker   string          `json:"ticker"`
+	Amount   decimal.Decimal `json:"amount"`
+	Schedule string          `json:"schedule"`
+}
+
+func (c PlanController) CreatePlan(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request createPlanRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	plan, err := c.planManager.CreatePlan(user.ID, plans.CreatePlanRequest{
+		Ticker:   request.Ticker,
+		Amount:   request.Amount,
+		Schedule: request.Schedule,
+	})
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionPlanCreate, request.Ticker, audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responsePlanError(w, err, "Cannot create plan")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionPlanCreate, plan.ID.String(), audit.OutcomeSuccess,
+		map[string]string{"ticker": plan.Ticker, "amount": plan.Amount.String(), "schedule": plan.Schedule})
+	ljlib.ResponseHTTP(w, http.StatusCreated, plan)
+}
+
+// GetPlans returns the user's plans, the most recent first.
+func (c PlanController) GetPlans(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	userPlans, err := c.planManager.ListPlans(user.ID)
+	if err != nil {
+		c.responsePlanError(w, err, "Cannot get plans")
+		return
+	}
+	if userPlans == nil {
+		userPlans = []ljlib.Plan{}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, userPlans)
+}
+
+func (c PlanController) GetPlan(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	planID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid plan id")
+		return
+	}
+
+	plan, err := c.planManager.GetPlan(user.ID, planID)
+	if err != nil {
+		c.responsePlanError(w, err, "Cannot get plan")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, plan)
+}
+
+// GetPlanExecutions returns the history of the plan's scheduled runs, the oldest first.
+func (c PlanController) GetPlanExecutions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	planID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid plan id")
+		return
+	}
+
+	plan, err := c.planManager.GetPlan(user.ID, planID)
+	if err != nil {
+		c.responsePlanError(w, err, "Cannot get plan executions")
+		return
+	}
+	executions := plan.Executions
+	if executions == nil {
+		executions = []ljlib.PlanExecution{}
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, executions)
+}
+
+type updatePlanRequest struct {
+	Amount   decimal.Decimal `json:"amount"`
+	Schedule string          `json:"schedule"`
+}
+
+func (c PlanController) UpdatePlan(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	planID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid plan id")
+		return
+	}
+
+	var request updatePlanRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	plan, err := c.planManager.UpdatePlan(user.ID, planID, plans.UpdatePlanRequest{
+		Amount:   request.Amount,
+		Schedule: request.Schedule,
+	})
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionPlanUpdate, planID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responsePlanError(w, err, "Cannot update plan")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionPlanUpdate, plan.ID.String(), audit.OutcomeSuccess,
+		map[string]string{"amount": plan.Amount.String(), "schedule": plan.Schedule})
+	ljlib.ResponseHTTP(w, http.StatusOK, plan)
+}
+
+func (c PlanController) DeletePlan(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	planID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid plan id")
+		return
+	}
+
+	if err := c.planManager.DeletePlan(user.ID, planID); err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionPlanDelete, planID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responsePlanError(w, err, "Cannot delete plan")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionPlanDelete, planID.String(), audit.OutcomeSuccess, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c PlanController) PausePlan(w http.ResponseWriter, r *http.Request) {
+	c.changePlan(w, r, audit.ActionPlanPause, c.planManager.PausePlan)
+}
+
+func (c PlanController) ResumePlan(w http.ResponseWriter, r *http.Request) {
+	c.changePlan(w, r, audit.ActionPlanResume, c.planManager.ResumePlan)
+}
+
+// SkipPlanRun skips the next scheduled run of the plan.
+func (c PlanController) SkipPlanRun(w http.ResponseWriter, r *http.Request) {
+	c.changePlan(w, r, audit.ActionPlanSkip, c.planManager.SkipNextRun)
+}
+
+func (c PlanController) changePlan(w http.ResponseWriter, r *http.Request, action string,
+	change func(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error)) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	planID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid plan id")
+		return
+	}
+
+	plan, err := change(user.ID, planID)
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, action, planID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responsePlanError(w, err, "Cannot change plan")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, action, plan.ID.String(), audit.OutcomeSuccess, nil)
+	ljlib.ResponseHTTP(w, http.StatusOK, plan)
+}
+
+func (c PlanController) responsePlanError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.IllegalArgumentError{}):
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+	case errors.Is(err, ljlib.NotFoundError{}):
+		ljlib.ResponseHTTPNotFound(w, "Plan not found")
+	case errors.Is(err, ljlib.ConflictError{}):
+		ljlib.ResponseHTTPConflict(w, err.Error())
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
@@ -9,6 +9,9 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/indicators"
 	"github.com/iliyaisd/littlejohn/ljlib"
 )
 
@@ -20,6 +23,7 @@ const (
 
 type PortfolioController struct {
 	priceDataSource DataSource
+	auditLogger     AuditLogger
 }
 
 type DataSource interface {
@@ -28,18 +32,20 @@ type DataSource interface {
 	UserHasTicker(userID uuid.UUID, ticker string) (bool, error)
 }
 
-func NewPortfolioController(ds DataSource) PortfolioController {
+func NewPortfolioController(ds DataSource, auditLogger AuditLogger) PortfolioController {
 	return PortfolioController{
 		priceDataSource: ds,
+		auditLogger:     auditLogger,
 	}
 }
 
 func (c PortfolioController) GetTickers(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value("user").(*ljlib.User)
+	principal, ok := auth.PrincipalFrom(r.Context())
 	if !ok {
 		ljlib.ResponseHTTPForbidden(w, "Forbidden")
 		return
 	}
+	user := principal.User
 
 	portfolio, err := c.priceDataSource.GetUserPortfolio(user.ID)
 	if err != nil {
@@ -52,15 +58,17 @@ func (c PortfolioController) GetTickers(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionPortfolioRead, "", audit.OutcomeSuccess, nil)
 	ljlib.ResponseHTTP(w, http.StatusOK, portfolio)
 }
 
 func (c PortfolioController) GetTickerHistory(w http.ResponseWriter, r *http.Request) {
-	user, ok := r.Context().Value("user").(*ljlib.User)
+	principal, ok := auth.PrincipalFrom(r.Context())
 	if !ok {
 		ljlib.ResponseHTTPForbidden(w, "Forbidden")
 		return
 	}
+	user := principal.User
 
 	ticker := mux.Vars(r)["ticker"]
 	hasTicker, err := c.priceDataSource.UserHasTicker(user.ID, ticker)
@@ -70,21 +78,55 @@ func (c PortfolioController) GetTickerHistory(w http.ResponseWriter, r *http.Req
 		return
 	}
 	if !hasTicker {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionHistoryRead, ticker, audit.OutcomeFailure,
+			map[string]string{"reason": "ticker not in portfolio"})
 		ljlib.ResponseHTTPNotFound(w, "Ticker not found")
 		return
 	}
 
+	requested, err := indicators.Parse(r.URL.Query().Get("indicators"))
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
 	page := c.extractPage(r)
 	dateFrom, dateTo := c.buildDatesForPage(page)
 
-	prices, err := c.priceDataSource.GetHistoricalPrices(ticker, dateFrom, dateTo)
+	//the indicators on the first day of the page depend on the prices before it
+	warmUpFrom := dateFrom.AddDate(0, 0, -warmUpDays(indicators.WarmUp(requested)))
+	prices, err := c.priceDataSource.GetHistoricalPrices(ticker, warmUpFrom, dateTo)
 	if err != nil {
 		log.Printf("cannot get historical prices: %s", err)
 		ljlib.ResponseHTTPError(w, "Cannot get historical prices")
 		return
 	}
 
-	ljlib.ResponseHTTP(w, http.StatusOK, prices)
+	details := map[string]string{"page": strconv.Itoa(page + 1)}
+	if len(requested) == 0 {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionHistoryRead, ticker, audit.OutcomeSuccess, details)
+		ljlib.ResponseHTTP(w, http.StatusOK, prices)
+		return
+	}
+
+	points := make([]indicators.Point, 0, len(prices))
+	for _, point := range indicators.Apply(prices, requested) {
+		if !point.Date.Before(dateFrom) {
+			points = append(points, point)
+		}
+	}
+	details["indicators"] = r.URL.Query().Get("indicators")
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionHistoryRead, ticker, audit.OutcomeSuccess, details)
+	ljlib.ResponseHTTP(w, http.StatusOK, points)
+}
+
+// warmUpDays is the number of calendar days which have at least the number of daily prices, even if there
+// are no prices on the weekends and the holidays.
+func warmUpDays(prices int) int {
+	if prices == 0 {
+		return 0
+	}
+	return prices*7/5 + 7
 }
 
 func (c PortfolioController) extractPage(r *http.Request) int {
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/rebalance"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type RebalanceController struct {
+	rebalancer  Rebalancer
+	auditLogger AuditLogger
+}
+
+type Rebalancer interface {
+	SetTargets(userID uuid.UUID, request rebalance.SetTargetsRequest) (ljlib.TargetAllocation, error)
+	GetTargets(userID uuid.UUID) (ljlib.TargetAllocation, error)
+	Preview(userID uuid.UUID, request rebalance.PreviewRequest) (rebalance.Preview, error)
+}
+
+func NewRebalanceController(rebalancer Rebalancer, auditLogger AuditLogger) RebalanceController {
+	return RebalanceController{
+		rebalancer:  rebalancer,
+		auditLogger: auditLogger,
+	}
+}
+
+type targetRequest struct {
+	Name   string          `json:"name"`
+	Weight decimal.Decimal `json:"weight"`
+}
+
+type setTargetsRequest struct {
+	By      ljlib.TargetsBy `json:"by"`
+	Targets []targetRequest `json:"targets"`
+}
+
+// SetTargets replaces the user's target weights, per ticker or per sector.
+func (c RebalanceController) SetTargets(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request setTargetsRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	targets := make([]ljlib.Target, 0, len(request.Targets))
+	for _, target := range request.Targets {
+		targets = append(targets, ljlib.Target{Name: target.Name, Weight: target.Weight})
+	}
+
+	allocation, err := c.rebalancer.SetTargets(user.ID, rebalance.SetTargetsRequest{By: request.By, Targets: targets})
+	if err != nil {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionTargetsUpdate, user.ID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": err.Error()})
+		c.responseRebalanceError(w, err, "Cannot set targets")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionTargetsUpdate, user.ID.String(), audit.OutcomeSuccess,
+		map[string]string{"by": string(allocation.By), "targets": strconv.Itoa(len(allocation.Targets))})
+	ljlib.ResponseHTTP(w, http.StatusOK, allocation)
+}
+
+func (c RebalanceController) GetTargets(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	allocation, err := c.rebalancer.GetTargets(user.ID)
+	if err != nil {
+		c.responseRebalanceError(w, err, "Cannot get targets")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, allocation)
+}
+
+type previewRebalanceRequest struct {
+	DriftBand           decimal.Decimal `json:"drift_band"`
+	MinTradeAmount      decimal.Decimal `json:"min_trade_amount"`
+	CashBuffer          decimal.Decimal `json:"cash_buffer"`
+	AvoidShortTermGains bool            `json:"avoid_short_term_gains"`
+	PlaceOrders         bool            `json:"place_orders"`
+}
+
+// PreviewRebalance returns the trades which get the user's portfolio back to the targets, placing them
+// as the orders when asked to.
+func (c RebalanceController) PreviewRebalance(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request previewRebalanceRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	preview, err := c.rebalancer.Preview(user.ID, rebalance.PreviewRequest{
+		Constraints: rebalance.Constraints{
+			DriftBand:           request.DriftBand,
+			MinTradeAmount:      request.MinTradeAmount,
+			CashBuffer:          request.CashBuffer,
+			AvoidShortTermGains: request.AvoidShortTermGains,
+		},
+		PlaceOrders: request.PlaceOrders,
+	})
+	if err != nil {
+		if request.PlaceOrders {
+			recordAuditEvent(c.auditLogger, r, user, audit.ActionRebalance, user.ID.String(), audit.OutcomeFailure,
+				map[string]string{"reason": err.Error()})
+		}
+		c.responseRebalanceError(w, err, "Cannot preview rebalance")
+		return
+	}
+
+	if request.PlaceOrders {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionRebalance, user.ID.String(), audit.OutcomeSuccess,
+			map[string]string{"trades": strconv.Itoa(len(preview.Trades)), "orders": strconv.Itoa(len(preview.Orders))})
+	}
+	ljlib.ResponseHTTP(w, http.StatusOK, preview)
+}
+
+func (c RebalanceController) responseRebalanceError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.IllegalArgumentError{}):
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+	case errors.Is(err, ljlib.NotFoundError{}):
+		ljlib.ResponseHTTPNotFound(w, "Targets not found")
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MaxRequestBodyBytes is the largest request body read by the API.
+const MaxRequestBodyBytes = 1 << 20
+
+// decodeJSONBody decodes the request body into v, rejecting unknown fields and trailing data.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, MaxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("cannot decode request body: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("request body must contain a single JSON object")
+	}
+	return nil
+}
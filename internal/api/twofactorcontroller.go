@@ -0,0 +1,248 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/totp"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+const (
+	totpIssuer         = "LittleJohn"
+	recoveryCodesCount = 10
+	recoveryCodeBytes  = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorController handles TOTP enrollment: the secret is generated first, and 2FA gets enabled
+// only after the user proves their authenticator app produces valid codes.
+type TwoFactorController struct {
+	userStorage UserStorage
+	auditLogger AuditLogger
+}
+
+func NewTwoFactorController(userStorage UserStorage, auditLogger AuditLogger) TwoFactorController {
+	return TwoFactorController{
+		userStorage: userStorage,
+		auditLogger: auditLogger,
+	}
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type twoFactorEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (c TwoFactorController) Enroll(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+	if user.TOTPEnabled {
+		ljlib.ResponseHTTPConflict(w, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("cannot generate TOTP secret for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot enroll two-factor authentication")
+		return
+	}
+
+	_, err = c.userStorage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		if stored.TOTPEnabled {
+			return ljlib.NewConflictError("two-factor authentication is already enabled")
+		}
+		stored.TOTPSecret = secret
+		return nil
+	})
+	if errors.Is(err, ljlib.ConflictError{}) {
+		ljlib.ResponseHTTPConflict(w, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		log.Printf("cannot store TOTP secret for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot enroll two-factor authentication")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, twoFactorEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(totpIssuer, user.Username, secret),
+	})
+}
+
+// Verify enables 2FA once the first code from the enrolled secret is verified, and returns one-time recovery codes.
+// The recovery codes are shown only once, as just their hashes are stored.
+func (c TwoFactorController) Verify(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+	if user.TOTPEnabled {
+		ljlib.ResponseHTTPConflict(w, "Two-factor authentication is already enabled")
+		return
+	}
+	if len(user.TOTPSecret) == 0 {
+		ljlib.ResponseHTTPBadRequest(w, "Two-factor authentication enrollment is not started")
+		return
+	}
+
+	var request twoFactorCodeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	counter, ok := totp.Validate(user.TOTPSecret, request.Code, time.Now())
+	if !ok {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("cannot generate recovery codes for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot enable two-factor authentication")
+		return
+	}
+
+	//the code is of the secret read at the authentication, which a concurrent enrollment may have replaced since
+	_, err = c.userStorage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		if stored.TOTPEnabled || stored.TOTPSecret != user.TOTPSecret {
+			return ljlib.NewConflictError("two-factor authentication is already enabled or re-enrolled")
+		}
+		stored.TOTPEnabled = true
+		stored.TOTPLastCounter = counter
+		stored.RecoveryCodeHashes = hashes
+		return nil
+	})
+	if errors.Is(err, ljlib.ConflictError{}) {
+		ljlib.ResponseHTTPConflict(w, "Two-factor authentication is already enabled or re-enrolled")
+		return
+	}
+	if err != nil {
+		log.Printf("cannot enable 2FA for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot enable two-factor authentication")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionTwoFactorSetup, user.ID.String(), audit.OutcomeSuccess, nil)
+	ljlib.ResponseHTTP(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Disable turns 2FA off. It requires a fresh code (or a recovery code) on top of the authentication itself.
+func (c TwoFactorController) Disable(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+	if !user.TOTPEnabled {
+		ljlib.ResponseHTTPBadRequest(w, "Two-factor authentication is not enabled")
+		return
+	}
+
+	var request twoFactorCodeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	ok, err := verifySecondFactor(c.userStorage, *user, request.Code, time.Now())
+	if err != nil {
+		log.Printf("cannot verify 2FA code for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot disable two-factor authentication")
+		return
+	}
+	if !ok {
+		recordAuditEvent(c.auditLogger, r, user, audit.ActionTwoFactorOff, user.ID.String(), audit.OutcomeFailure,
+			map[string]string{"reason": "invalid code"})
+		ljlib.ResponseHTTPBadRequest(w, "Invalid code")
+		return
+	}
+
+	_, err = c.userStorage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		stored.TOTPEnabled = false
+		stored.TOTPSecret = ""
+		stored.TOTPLastCounter = 0
+		stored.RecoveryCodeHashes = nil
+		return nil
+	})
+	if err != nil {
+		log.Printf("cannot disable 2FA for user [%s]: %s", user.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot disable two-factor authentication")
+		return
+	}
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionTwoFactorOff, user.ID.String(), audit.OutcomeSuccess, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifySecondFactor accepts either a TOTP code, which must be newer than the last accepted one,
+// or an unused recovery code, which gets consumed. The code is used up in the storage, which fails
+// the verification when a concurrent request has already used it.
+func verifySecondFactor(storage SecondFactorStorage, user ljlib.User, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == 0 || !user.TOTPEnabled {
+		return false, nil
+	}
+
+	var err error
+	if counter, ok := totp.Validate(user.TOTPSecret, code, now); ok {
+		err = storage.UseTOTPCounter(user.ID, counter)
+	} else {
+		err = storage.UseRecoveryCode(user.ID, hashRecoveryCode(code))
+	}
+	if errors.Is(err, ljlib.ConflictError{}) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("cannot generate recovery code: %w", err)
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+		code := encoded[:4] + "-" + encoded[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes the code, so it can be typed in any case and with or without the dash.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(code)), "-", "")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
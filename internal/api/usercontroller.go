@@ -0,0 +1,264 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_.-]{3,32}$`)
+
+type UserController struct {
+	userStorage UserStorage
+	auditLogger AuditLogger
+}
+
+type UserStorage interface {
+	GetUserByID(userID uuid.UUID) (*ljlib.User, error)
+	ListUsers() ([]ljlib.User, error)
+	CreateUser(user ljlib.User) error
+	//ModifyUser applies the change to the latest stored user under the storage lock, so the fields it doesn't
+	//set are kept as they are, and not restored from a copy read earlier.
+	ModifyUser(userID uuid.UUID, change func(user *ljlib.User) error) (ljlib.User, error)
+	SecondFactorStorage
+}
+
+func NewUserController(userStorage UserStorage, auditLogger AuditLogger) UserController {
+	return UserController{
+		userStorage: userStorage,
+		auditLogger: auditLogger,
+	}
+}
+
+type createUserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type updateUserRequest struct {
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+}
+
+func (c UserController) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var request createUserRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+	if err := validateSignup(request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	passwordHash, err := hashPassword(request.Password)
+	if err != nil {
+		log.Printf("cannot hash password for new user [%s]: %s", request.Username, err)
+		ljlib.ResponseHTTPError(w, "Cannot create user")
+		return
+	}
+
+	user := ljlib.User{
+		ID:           uuid.New(),
+		Username:     request.Username,
+		Email:        request.Email,
+		Role:         ljlib.RoleUser,
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := c.userStorage.CreateUser(user); err != nil {
+		c.responseStorageError(w, err, "Cannot create user")
+		return
+	}
+
+	recordAuditEvent(c.auditLogger, r, &user, audit.ActionUserCreate, user.ID.String(), audit.OutcomeSuccess, nil)
+	ljlib.ResponseHTTP(w, http.StatusCreated, user)
+}
+
+func (c UserController) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	ljlib.ResponseHTTP(w, http.StatusOK, user)
+}
+
+func (c UserController) UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	var request updateUserRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		ljlib.ResponseHTTPBadRequest(w, err.Error())
+		return
+	}
+
+	if request.Email != nil {
+		if err := validateEmail(*request.Email); err != nil {
+			ljlib.ResponseHTTPBadRequest(w, err.Error())
+			return
+		}
+	}
+	var passwordHash string
+	if request.Password != nil {
+		if err := validatePassword(*request.Password); err != nil {
+			ljlib.ResponseHTTPBadRequest(w, err.Error())
+			return
+		}
+		var err error
+		passwordHash, err = hashPassword(*request.Password)
+		if err != nil {
+			log.Printf("cannot hash password for user [%s]: %s", user.Username, err)
+			ljlib.ResponseHTTPError(w, "Cannot update user")
+			return
+		}
+	}
+
+	updated, err := c.userStorage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		if request.Email != nil {
+			stored.Email = *request.Email
+		}
+		if len(passwordHash) > 0 {
+			stored.PasswordHash = passwordHash
+		}
+		return nil
+	})
+	if err != nil {
+		c.responseStorageError(w, err, "Cannot update user")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, updated)
+}
+
+// DeleteCurrentUser soft-deletes the account: the record is kept, but the user can no longer authenticate.
+func (c UserController) DeleteCurrentUser(w http.ResponseWriter, r *http.Request) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	user := principal.User
+
+	deletedAt := time.Now().UTC()
+	_, err := c.userStorage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		stored.DeletedAt = &deletedAt
+		return nil
+	})
+	if err != nil {
+		c.responseStorageError(w, err, "Cannot delete user")
+		return
+	}
+	recordAuditEvent(c.auditLogger, r, user, audit.ActionUserDelete, user.ID.String(), audit.OutcomeSuccess, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c UserController) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := c.userStorage.ListUsers()
+	if err != nil {
+		log.Printf("cannot list users: %s", err)
+		ljlib.ResponseHTTPError(w, "Cannot list users")
+		return
+	}
+
+	ljlib.ResponseHTTP(w, http.StatusOK, users)
+}
+
+func (c UserController) DisableUser(w http.ResponseWriter, r *http.Request) {
+	c.setUserDisabled(w, r, true)
+}
+
+func (c UserController) EnableUser(w http.ResponseWriter, r *http.Request) {
+	c.setUserDisabled(w, r, false)
+}
+
+func (c UserController) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		ljlib.ResponseHTTPForbidden(w, "Forbidden")
+		return
+	}
+	admin := principal.User
+
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		ljlib.ResponseHTTPBadRequest(w, "Invalid user id")
+		return
+	}
+	if userID == admin.ID {
+		ljlib.ResponseHTTPBadRequest(w, "Cannot change own account status")
+		return
+	}
+
+	user, err := c.userStorage.ModifyUser(userID, func(stored *ljlib.User) error {
+		stored.Disabled = disabled
+		return nil
+	})
+	if err != nil {
+		c.responseStorageError(w, err, "Cannot update user")
+		return
+	}
+	action := audit.ActionUserEnable
+	if disabled {
+		action = audit.ActionUserDisable
+	}
+	recordAuditEvent(c.auditLogger, r, admin, action, user.ID.String(), audit.OutcomeSuccess, nil)
+
+	ljlib.ResponseHTTP(w, http.StatusOK, user)
+}
+
+func (c UserController) responseStorageError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, ljlib.ConflictError{}):
+		ljlib.ResponseHTTPConflict(w, err.Error())
+	case errors.Is(err, ljlib.NotFoundError{}):
+		ljlib.ResponseHTTPNotFound(w, "User not found")
+	default:
+		log.Printf("%s: %s", message, err)
+		ljlib.ResponseHTTPError(w, message)
+	}
+}
+
+func validateSignup(request createUserRequest) error {
+	if !usernamePattern.MatchString(request.Username) {
+		return ljlib.NewIllegalArgumentError(
+			"username must be 3 to 32 characters long and contain only lowercase letters, digits, '_', '.' or '-'")
+	}
+	if err := validateEmail(request.Email); err != nil {
+		return err
+	}
+	return validatePassword(request.Password)
+}
+
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return ljlib.NewIllegalArgumentError("invalid email address [%s]", email)
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return ljlib.NewIllegalArgumentError("password must be at least %d characters long", minPasswordLength)
+	}
+	return nil
+}
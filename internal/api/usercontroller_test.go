@@ -0,0 +1,101 @@
+package api_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/iliyaisd/littlejohn/internal/api"
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRecoveryCode = "abcd-efgh"
+
+func TestUserController_KeepsUsedRecoveryCode(t *testing.T) {
+	testCases := map[string]struct {
+		method  string
+		body    string
+		handler func(c api.UserController) http.HandlerFunc
+	}{
+		"it should not restore the used recovery code when updating the user": {
+			method:  http.MethodPatch,
+			body:    `{"email": "changed@littlejohn.example"}`,
+			handler: func(c api.UserController) http.HandlerFunc { return c.UpdateCurrentUser },
+		},
+		"it should not restore the used recovery code when deleting the user": {
+			method:  http.MethodDelete,
+			handler: func(c api.UserController) http.HandlerFunc { return c.DeleteCurrentUser },
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			storage := datasource.NewLocalUserStorage()
+			sum := sha256.Sum256([]byte(strings.ReplaceAll(testRecoveryCode, "-", "")))
+			user := ljlib.User{ID: uuid.New(), Username: testTOTPUsername, TOTPSecret: testTOTPSecret,
+				TOTPEnabled: true, RecoveryCodeHashes: []string{hex.EncodeToString(sum[:])}}
+			require.NoError(t, storage.CreateUser(user))
+			authorizer := api.NewAPIKeyAuthorizer(storage, mockTokenRepository{})
+			controller := api.NewUserController(storage, mockAuditLogger{})
+
+			r := httptest.NewRequest(testCase.method, "/users/me", strings.NewReader(testCase.body))
+			r.SetBasicAuth(testTOTPUsername, "")
+			r.Header.Set(api.OTPHeader, testRecoveryCode)
+			r, err := authorizer.Authorize(r)
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			testCase.handler(controller)(w, r)
+			require.Less(t, w.Code, 300, w.Body.String())
+
+			stored, err := storage.GetUserByID(user.ID)
+			require.NoError(t, err)
+			assert.Empty(t, stored.RecoveryCodeHashes)
+			assert.True(t, stored.TOTPEnabled)
+		})
+	}
+}
+
+func TestUserController_ConcurrentWrites(t *testing.T) {
+	storage := datasource.NewLocalUserStorage()
+	controller := api.NewUserController(storage, mockAuditLogger{})
+	user, err := storage.GetUserByUsername("jennifer")
+	require.NoError(t, err)
+	admin, err := storage.GetUserByUsername("littlejohn")
+	require.NoError(t, err)
+
+	//the user is authenticated before the admin disables them, and updates their email after
+	userRequest := httptest.NewRequest(http.MethodPatch, "/users/me",
+		strings.NewReader(`{"email": "changed@littlejohn.example"}`))
+	userRequest = userRequest.WithContext(auth.WithPrincipal(userRequest.Context(),
+		auth.NewPrincipal(user, auth.MethodPassword, "")))
+	adminRequest := httptest.NewRequest(http.MethodPost, "/users/"+user.ID.String()+"/disable", nil)
+	adminRequest = mux.SetURLVars(adminRequest.WithContext(auth.WithPrincipal(adminRequest.Context(),
+		auth.NewPrincipal(admin, auth.MethodPassword, ""))), map[string]string{"id": user.ID.String()})
+
+	w := httptest.NewRecorder()
+	controller.DisableUser(w, adminRequest)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = httptest.NewRecorder()
+	controller.UpdateCurrentUser(w, userRequest)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.Disabled, "the update doesn't re-enable the user")
+	assert.Equal(t, "changed@littlejohn.example", stored.Email)
+}
+
+type mockAuditLogger struct{}
+
+func (m mockAuditLogger) Record(event audit.Event) error {
+	return nil
+}
@@ -0,0 +1,199 @@
+// Package audit keeps a tamper-evident trail of security relevant events.
+// Every event holds the hash of the previous one, so removing or altering any event breaks the chain.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionLogin          = "auth.login"
+	ActionTokenIssue     = "auth.token.issue"
+	ActionTokenRevoke    = "auth.token.revoke"
+	ActionPortfolioRead  = "portfolio.read"
+	ActionHistoryRead    = "ticker_history.read"
+	ActionAnalyticsRead  = "portfolio_analytics.read"
+	ActionUserCreate     = "user.create"
+	ActionUserDelete     = "user.delete"
+	ActionUserDisable    = "user.disable"
+	ActionUserEnable     = "user.enable"
+	ActionTwoFactorSetup = "user.2fa.enable"
+	ActionTwoFactorOff   = "user.2fa.disable"
+	ActionOrderPlace     = "order.place"
+	ActionOrderCancel    = "order.cancel"
+	ActionOrderAmend     = "order.amend"
+	ActionPlanCreate     = "plan.create"
+	ActionPlanUpdate     = "plan.update"
+	ActionPlanDelete     = "plan.delete"
+	ActionPlanPause      = "plan.pause"
+	ActionPlanResume     = "plan.resume"
+	ActionPlanSkip       = "plan.skip"
+	ActionCashDeposit    = "cash.deposit"
+	ActionCashWithdraw   = "cash.withdraw"
+	ActionTargetsUpdate  = "portfolio.targets.update"
+	ActionRebalance      = "portfolio.rebalance"
+	ActionBacktestCreate = "backtest.create"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event answers who did what, when, from where, and with what outcome.
+type Event struct {
+	Sequence int64             `json:"sequence"`
+	Time     time.Time         `json:"time"`
+	ActorID  string            `json:"actor_id,omitempty"`
+	Actor    string            `json:"actor,omitempty"`
+	Action   string            `json:"action"`
+	Resource string            `json:"resource,omitempty"`
+	Outcome  string            `json:"outcome"`
+	SourceIP string            `json:"source_ip,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	PrevHash string            `json:"prev_hash"`
+	Hash     string            `json:"hash"`
+}
+
+// computeHash hashes all the fields but Hash itself. JSON encoding is stable, as struct fields
+// are encoded in declaration order and map keys are sorted.
+func (e Event) computeHash() (string, error) {
+	e.Hash = ""
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal audit event: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Sink stores events. Implementations must be append-only: events are never updated or deleted.
+type Sink interface {
+	Append(event Event) error
+	ReadAll() ([]Event, error)
+}
+
+// Filter narrows down the query; zero values match everything.
+type Filter struct {
+	ActorID string
+	Action  string
+	Outcome string
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+func (f Filter) matches(e Event) bool {
+	return (len(f.ActorID) == 0 || e.ActorID == f.ActorID) &&
+		(len(f.Action) == 0 || e.Action == f.Action) &&
+		(len(f.Outcome) == 0 || e.Outcome == f.Outcome) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || !e.Time.After(f.To))
+}
+
+// Logger assigns sequence numbers and chains the events before appending them to the sink.
+type Logger struct {
+	mu       sync.Mutex
+	sink     Sink
+	sequence int64
+	lastHash string
+	now      func() time.Time
+}
+
+// NewLogger continues the chain already stored in the sink, after verifying it.
+func NewLogger(sink Sink) (*Logger, error) {
+	events, err := sink.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audit log: %w", err)
+	}
+	if err := Verify(events); err != nil {
+		return nil, fmt.Errorf("audit log is corrupted: %w", err)
+	}
+	logger := &Logger{
+		sink: sink,
+		now:  time.Now,
+	}
+	if len(events) > 0 {
+		last := events[len(events)-1]
+		logger.sequence = last.Sequence
+		logger.lastHash = last.Hash
+	}
+	return logger, nil
+}
+
+func (l *Logger) Record(event Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.Sequence = l.sequence + 1
+	event.Time = l.now().UTC()
+	event.PrevHash = l.lastHash
+	hash, err := event.computeHash()
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	if err := l.sink.Append(event); err != nil {
+		return fmt.Errorf("cannot append audit event: %w", err)
+	}
+	l.sequence = event.Sequence
+	l.lastHash = event.Hash
+	return nil
+}
+
+// Query returns the matching events, the most recent first.
+func (l *Logger) Query(filter Filter) ([]Event, error) {
+	events, err := l.sink.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audit log: %w", err)
+	}
+	var matched []Event
+	for i := len(events) - 1; i >= 0; i-- {
+		if !filter.matches(events[i]) {
+			continue
+		}
+		matched = append(matched, events[i])
+		if filter.Limit > 0 && len(matched) == filter.Limit {
+			break
+		}
+	}
+	return matched, nil
+}
+
+// VerifyChain checks the whole stored chain.
+func (l *Logger) VerifyChain() (int, error) {
+	events, err := l.sink.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("cannot read audit log: %w", err)
+	}
+	return len(events), Verify(events)
+}
+
+// Verify checks that the events form an unbroken chain, and that none of them was altered.
+func Verify(events []Event) error {
+	var prevHash string
+	for i, e := range events {
+		if e.Sequence != int64(i+1) {
+			return fmt.Errorf("event [%d] has sequence [%d]", i+1, e.Sequence)
+		}
+		if e.PrevHash != prevHash {
+			return fmt.Errorf("event [%d] does not link to the previous event", e.Sequence)
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("event [%d] was altered", e.Sequence)
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
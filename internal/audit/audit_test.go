@@ -0,0 +1,88 @@
+package audit_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/iliyaisd/littlejohn/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	testCases := map[string]struct {
+		tamper        func(events []audit.Event) []audit.Event
+		expectedError bool
+	}{
+		"it should accept an untouched chain": {
+			tamper: func(events []audit.Event) []audit.Event { return events },
+		},
+		"it should detect an altered event": {
+			tamper: func(events []audit.Event) []audit.Event {
+				events[1].Outcome = audit.OutcomeSuccess
+				return events
+			},
+			expectedError: true,
+		},
+		"it should detect a removed event": {
+			tamper: func(events []audit.Event) []audit.Event {
+				return append(events[:1], events[2:]...)
+			},
+			expectedError: true,
+		},
+		"it should detect a rehashed event not linked to the previous one": {
+			tamper: func(events []audit.Event) []audit.Event {
+				events[2].PrevHash = events[0].Hash
+				return events
+			},
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			sink := audit.NewMemorySink()
+			logger, err := audit.NewLogger(sink)
+			require.NoError(t, err)
+			require.NoError(t, logger.Record(audit.Event{Actor: "johndoe", Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess}))
+			require.NoError(t, logger.Record(audit.Event{Actor: "johndoe", Action: audit.ActionLogin, Outcome: audit.OutcomeFailure}))
+			require.NoError(t, logger.Record(audit.Event{Actor: "johndoe", Action: audit.ActionPortfolioRead, Outcome: audit.OutcomeSuccess}))
+
+			events, err := sink.ReadAll()
+			require.NoError(t, err)
+			err = audit.Verify(testCase.tamper(events))
+			if testCase.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := audit.NewFileSink(path)
+	require.NoError(t, err)
+	logger, err := audit.NewLogger(sink)
+	require.NoError(t, err)
+	require.NoError(t, logger.Record(audit.Event{Actor: "johndoe", Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess}))
+	require.NoError(t, sink.Close())
+
+	//reopening should continue the same chain
+	sink, err = audit.NewFileSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+	logger, err = audit.NewLogger(sink)
+	require.NoError(t, err)
+	require.NoError(t, logger.Record(audit.Event{Actor: "jennifer", Action: audit.ActionHistoryRead, Outcome: audit.OutcomeSuccess}))
+
+	count, err := logger.VerifyChain()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	events, err := logger.Query(audit.Filter{Action: audit.ActionLogin})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "johndoe", events[0].Actor)
+}
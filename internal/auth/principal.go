@@ -0,0 +1,80 @@
+// Package auth describes the authenticated principal of a request, independently of how it was authenticated,
+// so that new authentication methods plug in without changes to the handlers.
+package auth
+
+import (
+	"context"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// Authentication methods.
+const (
+	MethodPassword = "password"
+	MethodToken    = "token"
+)
+
+// Scopes granted to a principal.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// principalKey is unexported, so no other package can collide with or overwrite the principal in the context.
+type principalKey struct{}
+
+type Principal struct {
+	User   *ljlib.User
+	Roles  []string
+	Scopes []string
+	//Method is the way the principal authenticated, one of the Method* constants.
+	Method string
+	//KeyID identifies the credential used, e.g. the token ID. It is empty for password authentication.
+	KeyID string
+}
+
+// NewPrincipal builds the principal for the user, with roles and scopes derived from the user's role.
+func NewPrincipal(user *ljlib.User, method string, keyID string) Principal {
+	scopes := []string{ScopeRead, ScopeWrite}
+	if user.IsAdmin() {
+		scopes = append(scopes, ScopeAdmin)
+	}
+	return Principal{
+		User:   user,
+		Roles:  []string{user.Role},
+		Scopes: scopes,
+		Method: method,
+		KeyID:  keyID,
+	}
+}
+
+func (p Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal set by the authorizer; ok is false for unauthenticated requests.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	if !ok || principal.User == nil {
+		return Principal{}, false
+	}
+	return principal, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
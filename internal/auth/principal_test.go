@@ -0,0 +1,49 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipalFrom(t *testing.T) {
+	testCases := map[string]struct {
+		ctx            context.Context
+		expectedOK     bool
+		expectedScopes []string
+	}{
+		"it should not return a principal for unauthenticated context": {
+			ctx: context.Background(),
+		},
+		"it should not return a principal stored under a plain string key": {
+			ctx: context.WithValue(context.Background(), "user", &ljlib.User{Username: "johndoe"}),
+		},
+		"it should return read and write scopes for a user": {
+			ctx: auth.WithPrincipal(context.Background(), auth.NewPrincipal(
+				&ljlib.User{ID: uuid.New(), Username: "johndoe", Role: ljlib.RoleUser}, auth.MethodPassword, "")),
+			expectedOK:     true,
+			expectedScopes: []string{auth.ScopeRead, auth.ScopeWrite},
+		},
+		"it should add admin scope for an admin": {
+			ctx: auth.WithPrincipal(context.Background(), auth.NewPrincipal(
+				&ljlib.User{ID: uuid.New(), Username: "littlejohn", Role: ljlib.RoleAdmin}, auth.MethodToken, "key")),
+			expectedOK:     true,
+			expectedScopes: []string{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			principal, ok := auth.PrincipalFrom(testCase.ctx)
+			require.Equal(t, testCase.expectedOK, ok)
+			if ok {
+				assert.Equal(t, testCase.expectedScopes, principal.Scopes)
+				assert.True(t, principal.HasRole(principal.User.Role))
+			}
+		})
+	}
+}
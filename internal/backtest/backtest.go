@@ -0,0 +1,262 @@
+// Package backtest replays strategies against the historical prices, filling their orders at the next day's
+// price with the commissions and the slippage, and reports how they performed.
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// MaxTickers is the number of the tickers a backtest can trade.
+	MaxTickers = 20
+	// MaxDays limits the range of a backtest, roughly 10 years.
+	MaxDays = 3650
+	// QuantityPlaces is the precision of the quantities bought for an amount.
+	QuantityPlaces = 6
+	// PricePlaces is the precision of the fill prices, the same as of the simulated executions.
+	PricePlaces = 4
+)
+
+// PriceSource is the daily price history the backtests are fed from, so any api.DataSource can feed them.
+type PriceSource interface {
+	GetHistoricalPrices(ticker string, dateFrom time.Time, dateTo time.Time) ([]ljlib.HistoricalPrice, error)
+}
+
+// Bar is the closing prices of all the traded tickers on a date.
+type Bar struct {
+	Date   time.Time
+	Prices map[string]decimal.Decimal
+}
+
+// Order is a market order of a strategy, for either the quantity or the amount. The amount of a buy is reduced
+// to the cash available, while the orders for more than that, or for more than held, are rejected.
+type Order struct {
+	Ticker   string
+	Side     ljlib.OrderSide
+	Quantity decimal.Decimal
+	Amount   decimal.Decimal
+}
+
+// Portfolio is the cash and the quantities held by a strategy.
+type Portfolio struct {
+	Cash      decimal.Decimal
+	Positions map[string]decimal.Decimal
+}
+
+// Position returns the quantity of the ticker held, zero when it's not.
+func (p Portfolio) Position(ticker string) decimal.Decimal {
+	return p.Positions[ticker]
+}
+
+// Value returns the cash and the positions valued at the prices of the bar.
+func (p Portfolio) Value(bar Bar) decimal.Decimal {
+	value := p.Cash
+	for ticker, quantity := range p.Positions {
+		value = value.Add(quantity.Mul(bar.Prices[ticker]))
+	}
+	return value
+}
+
+func (p Portfolio) copy() Portfolio {
+	positions := make(map[string]decimal.Decimal, len(p.Positions))
+	for ticker, quantity := range p.Positions {
+		positions[ticker] = quantity
+	}
+	return Portfolio{Cash: p.Cash, Positions: positions}
+}
+
+// Strategy decides what to trade. OnBar is called with every bar, the oldest first, together with the portfolio
+// at its close, and the orders it returns are filled at the prices of the next bar, so that the strategy can't
+// trade at the prices it has just seen. The orders of the last bar are not filled.
+type Strategy interface {
+	OnBar(bar Bar, portfolio Portfolio) []Order
+}
+
+// Config is what a backtest trades, over which range, and how its orders are filled.
+type Config struct {
+	Tickers     []string
+	From        time.Time
+	To          time.Time
+	InitialCash decimal.Decimal
+	Commission  trading.CommissionSchedule
+	//SlippageBps moves the fill price against the order, in basis points of the price of the bar.
+	SlippageBps decimal.Decimal
+}
+
+// Validate normalizes the tickers to upper case, and checks the config.
+func (c *Config) Validate() error {
+	if len(c.Tickers) == 0 || len(c.Tickers) > MaxTickers {
+		return ljlib.NewIllegalArgumentError("between 1 and %d tickers can be traded", MaxTickers)
+	}
+	seen := make(map[string]bool, len(c.Tickers))
+	for i, ticker := range c.Tickers {
+		ticker = strings.ToUpper(strings.TrimSpace(ticker))
+		if len(ticker) == 0 || seen[ticker] {
+			return ljlib.NewIllegalArgumentError("tickers must be set and unique")
+		}
+		seen[ticker] = true
+		c.Tickers[i] = ticker
+	}
+	if !c.From.Before(c.To) {
+		return ljlib.NewIllegalArgumentError("from must be before to")
+	}
+	if c.To.Sub(c.From) > MaxDays*24*time.Hour {
+		return ljlib.NewIllegalArgumentError("the range cannot be longer than %d days", MaxDays)
+	}
+	if !c.InitialCash.IsPositive() {
+		return ljlib.NewIllegalArgumentError("initial cash must be positive")
+	}
+	if c.SlippageBps.IsNegative() {
+		return ljlib.NewIllegalArgumentError("slippage cannot be negative")
+	}
+	return nil
+}
+
+// Run backtests the strategy over the dates all the tickers have a price for.
+func Run(source PriceSource, strategy Strategy, config Config) (Report, error) {
+	config.Tickers = append([]string{}, config.Tickers...)
+	if err := config.Validate(); err != nil {
+		return Report{}, err
+	}
+	bars, err := loadBars(source, config)
+	if err != nil {
+		return Report{}, err
+	}
+
+	portfolio := Portfolio{Cash: config.InitialCash, Positions: make(map[string]decimal.Decimal)}
+	report := Report{InitialCash: config.InitialCash}
+	var pending []Order
+	for _, bar := range bars {
+		for _, order := range pending {
+			trade, err := fill(&portfolio, order, bar, config)
+			if err != nil {
+				report.Rejections = append(report.Rejections, Rejection{Date: bar.Date, Order: order, Reason: err.Error()})
+				continue
+			}
+			report.Trades = append(report.Trades, trade)
+		}
+		report.Equity = append(report.Equity, EquityPoint{
+			Date:  bar.Date,
+			Cash:  portfolio.Cash,
+			Value: portfolio.Value(bar).Round(ljlib.CashPlaces),
+		})
+		pending = strategy.OnBar(bar, portfolio.copy())
+	}
+	report.summarize()
+	return report, nil
+}
+
+// loadBars returns the bars of the dates all the tickers have a positive price for, the oldest first.
+func loadBars(source PriceSource, config Config) ([]Bar, error) {
+	byDate := make(map[string]map[string]decimal.Decimal)
+	for _, ticker := range config.Tickers {
+		prices, err := source.GetHistoricalPrices(ticker, config.From, config.To)
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			return nil, ljlib.NewIllegalArgumentError("unknown ticker [%s]", ticker)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot get historical prices for ticker [%s]: %w", ticker, err)
+		}
+		for _, price := range prices {
+			if !price.Price.IsPositive() {
+				continue
+			}
+			date := price.Date.Format(time.DateOnly)
+			if byDate[date] == nil {
+				byDate[date] = make(map[string]decimal.Decimal, len(config.Tickers))
+			}
+			byDate[date][ticker] = price.Price
+		}
+	}
+
+	var bars []Bar
+	for date, prices := range byDate {
+		if len(prices) < len(config.Tickers) {
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return nil, err
+		}
+		bars = append(bars, Bar{Date: day, Prices: prices})
+	}
+	if len(bars) < 2 {
+		return nil, ljlib.NewIllegalArgumentError("the tickers don't have enough prices in common in the range")
+	}
+	sort.Slice(bars, func(i, j int) bool {
+		return bars[i].Date.Before(bars[j].Date)
+	})
+	return bars, nil
+}
+
+// fill executes the order at the price of the bar moved against it by the slippage.
+func fill(portfolio *Portfolio, order Order, bar Bar, config Config) (Trade, error) {
+	marketPrice, ok := bar.Prices[order.Ticker]
+	if !ok {
+		return Trade{}, fmt.Errorf("ticker [%s] is not traded", order.Ticker)
+	}
+	if order.Side != ljlib.OrderSideBuy && order.Side != ljlib.OrderSideSell {
+		return Trade{}, fmt.Errorf("side must be %s or %s", ljlib.OrderSideBuy, ljlib.OrderSideSell)
+	}
+	if order.Quantity.IsPositive() == order.Amount.IsPositive() || order.Quantity.IsNegative() ||
+		order.Amount.IsNegative() {
+		return Trade{}, fmt.Errorf("either quantity or amount must be positive")
+	}
+	slippage := marketPrice.Mul(config.SlippageBps).Div(decimal.NewFromInt(10000))
+	price := marketPrice.Add(slippage)
+	if order.Side == ljlib.OrderSideSell {
+		price = marketPrice.Sub(slippage)
+	}
+	price = price.Round(PricePlaces)
+
+	quantity := order.Quantity
+	if quantity.IsZero() {
+		amount := order.Amount
+		if order.Side == ljlib.OrderSideBuy {
+			//the commission is paid from the amount too, and half a cent is kept from it, so that the amount
+			//of the quantity rounded to the cents doesn't exceed it
+			amount = decimal.Min(amount, portfolio.Cash)
+			amount = amount.Sub(config.Commission.Calculate(amount.Div(price), price)).Sub(decimal.New(5, -3))
+		}
+		quantity = amount.Div(price).Truncate(QuantityPlaces)
+		if !quantity.IsPositive() {
+			return Trade{}, fmt.Errorf("amount is too small")
+		}
+	}
+	trade := Trade{
+		Date:       bar.Date,
+		Ticker:     order.Ticker,
+		Side:       order.Side,
+		Quantity:   quantity,
+		Price:      price,
+		Commission: config.Commission.Calculate(quantity, price),
+	}
+	trade.Amount = quantity.Mul(price).Round(ljlib.CashPlaces)
+
+	if order.Side == ljlib.OrderSideBuy {
+		cost := trade.Amount.Add(trade.Commission)
+		if cost.GreaterThan(portfolio.Cash) {
+			return Trade{}, fmt.Errorf("not enough cash")
+		}
+		portfolio.Cash = portfolio.Cash.Sub(cost)
+		portfolio.Positions[order.Ticker] = portfolio.Positions[order.Ticker].Add(quantity)
+		return trade, nil
+	}
+	if quantity.GreaterThan(portfolio.Positions[order.Ticker]) {
+		return Trade{}, fmt.Errorf("not enough held to sell")
+	}
+	portfolio.Cash = portfolio.Cash.Add(trade.Amount).Sub(trade.Commission)
+	portfolio.Positions[order.Ticker] = portfolio.Positions[order.Ticker].Sub(quantity)
+	if portfolio.Positions[order.Ticker].IsZero() {
+		delete(portfolio.Positions, order.Ticker)
+	}
+	return trade, nil
+}
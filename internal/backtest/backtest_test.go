@@ -0,0 +1,306 @@
+package backtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/backtest"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testStart = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestRun(t *testing.T) {
+	testCases := map[string]struct {
+		orders          map[int][]backtest.Order
+		initialCash     int64
+		slippageBps     int64
+		expectedTrades  []string
+		expectedReasons []string
+		expectedCash    string
+		expectedValue   string
+	}{
+		"it should fill the order at the next price with the slippage and the commission": {
+			orders: map[int][]backtest.Order{
+				0: {buy("A", decimal.NewFromInt(10), decimal.Zero)},
+			},
+			initialCash: 10000,
+			slippageBps: 10,
+			//110 and 10 bps of it
+			expectedTrades: []string{"2023-01-02 buy 10 A at 110.11 for 1101.1 and 1"},
+			expectedCash:   "8897.9",
+			expectedValue:  "10097.9",
+		},
+		"it should sell at the price moved down by the slippage": {
+			orders: map[int][]backtest.Order{
+				0: {buy("A", decimal.NewFromInt(10), decimal.Zero)},
+				1: {sell("A", decimal.NewFromInt(4))},
+			},
+			initialCash: 10000,
+			slippageBps: 10,
+			expectedTrades: []string{
+				"2023-01-02 buy 10 A at 110.11 for 1101.1 and 1",
+				"2023-01-03 sell 4 A at 119.88 for 479.52 and 1",
+			},
+			expectedCash:  "9376.42",
+			expectedValue: "10096.42",
+		},
+		"it should buy for the cash available, with the commission, when the amount is more": {
+			orders: map[int][]backtest.Order{
+				0: {buy("A", decimal.Zero, decimal.NewFromInt(5000))},
+			},
+			initialCash:    1000,
+			expectedTrades: []string{"2023-01-02 buy 9.081772 A at 110 for 998.99 and 1"},
+			expectedCash:   "0.01",
+			expectedValue:  "1089.82",
+		},
+		"it should reject the orders which can't be filled": {
+			orders: map[int][]backtest.Order{
+				0: {
+					buy("A", decimal.NewFromInt(100), decimal.Zero),
+					sell("A", decimal.NewFromInt(1)),
+					buy("B", decimal.NewFromInt(1), decimal.Zero),
+					buy("A", decimal.NewFromInt(1), decimal.NewFromInt(100)),
+				},
+			},
+			initialCash: 1000,
+			expectedReasons: []string{
+				"not enough cash",
+				"not enough held to sell",
+				"ticker [B] is not traded",
+				"either quantity or amount must be positive",
+			},
+			expectedCash:  "1000",
+			expectedValue: "1000",
+		},
+		"it should not fill the orders of the last bar": {
+			orders: map[int][]backtest.Order{
+				2: {buy("A", decimal.NewFromInt(1), decimal.Zero)},
+			},
+			initialCash:   1000,
+			expectedCash:  "1000",
+			expectedValue: "1000",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			source := mockPriceSource{"A": {100, 110, 120}}
+			strategy := &scriptedStrategy{orders: testCase.orders}
+
+			report, err := backtest.Run(source, strategy, backtest.Config{
+				Tickers:     []string{"a"},
+				From:        testStart,
+				To:          testStart.AddDate(0, 0, 2),
+				InitialCash: decimal.NewFromInt(testCase.initialCash),
+				Commission:  trading.CommissionSchedule{PerShare: decimal.RequireFromString("0.01"), Minimum: decimal.NewFromInt(1)},
+				SlippageBps: decimal.NewFromInt(testCase.slippageBps),
+			})
+			require.NoError(t, err)
+
+			var trades []string
+			for _, trade := range report.Trades {
+				trades = append(trades, trade.Date.Format(time.DateOnly)+" "+string(trade.Side)+" "+
+					trade.Quantity.String()+" "+trade.Ticker+" at "+trade.Price.String()+" for "+
+					trade.Amount.String()+" and "+trade.Commission.String())
+			}
+			assert.Equal(t, testCase.expectedTrades, trades)
+			var reasons []string
+			for _, rejection := range report.Rejections {
+				reasons = append(reasons, rejection.Reason)
+			}
+			assert.Equal(t, testCase.expectedReasons, reasons)
+			require.Len(t, report.Equity, 3)
+			last := report.Equity[len(report.Equity)-1]
+			assert.Equal(t, testCase.expectedCash, last.Cash.String())
+			assert.Equal(t, testCase.expectedValue, report.FinalValue.String())
+			//the strategy sees the portfolio at the close of every bar
+			assert.Equal(t, 3, strategy.bars)
+		})
+	}
+}
+
+func TestRun_Report(t *testing.T) {
+	source := mockPriceSource{"A": {100, 120, 90, 100, 130}}
+	strategy, err := backtest.NewStrategy(backtest.StrategyBuyAndHold, nil)
+	require.NoError(t, err)
+
+	report, err := backtest.Run(source, strategy, backtest.Config{
+		Tickers:     []string{"A"},
+		From:        testStart,
+		To:          testStart.AddDate(0, 0, 10),
+		InitialCash: decimal.NewFromInt(1200),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, testStart, report.From)
+	assert.Equal(t, testStart.AddDate(0, 0, 4), report.To)
+	//almost 10 shares bought at 120 on the second day
+	assert.Equal(t, "1300", report.FinalValue.String())
+	assert.Equal(t, "0.083333", report.TotalReturn.String())
+	assert.False(t, report.AnnualizedReturn.Valid, "the backtest is shorter than a year")
+	assert.True(t, report.Volatility.Valid)
+	assert.InDelta(t, 0.25, report.MaxDrawdown.Depth, 1e-4)
+	assert.Equal(t, testStart, report.MaxDrawdown.Peak)
+	assert.Equal(t, testStart.AddDate(0, 0, 2), report.MaxDrawdown.Trough)
+	assert.Equal(t, testStart.AddDate(0, 0, 4), report.MaxDrawdown.Recovery)
+	assert.True(t, report.Commissions.IsZero())
+}
+
+func TestRun_Bars(t *testing.T) {
+	source := mockPriceSource{
+		"A": {100, 101, 102, 103},
+		"B": {0, 50, 51, 52},
+	}
+	strategy := &scriptedStrategy{}
+
+	report, err := backtest.Run(source, strategy, backtest.Config{
+		Tickers:     []string{"A", "B"},
+		From:        testStart,
+		To:          testStart.AddDate(0, 0, 3),
+		InitialCash: decimal.NewFromInt(1000),
+	})
+	require.NoError(t, err)
+
+	//the first day B has no positive price
+	require.Len(t, report.Equity, 3)
+	assert.Equal(t, testStart.AddDate(0, 0, 1), report.Equity[0].Date)
+	assert.Equal(t, testStart.AddDate(0, 0, 3), report.Equity[2].Date)
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	valid := func() backtest.Config {
+		return backtest.Config{
+			Tickers:     []string{"A"},
+			From:        testStart,
+			To:          testStart.AddDate(0, 0, 2),
+			InitialCash: decimal.NewFromInt(1000),
+		}
+	}
+	testCases := map[string]func(config *backtest.Config){
+		"it should return IllegalArgumentError without tickers": func(config *backtest.Config) {
+			config.Tickers = nil
+		},
+		"it should return IllegalArgumentError for the same ticker twice": func(config *backtest.Config) {
+			config.Tickers = []string{"A", "a"}
+		},
+		"it should return IllegalArgumentError for the range ending before it starts": func(config *backtest.Config) {
+			config.To = config.From
+		},
+		"it should return IllegalArgumentError for a range which is too long": func(config *backtest.Config) {
+			config.To = config.From.AddDate(0, 0, backtest.MaxDays+1)
+		},
+		"it should return IllegalArgumentError without the initial cash": func(config *backtest.Config) {
+			config.InitialCash = decimal.Zero
+		},
+		"it should return IllegalArgumentError for a negative slippage": func(config *backtest.Config) {
+			config.SlippageBps = decimal.NewFromInt(-1)
+		},
+		"it should return IllegalArgumentError for an unknown ticker": func(config *backtest.Config) {
+			config.Tickers = []string{"A", "UNKNOWN"}
+		},
+		"it should return IllegalArgumentError without enough prices": func(config *backtest.Config) {
+			config.To = config.From.Add(time.Hour)
+		},
+	}
+	for testName, modify := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			config := valid()
+			modify(&config)
+
+			_, err := backtest.Run(mockPriceSource{"A": {100, 110, 120}}, &scriptedStrategy{}, config)
+			assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+		})
+	}
+}
+
+func TestSMACrossover(t *testing.T) {
+	source := mockPriceSource{"A": {10, 9, 8, 9, 10, 11, 10, 9, 8}}
+	strategy, err := backtest.NewStrategy(backtest.StrategySMACrossover, map[string]int{"fast": 2, "slow": 3})
+	require.NoError(t, err)
+
+	report, err := backtest.Run(source, strategy, backtest.Config{
+		Tickers:     []string{"A"},
+		From:        testStart,
+		To:          testStart.AddDate(0, 0, 8),
+		InitialCash: decimal.NewFromInt(1100),
+	})
+	require.NoError(t, err)
+
+	//the fast average crosses above the slow one on the fifth day, and below it on the eighth
+	require.Len(t, report.Trades, 2)
+	assert.Equal(t, ljlib.OrderSideBuy, report.Trades[0].Side)
+	assert.Equal(t, testStart.AddDate(0, 0, 5), report.Trades[0].Date)
+	assert.Equal(t, "99.999545", report.Trades[0].Quantity.String())
+	assert.Equal(t, ljlib.OrderSideSell, report.Trades[1].Side)
+	assert.Equal(t, testStart.AddDate(0, 0, 8), report.Trades[1].Date)
+	assert.Equal(t, report.Trades[0].Quantity, report.Trades[1].Quantity)
+}
+
+func TestNewStrategy(t *testing.T) {
+	testCases := map[string]struct {
+		name       string
+		parameters map[string]int
+	}{
+		"it should return IllegalArgumentError for an unknown strategy": {name: "momentum"},
+		"it should return IllegalArgumentError for an unknown parameter": {
+			name:       backtest.StrategyBuyAndHold,
+			parameters: map[string]int{"fast": 1},
+		},
+		"it should return IllegalArgumentError for the fast period not shorter than the slow one": {
+			name:       backtest.StrategySMACrossover,
+			parameters: map[string]int{"fast": 50, "slow": 50},
+		},
+		"it should return IllegalArgumentError for a period which is too long": {
+			name:       backtest.StrategySMACrossover,
+			parameters: map[string]int{"slow": 1000},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := backtest.NewStrategy(testCase.name, testCase.parameters)
+			assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+		})
+	}
+}
+
+func buy(ticker string, quantity decimal.Decimal, amount decimal.Decimal) backtest.Order {
+	return backtest.Order{Ticker: ticker, Side: ljlib.OrderSideBuy, Quantity: quantity, Amount: amount}
+}
+
+func sell(ticker string, quantity decimal.Decimal) backtest.Order {
+	return backtest.Order{Ticker: ticker, Side: ljlib.OrderSideSell, Quantity: quantity}
+}
+
+// scriptedStrategy places the orders by the index of the bar.
+type scriptedStrategy struct {
+	orders map[int][]backtest.Order
+	bars   int
+}
+
+func (s *scriptedStrategy) OnBar(_ backtest.Bar, _ backtest.Portfolio) []backtest.Order {
+	orders := s.orders[s.bars]
+	s.bars++
+	return orders
+}
+
+// mockPriceSource has a price of each ticker for every day from testStart.
+type mockPriceSource map[string][]float64
+
+func (m mockPriceSource) GetHistoricalPrices(ticker string, dateFrom time.Time, dateTo time.Time) ([]ljlib.HistoricalPrice, error) {
+	prices, ok := m[ticker]
+	if !ok {
+		return nil, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	var result []ljlib.HistoricalPrice
+	for i, price := range prices {
+		date := testStart.AddDate(0, 0, i)
+		if !date.Before(dateFrom) && !date.After(dateTo) {
+			result = append(result, ljlib.HistoricalPrice{Date: date, Price: decimal.NewFromFloat(price)})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,199 @@
+package backtest
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// EquityPoint is the cash and the value of the portfolio at the close of a bar.
+type EquityPoint struct {
+	Date  time.Time
+	Cash  decimal.Decimal
+	Value decimal.Decimal
+}
+
+// Trade is a filled order. Amount is the quantity at the price, without the commission.
+type Trade struct {
+	Date       time.Time
+	Ticker     string
+	Side       ljlib.OrderSide
+	Quantity   decimal.Decimal
+	Price      decimal.Decimal
+	Commission decimal.Decimal
+	Amount     decimal.Decimal
+}
+
+// Rejection is an order which couldn't be filled, and why.
+type Rejection struct {
+	Date   time.Time
+	Order  Order
+	Reason string
+}
+
+// Report is how a strategy performed. The returns are ratios of the initial cash, and the annualized return
+// is not set for a backtest shorter than a year, like the volatility of the equity curve without two returns.
+type Report struct {
+	From             time.Time
+	To               time.Time
+	InitialCash      decimal.Decimal
+	FinalValue       decimal.Decimal
+	TotalReturn      decimal.Decimal
+	AnnualizedReturn decimal.NullDecimal
+	Volatility       decimal.NullDecimal
+	MaxDrawdown      analytics.Drawdown
+	Commissions      decimal.Decimal
+	Equity           []EquityPoint
+	Trades           []Trade
+	Rejections       []Rejection
+}
+
+// summarize computes the figures of the report from its equity curve and its trades.
+func (r *Report) summarize() {
+	first, last := r.Equity[0], r.Equity[len(r.Equity)-1]
+	r.From, r.To = first.Date, last.Date
+	r.FinalValue = last.Value
+	total := last.Value.Div(r.InitialCash).Sub(decimal.NewFromInt(1))
+	r.TotalReturn = total.Round(analytics.ReturnPlaces)
+	days := last.Date.Sub(first.Date).Hours() / 24
+	if days >= analytics.PeriodsPerYear && last.Value.IsPositive() {
+		annualized := math.Pow(1+total.InexactFloat64(), analytics.PeriodsPerYear/days) - 1
+		r.AnnualizedReturn = decimal.NewNullDecimal(decimal.NewFromFloat(annualized).Round(analytics.ReturnPlaces))
+	}
+
+	values := make([]ljlib.HistoricalPrice, 0, len(r.Equity))
+	for _, point := range r.Equity {
+		values = append(values, ljlib.HistoricalPrice{Date: point.Date, Price: point.Value})
+	}
+	if volatility, ok := analytics.Volatility(analytics.DailyReturns(values)); ok {
+		r.Volatility = decimal.NewNullDecimal(decimal.NewFromFloat(volatility).Round(analytics.ReturnPlaces))
+	}
+	r.MaxDrawdown = analytics.MaxDrawdown(values)
+
+	r.Commissions = decimal.Zero
+	for _, trade := range r.Trades {
+		r.Commissions = r.Commissions.Add(trade.Commission)
+	}
+}
+
+func (r Report) MarshalJSON() ([]byte, error) {
+	type equityPoint struct {
+		Date  string `json:"date"`
+		Cash  string `json:"cash"`
+		Value string `json:"value"`
+	}
+	type trade struct {
+		Date       string          `json:"date"`
+		Ticker     string          `json:"ticker"`
+		Side       ljlib.OrderSide `json:"side"`
+		Quantity   string          `json:"quantity"`
+		Price      string          `json:"price"`
+		Commission string          `json:"commission"`
+		Amount     string          `json:"amount"`
+	}
+	type rejection struct {
+		Date     string          `json:"date"`
+		Ticker   string          `json:"ticker"`
+		Side     ljlib.OrderSide `json:"side"`
+		Quantity *string         `json:"quantity"`
+		Amount   *string         `json:"amount"`
+		Reason   string          `json:"reason"`
+	}
+	type drawdown struct {
+		Depth    string  `json:"depth"`
+		Peak     *string `json:"peak"`
+		Trough   *string `json:"trough"`
+		Recovery *string `json:"recovery"`
+	}
+	equity := make([]equityPoint, 0, len(r.Equity))
+	for _, point := range r.Equity {
+		equity = append(equity, equityPoint{
+			Date:  point.Date.Format(time.DateOnly),
+			Cash:  point.Cash.StringFixed(ljlib.CashPlaces),
+			Value: point.Value.StringFixed(ljlib.CashPlaces),
+		})
+	}
+	trades := make([]trade, 0, len(r.Trades))
+	for _, t := range r.Trades {
+		trades = append(trades, trade{
+			Date:       t.Date.Format(time.DateOnly),
+			Ticker:     t.Ticker,
+			Side:       t.Side,
+			Quantity:   t.Quantity.String(),
+			Price:      t.Price.String(),
+			Commission: t.Commission.StringFixed(ljlib.CashPlaces),
+			Amount:     t.Amount.StringFixed(ljlib.CashPlaces),
+		})
+	}
+	rejections := make([]rejection, 0, len(r.Rejections))
+	for _, rejected := range r.Rejections {
+		rejections = append(rejections, rejection{
+			Date:     rejected.Date.Format(time.DateOnly),
+			Ticker:   rejected.Order.Ticker,
+			Side:     rejected.Order.Side,
+			Quantity: optionalDecimal(rejected.Order.Quantity),
+			Amount:   optionalDecimal(rejected.Order.Amount),
+			Reason:   rejected.Reason,
+		})
+	}
+	return json.Marshal(struct {
+		From             string        `json:"from"`
+		To               string        `json:"to"`
+		InitialCash      string        `json:"initial_cash"`
+		FinalValue       string        `json:"final_value"`
+		TotalReturn      string        `json:"total_return"`
+		AnnualizedReturn *string       `json:"annualized_return"`
+		Volatility       *string       `json:"volatility"`
+		MaxDrawdown      drawdown      `json:"max_drawdown"`
+		Commissions      string        `json:"commissions"`
+		Equity           []equityPoint `json:"equity"`
+		Trades           []trade       `json:"trades"`
+		Rejections       []rejection   `json:"rejections"`
+	}{
+		From:             r.From.Format(time.DateOnly),
+		To:               r.To.Format(time.DateOnly),
+		InitialCash:      r.InitialCash.StringFixed(ljlib.CashPlaces),
+		FinalValue:       r.FinalValue.StringFixed(ljlib.CashPlaces),
+		TotalReturn:      r.TotalReturn.String(),
+		AnnualizedReturn: optionalNullDecimal(r.AnnualizedReturn),
+		Volatility:       optionalNullDecimal(r.Volatility),
+		MaxDrawdown: drawdown{
+			Depth:    decimal.NewFromFloat(r.MaxDrawdown.Depth).Round(analytics.ReturnPlaces).String(),
+			Peak:     optionalDate(r.MaxDrawdown.Peak),
+			Trough:   optionalDate(r.MaxDrawdown.Trough),
+			Recovery: optionalDate(r.MaxDrawdown.Recovery),
+		},
+		Commissions: r.Commissions.StringFixed(ljlib.CashPlaces),
+		Equity:      equity,
+		Trades:      trades,
+		Rejections:  rejections,
+	})
+}
+
+func optionalDecimal(value decimal.Decimal) *string {
+	if value.IsZero() {
+		return nil
+	}
+	s := value.String()
+	return &s
+}
+
+func optionalNullDecimal(value decimal.NullDecimal) *string {
+	if !value.Valid {
+		return nil
+	}
+	s := value.Decimal.String()
+	return &s
+}
+
+func optionalDate(date time.Time) *string {
+	if date.IsZero() {
+		return nil
+	}
+	s := date.Format(time.DateOnly)
+	return &s
+}
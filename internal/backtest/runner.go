@@ -0,0 +1,280 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// MaxPendingJobs is the number of the backtests a user can have queued or running at once.
+const MaxPendingJobs = 5
+
+// MaxKeptJobs is the number of the backtests kept for a user. The oldest finished ones are dropped beyond it.
+const MaxKeptJobs = 50
+
+// DefaultDays is the range of a backtest which doesn't set it, ending today.
+const DefaultDays = 365
+
+// DefaultInitialCash is the cash a backtest starts with when it's not set.
+var DefaultInitialCash = decimal.NewFromInt(10000)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Request is a backtest of a built-in strategy. The range defaults to the year ending today, and the slippage
+// to the runner's, while the commission is always the runner's.
+type Request struct {
+	Strategy    string
+	Parameters  map[string]int
+	Tickers     []string
+	From        time.Time
+	To          time.Time
+	InitialCash decimal.Decimal
+	SlippageBps decimal.NullDecimal
+}
+
+// Job is a backtest run asynchronously, with the config it's run with. The report is set once it's completed,
+// and the error once it's failed.
+type Job struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Status     Status
+	Strategy   string
+	Parameters map[string]int
+	Config     Config
+	Error      string
+	Report     *Report
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (j Job) MarshalJSON() ([]byte, error) {
+	var finishedAt *time.Time
+	if !j.FinishedAt.IsZero() {
+		finishedAt = &j.FinishedAt
+	}
+	var jobError *string
+	if len(j.Error) > 0 {
+		jobError = &j.Error
+	}
+	parameters := j.Parameters
+	if parameters == nil {
+		parameters = map[string]int{}
+	}
+	return json.Marshal(struct {
+		ID          uuid.UUID      `json:"id"`
+		Status      Status         `json:"status"`
+		Strategy    string         `json:"strategy"`
+		Parameters  map[string]int `json:"parameters"`
+		Tickers     []string       `json:"tickers"`
+		From        string         `json:"from"`
+		To          string         `json:"to"`
+		InitialCash string         `json:"initial_cash"`
+		SlippageBps string         `json:"slippage_bps"`
+		Error       *string        `json:"error"`
+		Report      *Report        `json:"report"`
+		CreatedAt   time.Time      `json:"created_at"`
+		FinishedAt  *time.Time     `json:"finished_at"`
+	}{
+		ID:          j.ID,
+		Status:      j.Status,
+		Strategy:    j.Strategy,
+		Parameters:  parameters,
+		Tickers:     j.Config.Tickers,
+		From:        j.Config.From.Format(time.DateOnly),
+		To:          j.Config.To.Format(time.DateOnly),
+		InitialCash: j.Config.InitialCash.StringFixed(ljlib.CashPlaces),
+		SlippageBps: j.Config.SlippageBps.String(),
+		Error:       jobError,
+		Report:      j.Report,
+		CreatedAt:   j.CreatedAt,
+		FinishedAt:  finishedAt,
+	})
+}
+
+// Runner runs the backtests in the background, at most parallelism of them at once, and keeps their jobs
+// in memory.
+type Runner struct {
+	source   PriceSource
+	clock    clock.Clock
+	fees     trading.SimulatorConfig
+	slots    chan struct{}
+	mu       sync.Mutex
+	jobs     map[uuid.UUID]*Job
+	inFlight sync.WaitGroup
+}
+
+// NewRunner returns a runner filling the orders with the commission and the slippage of the simulator config.
+func NewRunner(source PriceSource, clock clock.Clock, parallelism int, fees trading.SimulatorConfig) (*Runner, error) {
+	if parallelism < 1 {
+		return nil, fmt.Errorf("parallelism must be positive")
+	}
+	return &Runner{
+		source: source,
+		clock:  clock,
+		fees:   fees,
+		slots:  make(chan struct{}, parallelism),
+		jobs:   make(map[uuid.UUID]*Job),
+	}, nil
+}
+
+// Submit validates the request, and queues its backtest for the user.
+func (r *Runner) Submit(userID uuid.UUID, request Request) (Job, error) {
+	now := r.clock.Now()
+	config := Config{
+		Tickers:     append([]string{}, request.Tickers...),
+		From:        request.From,
+		To:          request.To,
+		InitialCash: request.InitialCash,
+		Commission:  r.fees.Commission,
+		SlippageBps: r.fees.SlippageBps,
+	}
+	if config.To.IsZero() {
+		config.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if config.From.IsZero() {
+		config.From = config.To.AddDate(0, 0, -DefaultDays)
+	}
+	if config.InitialCash.IsZero() {
+		config.InitialCash = DefaultInitialCash
+	}
+	if request.SlippageBps.Valid {
+		config.SlippageBps = request.SlippageBps.Decimal
+	}
+	if err := config.Validate(); err != nil {
+		return Job{}, err
+	}
+	if _, err := NewStrategy(request.Strategy, request.Parameters); err != nil {
+		return Job{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var finished []*Job
+	pending := 0
+	for _, job := range r.jobs {
+		if job.UserID != userID {
+			continue
+		}
+		if job.Status == StatusQueued || job.Status == StatusRunning {
+			pending++
+		} else {
+			finished = append(finished, job)
+		}
+	}
+	if pending >= MaxPendingJobs {
+		return Job{}, ljlib.NewConflictError("at most %d backtests can be in progress at once", MaxPendingJobs)
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+	for i := 0; i < len(finished) && pending+len(finished)-i >= MaxKeptJobs; i++ {
+		delete(r.jobs, finished[i].ID)
+	}
+	parameters := make(map[string]int, len(request.Parameters))
+	for name, value := range request.Parameters {
+		parameters[name] = value
+	}
+	job := &Job{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Status:     StatusQueued,
+		Strategy:   request.Strategy,
+		Parameters: parameters,
+		Config:     config,
+		CreatedAt:  now,
+	}
+	r.jobs[job.ID] = job
+	r.inFlight.Add(1)
+	go r.run(job.ID)
+	return *job, nil
+}
+
+func (r *Runner) run(id uuid.UUID) {
+	defer r.inFlight.Done()
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	r.mu.Lock()
+	job := r.jobs[id]
+	job.Status = StatusRunning
+	strategy, parameters, config := job.Strategy, job.Parameters, job.Config
+	r.mu.Unlock()
+
+	report, err := r.backtest(strategy, parameters, config)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.FinishedAt = r.clock.Now()
+	if err != nil {
+		log.Printf("backtest [%s] failed: %v", id, err)
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Status = StatusCompleted
+	job.Report = &report
+}
+
+func (r *Runner) backtest(name string, parameters map[string]int, config Config) (report Report, err error) {
+	//a panicking strategy fails its backtest only
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("backtest panicked: %v", recovered)
+		}
+	}()
+	strategy, err := NewStrategy(name, parameters)
+	if err != nil {
+		return Report{}, err
+	}
+	return Run(r.source, strategy, config)
+}
+
+// GetJob returns the user's backtest, or NotFoundError when the user has no backtest with the ID.
+func (r *Runner) GetJob(userID uuid.UUID, id uuid.UUID) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok || job.UserID != userID {
+		return Job{}, ljlib.NewNotFoundError("backtest [%s] is not found", id)
+	}
+	return *job, nil
+}
+
+// ListJobs returns the user's backtests, the most recent first, without their reports.
+func (r *Runner) ListJobs(userID uuid.UUID) []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := []Job{}
+	for _, job := range r.jobs {
+		if job.UserID == userID {
+			listed := *job
+			listed.Report = nil
+			jobs = append(jobs, listed)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Wait blocks until the submitted backtests are finished.
+func (r *Runner) Wait() {
+	r.inFlight.Wait()
+}
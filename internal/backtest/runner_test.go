@@ -0,0 +1,146 @@
+package backtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/backtest"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+
+func TestRunner_Submit(t *testing.T) {
+	testCases := map[string]struct {
+		request        backtest.Request
+		expectedStatus backtest.Status
+		expectedError  string
+	}{
+		"it should complete the backtest with the defaults": {
+			request:        backtest.Request{Strategy: backtest.StrategyBuyAndHold, Tickers: []string{"a"}},
+			expectedStatus: backtest.StatusCompleted,
+		},
+		"it should fail the backtest of a ticker without the prices": {
+			request:        backtest.Request{Strategy: backtest.StrategyBuyAndHold, Tickers: []string{"UNKNOWN"}},
+			expectedStatus: backtest.StatusFailed,
+			expectedError:  "unknown ticker [UNKNOWN]",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			runner := newTestRunner(t, mockPriceSource{"A": prices(400)}, 2)
+
+			submitted, err := runner.Submit(testUserID, testCase.request)
+			require.NoError(t, err)
+			runner.Wait()
+
+			job, err := runner.GetJob(testUserID, submitted.ID)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedStatus, job.Status)
+			assert.Equal(t, testCase.expectedError, job.Error)
+			assert.Equal(t, testStart.AddDate(1, 0, 0), job.Config.To)
+			assert.Equal(t, testStart, job.Config.From)
+			assert.Equal(t, backtest.DefaultInitialCash, job.Config.InitialCash)
+			assert.Equal(t, "5", job.Config.SlippageBps.String())
+			assert.False(t, job.FinishedAt.IsZero())
+			if testCase.expectedStatus == backtest.StatusCompleted {
+				require.NotNil(t, job.Report)
+				assert.Len(t, job.Report.Equity, 366)
+				assert.Len(t, job.Report.Trades, 1)
+			}
+		})
+	}
+}
+
+func TestRunner_Submit_Invalid(t *testing.T) {
+	testCases := map[string]backtest.Request{
+		"it should return IllegalArgumentError for an unknown strategy": {
+			Strategy: "momentum",
+			Tickers:  []string{"A"},
+		},
+		"it should return IllegalArgumentError without tickers": {Strategy: backtest.StrategyBuyAndHold},
+		"it should return IllegalArgumentError for a negative slippage": {
+			Strategy:    backtest.StrategyBuyAndHold,
+			Tickers:     []string{"A"},
+			SlippageBps: decimal.NewNullDecimal(decimal.NewFromInt(-1)),
+		},
+	}
+	for testName, request := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			runner := newTestRunner(t, mockPriceSource{"A": prices(400)}, 2)
+
+			_, err := runner.Submit(testUserID, request)
+			assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+			assert.Empty(t, runner.ListJobs(testUserID))
+		})
+	}
+}
+
+func TestRunner_Jobs(t *testing.T) {
+	source := &blockingPriceSource{prices: mockPriceSource{"A": prices(400)}, release: make(chan struct{})}
+	virtual := clock.NewVirtual(testStart.AddDate(1, 0, 0))
+	runner, err := backtest.NewRunner(source, virtual, 1, trading.DefaultSimulatorConfig())
+	require.NoError(t, err)
+	request := backtest.Request{Strategy: backtest.StrategyBuyAndHold, Tickers: []string{"A"}}
+
+	var ids []uuid.UUID
+	for i := 0; i < backtest.MaxPendingJobs; i++ {
+		job, err := runner.Submit(testUserID, request)
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+		virtual.Advance(time.Minute)
+	}
+	_, err = runner.Submit(testUserID, request)
+	assert.ErrorIs(t, err, ljlib.ConflictError{}, "too many backtests are in progress")
+
+	otherUserID := uuid.New()
+	_, err = runner.GetJob(otherUserID, ids[0])
+	assert.ErrorIs(t, err, ljlib.NotFoundError{})
+	assert.Empty(t, runner.ListJobs(otherUserID))
+
+	close(source.release)
+	runner.Wait()
+	jobs := runner.ListJobs(testUserID)
+	require.Len(t, jobs, backtest.MaxPendingJobs)
+	for i, job := range jobs {
+		assert.Equal(t, ids[len(ids)-1-i], job.ID, "the most recent first")
+		assert.Equal(t, backtest.StatusCompleted, job.Status)
+		assert.Nil(t, job.Report)
+	}
+	_, err = runner.Submit(testUserID, request)
+	assert.NoError(t, err)
+	runner.Wait()
+}
+
+func newTestRunner(t *testing.T, source backtest.PriceSource, parallelism int) *backtest.Runner {
+	runner, err := backtest.NewRunner(source, clock.NewVirtual(testStart.AddDate(1, 0, 0).Add(10*time.Hour)),
+		parallelism, trading.DefaultSimulatorConfig())
+	require.NoError(t, err)
+	return runner
+}
+
+// prices returns the prices growing by 1 every day from 100.
+func prices(days int) []float64 {
+	var result []float64
+	for i := 0; i < days; i++ {
+		result = append(result, float64(100+i))
+	}
+	return result
+}
+
+// blockingPriceSource holds the prices back until it's released.
+type blockingPriceSource struct {
+	prices  mockPriceSource
+	release chan struct{}
+}
+
+func (b *blockingPriceSource) GetHistoricalPrices(ticker string, dateFrom time.Time, dateTo time.Time) ([]ljlib.HistoricalPrice, error) {
+	<-b.release
+	return b.prices.GetHistoricalPrices(ticker, dateFrom, dateTo)
+}
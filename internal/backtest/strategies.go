@@ -0,0 +1,135 @@
+package backtest
+
+import (
+	"sort"
+
+	"github.com/iliyaisd/littlejohn/internal/indicators"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// The names of the built-in strategies.
+const (
+	StrategyBuyAndHold   = "buy_and_hold"
+	StrategySMACrossover = "sma_crossover"
+)
+
+// The default periods of the moving averages of the crossover.
+const (
+	DefaultFastPeriod = 20
+	DefaultSlowPeriod = 50
+)
+
+// NewStrategy returns a built-in strategy by its name, with the parameters it takes.
+func NewStrategy(name string, parameters map[string]int) (Strategy, error) {
+	switch name {
+	case StrategyBuyAndHold:
+		if err := checkParameters(parameters); err != nil {
+			return nil, err
+		}
+		return &BuyAndHold{}, nil
+	case StrategySMACrossover:
+		if err := checkParameters(parameters, "fast", "slow"); err != nil {
+			return nil, err
+		}
+		strategy := &SMACrossover{Fast: DefaultFastPeriod, Slow: DefaultSlowPeriod}
+		if fast, ok := parameters["fast"]; ok {
+			strategy.Fast = fast
+		}
+		if slow, ok := parameters["slow"]; ok {
+			strategy.Slow = slow
+		}
+		if strategy.Fast < 1 || strategy.Fast >= strategy.Slow || strategy.Slow > indicators.MaxPeriod {
+			return nil, ljlib.NewIllegalArgumentError("the periods must be 1 <= fast < slow <= %d",
+				indicators.MaxPeriod)
+		}
+		return strategy, nil
+	default:
+		return nil, ljlib.NewIllegalArgumentError("strategy must be %s or %s", StrategyBuyAndHold,
+			StrategySMACrossover)
+	}
+}
+
+func checkParameters(parameters map[string]int, known ...string) error {
+	isKnown := make(map[string]bool, len(known))
+	for _, name := range known {
+		isKnown[name] = true
+	}
+	for name := range parameters {
+		if !isKnown[name] {
+			return ljlib.NewIllegalArgumentError("unknown parameter [%s]", name)
+		}
+	}
+	return nil
+}
+
+// BuyAndHold spends the cash on the tickers in equal amounts on the first bar, and holds them.
+type BuyAndHold struct {
+	bought bool
+}
+
+func (s *BuyAndHold) OnBar(bar Bar, portfolio Portfolio) []Order {
+	if s.bought {
+		return nil
+	}
+	s.bought = true
+	amount := portfolio.Cash.Div(decimal.NewFromInt(int64(len(bar.Prices)))).Truncate(ljlib.CashPlaces)
+	var orders []Order
+	for _, ticker := range sortedTickers(bar) {
+		orders = append(orders, Order{Ticker: ticker, Side: ljlib.OrderSideBuy, Amount: amount})
+	}
+	return orders
+}
+
+// SMACrossover holds a ticker while its fast simple moving average is above the slow one. It buys a ticker for
+// an equal share of the portfolio's value when the fast average crosses above the slow one, or for the cash left
+// when less, and sells all of it when the fast one falls below. Nothing is traded until there are enough prices
+// for the slow average.
+type SMACrossover struct {
+	Fast   int
+	Slow   int
+	closes map[string][]decimal.Decimal
+}
+
+func (s *SMACrossover) OnBar(bar Bar, portfolio Portfolio) []Order {
+	if s.closes == nil {
+		s.closes = make(map[string][]decimal.Decimal, len(bar.Prices))
+	}
+	share := portfolio.Value(bar).Div(decimal.NewFromInt(int64(len(bar.Prices)))).Truncate(ljlib.CashPlaces)
+	cash := portfolio.Cash
+	var orders []Order
+	for _, ticker := range sortedTickers(bar) {
+		closes := append(s.closes[ticker], bar.Prices[ticker])
+		if len(closes) > s.Slow {
+			closes = closes[1:]
+		}
+		s.closes[ticker] = closes
+		if len(closes) < s.Slow {
+			continue
+		}
+		fast, slow := average(closes[len(closes)-s.Fast:]), average(closes)
+		held := portfolio.Position(ticker)
+		switch {
+		case fast.GreaterThan(slow) && held.IsZero() && cash.IsPositive():
+			amount := decimal.Min(share, cash)
+			cash = cash.Sub(amount)
+			orders = append(orders, Order{Ticker: ticker, Side: ljlib.OrderSideBuy, Amount: amount})
+		case fast.LessThan(slow) && held.IsPositive():
+			orders = append(orders, Order{Ticker: ticker, Side: ljlib.OrderSideSell, Quantity: held})
+		}
+	}
+	return orders
+}
+
+func average(values []decimal.Decimal) decimal.Decimal {
+	return decimal.Sum(values[0], values[1:]...).Div(decimal.NewFromInt(int64(len(values))))
+}
+
+func sortedTickers(bar Bar) []string {
+	tickers := make([]string, 0, len(bar.Prices))
+	for ticker := range bar.Prices {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	return tickers
+}
@@ -0,0 +1,533 @@
+// Package cash keeps the user's cash accounts, one per currency: deposits and withdrawals, the settlement
+// of the trades, and the interest paid on the idle cash.
+package cash
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// daysInYear is the day count convention of the interest: the annual rate is accrued in 1/365 a day.
+var daysInYear = decimal.NewFromInt(365)
+
+type Ledger interface {
+	GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error)
+	PostTransaction(tx ljlib.Transaction) error
+}
+
+type UserSource interface {
+	ListUsers() ([]ljlib.User, error)
+}
+
+// AccountSource provides the buying power, so that the cash held for the open buy orders cannot be withdrawn.
+type AccountSource interface {
+	GetAccount(userID uuid.UUID, at time.Time) (risk.Account, error)
+	//LockAccount locks the user's account, which the orders spend the buying power of too, until the returned
+	//function is called.
+	LockAccount(userID uuid.UUID) func()
+}
+
+type PortfolioSource interface {
+	GetUserPortfolio(userID uuid.UUID) ([]ljlib.TickerPrice, error)
+}
+
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+}
+
+type Config struct {
+	//InterestRates are the annual rates paid on the settled cash, per currency. The accounts can only be held
+	//in these currencies, and the base one has to be among them.
+	InterestRates map[string]decimal.Decimal
+}
+
+func DefaultConfig() Config {
+	return Config{
+		InterestRates: map[string]decimal.Decimal{
+			ljlib.BaseCurrency: decimal.RequireFromString("0.02"),
+			"EUR":              decimal.RequireFromString("0.01"),
+			"GBP":              decimal.RequireFromString("0.015"),
+		},
+	}
+}
+
+// ParseInterestRates parses the rates in form CUR:rate, separated by commas, e.g. USD:0.02,EUR:0.01.
+func ParseInterestRates(value string) (map[string]decimal.Decimal, error) {
+	rates := make(map[string]decimal.Decimal)
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid interest rate [%s], expected CUR:rate", entry)
+		}
+		rate, err := decimal.NewFromString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interest rate of %s: %w", parts[0], err)
+		}
+		rates[strings.ToUpper(parts[0])] = rate
+	}
+	return rates, nil
+}
+
+// Account is the user's cash in a single currency.
+type Account struct {
+	Currency string
+	//Balance is the settled and the pending cash together.
+	Balance decimal.Decimal
+	Settled decimal.Decimal
+	//Pending is the cash of the trades which haven't settled yet: positive for the sells, negative for the buys.
+	Pending decimal.Decimal
+	//Withdrawable is the settled cash, less the pending buys and the cash held for the open buy orders.
+	Withdrawable decimal.Decimal
+	InterestRate decimal.Decimal
+	//AccruedInterest is earned since the start of the month, and paid on the first day of the next one.
+	AccruedInterest    decimal.Decimal
+	PendingSettlements []Settlement
+}
+
+// Settlement is the cash of a trade which is yet to settle.
+type Settlement struct {
+	TransactionID uuid.UUID
+	Type          ljlib.TransactionType
+	Ticker        string
+	Amount        decimal.Decimal
+	SettlesAt     time.Time
+}
+
+// Total is the value of the user's holdings in a single currency: the cash, and the securities at today's prices.
+type Total struct {
+	Currency        string
+	Cash            decimal.Decimal
+	SecuritiesValue decimal.Decimal
+	Total           decimal.Decimal
+}
+
+type Summary struct {
+	//Accounts are sorted by currency, starting with the base one.
+	Accounts []Account
+	Totals   []Total
+}
+
+// TransferRequest is a deposit or a withdrawal. An empty currency is the base one.
+type TransferRequest struct {
+	Amount   decimal.Decimal
+	Currency string
+}
+
+// Service serializes the withdrawals and the interest payments, so that neither can be made twice.
+type Service struct {
+	mu          sync.Mutex
+	ledger      Ledger
+	users       UserSource
+	accounts    AccountSource
+	portfolio   PortfolioSource
+	instruments InstrumentSource
+	clock       clock.Clock
+	config      Config
+}
+
+func NewService(ledger Ledger, users UserSource, accounts AccountSource, portfolio PortfolioSource,
+	instruments InstrumentSource, clock clock.Clock, config Config) (*Service, error) {
+	if _, ok := config.InterestRates[ljlib.BaseCurrency]; !ok {
+		return nil, fmt.Errorf("interest rate of the base currency %s is required", ljlib.BaseCurrency)
+	}
+	for currency, rate := range config.InterestRates {
+		if rate.IsNegative() {
+			return nil, fmt.Errorf("interest rate of %s cannot be negative", currency)
+		}
+	}
+	return &Service{
+		ledger:      ledger,
+		users:       users,
+		accounts:    accounts,
+		portfolio:   portfolio,
+		instruments: instruments,
+		clock:       clock,
+		config:      config,
+	}, nil
+}
+
+// GetSummary returns the user's cash accounts, together with the totals of the cash and the securities
+// per currency. It only reads the ledger: the interest of the months which are over is posted by PayInterest.
+func (s *Service) GetSummary(userID uuid.UUID) (Summary, error) {
+	portfolio, err := s.portfolio.GetUserPortfolio(userID)
+	if err != nil {
+		return Summary{}, fmt.Errorf("cannot get portfolio: %w", err)
+	}
+	accounts, err := s.getAccounts(userID, s.clock.Now())
+	if err != nil {
+		return Summary{}, err
+	}
+
+	totals := make(map[string]Total, len(accounts))
+	for _, account := range accounts {
+		totals[account.Currency] = Total{Currency: account.Currency, Cash: account.Balance}
+	}
+	for _, position := range portfolio {
+		instrument, err := s.instruments.GetInstrument(position.Ticker)
+		if err != nil {
+			return Summary{}, fmt.Errorf("cannot get instrument [%s]: %w", position.Ticker, err)
+		}
+		total := totals[instrument.Currency]
+		total.Currency = instrument.Currency
+		total.SecuritiesValue = total.SecuritiesValue.Add(position.MarketValue())
+		totals[instrument.Currency] = total
+	}
+
+	summary := Summary{Accounts: accounts}
+	for _, total := range totals {
+		total.Total = total.Cash.Add(total.SecuritiesValue)
+		summary.Totals = append(summary.Totals, total)
+	}
+	sort.Slice(summary.Totals, func(i, j int) bool {
+		return currencyLess(summary.Totals[i].Currency, summary.Totals[j].Currency)
+	})
+	return summary, nil
+}
+
+// Deposit credits the account right away, without waiting for the settlement.
+func (s *Service) Deposit(userID uuid.UUID, request TransferRequest) (Account, error) {
+	currency, err := s.validateTransfer(request)
+	if err != nil {
+		return Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	err = s.ledger.PostTransaction(ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Type:       ljlib.TransactionTypeDeposit,
+		Amount:     request.Amount,
+		Currency:   currency,
+		ExecutedAt: now,
+	})
+	if err != nil {
+		return Account{}, fmt.Errorf("cannot post deposit: %w", err)
+	}
+	return s.getAccount(userID, currency, now)
+}
+
+// Withdraw debits the account, as long as the amount is not more than the withdrawable cash.
+func (s *Service) Withdraw(userID uuid.UUID, request TransferRequest) (Account, error) {
+	currency, err := s.validateTransfer(request)
+	if err != nil {
+		return Account{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock := s.accounts.LockAccount(userID)
+	defer unlock()
+
+	now := s.clock.Now()
+	account, err := s.getAccount(userID, currency, now)
+	if err != nil {
+		return Account{}, err
+	}
+	if request.Amount.GreaterThan(account.Withdrawable) {
+		return Account{}, ljlib.NewIllegalArgumentError("amount %s exceeds the withdrawable cash of %s %s",
+			request.Amount.StringFixed(ljlib.CashPlaces), account.Withdrawable.StringFixed(ljlib.CashPlaces), currency)
+	}
+	err = s.ledger.PostTransaction(ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Type:       ljlib.TransactionTypeWithdrawal,
+		Amount:     request.Amount,
+		Currency:   currency,
+		ExecutedAt: now,
+	})
+	if err != nil {
+		return Account{}, fmt.Errorf("cannot post withdrawal: %w", err)
+	}
+	return s.getAccount(userID, currency, now)
+}
+
+// PayInterest pays the interest of the months which are over to all the users. Payments are identified
+// by the user, the currency and the month, so running it more than once within a month pays nothing more.
+func (s *Service) PayInterest(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.users.ListUsers()
+	if err != nil {
+		return fmt.Errorf("cannot list users: %w", err)
+	}
+	for _, user := range users {
+		if err := s.payInterest(user.ID, now); err != nil {
+			log.Printf("Cannot pay interest to user [%s]: %s", user.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) validateTransfer(request TransferRequest) (string, error) {
+	currency := strings.ToUpper(strings.TrimSpace(request.Currency))
+	if len(currency) == 0 {
+		currency = ljlib.BaseCurrency
+	}
+	if _, ok := s.config.InterestRates[currency]; !ok {
+		return "", ljlib.NewIllegalArgumentError("unsupported currency [%s]", currency)
+	}
+	if !request.Amount.IsPositive() {
+		return "", ljlib.NewIllegalArgumentError("amount must be positive")
+	}
+	if !request.Amount.Equal(request.Amount.Truncate(ljlib.CashPlaces)) {
+		return "", ljlib.NewIllegalArgumentError("amount cannot have more than %d decimal places", ljlib.CashPlaces)
+	}
+	return currency, nil
+}
+
+func (s *Service) getAccount(userID uuid.UUID, currency string, now time.Time) (Account, error) {
+	accounts, err := s.getAccounts(userID, now)
+	if err != nil {
+		return Account{}, err
+	}
+	for _, account := range accounts {
+		if account.Currency == currency {
+			return account, nil
+		}
+	}
+	return Account{Currency: currency, InterestRate: s.config.InterestRates[currency]}, nil
+}
+
+// getAccounts returns an account per currency the user has ever held cash in, and always the base one.
+func (s *Service) getAccounts(userID uuid.UUID, now time.Time) ([]Account, error) {
+	transactions, err := s.ledger.GetTransactions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get transactions: %w", err)
+	}
+	riskAccount, err := s.accounts.GetAccount(userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get account: %w", err)
+	}
+
+	accounts := map[string]*Account{
+		ljlib.BaseCurrency: {Currency: ljlib.BaseCurrency},
+	}
+	for _, tx := range transactions {
+		currency := tx.CashCurrency()
+		account, ok := accounts[currency]
+		if !ok {
+			account = &Account{Currency: currency}
+			accounts[currency] = account
+		}
+		cashFlow := tx.CashFlow()
+		if tx.IsSettledAt(now) {
+			account.Settled = account.Settled.Add(cashFlow)
+			continue
+		}
+		account.Pending = account.Pending.Add(cashFlow)
+		account.PendingSettlements = append(account.PendingSettlements, Settlement{
+			TransactionID: tx.ID,
+			Type:          tx.Type,
+			Ticker:        tx.Ticker,
+			Amount:        cashFlow,
+			SettlesAt:     tx.SettlesAt,
+		})
+	}
+
+	result := make([]Account, 0, len(accounts))
+	for currency, account := range accounts {
+		account.Balance = account.Settled.Add(account.Pending)
+		//the pending sells cannot be withdrawn before they settle, while the pending buys have to be paid for
+		account.Withdrawable = decimal.Min(account.Settled, account.Balance)
+		if currency == ljlib.BaseCurrency {
+			account.Withdrawable = decimal.Min(account.Withdrawable, riskAccount.BuyingPower)
+		}
+		account.Withdrawable = decimal.Max(account.Withdrawable, decimal.Zero)
+		account.InterestRate = s.config.InterestRates[currency]
+		if rate := account.InterestRate; rate.IsPositive() {
+			_, account.AccruedInterest = accrueInterest(transactions, currency, rate, now)
+		}
+		result = append(result, *account)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return currencyLess(result[i].Currency, result[j].Currency)
+	})
+	return result, nil
+}
+
+// payInterest posts the interest of the months which are over and not paid yet.
+func (s *Service) payInterest(userID uuid.UUID, now time.Time) error {
+	transactions, err := s.ledger.GetTransactions(userID)
+	if err != nil {
+		return fmt.Errorf("cannot get transactions: %w", err)
+	}
+
+	for currency, rate := range s.config.InterestRates {
+		if !rate.IsPositive() {
+			continue
+		}
+		payments, _ := accrueInterest(transactions, currency, rate, now)
+		for _, payment := range payments {
+			payment.ID = interestPaymentID(userID, currency, payment.ExecutedAt)
+			payment.UserID = userID
+			if err := s.ledger.PostTransaction(payment); err != nil {
+				return fmt.Errorf("cannot post interest payment: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// accrueInterest replays the settled cash in the currency day by day, accruing the interest on the positive
+// balance at the end of each day. Every month which is over is paid on the first day of the next one,
+// rounded down to cents. It returns the payments missing from the ledger, and the interest accrued
+// in the current month.
+func accrueInterest(transactions []ljlib.Transaction, currency string, rate decimal.Decimal,
+	now time.Time) ([]ljlib.Transaction, decimal.Decimal) {
+	var settled []ljlib.Transaction
+	paid := make(map[time.Time]bool)
+	for _, tx := range transactions {
+		if tx.CashCurrency() != currency || !tx.IsSettledAt(now) {
+			continue
+		}
+		settled = append(settled, tx)
+		if tx.Type == ljlib.TransactionTypeInterest {
+			paid[tx.ExecutedAt.UTC()] = true
+		}
+	}
+	if len(settled) == 0 {
+		return nil, decimal.Zero
+	}
+	sort.SliceStable(settled, func(i, j int) bool {
+		return settled[i].SettledSince().Before(settled[j].SettledSince())
+	})
+
+	dailyRate := rate.Div(daysInYear)
+	today := startOfDay(now)
+	var payments []ljlib.Transaction
+	balance, accrued := decimal.Zero, decimal.Zero
+	next := 0
+	for day := startOfDay(settled[0].SettledSince()); !day.After(today); day = day.AddDate(0, 0, 1) {
+		if day.Day() == 1 && accrued.IsPositive() {
+			amount := accrued.RoundFloor(ljlib.CashPlaces)
+			if !paid[day] && amount.IsPositive() {
+				payments = append(payments, ljlib.Transaction{
+					Type:       ljlib.TransactionTypeInterest,
+					Amount:     amount,
+					Currency:   currency,
+					ExecutedAt: day,
+				})
+				//the payments already in the ledger are added to the balance as any other transaction
+				balance = balance.Add(amount)
+			}
+			accrued = decimal.Zero
+		}
+		if day.Equal(today) {
+			break
+		}
+		endOfDay := day.AddDate(0, 0, 1)
+		for ; next < len(settled) && settled[next].SettledSince().Before(endOfDay); next++ {
+			balance = balance.Add(settled[next].CashFlow())
+		}
+		if balance.IsPositive() {
+			accrued = accrued.Add(balance.Mul(dailyRate))
+		}
+	}
+	return payments, accrued.RoundFloor(ljlib.CashPlaces)
+}
+
+func interestPaymentID(userID uuid.UUID, currency string, paidAt time.Time) uuid.UUID {
+	return uuid.NewSHA1(userID, []byte(fmt.Sprintf("%s:%s:%s", ljlib.TransactionTypeInterest, currency,
+		paidAt.AddDate(0, -1, 0).Format("2006-01"))))
+}
+
+func startOfDay(at time.Time) time.Time {
+	year, month, day := at.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// currencyLess sorts the base currency first, and the others alphabetically.
+func currencyLess(a string, b string) bool {
+	if a == ljlib.BaseCurrency || b == ljlib.BaseCurrency {
+		return a == ljlib.BaseCurrency && b != ljlib.BaseCurrency
+	}
+	return a < b
+}
+
+func (a Account) MarshalJSON() ([]byte, error) {
+	settlements := a.PendingSettlements
+	if settlements == nil {
+		settlements = []Settlement{}
+	}
+	return json.Marshal(struct {
+		Currency           string       `json:"currency"`
+		Balance            string       `json:"balance"`
+		Settled            string       `json:"settled"`
+		Pending            string       `json:"pending"`
+		Withdrawable       string       `json:"withdrawable"`
+		InterestRate       string       `json:"interest_rate"`
+		AccruedInterest    string       `json:"accrued_interest"`
+		PendingSettlements []Settlement `json:"pending_settlements"`
+	}{
+		Currency:           a.Currency,
+		Balance:            a.Balance.StringFixed(ljlib.CashPlaces),
+		Settled:            a.Settled.StringFixed(ljlib.CashPlaces),
+		Pending:            a.Pending.StringFixed(ljlib.CashPlaces),
+		Withdrawable:       a.Withdrawable.StringFixed(ljlib.CashPlaces),
+		InterestRate:       a.InterestRate.String(),
+		AccruedInterest:    a.AccruedInterest.StringFixed(ljlib.CashPlaces),
+		PendingSettlements: settlements,
+	})
+}
+
+func (s Settlement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TransactionID string                `json:"transaction_id"`
+		Type          ljlib.TransactionType `json:"type"`
+		Ticker        string                `json:"ticker"`
+		Amount        string                `json:"amount"`
+		SettlesAt     string                `json:"settles_at"`
+	}{
+		TransactionID: s.TransactionID.String(),
+		Type:          s.Type,
+		Ticker:        s.Ticker,
+		Amount:        s.Amount.StringFixed(ljlib.CashPlaces),
+		SettlesAt:     s.SettlesAt.Format(time.RFC3339),
+	})
+}
+
+func (t Total) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Currency        string `json:"currency"`
+		Cash            string `json:"cash"`
+		SecuritiesValue string `json:"securities_value"`
+		Total           string `json:"total"`
+	}{
+		Currency:        t.Currency,
+		Cash:            t.Cash.StringFixed(ljlib.CashPlaces),
+		SecuritiesValue: t.SecuritiesValue.StringFixed(ljlib.CashPlaces),
+		Total:           t.Total.StringFixed(ljlib.CashPlaces),
+	})
+}
+
+func (s Summary) MarshalJSON() ([]byte, error) {
+	accounts, totals := s.Accounts, s.Totals
+	if accounts == nil {
+		accounts = []Account{}
+	}
+	if totals == nil {
+		totals = []Total{}
+	}
+	return json.Marshal(struct {
+		Accounts []Account `json:"accounts"`
+		Totals   []Total   `json:"totals"`
+	}{
+		Accounts: accounts,
+		Totals:   totals,
+	})
+}
@@ -0,0 +1,259 @@
+package cash_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/cash"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+	testStart  = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func TestService_GetSummary(t *testing.T) {
+	now := time.Date(2023, 1, 3, 12, 0, 0, 0, time.UTC)
+	ledger := newMockLedger(
+		deposit("10000", "", testStart),
+		deposit("500", "EUR", testStart),
+		//bought on Monday, settles on Tuesday
+		ljlib.Transaction{Type: ljlib.TransactionTypeBuy, Ticker: "AAPL", Quantity: decimal.NewFromInt(10),
+			Price: decimal.NewFromInt(150), ExecutedAt: now.AddDate(0, 0, -1), SettlesAt: now.AddDate(0, 0, -1)},
+		ljlib.Transaction{Type: ljlib.TransactionTypeSell, Ticker: "AAPL", Quantity: decimal.NewFromInt(2),
+			Price: decimal.NewFromInt(160), Commission: decimal.NewFromInt(1), ExecutedAt: now,
+			SettlesAt: now.AddDate(0, 0, 1)},
+	)
+	service := newTestService(t, ledger, decimal.NewFromInt(8000), clock.NewVirtual(now), noInterest())
+
+	summary, err := service.GetSummary(testUserID)
+	require.NoError(t, err)
+
+	require.Len(t, summary.Accounts, 2)
+	usd := summary.Accounts[0]
+	assert.Equal(t, "USD", usd.Currency)
+	assert.Equal(t, "8500", usd.Settled.String())
+	assert.Equal(t, "319", usd.Pending.String())
+	assert.Equal(t, "8819", usd.Balance.String())
+	//the settled cash, limited by the buying power
+	assert.Equal(t, "8000", usd.Withdrawable.String())
+	require.Len(t, usd.PendingSettlements, 1)
+	assert.Equal(t, now.AddDate(0, 0, 1), usd.PendingSettlements[0].SettlesAt)
+
+	eur := summary.Accounts[1]
+	assert.Equal(t, "EUR", eur.Currency)
+	assert.Equal(t, "500", eur.Balance.String())
+	assert.Equal(t, "500", eur.Withdrawable.String())
+
+	require.Len(t, summary.Totals, 2)
+	assert.Equal(t, "8819", summary.Totals[0].Cash.String())
+	assert.Equal(t, "1200", summary.Totals[0].SecuritiesValue.String())
+	assert.Equal(t, "10019", summary.Totals[0].Total.String())
+	assert.Equal(t, "EUR", summary.Totals[1].Currency)
+	assert.Equal(t, "500", summary.Totals[1].Total.String())
+}
+
+func TestService_Withdraw(t *testing.T) {
+	now := time.Date(2023, 1, 3, 12, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		request         cash.TransferRequest
+		expectedError   bool
+		expectedBalance string
+	}{
+		"it should withdraw the settled cash": {
+			request:         cash.TransferRequest{Amount: decimal.NewFromInt(1000)},
+			expectedBalance: "2000",
+		},
+		"it should return IllegalArgumentError for the cash of a sell which hasn't settled yet": {
+			request:       cash.TransferRequest{Amount: decimal.NewFromInt(1500)},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for a currency the user doesn't hold": {
+			request:       cash.TransferRequest{Amount: decimal.NewFromInt(10), Currency: "GBP"},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for an unsupported currency": {
+			request:       cash.TransferRequest{Amount: decimal.NewFromInt(10), Currency: "JPY"},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for fractions of a cent": {
+			request:       cash.TransferRequest{Amount: decimal.RequireFromString("10.001")},
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ledger := newMockLedger(
+				deposit("1000", "", testStart),
+				ljlib.Transaction{Type: ljlib.TransactionTypeSell, Ticker: "AAPL", Quantity: decimal.NewFromInt(10),
+					Price: decimal.NewFromInt(200), ExecutedAt: now, SettlesAt: now.AddDate(0, 0, 1)},
+			)
+			service := newTestService(t, ledger, decimal.NewFromInt(3000), clock.NewVirtual(now), noInterest())
+
+			account, err := service.Withdraw(testUserID, testCase.request)
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+				assert.Len(t, ledger.transactions, 2)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedBalance, account.Balance.String())
+			assert.Equal(t, "0", account.Withdrawable.String())
+		})
+	}
+}
+
+func TestService_Deposit(t *testing.T) {
+	ledger := newMockLedger()
+	service := newTestService(t, ledger, decimal.Zero, clock.NewVirtual(testStart), noInterest())
+
+	account, err := service.Deposit(testUserID, cash.TransferRequest{Amount: decimal.RequireFromString("250.50"),
+		Currency: "eur"})
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", account.Currency)
+	assert.Equal(t, "250.5", account.Settled.String())
+
+	_, err = service.Deposit(testUserID, cash.TransferRequest{Amount: decimal.NewFromInt(-1)})
+	assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+}
+
+func TestService_PayInterest(t *testing.T) {
+	virtualClock := clock.NewVirtual(time.Date(2023, 1, 20, 0, 0, 0, 0, time.UTC))
+	//36500 at 1% accrues exactly 1 a day
+	ledger := newMockLedger(deposit("36500", "", testStart))
+	config := cash.Config{InterestRates: map[string]decimal.Decimal{
+		ljlib.BaseCurrency: decimal.RequireFromString("0.01"),
+	}}
+	service := newTestService(t, ledger, decimal.Zero, virtualClock, config)
+
+	summary, err := service.GetSummary(testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, "19", summary.Accounts[0].AccruedInterest.String())
+	assert.Len(t, ledger.transactions, 1)
+
+	virtualClock.Set(time.Date(2023, 3, 1, 6, 0, 0, 0, time.UTC))
+	_, err = service.GetSummary(testUserID)
+	require.NoError(t, err)
+	assert.Len(t, ledger.transactions, 1, "reading the summary doesn't post the interest")
+
+	require.NoError(t, service.PayInterest(virtualClock.Now()))
+	require.NoError(t, service.PayInterest(virtualClock.Now()))
+
+	require.Len(t, ledger.transactions, 3)
+	january, february := ledger.transactions[1], ledger.transactions[2]
+	assert.Equal(t, ljlib.TransactionTypeInterest, january.Type)
+	assert.Equal(t, "31", january.Amount.String())
+	assert.Equal(t, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC), january.ExecutedAt)
+	//the interest paid for January earns interest in February: 28 * 36531 * 0.01 / 365 = 28.0237
+	assert.Equal(t, "28.02", february.Amount.String())
+	assert.Equal(t, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), february.ExecutedAt)
+	assert.NotEqual(t, january.ID, february.ID)
+
+	summary, err = service.GetSummary(testUserID)
+	require.NoError(t, err)
+	assert.Equal(t, "36559.02", summary.Accounts[0].Balance.String())
+	assert.Equal(t, "0", summary.Accounts[0].AccruedInterest.String())
+}
+
+func TestParseInterestRates(t *testing.T) {
+	rates, err := cash.ParseInterestRates("USD:0.02, eur:0.01")
+	require.NoError(t, err)
+	assert.Equal(t, "0.02", rates["USD"].String())
+	assert.Equal(t, "0.01", rates["EUR"].String())
+
+	_, err = cash.ParseInterestRates("USD=0.02")
+	assert.Error(t, err)
+}
+
+func newTestService(t *testing.T, ledger *mockLedger, buyingPower decimal.Decimal, clock clock.Clock,
+	config cash.Config) *cash.Service {
+	service, err := cash.NewService(ledger, mockUserSource{}, mockAccountSource{buyingPower: buyingPower},
+		mockPortfolioSource{}, mockInstrumentSource{}, clock, config)
+	require.NoError(t, err)
+	return service
+}
+
+func noInterest() cash.Config {
+	return cash.Config{InterestRates: map[string]decimal.Decimal{
+		ljlib.BaseCurrency: decimal.Zero,
+		"EUR":              decimal.Zero,
+		"GBP":              decimal.Zero,
+	}}
+}
+
+func deposit(amount string, currency string, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		Type:       ljlib.TransactionTypeDeposit,
+		Amount:     decimal.RequireFromString(amount),
+		Currency:   currency,
+		ExecutedAt: at,
+	}
+}
+
+type mockLedger struct {
+	mu           sync.Mutex
+	transactions []ljlib.Transaction
+}
+
+func newMockLedger(transactions ...ljlib.Transaction) *mockLedger {
+	for i := range transactions {
+		transactions[i].ID = uuid.New()
+		transactions[i].UserID = testUserID
+	}
+	return &mockLedger{transactions: transactions}
+}
+
+func (m *mockLedger) GetTransactions(uuid.UUID) ([]ljlib.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]ljlib.Transaction, len(m.transactions))
+	copy(result, m.transactions)
+	return result, nil
+}
+
+func (m *mockLedger) PostTransaction(tx ljlib.Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transactions = append(m.transactions, tx)
+	return nil
+}
+
+type mockUserSource struct{}
+
+func (mockUserSource) ListUsers() ([]ljlib.User, error) {
+	return []ljlib.User{{ID: testUserID}}, nil
+}
+
+type mockAccountSource struct {
+	buyingPower decimal.Decimal
+}
+
+func (m mockAccountSource) GetAccount(uuid.UUID, time.Time) (risk.Account, error) {
+	return risk.Account{BuyingPower: m.buyingPower}, nil
+}
+
+func (m mockAccountSource) LockAccount(uuid.UUID) func() {
+	return func() {}
+}
+
+type mockPortfolioSource struct{}
+
+func (mockPortfolioSource) GetUserPortfolio(uuid.UUID) ([]ljlib.TickerPrice, error) {
+	return []ljlib.TickerPrice{
+		{Ticker: "AAPL", Price: decimal.NewFromInt(150), Quantity: decimal.NewFromInt(8)},
+	}, nil
+}
+
+type mockInstrumentSource struct{}
+
+func (mockInstrumentSource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	return ljlib.Instrument{Ticker: ticker, QuantityPrecision: 6, Currency: ljlib.BaseCurrency, SettlementDays: 1}, nil
+}
@@ -2,6 +2,8 @@ package datasource
 
 import (
 	"fmt"
+	"hash/fnv"
+	"math"
 	"sort"
 	"time"
 
@@ -10,7 +12,73 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-const mockDailyPriceIncrement = 0.5
+const (
+	mockDailyPriceIncrement = 0.5
+	//opening positions are multiples of mockOpeningQuantityStep, up to mockOpeningQuantitySteps of them
+	mockOpeningQuantityStep  = 10
+	mockOpeningQuantitySteps = 10
+	//intraday prices deviate from the daily price by up to mockIntradayAmplitude, mockIntradayWaves times a day
+	mockIntradayAmplitude = 0.02
+	mockIntradayWaves     = 4
+	//mockOpeningCash is left on the user's account after the opening positions are bought
+	mockOpeningCash = 100000
+)
+
+// mockDefaultQuantityPrecision is the precision of the fractional shares, unless overridden in mockQuantityPrecisions.
+const mockDefaultQuantityPrecision = 6
+
+var mockQuantityPrecisions = map[string]int32{
+	//ADRs are traded in whole shares only
+	"BABA": 0,
+	"NFLX": 4,
+}
+
+// mockDefaultSettlementDays is the settlement cycle of the trades (T+1), unless overridden in mockSettlementDays.
+const mockDefaultSettlementDays = 1
+
+var mockSettlementDays = map[string]int{
+	"BABA": 2,
+}
+
+// mockDefaultBorrowRate is the annual fee for borrowing the shares of the easy to borrow tickers,
+// while the hard to borrow ones are listed in mockBorrowRates.
+var mockDefaultBorrowRate = decimal.RequireFromString("0.005")
+
+var mockBorrowRates = map[string]decimal.Decimal{
+	"TSLA": decimal.RequireFromString("0.05"),
+	"BABA": decimal.RequireFromString("0.03"),
+	"NVDA": decimal.RequireFromString("0.02"),
+}
+
+// mockClassifications are the sectors and the industries of the companies, as in GICS, and their countries.
+var mockClassifications = map[string]mockClassification{
+	"AAPL": {sector: "Information Technology", industry: "Technology Hardware", country: "US"},
+	"MSFT": {sector: "Information Technology", industry: "Software", country: "US"},
+	"GOOG": {sector: "Communication Services", industry: "Interactive Media", country: "US"},
+	"AMZN": {sector: "Consumer Discretionary", industry: "Broadline Retail", country: "US"},
+	"META": {sector: "Communication Services", industry: "Interactive Media", country: "US"},
+	"TSLA": {sector: "Consumer Discretionary", industry: "Automobiles", country: "US"},
+	"NVDA": {sector: "Information Technology", industry: "Semiconductors", country: "US"},
+	"JPM":  {sector: "Financials", industry: "Banks", country: "US"},
+	"BABA": {sector: "Consumer Discretionary", industry: "Broadline Retail", country: "CN"},
+	"JNJ":  {sector: "Health Care", industry: "Pharmaceuticals", country: "US"},
+	"WMT":  {sector: "Consumer Staples", industry: "Consumer Staples Retail", country: "US"},
+	"PG":   {sector: "Consumer Staples", industry: "Household Products", country: "US"},
+	"PYPL": {sector: "Financials", industry: "Financial Services", country: "US"},
+	"DIS":  {sector: "Communication Services", industry: "Entertainment", country: "US"},
+	"ADBE": {sector: "Information Technology", industry: "Software", country: "US"},
+	"PFE":  {sector: "Health Care", industry: "Pharmaceuticals", country: "US"},
+	"V":    {sector: "Financials", industry: "Financial Services", country: "US"},
+	"MA":   {sector: "Financials", industry: "Financial Services", country: "US"},
+	"CRM":  {sector: "Information Technology", industry: "Software", country: "US"},
+	"NFLX": {sector: "Communication Services", industry: "Entertainment", country: "US"},
+}
+
+type mockClassification struct {
+	sector   string
+	industry string
+	country  string
+}
 
 var mockRoughTickerPrices = map[string]float64{
 	"AAPL": 150,
@@ -35,32 +103,45 @@ var mockRoughTickerPrices = map[string]float64{
 	"NFLX": 280,
 }
 
-var mockUsers = []ljlib.User{
-	{ID: uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063"), Username: "johndoe"},
-	{ID: uuid.MustParse("f2f208c8-16a4-4ef6-80e3-88103f6471a2"), Username: "littlejohn"},
-	{ID: uuid.MustParse("fa2fc7df-37ed-4582-8c46-01de352b375f"), Username: "jennifer"},
+// mockBenchmarks are the market indices the portfolios are measured against, with their rough prices. They have
+// the price history like the tickers, but cannot be traded or held.
+var mockBenchmarks = map[string]mockBenchmark{
+	"SPY": {name: "SPDR S&P 500 ETF Trust", price: 400},
+	"QQQ": {name: "Invesco QQQ Trust (Nasdaq-100)", price: 300},
+	"DIA": {name: "SPDR Dow Jones Industrial Average ETF Trust", price: 330},
+	"IWM": {name: "iShares Russell 2000 ETF", price: 180},
+}
+
+type mockBenchmark struct {
+	name  string
+	price float64
 }
 
 // LocalDatasource provides mocked data for users, their portfolio, and price history.
 // More details on the approach are described in README file.
+// Users are kept in the embedded LocalUserStorage, so the data source can be used as a user repository as well.
 type LocalDatasource struct {
+	*LocalUserStorage
+	ledger *localLedger
 }
 
 func NewLocalDatasource() LocalDatasource {
-	return LocalDatasource{}
-}
-
-func (l LocalDatasource) GetUserByUsername(username string) (*ljlib.User, error) {
-	for _, u := range mockUsers {
-		if u.Username == username {
-			return &u, nil
-		}
+	return LocalDatasource{
+		LocalUserStorage: NewLocalUserStorage(),
+		ledger:           newLocalLedger(),
 	}
-	return nil, ljlib.NewNotFoundError("cannot find user for username [%s]", username)
 }
 
 func (l LocalDatasource) GetHistoricalPrices(ticker string, dateFrom time.Time, dateTo time.Time) ([]ljlib.HistoricalPrice, error) {
+	if contract, ok := ljlib.ParseOptionSymbol(ticker); ok {
+		return l.getOptionHistoricalPrices(contract, dateFrom, dateTo)
+	}
 	basePrice, ok := mockRoughTickerPrices[ticker]
+	if !ok {
+		var benchmark mockBenchmark
+		benchmark, ok = mockBenchmarks[ticker]
+		basePrice = benchmark.price
+	}
 	if !ok {
 		return nil, ljlib.NewIllegalArgumentError("invalid ticker")
 	}
@@ -78,33 +159,159 @@ func (l LocalDatasource) GetHistoricalPrices(ticker string, dateFrom time.Time,
 	return historicalPrices, nil
 }
 
+// GetPriceAt returns the price of the ticker on the day of the given moment.
+func (l LocalDatasource) GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error) {
+	prices, err := l.GetHistoricalPrices(ticker, at, at)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return prices[0].Price, nil
+}
+
+// GetIntradayPrice returns the price of the ticker at the given moment. The price oscillates around the daily price
+// a few times a day, in a deterministic way, with the phase depending on the ticker.
+func (l LocalDatasource) GetIntradayPrice(ticker string, at time.Time) (decimal.Decimal, error) {
+	//options follow the intraday price of the underlying
+	if contract, ok := ljlib.ParseOptionSymbol(ticker); ok {
+		underlyingPrice, err := l.GetIntradayPrice(contract.Underlying, at)
+		if err != nil {
+			return decimal.Decimal{}, err
+		}
+		return l.getOptionPrice(contract, underlyingPrice, at)
+	}
+	dailyPrice, err := l.GetPriceAt(ticker, at)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	at = at.UTC()
+	dayStart := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	dayFraction := at.Sub(dayStart).Seconds() / (24 * time.Hour).Seconds()
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(ticker))
+	phase := float64(hash.Sum32()%360) * math.Pi / 180
+
+	wave := math.Sin(2*math.Pi*mockIntradayWaves*dayFraction + phase)
+	return dailyPrice.Mul(decimal.NewFromFloat(1 + mockIntradayAmplitude*wave)).Round(2), nil
+}
+
+// GetUserPortfolio returns the user's open positions, valued at today's prices, in the order they were opened.
 func (l LocalDatasource) GetUserPortfolio(userID uuid.UUID) ([]ljlib.TickerPrice, error) {
-	return l.getUserTickers(userID)
+	positions, err := l.getPositions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now()
+	userTickers := make([]ljlib.TickerPrice, 0, len(positions))
+	for _, position := range positions {
+		todayPrice, err := l.GetPriceAt(position.Ticker, today)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get today's price for ticket [%s]: %w", position.Ticker, err)
+		}
+		userTickers = append(userTickers, ljlib.TickerPrice{
+			Ticker:    position.Ticker,
+			Price:     todayPrice,
+			Quantity:  position.Quantity,
+			CostBasis: position.CostBasis(),
+		})
+	}
+	return userTickers, nil
 }
 
 func (l LocalDatasource) UserHasTicker(userID uuid.UUID, ticker string) (bool, error) {
-	tickers, err := l.getUserTickers(userID)
+	positions, err := l.getPositions(userID)
 	if err != nil {
 		return false, err
 	}
-	for _, t := range tickers {
-		if t.Ticker == ticker {
+	for _, position := range positions {
+		if position.Ticker == ticker {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
-func (l LocalDatasource) getUserTickers(userID uuid.UUID) ([]ljlib.TickerPrice, error) {
-	var user *ljlib.User
-	for _, u := range mockUsers {
-		if u.ID == userID {
-			user = &u
-			break
+// GetInstrument returns the reference data of the ticker.
+func (l LocalDatasource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	if contract, ok := ljlib.ParseOptionSymbol(ticker); ok {
+		return l.getOptionInstrument(contract)
+	}
+	if _, ok := mockRoughTickerPrices[ticker]; !ok {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	precision, ok := mockQuantityPrecisions[ticker]
+	if !ok {
+		precision = mockDefaultQuantityPrecision
+	}
+	settlementDays, ok := mockSettlementDays[ticker]
+	if !ok {
+		settlementDays = mockDefaultSettlementDays
+	}
+	borrowRate, ok := mockBorrowRates[ticker]
+	if !ok {
+		borrowRate = mockDefaultBorrowRate
+	}
+	classification := mockClassifications[ticker]
+	return ljlib.Instrument{
+		Ticker:            ticker,
+		AssetClass:        ljlib.AssetClassEquity,
+		Sector:            classification.sector,
+		Industry:          classification.industry,
+		Country:           classification.country,
+		QuantityPrecision: precision,
+		Currency:          ljlib.BaseCurrency,
+		SettlementDays:    settlementDays,
+		BorrowRate:        borrowRate,
+	}, nil
+}
+
+// GetBenchmarks returns the reference data of the market indices, ordered by their tickers.
+func (l LocalDatasource) GetBenchmarks() ([]ljlib.Benchmark, error) {
+	benchmarks := make([]ljlib.Benchmark, 0, len(mockBenchmarks))
+	for ticker, benchmark := range mockBenchmarks {
+		benchmarks = append(benchmarks, ljlib.Benchmark{Ticker: ticker, Name: benchmark.name})
+	}
+	sort.Slice(benchmarks, func(i, j int) bool {
+		return benchmarks[i].Ticker < benchmarks[j].Ticker
+	})
+	return benchmarks, nil
+}
+
+// GetTransactions returns the user's ledger, starting with the generated opening positions.
+func (l LocalDatasource) GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error) {
+	return l.ledger.getOrSeed(userID, func() ([]ljlib.Transaction, error) {
+		return l.generateOpeningTransactions(userID)
+	})
+}
+
+func (l LocalDatasource) PostTransaction(tx ljlib.Transaction) error {
+	return l.ledger.post(tx, func() ([]ljlib.Transaction, error) {
+		return l.generateOpeningTransactions(tx.UserID)
+	})
+}
+
+// getPositions replays the ledger into the open positions, in the order they were opened.
+func (l LocalDatasource) getPositions(userID uuid.UUID) ([]ljlib.Position, error) {
+	transactions, err := l.GetTransactions(userID)
+	if err != nil {
+		return nil, err
+	}
+	var positions []ljlib.Position
+	for _, position := range ljlib.BuildPositions(transactions) {
+		if !position.Quantity.IsZero() {
+			positions = append(positions, position)
 		}
 	}
-	if user == nil {
-		return nil, ljlib.NewNotFoundError("user not found: %s", userID)
+	return positions, nil
+}
+
+// generateOpeningTransactions generates the user's initial portfolio, bought on the day the user was created,
+// preceded by the deposit paying for it and leaving mockOpeningCash on the account.
+func (l LocalDatasource) generateOpeningTransactions(userID uuid.UUID) ([]ljlib.Transaction, error) {
+	user, err := l.GetUserByID(userID)
+	if err != nil {
+		return nil, err
 	}
 
 	//generate user tickers
@@ -115,24 +322,41 @@ func (l LocalDatasource) getUserTickers(userID uuid.UUID) ([]ljlib.TickerPrice,
 	sort.Slice(tickerNames, func(i, j int) bool {
 		return tickerNames[i] < tickerNames[j]
 	})
-	var userTickers []ljlib.TickerPrice
+	var transactions []ljlib.Transaction
 	var alreadyUsedTickers = make(map[string]bool)
-	today := time.Now()
+	openedAt := user.CreatedAt
 	for _, c := range user.Username {
 		ticker := tickerNames[int(c)%len(tickerNames)]
 		if _, ok := alreadyUsedTickers[ticker]; ok {
 			continue
 		}
-		todayPrice, err := l.GetHistoricalPrices(ticker, today, today)
+		openingPrice, err := l.GetPriceAt(ticker, openedAt)
 		if err != nil {
-			return nil, fmt.Errorf("cannot get today's price for ticket [%s]: %w", ticker, err)
+			return nil, fmt.Errorf("cannot get opening price for ticket [%s]: %w", ticker, err)
 		}
-		userTickers = append(userTickers, ljlib.TickerPrice{
-			Ticker: ticker,
-			Price:  todayPrice[0].Price,
+		transactions = append(transactions, ljlib.Transaction{
+			ID:         uuid.NewSHA1(userID, []byte(ticker)),
+			UserID:     userID,
+			Type:       ljlib.TransactionTypeBuy,
+			Ticker:     ticker,
+			Quantity:   decimal.NewFromInt(int64(int(c)%mockOpeningQuantitySteps+1) * mockOpeningQuantityStep),
+			Price:      openingPrice,
+			ExecutedAt: openedAt,
 		})
 		alreadyUsedTickers[ticker] = true
 	}
 
-	return userTickers, nil
+	deposit := decimal.NewFromInt(mockOpeningCash)
+	for _, tx := range transactions {
+		deposit = deposit.Sub(tx.CashFlow())
+	}
+	transactions = append([]ljlib.Transaction{{
+		ID:         uuid.NewSHA1(userID, []byte(ljlib.TransactionTypeDeposit)),
+		UserID:     userID,
+		Type:       ljlib.TransactionTypeDeposit,
+		Amount:     deposit,
+		ExecutedAt: openedAt,
+	}}, transactions...)
+
+	return transactions, nil
 }
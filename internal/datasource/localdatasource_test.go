@@ -26,6 +26,10 @@ func TestLocalDatasource_GetHistoricalPrices(t *testing.T) {
 			ticker:         "AAPL",
 			expectedLength: 11,
 		},
+		"it should return the prices of a benchmark": {
+			ticker:         "SPY",
+			expectedLength: 11,
+		},
 	}
 	for testName, testCase := range testCases {
 		t.Run(testName, func(t *testing.T) {
@@ -42,6 +46,22 @@ func TestLocalDatasource_GetHistoricalPrices(t *testing.T) {
 	}
 }
 
+func TestLocalDatasource_GetBenchmarks(t *testing.T) {
+	localDS := datasource.NewLocalDatasource()
+
+	benchmarks, err := localDS.GetBenchmarks()
+	require.NoError(t, err)
+
+	require.NotEmpty(t, benchmarks)
+	assert.Equal(t, "DIA", benchmarks[0].Ticker)
+	for _, benchmark := range benchmarks {
+		assert.NotEmpty(t, benchmark.Name)
+		//the benchmarks cannot be traded
+		_, err := localDS.GetInstrument(benchmark.Ticker)
+		assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+	}
+}
+
 func TestLocalDatasource_GetUserPortfolio(t *testing.T) {
 	testCases := map[string]struct {
 		userUUID       uuid.UUID
@@ -77,3 +97,36 @@ func mustParseDate(t *testing.T, dt string) time.Time {
 	require.NoError(t, err)
 	return tm
 }
+
+func TestLocalDatasource_GetOptionChain(t *testing.T) {
+	localDS := datasource.NewLocalDatasource()
+	at := time.Date(2023, 7, 20, 15, 0, 0, 0, time.UTC)
+
+	expiries, err := localDS.GetOptionExpiries("AAPL", at)
+	require.NoError(t, err)
+	require.NotEmpty(t, expiries)
+	//the third Friday of July 2023
+	assert.Equal(t, time.Date(2023, 7, 21, ljlib.OptionExpiryHour, 0, 0, 0, time.UTC), expiries[0])
+
+	chain, err := localDS.GetOptionChain("AAPL", expiries[1], at)
+	require.NoError(t, err)
+	assert.Equal(t, expiries[1], chain.Expiry)
+	require.NotEmpty(t, chain.Quotes)
+	for _, quote := range chain.Quotes {
+		assert.Equal(t, expiries[1], quote.Contract.Expiry)
+		assert.False(t, quote.Price.IsNegative())
+
+		//every listed contract is a tradable instrument, priced the same way
+		instrument, err := localDS.GetInstrument(quote.Contract.Symbol())
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), instrument.QuantityPrecision)
+		price, err := localDS.GetPriceAt(quote.Contract.Symbol(), at)
+		require.NoError(t, err)
+		assert.Equal(t, quote.Price, price)
+	}
+
+	_, err = localDS.GetOptionChain("AAPL", time.Date(2023, 7, 28, 0, 0, 0, 0, time.UTC), at)
+	assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+	_, err = localDS.GetInstrument("AAPL230728C00150000")
+	assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+}
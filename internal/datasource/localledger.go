@@ -0,0 +1,68 @@
+package datasource
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// localLedger keeps the transactions of every user in memory, in the order they were posted.
+// A user's ledger starts with the generated opening positions, written on first access.
+type localLedger struct {
+	mu           sync.RWMutex
+	transactions map[uuid.UUID][]ljlib.Transaction
+}
+
+func newLocalLedger() *localLedger {
+	return &localLedger{
+		transactions: make(map[uuid.UUID][]ljlib.Transaction),
+	}
+}
+
+// getOrSeed returns a copy of the user's transactions, seeding the ledger with the result of seed when it's empty.
+func (l *localLedger) getOrSeed(userID uuid.UUID, seed func() ([]ljlib.Transaction, error)) ([]ljlib.Transaction, error) {
+	l.mu.RLock()
+	transactions, ok := l.transactions[userID]
+	l.mu.RUnlock()
+	if !ok {
+		if err := l.seed(userID, seed); err != nil {
+			return nil, err
+		}
+		l.mu.RLock()
+		transactions = l.transactions[userID]
+		l.mu.RUnlock()
+	}
+	result := make([]ljlib.Transaction, len(transactions))
+	copy(result, transactions)
+	return result, nil
+}
+
+func (l *localLedger) post(tx ljlib.Transaction, seed func() ([]ljlib.Transaction, error)) error {
+	if err := l.seed(tx.UserID, seed); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.transactions[tx.UserID] = append(l.transactions[tx.UserID], tx)
+	return nil
+}
+
+func (l *localLedger) seed(userID uuid.UUID, seed func() ([]ljlib.Transaction, error)) error {
+	l.mu.RLock()
+	_, ok := l.transactions[userID]
+	l.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	opening, err := seed()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.transactions[userID]; !ok {
+		l.transactions[userID] = opening
+	}
+	return nil
+}
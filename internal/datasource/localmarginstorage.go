@@ -0,0 +1,78 @@
+package datasource
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// LocalMarginStorage keeps the margin calls and the notifications of the users in memory.
+type LocalMarginStorage struct {
+	mu            sync.RWMutex
+	marginCalls   map[uuid.UUID]ljlib.MarginCall
+	notifications map[uuid.UUID][]ljlib.Notification
+}
+
+func NewLocalMarginStorage() *LocalMarginStorage {
+	return &LocalMarginStorage{
+		marginCalls:   make(map[uuid.UUID]ljlib.MarginCall),
+		notifications: make(map[uuid.UUID][]ljlib.Notification),
+	}
+}
+
+func (s *LocalMarginStorage) CreateMarginCall(call ljlib.MarginCall) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.marginCalls[call.ID]; ok {
+		return ljlib.NewConflictError("margin call with id [%s] already exists", call.ID)
+	}
+	s.marginCalls[call.ID] = call
+	return nil
+}
+
+func (s *LocalMarginStorage) UpdateMarginCall(call ljlib.MarginCall) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.marginCalls[call.ID]; !ok {
+		return ljlib.NewNotFoundError("margin call not found: %s", call.ID)
+	}
+	s.marginCalls[call.ID] = call
+	return nil
+}
+
+// ListMarginCalls returns the user's margin calls, the most recent first.
+func (s *LocalMarginStorage) ListMarginCalls(userID uuid.UUID) ([]ljlib.MarginCall, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var calls []ljlib.MarginCall
+	for _, call := range s.marginCalls {
+		if call.UserID == userID {
+			calls = append(calls, call)
+		}
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].IssuedAt.After(calls[j].IssuedAt)
+	})
+	return calls, nil
+}
+
+func (s *LocalMarginStorage) CreateNotification(notification ljlib.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications[notification.UserID] = append(s.notifications[notification.UserID], notification)
+	return nil
+}
+
+// ListNotifications returns the user's notifications, the most recent first.
+func (s *LocalMarginStorage) ListNotifications(userID uuid.UUID) ([]ljlib.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored := s.notifications[userID]
+	notifications := make([]ljlib.Notification, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		notifications = append(notifications, stored[i])
+	}
+	return notifications, nil
+}
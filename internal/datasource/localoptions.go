@@ -0,0 +1,171 @@
+package datasource
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//mockRiskFreeRate is the annual rate the options are priced with
+	mockRiskFreeRate = 0.04
+	//options are listed for the monthly expiries (third Friday of the month) of the next mockOptionExpiries months
+	mockOptionExpiries = 6
+	//the chain has mockOptionStrikesPerSide strikes below and above the one closest to the underlying price
+	mockOptionStrikesPerSide = 10
+	//volatilities are generated from mockMinVolatility to mockMinVolatility + mockVolatilityRange
+	mockMinVolatility   = 0.2
+	mockVolatilityRange = 40
+)
+
+// GetVolatility returns the annual volatility of the ticker, generated deterministically from its name.
+func (l LocalDatasource) GetVolatility(ticker string) (float64, error) {
+	if _, ok := mockRoughTickerPrices[ticker]; !ok {
+		return 0, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(ticker))
+	return mockMinVolatility + float64(hash.Sum32()%mockVolatilityRange)/100, nil
+}
+
+// GetOptionExpiries returns the expiries the options of the underlying are listed for at the given moment,
+// the nearest first.
+func (l LocalDatasource) GetOptionExpiries(underlying string, at time.Time) ([]time.Time, error) {
+	if _, ok := mockRoughTickerPrices[underlying]; !ok {
+		return nil, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	at = at.UTC()
+	var expiries []time.Time
+	month := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for ; len(expiries) < mockOptionExpiries; month = month.AddDate(0, 1, 0) {
+		expiry := monthlyExpiry(month)
+		if expiry.After(at) {
+			expiries = append(expiries, expiry)
+		}
+	}
+	return expiries, nil
+}
+
+// GetOptionChain returns the calls and the puts of the underlying expiring on the given date, priced
+// at the given moment, by strike.
+func (l LocalDatasource) GetOptionChain(underlying string, expiryDate time.Time, at time.Time) (ljlib.OptionChain, error) {
+	expiries, err := l.GetOptionExpiries(underlying, at)
+	if err != nil {
+		return ljlib.OptionChain{}, err
+	}
+	var expiry time.Time
+	for _, listed := range expiries {
+		if listed.Format("2006-01-02") == expiryDate.Format("2006-01-02") {
+			expiry = listed
+		}
+	}
+	if expiry.IsZero() {
+		return ljlib.OptionChain{}, ljlib.NewIllegalArgumentError("no options of %s expire on %s", underlying,
+			expiryDate.Format("2006-01-02"))
+	}
+	underlyingPrice, err := l.GetPriceAt(underlying, at)
+	if err != nil {
+		return ljlib.OptionChain{}, err
+	}
+	volatility, err := l.GetVolatility(underlying)
+	if err != nil {
+		return ljlib.OptionChain{}, err
+	}
+
+	chain := ljlib.OptionChain{
+		Underlying:      underlying,
+		UnderlyingPrice: underlyingPrice,
+		Volatility:      volatility,
+		Expiry:          expiry,
+		Expiries:        expiries,
+	}
+	step := strikeStep(underlyingPrice)
+	atTheMoney := underlyingPrice.Div(step).Round(0).Mul(step)
+	for i := -mockOptionStrikesPerSide; i <= mockOptionStrikesPerSide; i++ {
+		strike := atTheMoney.Add(step.Mul(decimal.NewFromInt(int64(i))))
+		if !strike.IsPositive() {
+			continue
+		}
+		for _, optionType := range []ljlib.OptionType{ljlib.OptionTypeCall, ljlib.OptionTypePut} {
+			contract := ljlib.NewOptionContract(underlying, expiry, strike, optionType)
+			chain.Quotes = append(chain.Quotes,
+				ljlib.PriceOption(contract, underlyingPrice, volatility, mockRiskFreeRate, at))
+		}
+	}
+	return chain, nil
+}
+
+// getOptionInstrument returns the reference data of the option contract, as long as it's listed:
+// the underlying is known, and the contract is for its monthly expiry.
+func (l LocalDatasource) getOptionInstrument(contract ljlib.OptionContract) (ljlib.Instrument, error) {
+	if _, ok := mockRoughTickerPrices[contract.Underlying]; !ok {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	if !contract.Expiry.Equal(monthlyExpiry(contract.Expiry)) {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	classification := mockClassifications[contract.Underlying]
+	return ljlib.Instrument{
+		Ticker:            contract.Symbol(),
+		AssetClass:        ljlib.AssetClassOption,
+		Sector:            classification.sector,
+		Industry:          classification.industry,
+		Country:           classification.country,
+		QuantityPrecision: 0,
+		Currency:          ljlib.BaseCurrency,
+		SettlementDays:    mockDefaultSettlementDays,
+		Option:            &contract,
+	}, nil
+}
+
+// getOptionHistoricalPrices prices the option on each day from the daily price of the underlying.
+func (l LocalDatasource) getOptionHistoricalPrices(contract ljlib.OptionContract, dateFrom time.Time,
+	dateTo time.Time) ([]ljlib.HistoricalPrice, error) {
+	underlyingPrices, err := l.GetHistoricalPrices(contract.Underlying, dateFrom, dateTo)
+	if err != nil {
+		return nil, err
+	}
+	historicalPrices := make([]ljlib.HistoricalPrice, 0, len(underlyingPrices))
+	for _, underlyingPrice := range underlyingPrices {
+		price, err := l.getOptionPrice(contract, underlyingPrice.Price, underlyingPrice.Date)
+		if err != nil {
+			return nil, err
+		}
+		historicalPrices = append(historicalPrices, ljlib.HistoricalPrice{Date: underlyingPrice.Date, Price: price})
+	}
+	return historicalPrices, nil
+}
+
+// getOptionPrice prices the option from the given price of the underlying.
+func (l LocalDatasource) getOptionPrice(contract ljlib.OptionContract, underlyingPrice decimal.Decimal,
+	at time.Time) (decimal.Decimal, error) {
+	volatility, err := l.GetVolatility(contract.Underlying)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return ljlib.PriceOption(contract, underlyingPrice, volatility, mockRiskFreeRate, at).Price, nil
+}
+
+// monthlyExpiry returns the third Friday of the month, at the close.
+func monthlyExpiry(month time.Time) time.Time {
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	firstFriday := 1 + (int(time.Friday)-int(first.Weekday())+7)%7
+	return time.Date(month.Year(), month.Month(), firstFriday+14, ljlib.OptionExpiryHour, 0, 0, 0, time.UTC)
+}
+
+// strikeStep is the distance between the strikes, growing with the price of the underlying.
+func strikeStep(price decimal.Decimal) decimal.Decimal {
+	switch {
+	case price.LessThan(decimal.NewFromInt(25)):
+		return decimal.NewFromInt(1)
+	case price.LessThan(decimal.NewFromInt(100)):
+		return decimal.RequireFromString("2.5")
+	case price.LessThan(decimal.NewFromInt(250)):
+		return decimal.NewFromInt(5)
+	case price.LessThan(decimal.NewFromInt(1000)):
+		return decimal.NewFromInt(10)
+	}
+	return decimal.NewFromInt(25)
+}
@@ -0,0 +1,92 @@
+package datasource
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// LocalOrderStorage keeps orders in memory.
+type LocalOrderStorage struct {
+	mu     sync.RWMutex
+	orders map[uuid.UUID]ljlib.Order
+}
+
+func NewLocalOrderStorage() *LocalOrderStorage {
+	return &LocalOrderStorage{
+		orders: make(map[uuid.UUID]ljlib.Order),
+	}
+}
+
+func (s *LocalOrderStorage) CreateOrder(order ljlib.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[order.ID]; ok {
+		return ljlib.NewConflictError("order with id [%s] already exists", order.ID)
+	}
+	s.orders[order.ID] = copyOrder(order)
+	return nil
+}
+
+func (s *LocalOrderStorage) UpdateOrder(order ljlib.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[order.ID]; !ok {
+		return ljlib.NewNotFoundError("order not found: %s", order.ID)
+	}
+	s.orders[order.ID] = copyOrder(order)
+	return nil
+}
+
+func (s *LocalOrderStorage) GetOrder(orderID uuid.UUID) (*ljlib.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, ljlib.NewNotFoundError("order not found: %s", orderID)
+	}
+	order = copyOrder(order)
+	return &order, nil
+}
+
+// ListOrders returns the user's orders, the most recent first.
+func (s *LocalOrderStorage) ListOrders(userID uuid.UUID) ([]ljlib.Order, error) {
+	return s.list(func(o ljlib.Order) bool {
+		return o.UserID == userID
+	}), nil
+}
+
+// ListOpenOrders returns the orders of all users which can still be filled, the most recent first.
+func (s *LocalOrderStorage) ListOpenOrders() ([]ljlib.Order, error) {
+	return s.list(func(o ljlib.Order) bool {
+		return o.IsOpen()
+	}), nil
+}
+
+func (s *LocalOrderStorage) list(matches func(o ljlib.Order) bool) []ljlib.Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var orders []ljlib.Order
+	for _, o := range s.orders {
+		if matches(o) {
+			orders = append(orders, copyOrder(o))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.After(orders[j].CreatedAt)
+	})
+	return orders
+}
+
+// copyOrder makes sure callers never share the fills slice with the stored order.
+func copyOrder(order ljlib.Order) ljlib.Order {
+	fills := make([]ljlib.Fill, len(order.Fills))
+	copy(fills, order.Fills)
+	order.Fills = fills
+	events := make([]ljlib.OrderEvent, len(order.Events))
+	copy(events, order.Events)
+	order.Events = events
+	return order
+}
@@ -0,0 +1,99 @@
+package datasource
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// LocalPlanStorage keeps recurring investment plans in memory.
+type LocalPlanStorage struct {
+	mu    sync.RWMutex
+	plans map[uuid.UUID]ljlib.Plan
+}
+
+func NewLocalPlanStorage() *LocalPlanStorage {
+	return &LocalPlanStorage{
+		plans: make(map[uuid.UUID]ljlib.Plan),
+	}
+}
+
+func (s *LocalPlanStorage) CreatePlan(plan ljlib.Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[plan.ID]; ok {
+		return ljlib.NewConflictError("plan with id [%s] already exists", plan.ID)
+	}
+	s.plans[plan.ID] = copyPlan(plan)
+	return nil
+}
+
+func (s *LocalPlanStorage) UpdatePlan(plan ljlib.Plan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[plan.ID]; !ok {
+		return ljlib.NewNotFoundError("plan not found: %s", plan.ID)
+	}
+	s.plans[plan.ID] = copyPlan(plan)
+	return nil
+}
+
+func (s *LocalPlanStorage) DeletePlan(planID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[planID]; !ok {
+		return ljlib.NewNotFoundError("plan not found: %s", planID)
+	}
+	delete(s.plans, planID)
+	return nil
+}
+
+func (s *LocalPlanStorage) GetPlan(planID uuid.UUID) (*ljlib.Plan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	plan, ok := s.plans[planID]
+	if !ok {
+		return nil, ljlib.NewNotFoundError("plan not found: %s", planID)
+	}
+	plan = copyPlan(plan)
+	return &plan, nil
+}
+
+// ListPlans returns the user's plans, the most recent first.
+func (s *LocalPlanStorage) ListPlans(userID uuid.UUID) ([]ljlib.Plan, error) {
+	return s.list(func(p ljlib.Plan) bool {
+		return p.UserID == userID
+	}), nil
+}
+
+// ListActivePlans returns the plans of all users which are not paused, the most recent first.
+func (s *LocalPlanStorage) ListActivePlans() ([]ljlib.Plan, error) {
+	return s.list(func(p ljlib.Plan) bool {
+		return p.Status == ljlib.PlanStatusActive
+	}), nil
+}
+
+func (s *LocalPlanStorage) list(matches func(p ljlib.Plan) bool) []ljlib.Plan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var plans []ljlib.Plan
+	for _, p := range s.plans {
+		if matches(p) {
+			plans = append(plans, copyPlan(p))
+		}
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].CreatedAt.After(plans[j].CreatedAt)
+	})
+	return plans
+}
+
+// copyPlan makes sure callers never share the executions slice with the stored plan.
+func copyPlan(plan ljlib.Plan) ljlib.Plan {
+	executions := make([]ljlib.PlanExecution, len(plan.Executions))
+	copy(executions, plan.Executions)
+	plan.Executions = executions
+	return plan
+}
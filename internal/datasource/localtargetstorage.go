@@ -0,0 +1,44 @@
+package datasource
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// LocalTargetStorage keeps the users' target allocations in memory.
+type LocalTargetStorage struct {
+	mu      sync.RWMutex
+	targets map[uuid.UUID]ljlib.TargetAllocation
+}
+
+func NewLocalTargetStorage() *LocalTargetStorage {
+	return &LocalTargetStorage{
+		targets: make(map[uuid.UUID]ljlib.TargetAllocation),
+	}
+}
+
+// SaveTargets replaces the user's target allocation.
+func (s *LocalTargetStorage) SaveTargets(targets ljlib.TargetAllocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets[targets.UserID] = copyTargets(targets)
+	return nil
+}
+
+func (s *LocalTargetStorage) GetTargets(userID uuid.UUID) (ljlib.TargetAllocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	targets, ok := s.targets[userID]
+	if !ok {
+		return ljlib.TargetAllocation{}, ljlib.NewNotFoundError("targets not found for user: %s", userID)
+	}
+	return copyTargets(targets), nil
+}
+
+// copyTargets makes sure callers never share the targets slice with the stored allocation.
+func copyTargets(targets ljlib.TargetAllocation) ljlib.TargetAllocation {
+	targets.Targets = append([]ljlib.Target{}, targets.Targets...)
+	return targets
+}
@@ -0,0 +1,61 @@
+package datasource
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+// LocalTokenStorage keeps issued API tokens in memory, so they get invalidated on restart.
+type LocalTokenStorage struct {
+	mu     sync.RWMutex
+	tokens map[uuid.UUID]ljlib.APIToken
+}
+
+func NewLocalTokenStorage() *LocalTokenStorage {
+	return &LocalTokenStorage{
+		tokens: make(map[uuid.UUID]ljlib.APIToken),
+	}
+}
+
+func (s *LocalTokenStorage) CreateToken(token ljlib.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[token.ID]; ok {
+		return ljlib.NewConflictError("token with id [%s] already exists", token.ID)
+	}
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *LocalTokenStorage) GetTokenByHash(tokenHash string) (*ljlib.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tokens {
+		if t.TokenHash == tokenHash {
+			return &t, nil
+		}
+	}
+	return nil, ljlib.NewNotFoundError("token not found")
+}
+
+func (s *LocalTokenStorage) GetTokenByID(tokenID uuid.UUID) (*ljlib.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[tokenID]
+	if !ok {
+		return nil, ljlib.NewNotFoundError("token not found: %s", tokenID)
+	}
+	return &t, nil
+}
+
+func (s *LocalTokenStorage) UpdateToken(token ljlib.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[token.ID]; !ok {
+		return ljlib.NewNotFoundError("token not found: %s", token.ID)
+	}
+	s.tokens[token.ID] = token
+	return nil
+}
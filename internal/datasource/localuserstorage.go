@@ -0,0 +1,180 @@
+package datasource
+
+import (
+	"crypto/subtle"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/ljlib"
+)
+
+var mockUsersCreatedAt = time.Date(2023, 01, 01, 00, 00, 00, 0, time.UTC)
+
+var mockUsers = []ljlib.User{
+	{ID: uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063"), Username: "johndoe", Email: "johndoe@littlejohn.example",
+		Role: ljlib.RoleUser, CreatedAt: mockUsersCreatedAt},
+	{ID: uuid.MustParse("f2f208c8-16a4-4ef6-80e3-88103f6471a2"), Username: "littlejohn", Email: "littlejohn@littlejohn.example",
+		Role: ljlib.RoleAdmin, CreatedAt: mockUsersCreatedAt},
+	{ID: uuid.MustParse("fa2fc7df-37ed-4582-8c46-01de352b375f"), Username: "jennifer", Email: "jennifer@littlejohn.example",
+		Role: ljlib.RoleUser, CreatedAt: mockUsersCreatedAt},
+}
+
+// LocalUserStorage keeps users in memory. It is seeded with the mocked users, so they survive restarts,
+// while the users registered through the API live only as long as the process.
+type LocalUserStorage struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]ljlib.User
+}
+
+func NewLocalUserStorage() *LocalUserStorage {
+	storage := &LocalUserStorage{
+		users: make(map[uuid.UUID]ljlib.User, len(mockUsers)),
+	}
+	for _, u := range mockUsers {
+		storage.users[u.ID] = u
+	}
+	return storage
+}
+
+func (s *LocalUserStorage) GetUserByUsername(username string) (*ljlib.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, ljlib.NewNotFoundError("cannot find user for username [%s]", username)
+}
+
+func (s *LocalUserStorage) GetUserByID(userID uuid.UUID) (*ljlib.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, ljlib.NewNotFoundError("user not found: %s", userID)
+	}
+	return &u, nil
+}
+
+// ListUsers returns all users, including disabled and deleted ones, ordered by username.
+func (s *LocalUserStorage) ListUsers() ([]ljlib.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]ljlib.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+func (s *LocalUserStorage) CreateUser(user ljlib.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.ID]; ok {
+		return ljlib.NewConflictError("user with id [%s] already exists", user.ID)
+	}
+	if err := s.checkUnique(user); err != nil {
+		return err
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *LocalUserStorage) UpdateUser(user ljlib.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.ID]; !ok {
+		return ljlib.NewNotFoundError("user not found: %s", user.ID)
+	}
+	if err := s.checkUnique(user); err != nil {
+		return err
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+// ModifyUser applies the change to the stored user and stores it, all under the lock, so the change is made
+// to the latest record and doesn't undo the ones made since the user was read. The change returning an error
+// leaves the user untouched. It returns the modified user.
+func (s *LocalUserStorage) ModifyUser(userID uuid.UUID, change func(user *ljlib.User) error) (ljlib.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return ljlib.User{}, ljlib.NewNotFoundError("user not found: %s", userID)
+	}
+	user.RecoveryCodeHashes = append([]string(nil), user.RecoveryCodeHashes...)
+	if err := change(&user); err != nil {
+		return ljlib.User{}, err
+	}
+	user.ID = userID
+	if err := s.checkUnique(user); err != nil {
+		return ljlib.User{}, err
+	}
+	s.users[userID] = user
+	return user, nil
+}
+
+// UseTOTPCounter stores the counter of an accepted TOTP code, leaving the rest of the user untouched.
+// It returns ConflictError when the counter isn't newer than the last used one, so a code is accepted only once,
+// even by concurrent requests.
+func (s *LocalUserStorage) UseTOTPCounter(userID uuid.UUID, counter int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return ljlib.NewNotFoundError("user not found: %s", userID)
+	}
+	if !user.TOTPEnabled || counter <= user.TOTPLastCounter {
+		return ljlib.NewConflictError("one-time code for user [%s] is already used", user.Username)
+	}
+	user.TOTPLastCounter = counter
+	s.users[userID] = user
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code hash from the user, leaving the rest of the user untouched.
+// It returns ConflictError when the user has no such unused code.
+func (s *LocalUserStorage) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return ljlib.NewNotFoundError("user not found: %s", userID)
+	}
+	for i, h := range user.RecoveryCodeHashes {
+		if user.TOTPEnabled && subtle.ConstantTimeCompare([]byte(h), []byte(codeHash)) == 1 {
+			remaining := make([]string, 0, len(user.RecoveryCodeHashes)-1)
+			remaining = append(remaining, user.RecoveryCodeHashes[:i]...)
+			remaining = append(remaining, user.RecoveryCodeHashes[i+1:]...)
+			user.RecoveryCodeHashes = remaining
+			s.users[userID] = user
+			return nil
+		}
+	}
+	return ljlib.NewConflictError("recovery code for user [%s] is unknown or already used", user.Username)
+}
+
+// checkUnique verifies that no other user holds the same username or email.
+// Deleted users keep their username and email reserved, as their records are still kept.
+func (s *LocalUserStorage) checkUnique(user ljlib.User) error {
+	for _, u := range s.users {
+		if u.ID == user.ID {
+			continue
+		}
+		if u.Username == user.Username {
+			return ljlib.NewConflictError("username [%s] is already taken", user.Username)
+		}
+		if len(user.Email) > 0 && strings.EqualFold(u.Email, user.Email) {
+			return ljlib.NewConflictError("email [%s] is already registered", user.Email)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package datasource_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalUserStorage_CreateUser(t *testing.T) {
+	testCases := map[string]struct {
+		user          ljlib.User
+		expectedError bool
+	}{
+		"it should return ConflictError when username is already taken": {
+			user:          ljlib.User{ID: uuid.New(), Username: "johndoe", Email: "another@littlejohn.example"},
+			expectedError: true,
+		},
+		"it should return ConflictError when email is already registered, ignoring case": {
+			user:          ljlib.User{ID: uuid.New(), Username: "another", Email: "JohnDoe@littlejohn.example"},
+			expectedError: true,
+		},
+		"it should create a user with unique username and email": {
+			user: ljlib.User{ID: uuid.New(), Username: "another", Email: "another@littlejohn.example"},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			storage := datasource.NewLocalUserStorage()
+			err := storage.CreateUser(testCase.user)
+			if testCase.expectedError {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ljlib.ConflictError{}))
+			} else {
+				require.NoError(t, err)
+				user, err := storage.GetUserByUsername(testCase.user.Username)
+				require.NoError(t, err)
+				assert.Equal(t, testCase.user.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestLocalUserStorage_UpdateUser(t *testing.T) {
+	storage := datasource.NewLocalUserStorage()
+	user, err := storage.GetUserByUsername("jennifer")
+	require.NoError(t, err)
+
+	user.Disabled = true
+	require.NoError(t, storage.UpdateUser(*user))
+
+	updated, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, updated.IsActive())
+
+	err = storage.UpdateUser(ljlib.User{ID: uuid.New(), Username: "ghost"})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ljlib.NotFoundError{}))
+}
+
+func TestLocalUserStorage_UseTOTPCounter(t *testing.T) {
+	storage := datasource.NewLocalUserStorage()
+	user := ljlib.User{ID: uuid.New(), Username: "totp", TOTPEnabled: true, TOTPLastCounter: 10,
+		RecoveryCodeHashes: []string{"a", "b"}}
+	require.NoError(t, storage.CreateUser(user))
+
+	assert.ErrorIs(t, storage.UseTOTPCounter(user.ID, 10), ljlib.ConflictError{}, "the counter is already used")
+	require.NoError(t, storage.UseTOTPCounter(user.ID, 11))
+	assert.ErrorIs(t, storage.UseTOTPCounter(user.ID, 11), ljlib.ConflictError{}, "the counter is accepted only once")
+	assert.ErrorIs(t, storage.UseTOTPCounter(uuid.New(), 12), ljlib.NotFoundError{})
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), stored.TOTPLastCounter)
+	assert.Equal(t, []string{"a", "b"}, stored.RecoveryCodeHashes)
+}
+
+func TestLocalUserStorage_UseRecoveryCode(t *testing.T) {
+	storage := datasource.NewLocalUserStorage()
+	user := ljlib.User{ID: uuid.New(), Username: "totp", TOTPEnabled: true, TOTPLastCounter: 10,
+		RecoveryCodeHashes: []string{"a", "b"}}
+	require.NoError(t, storage.CreateUser(user))
+
+	require.NoError(t, storage.UseRecoveryCode(user.ID, "a"))
+	assert.ErrorIs(t, storage.UseRecoveryCode(user.ID, "a"), ljlib.ConflictError{}, "the code is used only once")
+	assert.ErrorIs(t, storage.UseRecoveryCode(user.ID, "c"), ljlib.ConflictError{})
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, stored.RecoveryCodeHashes)
+	assert.Equal(t, int64(10), stored.TOTPLastCounter)
+}
+
+func TestLocalUserStorage_ModifyUser(t *testing.T) {
+	storage := datasource.NewLocalUserStorage()
+	user := ljlib.User{ID: uuid.New(), Username: "totp", Email: "totp@littlejohn.example", TOTPEnabled: true}
+	require.NoError(t, storage.CreateUser(user))
+
+	//a change made since the user was read is kept
+	require.NoError(t, storage.UseTOTPCounter(user.ID, 1))
+	modified, err := storage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		stored.Email = "totp@example.com"
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "totp@example.com", modified.Email)
+	assert.Equal(t, int64(1), modified.TOTPLastCounter)
+
+	_, err = storage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		stored.Email = "Johndoe@littlejohn.example"
+		return nil
+	})
+	assert.ErrorIs(t, err, ljlib.ConflictError{}, "the email is already registered")
+	_, err = storage.ModifyUser(user.ID, func(stored *ljlib.User) error {
+		stored.Disabled = true
+		return ljlib.NewConflictError("changed my mind")
+	})
+	assert.ErrorIs(t, err, ljlib.ConflictError{})
+	_, err = storage.ModifyUser(uuid.New(), func(stored *ljlib.User) error { return nil })
+	assert.ErrorIs(t, err, ljlib.NotFoundError{})
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "totp@example.com", stored.Email)
+	assert.True(t, stored.IsActive(), "the failed change is not stored")
+}
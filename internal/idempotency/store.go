@@ -0,0 +1,172 @@
+// Package idempotency keeps the first response to each request carrying an idempotency key, so that the retries
+// of the request get that response replayed instead of executing the request again.
+package idempotency
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Outcome int
+
+const (
+	//OutcomeProceed means the key is new, and is now reserved for the request, which has to be executed.
+	OutcomeProceed Outcome = iota
+	//OutcomeReplay means the request was already executed, and its response has to be replayed.
+	OutcomeReplay
+	//OutcomeMismatch means the key was already used with a different request.
+	OutcomeMismatch
+	//OutcomeInProgress means the first request with the key is still being executed.
+	OutcomeInProgress
+)
+
+// Response is the stored response of the first request with the key.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+type entry struct {
+	fingerprint string
+	//response is nil while the first request is in progress
+	response  *Response
+	expiresAt time.Time
+}
+
+// Store keeps the keys for the TTL since the first request. Expired keys are dropped periodically,
+// so memory stays bounded by the number of recently used keys.
+type Store struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func NewStore(ttl time.Duration) (*Store, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("idempotency key TTL must be positive")
+	}
+	return &Store{
+		ttl:     ttl,
+		entries: make(map[string]*entry),
+	}, nil
+}
+
+// Begin looks the key up, and reserves it when it's new. The fingerprint identifies the request,
+// so that reusing the key for a different request can be told apart from a retry.
+func (s *Store) Begin(key string, fingerprint string, now time.Time) (Outcome, *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	if !ok || !now.Before(e.expiresAt) {
+		s.entries[key] = &entry{fingerprint: fingerprint, expiresAt: now.Add(s.ttl)}
+		return OutcomeProceed, nil
+	}
+	if e.fingerprint != fingerprint {
+		return OutcomeMismatch, nil
+	}
+	if e.response == nil {
+		return OutcomeInProgress, nil
+	}
+	return OutcomeReplay, e.response
+}
+
+// Complete stores the response of the request the key was reserved for.
+func (s *Store) Complete(key string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.response = &response
+	}
+}
+
+// Release forgets the key, so that the request can be retried, e.g. after a server error.
+func (s *Store) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Recorder passes the response through to the client, while keeping a copy of it.
+type Recorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	//header is the snapshot of the headers set before the response was passed to the handler
+	header http.Header
+}
+
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{
+		ResponseWriter: w,
+		header:         w.Header().Clone(),
+	}
+}
+
+func (r *Recorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Response returns the recorded response. Only the headers set by the handler are included,
+// as the others, e.g. the rate limit ones, describe the current request rather than the recorded one.
+func (r *Recorder) Response() Response {
+	header := make(http.Header)
+	for name, values := range r.ResponseWriter.Header() {
+		if !equalValues(r.header.Values(name), values) {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+	statusCode := r.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	return Response{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       append([]byte(nil), r.body.Bytes()...),
+	}
+}
+
+func equalValues(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
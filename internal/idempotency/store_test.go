@@ -0,0 +1,73 @@
+package idempotency_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/idempotency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Begin(t *testing.T) {
+	now := time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)
+	store, err := idempotency.NewStore(time.Hour)
+	require.NoError(t, err)
+
+	outcome, _ := store.Begin("user:key", "request", now)
+	assert.Equal(t, idempotency.OutcomeProceed, outcome)
+
+	outcome, _ = store.Begin("user:key", "request", now)
+	assert.Equal(t, idempotency.OutcomeInProgress, outcome, "it should not execute a retry while the first request is in progress")
+
+	store.Complete("user:key", idempotency.Response{StatusCode: http.StatusCreated, Body: []byte(`{"id":"1"}`)})
+
+	outcome, response := store.Begin("user:key", "request", now.Add(time.Minute))
+	require.Equal(t, idempotency.OutcomeReplay, outcome)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, `{"id":"1"}`, string(response.Body))
+
+	outcome, _ = store.Begin("user:key", "other request", now.Add(time.Minute))
+	assert.Equal(t, idempotency.OutcomeMismatch, outcome)
+
+	outcome, _ = store.Begin("other user:key", "other request", now.Add(time.Minute))
+	assert.Equal(t, idempotency.OutcomeProceed, outcome, "keys should be independent of each other")
+
+	outcome, _ = store.Begin("user:key", "other request", now.Add(time.Hour))
+	assert.Equal(t, idempotency.OutcomeProceed, outcome, "it should forget the key once expired")
+}
+
+func TestStore_Release(t *testing.T) {
+	now := time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)
+	store, err := idempotency.NewStore(time.Hour)
+	require.NoError(t, err)
+
+	outcome, _ := store.Begin("user:key", "request", now)
+	require.Equal(t, idempotency.OutcomeProceed, outcome)
+	store.Release("user:key")
+
+	outcome, _ = store.Begin("user:key", "request", now)
+	assert.Equal(t, idempotency.OutcomeProceed, outcome)
+}
+
+func TestRecorder_Response(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("RateLimit-Remaining", "10")
+
+	recorder := idempotency.NewRecorder(w)
+	recorder.Header().Set("Location", "/orders/1")
+	recorder.WriteHeader(http.StatusCreated)
+	_, err := recorder.Write([]byte("created"))
+	require.NoError(t, err)
+
+	response := recorder.Response()
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, "created", string(response.Body))
+	assert.Equal(t, "/orders/1", response.Header.Get("Location"))
+	assert.Empty(t, response.Header.Get("RateLimit-Remaining"), "headers set before the handler should not be recorded")
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "created", w.Body.String())
+}
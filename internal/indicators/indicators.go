@@ -0,0 +1,455 @@
+// Package indicators computes the technical indicators of the daily price history. The history only has
+// the closing prices, so the indicators which need the high and the low of the day, like the ATR, use the closes.
+package indicators
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type Kind string
+
+const (
+	KindSMA       Kind = "sma"
+	KindEMA       Kind = "ema"
+	KindRSI       Kind = "rsi"
+	KindMACD      Kind = "macd"
+	KindBollinger Kind = "bb"
+	KindATR       Kind = "atr"
+)
+
+const (
+	// MaxPeriod is the longest period of an indicator, in days.
+	MaxPeriod = 250
+	// MaxIndicators is the number of the indicators which can be requested at once.
+	MaxIndicators = 10
+	// ValuePlaces is the precision the values are rounded to.
+	ValuePlaces = 4
+	// MaxWarmUp is the most prices, about five years of them, an indicator can be warmed up with. The indicators
+	// which need more to converge, like the RSI of MaxPeriod, are rejected.
+	MaxWarmUp = 1250
+	// convergenceExponent is how far the exponentially smoothed indicators are warmed up: the weight of the prices
+	// before the warm-up is below e^-10.
+	convergenceExponent = 10
+)
+
+// Indicator computes a technical indicator from the closing prices.
+type Indicator interface {
+	// Name is the indicator with all of its parameters, the defaults included, e.g. sma:20.
+	Name() string
+	// WarmUp is the number of the prices before a date which its value depends on.
+	WarmUp() int
+	// Compute returns the values of the indicator for each of the closes, the oldest first. The values which
+	// cannot be computed yet, for lack of the prior prices, are NaN.
+	Compute(closes []float64) []Values
+}
+
+// Values are the components of the indicator's value on a date, e.g. the upper and the lower Bollinger Band.
+type Values map[string]float64
+
+func (v Values) MarshalJSON() ([]byte, error) {
+	formatted := make(map[string]*string, len(v))
+	for name, value := range v {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			formatted[name] = nil
+			continue
+		}
+		s := decimal.NewFromFloat(value).Round(ValuePlaces).String()
+		formatted[name] = &s
+	}
+	return json.Marshal(formatted)
+}
+
+// Parse parses the comma-separated indicators, each with its parameters separated by colons, e.g. sma:20,rsi:14.
+// The parameters which are not set are the usual defaults, e.g. macd is macd:12:26:9.
+func Parse(spec string) ([]Indicator, error) {
+	if len(strings.TrimSpace(spec)) == 0 {
+		return nil, nil
+	}
+	var indicators []Indicator
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		indicator, err := parseIndicator(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if seen[indicator.Name()] {
+			continue
+		}
+		if indicator.WarmUp() > MaxWarmUp {
+			return nil, ljlib.NewIllegalArgumentError("invalid indicator [%s]: it needs %d prices to converge, "+
+				"while at most %d are loaded, so the periods must be shorter", indicator.Name(), indicator.WarmUp(),
+				MaxWarmUp)
+		}
+		seen[indicator.Name()] = true
+		indicators = append(indicators, indicator)
+	}
+	if len(indicators) > MaxIndicators {
+		return nil, ljlib.NewIllegalArgumentError("at most %d indicators can be requested", MaxIndicators)
+	}
+	return indicators, nil
+}
+
+func parseIndicator(spec string) (Indicator, error) {
+	fields := strings.Split(spec, ":")
+	kind, params := Kind(strings.ToLower(fields[0])), fields[1:]
+	switch kind {
+	case KindSMA, KindEMA, KindRSI, KindATR:
+		defaults := map[Kind]int{KindSMA: 20, KindEMA: 20, KindRSI: 14, KindATR: 14}
+		periods, err := parsePeriods(spec, params, []int{defaults[kind]})
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case KindSMA:
+			return sma{period: periods[0]}, nil
+		case KindEMA:
+			return ema{period: periods[0]}, nil
+		case KindRSI:
+			return rsi{period: periods[0]}, nil
+		}
+		return atr{period: periods[0]}, nil
+	case KindMACD:
+		periods, err := parsePeriods(spec, params, []int{12, 26, 9})
+		if err != nil {
+			return nil, err
+		}
+		if periods[0] >= periods[1] {
+			return nil, ljlib.NewIllegalArgumentError("invalid indicator [%s]: the fast period must be shorter than "+
+				"the slow one", spec)
+		}
+		return macd{fast: periods[0], slow: periods[1], signal: periods[2]}, nil
+	case KindBollinger:
+		width := 2.0
+		if len(params) > 1 {
+			var err error
+			width, err = strconv.ParseFloat(params[1], 64)
+			if err != nil || width <= 0 || width > 10 {
+				return nil, ljlib.NewIllegalArgumentError("invalid indicator [%s]: the width must be a number "+
+					"between 0 and 10", spec)
+			}
+			params = params[:1]
+		}
+		periods, err := parsePeriods(spec, params, []int{20})
+		if err != nil {
+			return nil, err
+		}
+		return bollinger{period: periods[0], width: width}, nil
+	}
+	return nil, ljlib.NewIllegalArgumentError("invalid indicator [%s]: must be one of %s, %s, %s, %s, %s and %s",
+		spec, KindSMA, KindEMA, KindRSI, KindMACD, KindBollinger, KindATR)
+}
+
+// parsePeriods parses the periods of the indicator, taking the defaults for the ones which are not set.
+func parsePeriods(spec string, params []string, defaults []int) ([]int, error) {
+	if len(params) > len(defaults) {
+		return nil, ljlib.NewIllegalArgumentError("invalid indicator [%s]: expected at most %d parameters", spec,
+			len(defaults))
+	}
+	periods := append([]int{}, defaults...)
+	for i, param := range params {
+		period, err := strconv.Atoi(param)
+		if err != nil || period < 1 || period > MaxPeriod {
+			return nil, ljlib.NewIllegalArgumentError("invalid indicator [%s]: the periods must be whole numbers "+
+				"between 1 and %d", spec, MaxPeriod)
+		}
+		periods[i] = period
+	}
+	return periods, nil
+}
+
+// WarmUp is the longest warm-up of the indicators.
+func WarmUp(indicators []Indicator) int {
+	var warmUp int
+	for _, indicator := range indicators {
+		if indicator.WarmUp() > warmUp {
+			warmUp = indicator.WarmUp()
+		}
+	}
+	return warmUp
+}
+
+// Point is a price with the values of the indicators on its date, by the indicator name.
+type Point struct {
+	ljlib.HistoricalPrice
+	Indicators map[string]Values
+}
+
+func (p Point) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Date       string            `json:"date"`
+		Price      string            `json:"price"`
+		Indicators map[string]Values `json:"indicators"`
+	}{
+		Date:       p.Date.Format(time.DateOnly),
+		Price:      ljlib.FormatDecimal(p.Price, ljlib.CashPlaces),
+		Indicators: p.Indicators,
+	})
+}
+
+// Apply computes the indicators for the prices, which can be in any order, and returns them in the same order.
+func Apply(prices []ljlib.HistoricalPrice, indicators []Indicator) []Point {
+	if len(prices) == 0 {
+		return []Point{}
+	}
+	order := make([]int, len(prices))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return prices[order[i]].Date.Before(prices[order[j]].Date)
+	})
+	closes := make([]float64, len(prices))
+	for i, index := range order {
+		closes[i] = prices[index].Price.InexactFloat64()
+	}
+
+	points := make([]Point, len(prices))
+	for i, price := range prices {
+		points[i] = Point{HistoricalPrice: price, Indicators: make(map[string]Values, len(indicators))}
+	}
+	for _, indicator := range indicators {
+		values := indicator.Compute(closes)
+		for i, index := range order {
+			points[index].Indicators[indicator.Name()] = values[i]
+		}
+	}
+	return points
+}
+
+type sma struct {
+	period int
+}
+
+func (s sma) Name() string {
+	return name(KindSMA, s.period)
+}
+
+func (s sma) WarmUp() int {
+	return s.period - 1
+}
+
+func (s sma) Compute(closes []float64) []Values {
+	return single(simpleAverage(closes, s.period))
+}
+
+type ema struct {
+	period int
+}
+
+func (e ema) Name() string {
+	return name(KindEMA, e.period)
+}
+
+func (e ema) WarmUp() int {
+	return exponentialWarmUp(e.period, 2/float64(e.period+1))
+}
+
+func (e ema) Compute(closes []float64) []Values {
+	return single(exponentialAverage(closes, e.period, 2/float64(e.period+1)))
+}
+
+// rsi is the relative strength index, with Wilder's smoothing of the gains and the losses.
+type rsi struct {
+	period int
+}
+
+func (r rsi) Name() string {
+	return name(KindRSI, r.period)
+}
+
+func (r rsi) WarmUp() int {
+	return exponentialWarmUp(r.period, 1/float64(r.period)) + 1
+}
+
+func (r rsi) Compute(closes []float64) []Values {
+	gains, losses := make([]float64, len(closes)), make([]float64, len(closes))
+	gains[0], losses[0] = math.NaN(), math.NaN()
+	for i := 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		gains[i], losses[i] = math.Max(change, 0), math.Max(-change, 0)
+	}
+	alpha := 1 / float64(r.period)
+	averageGains := exponentialAverage(gains, r.period, alpha)
+	averageLosses := exponentialAverage(losses, r.period, alpha)
+
+	values := make([]float64, len(closes))
+	for i := range closes {
+		switch {
+		case math.IsNaN(averageGains[i]):
+			values[i] = math.NaN()
+		case averageLosses[i] == 0 && averageGains[i] == 0:
+			values[i] = 50
+		case averageLosses[i] == 0:
+			values[i] = 100
+		default:
+			values[i] = 100 - 100/(1+averageGains[i]/averageLosses[i])
+		}
+	}
+	return single(values)
+}
+
+// macd is the difference between the fast and the slow EMA, with the EMA of the difference as its signal line.
+type macd struct {
+	fast   int
+	slow   int
+	signal int
+}
+
+func (m macd) Name() string {
+	return name(KindMACD, m.fast, m.slow, m.signal)
+}
+
+// WarmUp of the MACD chains the warm-ups of its slow EMA and of the signal line, which smooths the difference
+// only once the slow EMA has converged.
+func (m macd) WarmUp() int {
+	return exponentialWarmUp(m.slow, 2/float64(m.slow+1)) + exponentialWarmUp(m.signal, 2/float64(m.signal+1))
+}
+
+func (m macd) Compute(closes []float64) []Values {
+	fast := exponentialAverage(closes, m.fast, 2/float64(m.fast+1))
+	slow := exponentialAverage(closes, m.slow, 2/float64(m.slow+1))
+	line := make([]float64, len(closes))
+	for i := range closes {
+		line[i] = fast[i] - slow[i]
+	}
+	signal := exponentialAverage(line, m.signal, 2/float64(m.signal+1))
+
+	values := make([]Values, len(closes))
+	for i := range closes {
+		values[i] = Values{"macd": line[i], "signal": signal[i], "histogram": line[i] - signal[i]}
+	}
+	return values
+}
+
+// bollinger is the SMA with the bands the width of the standard deviations above and below it.
+type bollinger struct {
+	period int
+	width  float64
+}
+
+func (b bollinger) Name() string {
+	return string(KindBollinger) + ":" + strconv.Itoa(b.period) + ":" + strconv.FormatFloat(b.width, 'f', -1, 64)
+}
+
+func (b bollinger) WarmUp() int {
+	return b.period - 1
+}
+
+func (b bollinger) Compute(closes []float64) []Values {
+	middle := simpleAverage(closes, b.period)
+	values := make([]Values, len(closes))
+	for i := range closes {
+		deviation := math.NaN()
+		if !math.IsNaN(middle[i]) {
+			var squares float64
+			for _, value := range closes[i-b.period+1 : i+1] {
+				squares += (value - middle[i]) * (value - middle[i])
+			}
+			deviation = math.Sqrt(squares / float64(b.period))
+		}
+		values[i] = Values{
+			"middle": middle[i],
+			"upper":  middle[i] + b.width*deviation,
+			"lower":  middle[i] - b.width*deviation,
+		}
+	}
+	return values
+}
+
+// atr is the average true range, with Wilder's smoothing. Without the highs and the lows, the true range
+// is the change of the close from the day before.
+type atr struct {
+	period int
+}
+
+func (a atr) Name() string {
+	return name(KindATR, a.period)
+}
+
+func (a atr) WarmUp() int {
+	return exponentialWarmUp(a.period, 1/float64(a.period)) + 1
+}
+
+func (a atr) Compute(closes []float64) []Values {
+	ranges := make([]float64, len(closes))
+	ranges[0] = math.NaN()
+	for i := 1; i < len(closes); i++ {
+		ranges[i] = math.Abs(closes[i] - closes[i-1])
+	}
+	return single(exponentialAverage(ranges, a.period, 1/float64(a.period)))
+}
+
+// simpleAverage is the average of the period's values ending with each of them.
+func simpleAverage(values []float64, period int) []float64 {
+	averages := make([]float64, len(values))
+	var sum float64
+	for i, value := range values {
+		sum += value
+		if i >= period {
+			sum -= values[i-period]
+		}
+		averages[i] = math.NaN()
+		if i >= period-1 {
+			averages[i] = sum / float64(period)
+		}
+	}
+	return averages
+}
+
+// exponentialAverage smooths the values by the alpha, starting from the simple average of the first period
+// of them. The leading NaN values, which the series isn't available for yet, are skipped.
+func exponentialAverage(values []float64, period int, alpha float64) []float64 {
+	averages := make([]float64, len(values))
+	start := 0
+	for start < len(values) && math.IsNaN(values[start]) {
+		averages[start] = math.NaN()
+		start++
+	}
+	var sum float64
+	for i := start; i < len(values); i++ {
+		switch n := i - start + 1; {
+		case n < period:
+			sum += values[i]
+			averages[i] = math.NaN()
+		case n == period:
+			averages[i] = (sum + values[i]) / float64(period)
+		default:
+			averages[i] = alpha*values[i] + (1-alpha)*averages[i-1]
+		}
+	}
+	return averages
+}
+
+// exponentialWarmUp is the number of the values before the exponential average of the period converges:
+// the period of its simple average seed, and then as many values as it takes the smoothing by the alpha
+// to bring the weight of the seed below e^-convergenceExponent.
+func exponentialWarmUp(period int, alpha float64) int {
+	if alpha >= 1 {
+		return period - 1
+	}
+	return period - 1 + int(math.Ceil(convergenceExponent/-math.Log(1-alpha)))
+}
+
+func single(values []float64) []Values {
+	result := make([]Values, len(values))
+	for i, value := range values {
+		result[i] = Values{"value": value}
+	}
+	return result
+}
+
+func name(kind Kind, periods ...int) string {
+	formatted := []string{string(kind)}
+	for _, period := range periods {
+		formatted = append(formatted, strconv.Itoa(period))
+	}
+	return strings.Join(formatted, ":")
+}
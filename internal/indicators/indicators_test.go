@@ -0,0 +1,227 @@
+package indicators_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/indicators"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testStart = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	var tooMany []string
+	for i := 1; i <= indicators.MaxIndicators+1; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("sma:%d", i))
+	}
+	testCases := map[string]struct {
+		spec          string
+		expectedNames []string
+		expectedError bool
+	}{
+		"it should parse the indicators with their periods": {
+			spec:          "sma:20,rsi:14",
+			expectedNames: []string{"sma:20", "rsi:14"},
+		},
+		"it should take the default parameters": {
+			spec:          "macd, BB,ema,atr",
+			expectedNames: []string{"macd:12:26:9", "bb:20:2", "ema:20", "atr:14"},
+		},
+		"it should parse the width of the Bollinger Bands": {
+			spec:          "bb:10:2.5",
+			expectedNames: []string{"bb:10:2.5"},
+		},
+		"it should skip the duplicates": {
+			spec:          "sma,sma:20",
+			expectedNames: []string{"sma:20"},
+		},
+		"it should return no indicators when none are requested": {
+			spec: "",
+		},
+		"it should reject an unknown indicator": {
+			spec:          "vwap:20",
+			expectedError: true,
+		},
+		"it should reject a period which is not positive": {
+			spec:          "sma:0",
+			expectedError: true,
+		},
+		"it should reject a period which is too long": {
+			spec:          "ema:251",
+			expectedError: true,
+		},
+		"it should reject an indicator which cannot converge within the longest warm-up": {
+			spec:          "rsi:250",
+			expectedError: true,
+		},
+		"it should reject a period which is not a number": {
+			spec:          "rsi:abc",
+			expectedError: true,
+		},
+		"it should reject too many parameters": {
+			spec:          "sma:20:30",
+			expectedError: true,
+		},
+		"it should reject a fast period which is not shorter than the slow one": {
+			spec:          "macd:26:12:9",
+			expectedError: true,
+		},
+		"it should reject a negative width": {
+			spec:          "bb:20:-1",
+			expectedError: true,
+		},
+		"it should reject too many indicators": {
+			spec:          strings.Join(tooMany, ","),
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			parsed, err := indicators.Parse(testCase.spec)
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, indicator := range parsed {
+				names = append(names, indicator.Name())
+			}
+			assert.Equal(t, testCase.expectedNames, names)
+		})
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	closes := []float64{10, 11, 12, 11, 13, 14, 13, 15}
+	nan := math.NaN()
+	testCases := map[string]struct {
+		spec     string
+		expected map[string][]float64
+	}{
+		"it should compute the simple moving average": {
+			spec:     "sma:3",
+			expected: map[string][]float64{"value": {nan, nan, 11, 11.333333, 12, 12.666667, 13.333333, 14}},
+		},
+		"it should compute the exponential moving average from the simple one": {
+			spec:     "ema:3",
+			expected: map[string][]float64{"value": {nan, nan, 11, 11, 12, 13, 13, 14}},
+		},
+		"it should compute the relative strength index with Wilder's smoothing": {
+			spec: "rsi:3",
+			//the average gain of 2/3 and loss of 1/3, then of 10/9 and 2/9
+			expected: map[string][]float64{"value": {nan, nan, nan, 66.666667, 83.333333, 87.878788, 62.365591,
+				79.885057}},
+		},
+		"it should compute the average true range from the closes": {
+			spec:     "atr:3",
+			expected: map[string][]float64{"value": {nan, nan, nan, 1, 1.333333, 1.222222, 1.148148, 1.432099}},
+		},
+		"it should compute the Bollinger Bands from the population deviation": {
+			spec: "bb:3:2",
+			expected: map[string][]float64{
+				"middle": {nan, nan, 11, 11.333333, 12, 12.666667, 13.333333, 14},
+				"upper":  {nan, nan, 12.632993, 12.276142, 13.632993, 15.161105, 14.276142, 15.632993},
+				"lower":  {nan, nan, 9.367007, 10.390524, 10.367007, 10.172228, 12.390524, 12.367007},
+			},
+		},
+		"it should compute the MACD with its signal line": {
+			spec: "macd:2:3:2",
+			expected: map[string][]float64{
+				"macd":      {nan, nan, 0.5, 0.166667, 0.388889, 0.462963, 0.154321, 0.384774},
+				"signal":    {nan, nan, nan, 0.333333, 0.37037, 0.432099, 0.246914, 0.33882},
+				"histogram": {nan, nan, nan, -0.166667, 0.018519, 0.030864, -0.092593, 0.045953},
+			},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			parsed, err := indicators.Parse(testCase.spec)
+			require.NoError(t, err)
+			require.Len(t, parsed, 1)
+
+			values := parsed[0].Compute(closes)
+			require.Len(t, values, len(closes))
+			for component, expected := range testCase.expected {
+				for i := range closes {
+					actual := values[i][component]
+					if math.IsNaN(expected[i]) {
+						assert.True(t, math.IsNaN(actual), "%s on day %d", component, i)
+						continue
+					}
+					assert.InDelta(t, expected[i], actual, 1e-6, "%s on day %d", component, i)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	testCases := map[string]struct {
+		spec           string
+		expectedWarmUp int
+	}{
+		"it should warm up the SMA with the period": {
+			spec:           "sma:20",
+			expectedWarmUp: 19,
+		},
+		"it should warm up the EMA until the weight of the prices before it is below e^-10": {
+			spec:           "ema:20",
+			expectedWarmUp: 119,
+		},
+		"it should warm up the RSI with Wilder's slower smoothing and the change from the day before": {
+			spec:           "rsi:14",
+			expectedWarmUp: 149,
+		},
+		"it should chain the warm-ups of the slow EMA and the signal line of the MACD": {
+			spec:           "macd",
+			expectedWarmUp: 208,
+		},
+		"it should return the longest warm-up of the indicators": {
+			spec:           "sma:20,macd,rsi:14",
+			expectedWarmUp: 208,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			parsed, err := indicators.Parse(testCase.spec)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedWarmUp, indicators.WarmUp(parsed))
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	var prices []ljlib.HistoricalPrice
+	//the newest first, like the price history
+	for i := 4; i >= 0; i-- {
+		prices = append(prices, ljlib.HistoricalPrice{
+			Date:  testStart.AddDate(0, 0, i),
+			Price: decimal.NewFromInt(int64(10 + i)),
+		})
+	}
+	parsed, err := indicators.Parse("sma:2,sma:4")
+	require.NoError(t, err)
+
+	points := indicators.Apply(prices, parsed)
+
+	require.Len(t, points, len(prices))
+	for i, point := range points {
+		assert.Equal(t, prices[i], point.HistoricalPrice)
+	}
+	assert.Equal(t, 13.5, points[0].Indicators["sma:2"]["value"])
+	assert.Equal(t, 12.5, points[0].Indicators["sma:4"]["value"])
+
+	encoded, err := json.Marshal(points[2])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"date": "2023-01-03", "price": "12.00",
+		"indicators": {"sma:2": {"value": "11.5"}, "sma:4": {"value": null}}}`, string(encoded))
+}
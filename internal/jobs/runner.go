@@ -0,0 +1,102 @@
+// Package jobs runs the background jobs of the service periodically, e.g. placing the orders of the investment plans.
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/clock"
+)
+
+// Job is run with the current time of the runner's clock. Errors are logged, and the job is run again
+// at its next interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(now time.Time) error
+}
+
+// Runner runs every job in its own goroutine, so a slow job never delays the others.
+// A job is never run concurrently with itself.
+type Runner struct {
+	clock clock.Clock
+	jobs  []Job
+
+	mu      sync.Mutex
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func NewRunner(clock clock.Clock) *Runner {
+	return &Runner{
+		clock: clock,
+	}
+}
+
+// Register adds the job, to be run once the runner is started.
+func (r *Runner) Register(job Job) error {
+	if job.Interval <= 0 {
+		return fmt.Errorf("interval of job [%s] must be positive", job.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return fmt.Errorf("job [%s] cannot be registered, as the runner is already started", job.Name)
+	}
+	r.jobs = append(r.jobs, job)
+	return nil
+}
+
+// Start runs every job right away, and then at every interval, until the runner is stopped.
+func (r *Runner) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	for _, job := range r.jobs {
+		r.stopped.Add(1)
+		go r.loop(job, r.stop)
+	}
+}
+
+// Stop waits for the running jobs to finish.
+func (r *Runner) Stop() {
+	r.mu.Lock()
+	stop := r.stop
+	r.stop = nil
+	r.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	r.stopped.Wait()
+}
+
+func (r *Runner) loop(job Job, stop <-chan struct{}) {
+	defer r.stopped.Done()
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		r.run(job)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) run(job Job) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Job [%s] panicked: %v", job.Name, recovered)
+		}
+	}()
+	if err := job.Run(r.clock.Now()); err != nil {
+		log.Printf("Job [%s] failed: %s", job.Name, err)
+	}
+}
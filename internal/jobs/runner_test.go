@@ -0,0 +1,42 @@
+package jobs_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/jobs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner(t *testing.T) {
+	now := time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)
+	runner := jobs.NewRunner(clock.NewVirtual(now))
+
+	var runs, failures atomic.Int32
+	var runAt atomic.Value
+	require.NoError(t, runner.Register(jobs.Job{Name: "counter", Interval: time.Millisecond, Run: func(at time.Time) error {
+		runAt.Store(at)
+		runs.Add(1)
+		return nil
+	}}))
+	require.NoError(t, runner.Register(jobs.Job{Name: "failing", Interval: time.Millisecond, Run: func(at time.Time) error {
+		failures.Add(1)
+		return errors.New("failure")
+	}}))
+	assert.Error(t, runner.Register(jobs.Job{Name: "invalid", Run: func(at time.Time) error { return nil }}))
+
+	runner.Start()
+	assert.Eventually(t, func() bool {
+		return runs.Load() >= 3 && failures.Load() >= 3
+	}, time.Second, time.Millisecond, "it should keep running the jobs, including the failing ones")
+	runner.Stop()
+
+	stoppedAt := runs.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stoppedAt, runs.Load(), "it should not run the jobs once stopped")
+	assert.Equal(t, now, runAt.Load(), "it should run the jobs with the time of the runner's clock")
+}
@@ -0,0 +1,249 @@
+// Package margin runs the margin accounting of the short positions: the daily borrow fees, and the margin calls
+// of the accounts whose equity falls below the maintenance margin.
+package margin
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// borrowFeeDays is the day count convention of the borrow fees: the annual rate is charged in 1/360 a day.
+var borrowFeeDays = decimal.NewFromInt(360)
+
+type Ledger interface {
+	GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error)
+	PostTransaction(tx ljlib.Transaction) error
+}
+
+type UserSource interface {
+	ListUsers() ([]ljlib.User, error)
+}
+
+// AccountSource computes the equity and the margin requirements of the account.
+type AccountSource interface {
+	GetAccount(userID uuid.UUID, at time.Time) (risk.Account, error)
+}
+
+type PriceSource interface {
+	GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error)
+}
+
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+}
+
+type MarginCallStorage interface {
+	CreateMarginCall(call ljlib.MarginCall) error
+	UpdateMarginCall(call ljlib.MarginCall) error
+	ListMarginCalls(userID uuid.UUID) ([]ljlib.MarginCall, error)
+}
+
+type Notifier interface {
+	CreateNotification(notification ljlib.Notification) error
+}
+
+// Service serializes the margin runs, so that neither a fee is charged nor a margin call is issued twice.
+type Service struct {
+	mu          sync.Mutex
+	ledger      Ledger
+	users       UserSource
+	accounts    AccountSource
+	prices      PriceSource
+	instruments InstrumentSource
+	calls       MarginCallStorage
+	notifier    Notifier
+	clock       clock.Clock
+}
+
+func NewService(ledger Ledger, users UserSource, accounts AccountSource, prices PriceSource,
+	instruments InstrumentSource, calls MarginCallStorage, notifier Notifier, clock clock.Clock) *Service {
+	return &Service{
+		ledger:      ledger,
+		users:       users,
+		accounts:    accounts,
+		prices:      prices,
+		instruments: instruments,
+		calls:       calls,
+		notifier:    notifier,
+		clock:       clock,
+	}
+}
+
+// Run charges the borrow fees of the days which are over, and issues or resolves the margin calls of all users.
+func (s *Service) Run(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.users.ListUsers()
+	if err != nil {
+		return fmt.Errorf("cannot list users: %w", err)
+	}
+	for _, user := range users {
+		if err := s.chargeBorrowFees(user.ID, now); err != nil {
+			log.Printf("Cannot charge borrow fees to user [%s]: %s", user.ID, err)
+		}
+		if err := s.checkMargin(user.ID, now); err != nil {
+			log.Printf("Cannot check margin of user [%s]: %s", user.ID, err)
+		}
+	}
+	return nil
+}
+
+// ListMarginCalls returns the user's margin calls, the most recent first.
+func (s *Service) ListMarginCalls(userID uuid.UUID) ([]ljlib.MarginCall, error) {
+	return s.calls.ListMarginCalls(userID)
+}
+
+// chargeBorrowFees replays the positions day by day, and charges the fee of every short position held at the end
+// of a day which is over, valued at the price of that day. Fees are identified by the user, the ticker and the day,
+// so the days which were already charged are skipped.
+func (s *Service) chargeBorrowFees(userID uuid.UUID, now time.Time) error {
+	transactions, err := s.ledger.GetTransactions(userID)
+	if err != nil {
+		return fmt.Errorf("cannot get transactions: %w", err)
+	}
+	var trades []ljlib.Transaction
+	charged := make(map[uuid.UUID]bool)
+	for _, tx := range transactions {
+		switch {
+		case tx.IsTrade():
+			trades = append(trades, tx)
+		case tx.Type == ljlib.TransactionTypeBorrowFee:
+			charged[tx.ID] = true
+		}
+	}
+	if len(trades) == 0 {
+		return nil
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		return trades[i].ExecutedAt.Before(trades[j].ExecutedAt)
+	})
+
+	quantities := make(map[string]decimal.Decimal)
+	instruments := make(map[string]ljlib.Instrument)
+	next := 0
+	for day := startOfDay(trades[0].ExecutedAt); day.Before(startOfDay(now)); day = day.AddDate(0, 0, 1) {
+		endOfDay := day.AddDate(0, 0, 1)
+		for ; next < len(trades) && trades[next].ExecutedAt.Before(endOfDay); next++ {
+			quantities[trades[next].Ticker] = quantities[trades[next].Ticker].Add(trades[next].SignedQuantity())
+		}
+		for ticker, quantity := range quantities {
+			if !quantity.IsNegative() {
+				continue
+			}
+			feeID := uuid.NewSHA1(userID, []byte(fmt.Sprintf("%s:%s:%s", ljlib.TransactionTypeBorrowFee, ticker,
+				day.Format(time.DateOnly))))
+			if charged[feeID] {
+				continue
+			}
+			instrument, ok := instruments[ticker]
+			if !ok {
+				instrument, err = s.instruments.GetInstrument(ticker)
+				if err != nil {
+					return fmt.Errorf("cannot get instrument [%s]: %w", ticker, err)
+				}
+				instruments[ticker] = instrument
+			}
+			price, err := s.prices.GetPriceAt(ticker, day)
+			if err != nil {
+				return fmt.Errorf("cannot get price for ticker [%s]: %w", ticker, err)
+			}
+			//fractions of a cent are rounded up, in favour of the broker
+			fee := quantity.Abs().Mul(price).Mul(ljlib.ContractMultiplier(ticker)).Mul(instrument.BorrowRate).
+				Div(borrowFeeDays).RoundCeil(ljlib.CashPlaces)
+			if !fee.IsPositive() {
+				continue
+			}
+			err = s.ledger.PostTransaction(ljlib.Transaction{
+				ID:         feeID,
+				UserID:     userID,
+				Type:       ljlib.TransactionTypeBorrowFee,
+				Ticker:     ticker,
+				Amount:     fee,
+				Currency:   instrument.Currency,
+				ExecutedAt: endOfDay,
+			})
+			if err != nil {
+				return fmt.Errorf("cannot post borrow fee: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkMargin issues a margin call when the equity is below the maintenance margin, and there's no open call,
+// or resolves the open call once the equity is back above it. The user is notified in both cases.
+func (s *Service) checkMargin(userID uuid.UUID, now time.Time) error {
+	account, err := s.accounts.GetAccount(userID, now)
+	if err != nil {
+		return fmt.Errorf("cannot get account: %w", err)
+	}
+	calls, err := s.calls.ListMarginCalls(userID)
+	if err != nil {
+		return fmt.Errorf("cannot list margin calls: %w", err)
+	}
+	var open *ljlib.MarginCall
+	for i := range calls {
+		if calls[i].IsOpen() {
+			open = &calls[i]
+			break
+		}
+	}
+
+	switch {
+	case account.MarginCall && open == nil:
+		call := ljlib.MarginCall{
+			ID:                uuid.New(),
+			UserID:            userID,
+			Deficit:           account.MarginExcess.Neg().RoundCeil(ljlib.CashPlaces),
+			Equity:            account.Equity,
+			MaintenanceMargin: account.MaintenanceMargin,
+			IssuedAt:          now,
+		}
+		if err := s.calls.CreateMarginCall(call); err != nil {
+			return fmt.Errorf("cannot store margin call: %w", err)
+		}
+		return s.notify(userID, ljlib.NotificationMarginCall, now,
+			"Margin call: the equity of %s is below the maintenance margin of %s. Deposit %s or cover "+
+				"the short positions.", account.Equity.StringFixed(ljlib.CashPlaces),
+			account.MaintenanceMargin.StringFixed(ljlib.CashPlaces), call.Deficit.StringFixed(ljlib.CashPlaces))
+	case !account.MarginCall && open != nil:
+		open.ResolvedAt = now
+		if err := s.calls.UpdateMarginCall(*open); err != nil {
+			return fmt.Errorf("cannot store margin call: %w", err)
+		}
+		return s.notify(userID, ljlib.NotificationMarginCallResolved, now,
+			"The margin call of %s is resolved: the equity is back above the maintenance margin.",
+			open.IssuedAt.Format(time.DateOnly))
+	}
+	return nil
+}
+
+func (s *Service) notify(userID uuid.UUID, notificationType ljlib.NotificationType, now time.Time,
+	message string, a ...interface{}) error {
+	err := s.notifier.CreateNotification(ljlib.Notification{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Type:      notificationType,
+		Message:   fmt.Sprintf(message, a...),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot notify user: %w", err)
+	}
+	return nil
+}
+
+func startOfDay(at time.Time) time.Time {
+	year, month, day := at.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,174 @@
+package margin_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/margin"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+
+func TestService_Run_BorrowFees(t *testing.T) {
+	ledger := &mockLedger{transactions: []ljlib.Transaction{
+		trade(ljlib.TransactionTypeBuy, "AAPL", 10, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)),
+		trade(ljlib.TransactionTypeSell, "TSLA", 100, time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)),
+		trade(ljlib.TransactionTypeBuy, "TSLA", 100, time.Date(2023, 1, 4, 10, 0, 0, 0, time.UTC)),
+	}}
+	now := time.Date(2023, 1, 6, 12, 0, 0, 0, time.UTC)
+	service := newTestService(ledger, &mockAccountSource{}, &mockStorage{}, now)
+
+	require.NoError(t, service.Run(now))
+	require.NoError(t, service.Run(now))
+
+	//the short position was held at the end of Jan 2 and Jan 3
+	require.Len(t, ledger.transactions, 5)
+	for i, expectedAt := range []time.Time{
+		time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 1, 4, 0, 0, 0, 0, time.UTC),
+	} {
+		fee := ledger.transactions[3+i]
+		assert.Equal(t, ljlib.TransactionTypeBorrowFee, fee.Type)
+		assert.Equal(t, "TSLA", fee.Ticker)
+		//100 shares * 100 * 3.6% / 360
+		assert.Equal(t, "1", fee.Amount.String())
+		assert.Equal(t, expectedAt, fee.ExecutedAt)
+	}
+}
+
+func TestService_Run_MarginCalls(t *testing.T) {
+	now := time.Date(2023, 1, 6, 12, 0, 0, 0, time.UTC)
+	accounts := &mockAccountSource{account: risk.Account{
+		Equity:            decimal.NewFromInt(399),
+		MaintenanceMargin: decimal.NewFromInt(480),
+		MarginExcess:      decimal.NewFromInt(-81),
+		MarginCall:        true,
+	}}
+	storage := &mockStorage{}
+	service := newTestService(&mockLedger{}, accounts, storage, now)
+
+	require.NoError(t, service.Run(now))
+	require.NoError(t, service.Run(now.Add(time.Hour)))
+
+	calls, err := service.ListMarginCalls(testUserID)
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	assert.True(t, calls[0].IsOpen())
+	assert.Equal(t, "81", calls[0].Deficit.String())
+	require.Len(t, storage.notifications, 1)
+	assert.Equal(t, ljlib.NotificationMarginCall, storage.notifications[0].Type)
+
+	accounts.account = risk.Account{Equity: decimal.NewFromInt(1000), MarginExcess: decimal.NewFromInt(520)}
+	resolvedAt := now.Add(2 * time.Hour)
+	require.NoError(t, service.Run(resolvedAt))
+
+	calls, err = service.ListMarginCalls(testUserID)
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	assert.False(t, calls[0].IsOpen())
+	assert.Equal(t, resolvedAt, calls[0].ResolvedAt)
+	require.Len(t, storage.notifications, 2)
+	assert.Equal(t, ljlib.NotificationMarginCallResolved, storage.notifications[1].Type)
+}
+
+func newTestService(ledger *mockLedger, accounts *mockAccountSource, storage *mockStorage,
+	now time.Time) *margin.Service {
+	return margin.NewService(ledger, mockUserSource{}, accounts, mockPriceSource{}, mockInstrumentSource{}, storage,
+		storage, clock.NewVirtual(now))
+}
+
+func trade(txType ljlib.TransactionType, ticker string, quantity int64, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Type:       txType,
+		Ticker:     ticker,
+		Quantity:   decimal.NewFromInt(quantity),
+		Price:      decimal.NewFromInt(100),
+		ExecutedAt: at,
+	}
+}
+
+type mockLedger struct {
+	mu           sync.Mutex
+	transactions []ljlib.Transaction
+}
+
+func (m *mockLedger) GetTransactions(uuid.UUID) ([]ljlib.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]ljlib.Transaction, len(m.transactions))
+	copy(result, m.transactions)
+	return result, nil
+}
+
+func (m *mockLedger) PostTransaction(tx ljlib.Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transactions = append(m.transactions, tx)
+	return nil
+}
+
+type mockUserSource struct{}
+
+func (mockUserSource) ListUsers() ([]ljlib.User, error) {
+	return []ljlib.User{{ID: testUserID}}, nil
+}
+
+type mockAccountSource struct {
+	account risk.Account
+}
+
+func (m *mockAccountSource) GetAccount(uuid.UUID, time.Time) (risk.Account, error) {
+	return m.account, nil
+}
+
+type mockPriceSource struct{}
+
+func (mockPriceSource) GetPriceAt(string, time.Time) (decimal.Decimal, error) {
+	return decimal.NewFromInt(100), nil
+}
+
+type mockInstrumentSource struct{}
+
+func (mockInstrumentSource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	return ljlib.Instrument{Ticker: ticker, Currency: ljlib.BaseCurrency,
+		BorrowRate: decimal.RequireFromString("0.036")}, nil
+}
+
+type mockStorage struct {
+	calls         []ljlib.MarginCall
+	notifications []ljlib.Notification
+}
+
+func (m *mockStorage) CreateMarginCall(call ljlib.MarginCall) error {
+	m.calls = append([]ljlib.MarginCall{call}, m.calls...)
+	return nil
+}
+
+func (m *mockStorage) UpdateMarginCall(call ljlib.MarginCall) error {
+	for i := range m.calls {
+		if m.calls[i].ID == call.ID {
+			m.calls[i] = call
+			return nil
+		}
+	}
+	return ljlib.NewNotFoundError("margin call not found: %s", call.ID)
+}
+
+func (m *mockStorage) ListMarginCalls(uuid.UUID) ([]ljlib.MarginCall, error) {
+	return append([]ljlib.MarginCall{}, m.calls...), nil
+}
+
+func (m *mockStorage) CreateNotification(notification ljlib.Notification) error {
+	m.notifications = append(m.notifications, notification)
+	return nil
+}
@@ -0,0 +1,353 @@
+// Package plans implements the recurring investment plans: a fixed amount invested into a ticker on a schedule,
+// with the orders placed by the job runner as the plans fall due.
+package plans
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// MinAmount is the smallest amount a plan can invest per run.
+var MinAmount = decimal.NewFromInt(1)
+
+type PlanStorage interface {
+	CreatePlan(plan ljlib.Plan) error
+	UpdatePlan(plan ljlib.Plan) error
+	DeletePlan(planID uuid.UUID) error
+	GetPlan(planID uuid.UUID) (*ljlib.Plan, error)
+	ListPlans(userID uuid.UUID) ([]ljlib.Plan, error)
+	ListActivePlans() ([]ljlib.Plan, error)
+}
+
+type PriceSource interface {
+	GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error)
+}
+
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+}
+
+type OrderPlacer interface {
+	PlaceOrder(userID uuid.UUID, request trading.PlaceOrderRequest) (ljlib.Order, error)
+}
+
+type CreatePlanRequest struct {
+	Ticker   string
+	Amount   decimal.Decimal
+	Schedule string
+}
+
+// UpdatePlanRequest changes the amount or the schedule of the plan, the zero values are left unchanged.
+type UpdatePlanRequest struct {
+	Amount   decimal.Decimal
+	Schedule string
+}
+
+// Service serializes all plan mutations, so that a plan is never run twice for the same schedule.
+type Service struct {
+	mu          sync.Mutex
+	plans       PlanStorage
+	prices      PriceSource
+	instruments InstrumentSource
+	orders      OrderPlacer
+	clock       clock.Clock
+}
+
+func NewService(plans PlanStorage, prices PriceSource, instruments InstrumentSource, orders OrderPlacer,
+	clock clock.Clock) *Service {
+	return &Service{
+		plans:       plans,
+		prices:      prices,
+		instruments: instruments,
+		orders:      orders,
+		clock:       clock,
+	}
+}
+
+func (s *Service) CreatePlan(userID uuid.UUID, request CreatePlanRequest) (ljlib.Plan, error) {
+	request.Ticker = strings.ToUpper(strings.TrimSpace(request.Ticker))
+	request.Schedule = strings.TrimSpace(request.Schedule)
+	if len(request.Ticker) == 0 {
+		return ljlib.Plan{}, ljlib.NewIllegalArgumentError("ticker is required")
+	}
+	if err := validateAmount(request.Amount); err != nil {
+		return ljlib.Plan{}, err
+	}
+	now := s.clock.Now()
+	if _, err := s.prices.GetPriceAt(request.Ticker, now); err != nil {
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			return ljlib.Plan{}, ljlib.NewIllegalArgumentError("unknown ticker [%s]", request.Ticker)
+		}
+		return ljlib.Plan{}, fmt.Errorf("cannot get price for ticker [%s]: %w", request.Ticker, err)
+	}
+	nextRunAt, err := nextRun(request.Schedule, now)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+
+	plan := ljlib.Plan{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Ticker:    request.Ticker,
+		Amount:    request.Amount,
+		Schedule:  request.Schedule,
+		Status:    ljlib.PlanStatusActive,
+		NextRunAt: nextRunAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.plans.CreatePlan(plan); err != nil {
+		return ljlib.Plan{}, fmt.Errorf("cannot store plan: %w", err)
+	}
+	return plan, nil
+}
+
+func (s *Service) GetPlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error) {
+	return s.getUserPlan(userID, planID)
+}
+
+// ListPlans returns the user's plans, the most recent first.
+func (s *Service) ListPlans(userID uuid.UUID) ([]ljlib.Plan, error) {
+	return s.plans.ListPlans(userID)
+}
+
+func (s *Service) UpdatePlan(userID uuid.UUID, planID uuid.UUID, request UpdatePlanRequest) (ljlib.Plan, error) {
+	request.Schedule = strings.TrimSpace(request.Schedule)
+	if request.Amount.IsZero() && len(request.Schedule) == 0 {
+		return ljlib.Plan{}, ljlib.NewIllegalArgumentError("update must change amount or schedule")
+	}
+	if !request.Amount.IsZero() {
+		if err := validateAmount(request.Amount); err != nil {
+			return ljlib.Plan{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, err := s.getUserPlan(userID, planID)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	now := s.clock.Now()
+	if !request.Amount.IsZero() {
+		plan.Amount = request.Amount
+	}
+	if len(request.Schedule) > 0 {
+		nextRunAt, err := nextRun(request.Schedule, now)
+		if err != nil {
+			return ljlib.Plan{}, err
+		}
+		plan.Schedule = request.Schedule
+		if plan.Status == ljlib.PlanStatusActive {
+			plan.NextRunAt = nextRunAt
+		}
+	}
+	plan.UpdatedAt = now
+	return plan, s.store(plan)
+}
+
+func (s *Service) DeletePlan(userID uuid.UUID, planID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getUserPlan(userID, planID); err != nil {
+		return err
+	}
+	return s.plans.DeletePlan(planID)
+}
+
+// PausePlan stops the plan from running until it's resumed. The runs scheduled in the meantime are not made up for.
+func (s *Service) PausePlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, err := s.getUserPlan(userID, planID)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	if plan.Status == ljlib.PlanStatusPaused {
+		return ljlib.Plan{}, ljlib.NewConflictError("plan [%s] is already paused", plan.ID)
+	}
+	plan.Status = ljlib.PlanStatusPaused
+	plan.NextRunAt = time.Time{}
+	plan.UpdatedAt = s.clock.Now()
+	return plan, s.store(plan)
+}
+
+// ResumePlan reactivates the plan, which next runs at its first scheduled time from now.
+func (s *Service) ResumePlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, err := s.getUserPlan(userID, planID)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	if plan.Status == ljlib.PlanStatusActive {
+		return ljlib.Plan{}, ljlib.NewConflictError("plan [%s] is not paused", plan.ID)
+	}
+	now := s.clock.Now()
+	plan.NextRunAt, err = nextRun(plan.Schedule, now)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	plan.Status = ljlib.PlanStatusActive
+	plan.UpdatedAt = now
+	return plan, s.store(plan)
+}
+
+// SkipNextRun records the next scheduled run as skipped, and moves the plan on to the run after it.
+func (s *Service) SkipNextRun(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, err := s.getUserPlan(userID, planID)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	if plan.Status != ljlib.PlanStatusActive {
+		return ljlib.Plan{}, ljlib.NewConflictError("plan [%s] is paused, so it has no run to skip", plan.ID)
+	}
+	now := s.clock.Now()
+	plan.Executions = append(plan.Executions, ljlib.PlanExecution{
+		ScheduledAt: plan.NextRunAt,
+		ExecutedAt:  now,
+		Status:      ljlib.PlanExecutionSkipped,
+		Message:     "skipped by the user",
+	})
+	plan.NextRunAt, err = nextRun(plan.Schedule, plan.NextRunAt)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	plan.UpdatedAt = now
+	return plan, s.store(plan)
+}
+
+// RunDuePlans places the orders of all the active plans due at the given moment. A plan which missed several runs,
+// e.g. while the service was down, is run only once.
+func (s *Service) RunDuePlans(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plans, err := s.plans.ListActivePlans()
+	if err != nil {
+		return fmt.Errorf("cannot list active plans: %w", err)
+	}
+	for _, plan := range plans {
+		if plan.NextRunAt.After(now) {
+			continue
+		}
+		plan.Executions = append(plan.Executions, s.execute(plan, now))
+		plan.NextRunAt, err = nextRun(plan.Schedule, now)
+		if err != nil {
+			log.Printf("Cannot schedule next run of plan [%s]: %s", plan.ID, err)
+			plan.Status = ljlib.PlanStatusPaused
+		}
+		plan.UpdatedAt = now
+		if err := s.store(plan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute places a market order for the fractional quantity the plan amount buys at the current price.
+func (s *Service) execute(plan ljlib.Plan, now time.Time) ljlib.PlanExecution {
+	execution := ljlib.PlanExecution{
+		ScheduledAt: plan.NextRunAt,
+		ExecutedAt:  now,
+		Status:      ljlib.PlanExecutionFailed,
+	}
+	price, err := s.prices.GetPriceAt(plan.Ticker, now)
+	if err != nil {
+		execution.Message = fmt.Sprintf("cannot get price: %s", err)
+		return execution
+	}
+	instrument, err := s.instruments.GetInstrument(plan.Ticker)
+	if err != nil {
+		execution.Message = fmt.Sprintf("cannot get instrument: %s", err)
+		return execution
+	}
+	//the quantity is rounded down to the precision of the instrument, so that the amount is never exceeded
+	quantity := instrument.QuantityForAmount(plan.Amount, price)
+	if !quantity.IsPositive() {
+		execution.Message = fmt.Sprintf("amount of %s is too small to buy a share at %s", plan.Amount.StringFixed(2),
+			price.StringFixed(2))
+		return execution
+	}
+
+	order, err := s.orders.PlaceOrder(plan.UserID, trading.PlaceOrderRequest{
+		Ticker:      plan.Ticker,
+		Side:        ljlib.OrderSideBuy,
+		Type:        ljlib.OrderTypeMarket,
+		TimeInForce: ljlib.TimeInForceDay,
+		Quantity:    quantity,
+	})
+	var rejection ljlib.RejectionError
+	if err != nil && !errors.As(err, &rejection) {
+		execution.Message = fmt.Sprintf("cannot place order: %s", err)
+		return execution
+	}
+	execution.OrderID = order.ID
+	execution.OrderStatus = order.Status
+	execution.Quantity = order.Quantity
+	execution.Price = order.AveragePrice
+	if err != nil {
+		execution.Message = rejection.Error()
+		return execution
+	}
+	execution.Status = ljlib.PlanExecutionExecuted
+	return execution
+}
+
+func (s *Service) getUserPlan(userID uuid.UUID, planID uuid.UUID) (ljlib.Plan, error) {
+	plan, err := s.plans.GetPlan(planID)
+	if err != nil {
+		return ljlib.Plan{}, err
+	}
+	//other users' plans are reported as not found, not to disclose they exist
+	if plan.UserID != userID {
+		return ljlib.Plan{}, ljlib.NewNotFoundError("plan not found: %s", planID)
+	}
+	return *plan, nil
+}
+
+func (s *Service) store(plan ljlib.Plan) error {
+	if err := s.plans.UpdatePlan(plan); err != nil {
+		return fmt.Errorf("cannot store plan [%s]: %w", plan.ID, err)
+	}
+	return nil
+}
+
+func validateAmount(amount decimal.Decimal) error {
+	if amount.LessThan(MinAmount) {
+		return ljlib.NewIllegalArgumentError("amount must be at least %s", MinAmount.StringFixed(2))
+	}
+	if !amount.Equal(amount.Truncate(2)) {
+		return ljlib.NewIllegalArgumentError("amount cannot have more than 2 decimal places")
+	}
+	return nil
+}
+
+func nextRun(expression string, after time.Time) (time.Time, error) {
+	schedule, err := ParseSchedule(expression)
+	if err != nil {
+		return time.Time{}, ljlib.NewIllegalArgumentError("invalid schedule: %s", err)
+	}
+	next := schedule.Next(after)
+	if next.IsZero() {
+		return time.Time{}, ljlib.NewIllegalArgumentError("schedule [%s] never runs", expression)
+	}
+	return next, nil
+}
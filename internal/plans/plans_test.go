@@ -0,0 +1,251 @@
+package plans_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/internal/plans"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+	testStart  = time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)
+	//testFirstRun is the first run of the daily plan created at testStart
+	testFirstRun = time.Date(2023, 7, 21, 9, 0, 0, 0, time.UTC)
+)
+
+func TestService_CreatePlan(t *testing.T) {
+	testCases := map[string]struct {
+		request       plans.CreatePlanRequest
+		expectedError bool
+	}{
+		"it should create a plan": {
+			request: plans.CreatePlanRequest{Ticker: "aapl", Amount: decimal.NewFromInt(100), Schedule: "0 9 * * *"},
+		},
+		"it should return IllegalArgumentError for an unknown ticker": {
+			request:       plans.CreatePlanRequest{Ticker: "XXX", Amount: decimal.NewFromInt(100), Schedule: "0 9 * * *"},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for an amount below the minimum": {
+			request:       plans.CreatePlanRequest{Ticker: "AAPL", Amount: decimal.RequireFromString("0.5"), Schedule: "0 9 * * *"},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for an invalid schedule": {
+			request:       plans.CreatePlanRequest{Ticker: "AAPL", Amount: decimal.NewFromInt(100), Schedule: "every day"},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for a schedule which never runs": {
+			request:       plans.CreatePlanRequest{Ticker: "AAPL", Amount: decimal.NewFromInt(100), Schedule: "0 9 30 2 *"},
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			service, _ := newTestService(clock.NewVirtual(testStart))
+
+			plan, err := service.CreatePlan(testUserID, testCase.request)
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "AAPL", plan.Ticker)
+			assert.Equal(t, ljlib.PlanStatusActive, plan.Status)
+			assert.Equal(t, testFirstRun, plan.NextRunAt)
+		})
+	}
+}
+
+func TestService_RunDuePlans(t *testing.T) {
+	testCases := map[string]struct {
+		amount             int64
+		prepare            func(t *testing.T, service *plans.Service, planID uuid.UUID, clock *clock.Virtual)
+		runAt              time.Time
+		expectedOrders     int
+		expectedExecutions []ljlib.PlanExecutionStatus
+		expectedNextRunAt  time.Time
+	}{
+		"it should not run the plan before it's due": {
+			amount:            100,
+			runAt:             testFirstRun.Add(-time.Minute),
+			expectedNextRunAt: testFirstRun,
+		},
+		"it should place an order for the fractional quantity bought by the amount": {
+			amount:             100,
+			runAt:              testFirstRun,
+			expectedOrders:     1,
+			expectedExecutions: []ljlib.PlanExecutionStatus{ljlib.PlanExecutionExecuted},
+			expectedNextRunAt:  testFirstRun.AddDate(0, 0, 1),
+		},
+		"it should run the plan which missed several runs only once": {
+			amount:             100,
+			runAt:              testFirstRun.AddDate(0, 0, 3).Add(time.Hour),
+			expectedOrders:     1,
+			expectedExecutions: []ljlib.PlanExecutionStatus{ljlib.PlanExecutionExecuted},
+			expectedNextRunAt:  testFirstRun.AddDate(0, 0, 4),
+		},
+		"it should record a failed execution for a rejected order": {
+			amount:             10000,
+			runAt:              testFirstRun,
+			expectedOrders:     1,
+			expectedExecutions: []ljlib.PlanExecutionStatus{ljlib.PlanExecutionFailed},
+			expectedNextRunAt:  testFirstRun.AddDate(0, 0, 1),
+		},
+		"it should not run the skipped run": {
+			amount: 100,
+			prepare: func(t *testing.T, service *plans.Service, planID uuid.UUID, clock *clock.Virtual) {
+				_, err := service.SkipNextRun(testUserID, planID)
+				require.NoError(t, err)
+			},
+			runAt:              testFirstRun,
+			expectedExecutions: []ljlib.PlanExecutionStatus{ljlib.PlanExecutionSkipped},
+			expectedNextRunAt:  testFirstRun.AddDate(0, 0, 1),
+		},
+		"it should not run a paused plan": {
+			amount: 100,
+			prepare: func(t *testing.T, service *plans.Service, planID uuid.UUID, clock *clock.Virtual) {
+				_, err := service.PausePlan(testUserID, planID)
+				require.NoError(t, err)
+			},
+			runAt: testFirstRun,
+		},
+		"it should resume the plan from its next scheduled run, without making up for the paused ones": {
+			amount: 100,
+			prepare: func(t *testing.T, service *plans.Service, planID uuid.UUID, clock *clock.Virtual) {
+				_, err := service.PausePlan(testUserID, planID)
+				require.NoError(t, err)
+				clock.Set(testFirstRun.AddDate(0, 0, 2).Add(time.Hour))
+				_, err = service.ResumePlan(testUserID, planID)
+				require.NoError(t, err)
+			},
+			runAt:             testFirstRun.AddDate(0, 0, 2).Add(time.Hour),
+			expectedNextRunAt: testFirstRun.AddDate(0, 0, 3),
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			virtualClock := clock.NewVirtual(testStart)
+			service, orders := newTestService(virtualClock)
+			plan, err := service.CreatePlan(testUserID, plans.CreatePlanRequest{
+				Ticker:   "AAPL",
+				Amount:   decimal.NewFromInt(testCase.amount),
+				Schedule: "0 9 * * *",
+			})
+			require.NoError(t, err)
+			if testCase.prepare != nil {
+				testCase.prepare(t, service, plan.ID, virtualClock)
+			}
+
+			require.NoError(t, service.RunDuePlans(testCase.runAt))
+
+			plan, err = service.GetPlan(testUserID, plan.ID)
+			require.NoError(t, err)
+			assert.Len(t, orders.requests, testCase.expectedOrders)
+			var statuses []ljlib.PlanExecutionStatus
+			for _, execution := range plan.Executions {
+				statuses = append(statuses, execution.Status)
+			}
+			assert.Equal(t, testCase.expectedExecutions, statuses)
+			assert.Equal(t, testCase.expectedNextRunAt, plan.NextRunAt)
+
+			if testCase.expectedOrders > 0 {
+				request := orders.requests[0]
+				assert.Equal(t, ljlib.OrderTypeMarket, request.Type)
+				assert.Equal(t, ljlib.OrderSideBuy, request.Side)
+				//the amount divided by the price of 150, truncated to the precision of the instrument
+				expected := decimal.NewFromInt(testCase.amount).Div(decimal.NewFromInt(150)).Truncate(6)
+				assert.Equal(t, expected.String(), request.Quantity.String())
+				assert.Equal(t, testFirstRun, plan.Executions[0].ScheduledAt)
+				assert.Equal(t, orders.placed[0].ID, plan.Executions[0].OrderID)
+			}
+		})
+	}
+}
+
+func TestService_UserPlans(t *testing.T) {
+	service, _ := newTestService(clock.NewVirtual(testStart))
+	plan, err := service.CreatePlan(testUserID, plans.CreatePlanRequest{
+		Ticker:   "AAPL",
+		Amount:   decimal.NewFromInt(100),
+		Schedule: "0 9 * * *",
+	})
+	require.NoError(t, err)
+
+	_, err = service.GetPlan(uuid.New(), plan.ID)
+	assert.ErrorIs(t, err, ljlib.NotFoundError{}, "it should not disclose other users' plans")
+	_, err = service.ResumePlan(testUserID, plan.ID)
+	assert.ErrorIs(t, err, ljlib.ConflictError{}, "it should not resume an active plan")
+
+	updated, err := service.UpdatePlan(testUserID, plan.ID, plans.UpdatePlanRequest{Schedule: "0 9 1 * *"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 8, 1, 9, 0, 0, 0, time.UTC), updated.NextRunAt)
+	assert.Equal(t, "100", updated.Amount.String())
+
+	require.NoError(t, service.DeletePlan(testUserID, plan.ID))
+	userPlans, err := service.ListPlans(testUserID)
+	require.NoError(t, err)
+	assert.Empty(t, userPlans)
+}
+
+func newTestService(clock clock.Clock) (*plans.Service, *mockOrderPlacer) {
+	orders := &mockOrderPlacer{}
+	return plans.NewService(datasource.NewLocalPlanStorage(), mockPriceSource{"AAPL": 150},
+		mockInstrumentSource{"AAPL": 6}, orders, clock), orders
+}
+
+// mockOrderPlacer fills every order at the price of 150, and rejects the ones worth more than 1000.
+type mockOrderPlacer struct {
+	requests []trading.PlaceOrderRequest
+	placed   []ljlib.Order
+}
+
+func (m *mockOrderPlacer) PlaceOrder(userID uuid.UUID, request trading.PlaceOrderRequest) (ljlib.Order, error) {
+	m.requests = append(m.requests, request)
+	order := ljlib.Order{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Ticker:   request.Ticker,
+		Side:     request.Side,
+		Type:     request.Type,
+		Quantity: request.Quantity,
+		Status:   ljlib.OrderStatusFilled,
+	}
+	var err error
+	if request.Quantity.Mul(decimal.NewFromInt(150)).GreaterThan(decimal.NewFromInt(1000)) {
+		order.Status = ljlib.OrderStatusRejected
+		err = ljlib.NewRejectionError(ljlib.RejectionInsufficientBuyingPower, "not enough cash")
+	} else {
+		order.FilledQuantity = request.Quantity
+		order.AveragePrice = decimal.NewFromInt(150)
+	}
+	m.placed = append(m.placed, order)
+	return order, err
+}
+
+type mockPriceSource map[string]float64
+
+func (m mockPriceSource) GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error) {
+	price, ok := m[ticker]
+	if !ok {
+		return decimal.Decimal{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return decimal.NewFromFloat(price), nil
+}
+
+type mockInstrumentSource map[string]int32
+
+func (m mockInstrumentSource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	precision, ok := m[ticker]
+	if !ok {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return ljlib.Instrument{Ticker: ticker, QuantityPrecision: precision}, nil
+}
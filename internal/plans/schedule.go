@@ -0,0 +1,178 @@
+package plans
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleHorizon is how far ahead the next run is looked for, so that schedules which never run
+// (e.g. on February 30th) are detected.
+const scheduleHorizon = 5 * 366 * 24 * time.Hour
+
+// scheduleDescriptors are the shortcuts for the common schedules.
+var scheduleDescriptors = map[string]string{
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// Schedule is a cron expression of five fields: minute, hour, day of month, month and day of week, evaluated in UTC.
+// Each field is either *, or a comma separated list of values and ranges, optionally with a step, e.g. 1-5 or */15.
+// Sunday is either 0 or 7. As in cron, when both the day of month and the day of week are restricted,
+// a day matching either of them is run.
+type Schedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	//daysAny and weekdaysAny are set when the field is *, i.e. not restricted
+	daysAny     bool
+	weekdaysAny bool
+}
+
+type scheduleField struct {
+	name string
+	min  int
+	max  int
+}
+
+var (
+	minuteField  = scheduleField{name: "minute", min: 0, max: 59}
+	hourField    = scheduleField{name: "hour", min: 0, max: 23}
+	dayField     = scheduleField{name: "day of month", min: 1, max: 31}
+	monthField   = scheduleField{name: "month", min: 1, max: 12}
+	weekdayField = scheduleField{name: "day of week", min: 0, max: 7}
+)
+
+func ParseSchedule(expression string) (Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	if descriptor, ok := scheduleDescriptors[strings.ToLower(expression)]; ok {
+		expression = descriptor
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("schedule must have 5 fields: minute, hour, day of month, month, day of week")
+	}
+
+	var s Schedule
+	var err error
+	if s.minutes, err = minuteField.parse(fields[0]); err != nil {
+		return Schedule{}, err
+	}
+	if s.hours, err = hourField.parse(fields[1]); err != nil {
+		return Schedule{}, err
+	}
+	if s.days, err = dayField.parse(fields[2]); err != nil {
+		return Schedule{}, err
+	}
+	if s.months, err = monthField.parse(fields[3]); err != nil {
+		return Schedule{}, err
+	}
+	if s.weekdays, err = weekdayField.parse(fields[4]); err != nil {
+		return Schedule{}, err
+	}
+	//Sunday is both 0 and 7
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	s.daysAny = strings.HasPrefix(fields[2], "*")
+	s.weekdaysAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+func (f scheduleField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field [%s]", f.name, part)
+			}
+		}
+
+		from, to := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if from, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if to, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range in %s field [%s]", f.name, part)
+			}
+		default:
+			var err error
+			if from, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			//a single value with a step, e.g. 5/15, runs from the value up to the maximum
+			if step == 1 {
+				to = from
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f scheduleField) value(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s must be between %d and %d, got [%s]", f.name, f.min, f.max, s)
+	}
+	return v, nil
+}
+
+// Next returns the first run strictly after the given moment, or zero time if the schedule never runs.
+func (s Schedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(scheduleHorizon)
+	for t.Before(limit) {
+		if !has(s.months, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.hours, t.Hour()) {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !has(s.minutes, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s Schedule) dayMatches(t time.Time) bool {
+	day := has(s.days, t.Day())
+	weekday := has(s.weekdays, int(t.Weekday()))
+	if !s.daysAny && !s.weekdaysAny {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<v) != 0
+}
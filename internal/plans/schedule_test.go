@@ -0,0 +1,93 @@
+package plans_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/plans"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	//testStart is Thursday
+	testStart := time.Date(2023, 7, 20, 10, 30, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		expression   string
+		expectedNext []string
+	}{
+		"it should run every day at the given time": {
+			expression:   "0 9 * * *",
+			expectedNext: []string{"2023-07-21T09:00:00Z", "2023-07-22T09:00:00Z"},
+		},
+		"it should run later on the same day": {
+			expression:   "45 10 * * *",
+			expectedNext: []string{"2023-07-20T10:45:00Z", "2023-07-21T10:45:00Z"},
+		},
+		"it should run every week on the given day": {
+			expression:   "0 9 * * 1",
+			expectedNext: []string{"2023-07-24T09:00:00Z", "2023-07-31T09:00:00Z"},
+		},
+		"it should treat 7 as Sunday": {
+			expression:   "0 9 * * 7",
+			expectedNext: []string{"2023-07-23T09:00:00Z", "2023-07-30T09:00:00Z"},
+		},
+		"it should run on the business days": {
+			expression:   "0 15 * * 1-5",
+			expectedNext: []string{"2023-07-20T15:00:00Z", "2023-07-21T15:00:00Z", "2023-07-24T15:00:00Z"},
+		},
+		"it should run every month on the given day": {
+			expression:   "0 9 15 * *",
+			expectedNext: []string{"2023-08-15T09:00:00Z", "2023-09-15T09:00:00Z"},
+		},
+		"it should skip the months without the given day": {
+			expression:   "0 9 31 * *",
+			expectedNext: []string{"2023-07-31T09:00:00Z", "2023-08-31T09:00:00Z", "2023-10-31T09:00:00Z"},
+		},
+		"it should run on either the day of month or the day of week when both are restricted": {
+			expression:   "0 9 1 * 5",
+			expectedNext: []string{"2023-07-21T09:00:00Z", "2023-07-28T09:00:00Z", "2023-08-01T09:00:00Z"},
+		},
+		"it should run with the step": {
+			expression:   "*/20 11 * * *",
+			expectedNext: []string{"2023-07-20T11:00:00Z", "2023-07-20T11:20:00Z", "2023-07-20T11:40:00Z"},
+		},
+		"it should run the descriptor": {
+			expression:   "@monthly",
+			expectedNext: []string{"2023-08-01T00:00:00Z", "2023-09-01T00:00:00Z"},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			schedule, err := plans.ParseSchedule(testCase.expression)
+			require.NoError(t, err)
+
+			at := testStart
+			for _, expected := range testCase.expectedNext {
+				at = schedule.Next(at)
+				assert.Equal(t, expected, at.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	testCases := map[string]string{
+		"it should reject a wrong number of fields": "0 9 * *",
+		"it should reject a value out of range":     "60 9 * * *",
+		"it should reject an inverted range":        "0 9 * * 5-1",
+		"it should reject a zero step":              "*/0 9 * * *",
+		"it should reject a non-numeric value":      "0 9 * * mon",
+	}
+	for testName, expression := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			_, err := plans.ParseSchedule(expression)
+			assert.Error(t, err)
+		})
+	}
+
+	schedule, err := plans.ParseSchedule("0 9 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(time.Now()).IsZero(), "it should never run on February 30th")
+}
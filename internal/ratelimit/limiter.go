@@ -0,0 +1,161 @@
+// Package ratelimit implements keyed token bucket rate limiting.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Period on average, with bursts of up to Burst requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	//Burst is the bucket capacity. When zero, it equals Requests.
+	Burst int
+}
+
+// ParseLimit parses limits in the form "requests/period[/burst]", e.g. "60/1m" or "10/1s/20".
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Limit{}, fmt.Errorf("rate limit must be in form requests/period[/burst], got [%s]", s)
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Limit{}, fmt.Errorf("cannot parse requests of rate limit [%s]: %w", s, err)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return Limit{}, fmt.Errorf("cannot parse period of rate limit [%s]: %w", s, err)
+	}
+	limit := Limit{Requests: requests, Period: period}
+	if len(parts) == 3 {
+		limit.Burst, err = strconv.Atoi(parts[2])
+		if err != nil {
+			return Limit{}, fmt.Errorf("cannot parse burst of rate limit [%s]: %w", s, err)
+		}
+	}
+	return limit, limit.validate()
+}
+
+func (l Limit) validate() error {
+	if l.Requests <= 0 || l.Period <= 0 || l.Burst < 0 {
+		return fmt.Errorf("rate limit requests and period must be positive, and burst must not be negative")
+	}
+	return nil
+}
+
+func (l Limit) capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// refillInterval is the time it takes to refill a single token.
+func (l Limit) refillInterval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result describes the outcome of a single Allow call, enough to fill in the RateLimit-* headers.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	//ResetAfter is the time until the bucket is full again.
+	ResetAfter time.Duration
+	//RetryAfter is the time until the next request would be allowed; zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket per key. Buckets that refilled completely are dropped periodically,
+// so memory stays bounded by the number of recently active keys.
+type Limiter struct {
+	limit     Limit
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewLimiter(limit Limit) (*Limiter, error) {
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+	}, nil
+}
+
+// Allow takes a token from the bucket of the key, if there's any.
+func (l *Limiter) Allow(key string, now time.Time) Result {
+	return l.check(key, now, true)
+}
+
+// Peek reports whether a request of the key would be allowed, without taking a token.
+func (l *Limiter) Peek(key string, now time.Time) Result {
+	return l.check(key, now, false)
+}
+
+func (l *Limiter) check(key string, now time.Time, take bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	capacity := float64(l.limit.capacity())
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	result := Result{Limit: l.limit.capacity()}
+	if b.tokens >= 1 {
+		if take {
+			b.tokens--
+		}
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.durationFor(1 - b.tokens)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.ResetAfter = l.durationFor(capacity - b.tokens)
+	return result
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(float64(l.limit.capacity()), b.tokens+float64(elapsed)/float64(l.limit.refillInterval()))
+	b.updated = now
+}
+
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens * float64(l.limit.refillInterval())))
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.limit.Period {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.limit.capacity()) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	testCases := map[string]struct {
+		value         string
+		expectedLimit ratelimit.Limit
+		expectedError bool
+	}{
+		"it should parse requests and period": {
+			value:         "60/1m",
+			expectedLimit: ratelimit.Limit{Requests: 60, Period: time.Minute},
+		},
+		"it should parse an optional burst": {
+			value:         "10/1s/20",
+			expectedLimit: ratelimit.Limit{Requests: 10, Period: time.Second, Burst: 20},
+		},
+		"it should return error on missing period": {
+			value:         "60",
+			expectedError: true,
+		},
+		"it should return error on non-positive requests": {
+			value:         "0/1m",
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			limit, err := ratelimit.ParseLimit(testCase.value)
+			if testCase.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, testCase.expectedLimit, limit)
+			}
+		})
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(ratelimit.Limit{Requests: 2, Period: time.Second})
+	require.NoError(t, err)
+	now := time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)
+
+	first := limiter.Allow("user", now)
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+
+	second := limiter.Allow("user", now)
+	assert.True(t, second.Allowed)
+	assert.Equal(t, 0, second.Remaining)
+
+	rejected := limiter.Allow("user", now)
+	assert.False(t, rejected.Allowed)
+	assert.Equal(t, 500*time.Millisecond, rejected.RetryAfter)
+	assert.Equal(t, time.Second, rejected.ResetAfter)
+
+	assert.True(t, limiter.Allow("other user", now).Allowed, "buckets should be kept per key")
+
+	assert.True(t, limiter.Allow("user", now.Add(500*time.Millisecond)).Allowed, "a token should be refilled")
+}
+
+func TestLimiter_Peek(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(ratelimit.Limit{Requests: 1, Period: time.Second})
+	require.NoError(t, err)
+	now := time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)
+
+	assert.True(t, limiter.Peek("user", now).Allowed)
+	assert.True(t, limiter.Peek("user", now).Allowed, "peeking should not take a token")
+	assert.True(t, limiter.Allow("user", now).Allowed)
+
+	peeked := limiter.Peek("user", now)
+	assert.False(t, peeked.Allowed)
+	assert.Equal(t, time.Second, peeked.RetryAfter)
+}
@@ -0,0 +1,631 @@
+// Package rebalance keeps the users' target allocations, and computes the trades which get their portfolios
+// back on target, optionally placing them as market orders.
+package rebalance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// MaxTargets is the number of the tickers, or the sectors, the targets can be set for.
+const MaxTargets = 50
+
+// WeightPlaces is the precision of the weights.
+const WeightPlaces = 6
+
+type TargetStorage interface {
+	SaveTargets(targets ljlib.TargetAllocation) error
+	GetTargets(userID uuid.UUID) (ljlib.TargetAllocation, error)
+}
+
+type Ledger interface {
+	GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error)
+}
+
+// AccountSource computes the cash and the buying power of the account.
+type AccountSource interface {
+	GetAccount(userID uuid.UUID, at time.Time) (risk.Account, error)
+}
+
+type PriceSource interface {
+	GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error)
+}
+
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+}
+
+type OrderPlacer interface {
+	PlaceOrder(userID uuid.UUID, request trading.PlaceOrderRequest) (ljlib.Order, error)
+}
+
+type Service struct {
+	targets     TargetStorage
+	ledger      Ledger
+	accounts    AccountSource
+	prices      PriceSource
+	instruments InstrumentSource
+	orders      OrderPlacer
+	clock       clock.Clock
+	fees        trading.SimulatorConfig
+}
+
+// NewService returns a service estimating the fills of the trades with the commission and the slippage
+// of the simulator config.
+func NewService(targets TargetStorage, ledger Ledger, accounts AccountSource, prices PriceSource,
+	instruments InstrumentSource, orders OrderPlacer, clock clock.Clock, fees trading.SimulatorConfig) *Service {
+	return &Service{
+		targets:     targets,
+		ledger:      ledger,
+		accounts:    accounts,
+		prices:      prices,
+		instruments: instruments,
+		orders:      orders,
+		clock:       clock,
+		fees:        fees,
+	}
+}
+
+type SetTargetsRequest struct {
+	By      ljlib.TargetsBy
+	Targets []ljlib.Target
+}
+
+// SetTargets replaces the user's target allocation. The tickers must be tradable, while the sectors are not checked,
+// since the user may target a sector they don't hold anything in yet.
+func (s *Service) SetTargets(userID uuid.UUID, request SetTargetsRequest) (ljlib.TargetAllocation, error) {
+	if request.By != ljlib.TargetsByTicker && request.By != ljlib.TargetsBySector {
+		return ljlib.TargetAllocation{}, ljlib.NewIllegalArgumentError("by must be one of %s and %s",
+			ljlib.TargetsByTicker, ljlib.TargetsBySector)
+	}
+	if len(request.Targets) > MaxTargets {
+		return ljlib.TargetAllocation{}, ljlib.NewIllegalArgumentError("at most %d targets can be set", MaxTargets)
+	}
+	targets := ljlib.TargetAllocation{UserID: userID, By: request.By, UpdatedAt: s.clock.Now()}
+	seen := make(map[string]bool)
+	var total decimal.Decimal
+	for _, target := range request.Targets {
+		target.Name = strings.TrimSpace(target.Name)
+		if request.By == ljlib.TargetsByTicker {
+			target.Name = strings.ToUpper(target.Name)
+		}
+		if len(target.Name) == 0 {
+			return ljlib.TargetAllocation{}, ljlib.NewIllegalArgumentError("target name is required")
+		}
+		if seen[target.Name] {
+			return ljlib.TargetAllocation{}, ljlib.NewIllegalArgumentError("duplicate target [%s]", target.Name)
+		}
+		seen[target.Name] = true
+		if !target.Weight.IsPositive() || !target.Weight.Equal(target.Weight.Truncate(WeightPlaces)) {
+			return ljlib.TargetAllocation{}, ljlib.NewIllegalArgumentError("weight of [%s] must be positive, "+
+				"with at most %d decimal places", target.Name, WeightPlaces)
+		}
+		total = total.Add(target.Weight)
+		if request.By == ljlib.TargetsByTicker {
+			if _, err := s.getEquity(target.Name); err != nil {
+				return ljlib.TargetAllocation{}, err
+			}
+		}
+		targets.Targets = append(targets.Targets, target)
+	}
+	if total.GreaterThan(decimal.NewFromInt(1)) {
+		return ljlib.TargetAllocation{}, ljlib.NewIllegalArgumentError("weights cannot add up to more than 1, got %s",
+			total)
+	}
+	sort.Slice(targets.Targets, func(i, j int) bool {
+		return targets.Targets[i].Name < targets.Targets[j].Name
+	})
+
+	if err := s.targets.SaveTargets(targets); err != nil {
+		return ljlib.TargetAllocation{}, fmt.Errorf("cannot store targets: %w", err)
+	}
+	return targets, nil
+}
+
+func (s *Service) GetTargets(userID uuid.UUID) (ljlib.TargetAllocation, error) {
+	return s.targets.GetTargets(userID)
+}
+
+// Constraints limit the trades of a rebalance. The zero values don't limit them.
+type Constraints struct {
+	//DriftBand is how far the weight of a ticker, or a sector, can drift from its target, e.g. 0.05 for
+	//5 percentage points, before it's traded back to the target.
+	DriftBand decimal.Decimal
+	//MinTradeAmount is the smallest amount worth trading.
+	MinTradeAmount decimal.Decimal
+	//CashBuffer is the part of the portfolio kept in cash on top of what the targets leave in cash.
+	CashBuffer decimal.Decimal
+	//AvoidShortTermGains limits the sells to the lots which would realize either a loss, or a long-term gain.
+	AvoidShortTermGains bool
+}
+
+type PreviewRequest struct {
+	Constraints Constraints
+	//PlaceOrders turns the trades of the preview into market orders.
+	PlaceOrders bool
+}
+
+// ShortTermPeriod is how long a lot has to be held for its gain to be long-term.
+const ShortTermPeriod = 365 * 24 * time.Hour
+
+// Drift is how far the ticker, or the sector, is from its target. The weights are of the total value
+// of the portfolio, including the cash, and the target value is after the cash buffer.
+type Drift struct {
+	Name          string
+	CurrentValue  decimal.Decimal
+	TargetValue   decimal.Decimal
+	CurrentWeight decimal.Decimal
+	TargetWeight  decimal.Decimal
+	Drift         decimal.Decimal
+	//WithinBand is set when the drift is within the band, so nothing is traded.
+	WithinBand bool
+}
+
+func (d Drift) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name          string `json:"name"`
+		CurrentValue  string `json:"current_value"`
+		TargetValue   string `json:"target_value"`
+		CurrentWeight string `json:"current_weight"`
+		TargetWeight  string `json:"target_weight"`
+		Drift         string `json:"drift"`
+		WithinBand    bool   `json:"within_band"`
+	}{
+		Name:          d.Name,
+		CurrentValue:  d.CurrentValue.StringFixed(ljlib.CashPlaces),
+		TargetValue:   d.TargetValue.StringFixed(ljlib.CashPlaces),
+		CurrentWeight: d.CurrentWeight.String(),
+		TargetWeight:  d.TargetWeight.String(),
+		Drift:         d.Drift.String(),
+		WithinBand:    d.WithinBand,
+	})
+}
+
+// Trade is a trade of the rebalance, valued at the current price. The gains of the sells are estimated from
+// the lots they close, first in, first out.
+type Trade struct {
+	Ticker   string
+	Side     ljlib.OrderSide
+	Quantity decimal.Decimal
+	Price    decimal.Decimal
+	Amount   decimal.Decimal
+	//Commission is the estimated commission of the fill.
+	Commission decimal.Decimal
+	//EstimatedGain is the gain the sell realizes, and ShortTermGain the part of it from the lots held
+	//for less than ShortTermPeriod.
+	EstimatedGain decimal.Decimal
+	ShortTermGain decimal.Decimal
+	//LimitedByShortTermGains is set when the sell was reduced not to realize any short-term gain.
+	LimitedByShortTermGains bool
+}
+
+func (t Trade) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Ticker                  string          `json:"ticker"`
+		Side                    ljlib.OrderSide `json:"side"`
+		Quantity                string          `json:"quantity"`
+		Price                   string          `json:"price"`
+		Amount                  string          `json:"amount"`
+		Commission              string          `json:"commission"`
+		EstimatedGain           string          `json:"estimated_gain"`
+		ShortTermGain           string          `json:"short_term_gain"`
+		LimitedByShortTermGains bool            `json:"limited_by_short_term_gains"`
+	}{
+		Ticker:                  t.Ticker,
+		Side:                    t.Side,
+		Quantity:                t.Quantity.String(),
+		Price:                   ljlib.FormatDecimal(t.Price, ljlib.CashPlaces),
+		Amount:                  t.Amount.StringFixed(ljlib.CashPlaces),
+		Commission:              t.Commission.StringFixed(ljlib.CashPlaces),
+		EstimatedGain:           t.EstimatedGain.StringFixed(ljlib.CashPlaces),
+		ShortTermGain:           t.ShortTermGain.StringFixed(ljlib.CashPlaces),
+		LimitedByShortTermGains: t.LimitedByShortTermGains,
+	})
+}
+
+// Preview is the rebalance of the portfolio to the targets: the drifts, and the trades, the sells first.
+// The value is of the long equities and the cash, less what the short positions are worth, since the cash
+// includes the proceeds of the short sales. The cash after the trades is net of the estimated commissions.
+// The orders are set when they were placed.
+type Preview struct {
+	AsOf      time.Time
+	By        ljlib.TargetsBy
+	Value     decimal.Decimal
+	Cash      decimal.Decimal
+	CashAfter decimal.Decimal
+	Drifts    []Drift
+	Trades    []Trade
+	Orders    []ljlib.Order
+	Warnings  []string
+}
+
+func (p Preview) MarshalJSON() ([]byte, error) {
+	drifts, trades, warnings := p.Drifts, p.Trades, p.Warnings
+	if drifts == nil {
+		drifts = []Drift{}
+	}
+	if trades == nil {
+		trades = []Trade{}
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return json.Marshal(struct {
+		AsOf      string          `json:"as_of"`
+		By        ljlib.TargetsBy `json:"by"`
+		Value     string          `json:"value"`
+		Cash      string          `json:"cash"`
+		CashAfter string          `json:"cash_after"`
+		Drifts    []Drift         `json:"drifts"`
+		Trades    []Trade         `json:"trades"`
+		Orders    []ljlib.Order   `json:"orders,omitempty"`
+		Warnings  []string        `json:"warnings"`
+	}{
+		AsOf:      p.AsOf.Format(time.RFC3339),
+		By:        p.By,
+		Value:     p.Value.StringFixed(ljlib.CashPlaces),
+		Cash:      p.Cash.StringFixed(ljlib.CashPlaces),
+		CashAfter: p.CashAfter.StringFixed(ljlib.CashPlaces),
+		Drifts:    drifts,
+		Trades:    trades,
+		Orders:    p.Orders,
+		Warnings:  warnings,
+	})
+}
+
+// holding is a long equity position valued at the current price.
+type holding struct {
+	position   ljlib.Position
+	instrument ljlib.Instrument
+	price      decimal.Decimal
+	value      decimal.Decimal
+}
+
+// group is the holdings of a ticker, or a sector, with its target.
+type group struct {
+	name     string
+	weight   decimal.Decimal
+	holdings []*holding
+	//ticker is the ticker of the group of a ticker target, which may not be held yet.
+	ticker string
+}
+
+func (g group) value() decimal.Decimal {
+	var value decimal.Decimal
+	for _, h := range g.holdings {
+		value = value.Add(h.value)
+	}
+	return value
+}
+
+// Preview computes the trades which get the user's portfolio back to the targets. Only the tickers, or the sectors,
+// which drifted out of the band are traded: to their target value, split between the holdings of a sector
+// by their value. The holdings without a target are sold. The buys are scaled down to the cash left after
+// the sells, the cash buffer and their estimated commissions and slippage. The short positions and the options
+// are not rebalanced.
+func (s *Service) Preview(userID uuid.UUID, request PreviewRequest) (Preview, error) {
+	constraints := request.Constraints
+	if err := validateConstraints(constraints); err != nil {
+		return Preview{}, err
+	}
+	targets, err := s.targets.GetTargets(userID)
+	if err != nil {
+		return Preview{}, err
+	}
+	now := s.clock.Now()
+	account, err := s.accounts.GetAccount(userID, now)
+	if err != nil {
+		return Preview{}, fmt.Errorf("cannot get account: %w", err)
+	}
+	preview := Preview{AsOf: now, By: targets.By, Cash: account.Cash}
+	holdings, warnings, err := s.getHoldings(userID, now)
+	if err != nil {
+		return Preview{}, err
+	}
+	preview.Warnings = warnings
+	//the proceeds of the short sales are owed back, while the shorts themselves are not among the holdings
+	preview.Value = account.Cash.Sub(account.ShortMarketValue)
+	for _, h := range holdings {
+		preview.Value = preview.Value.Add(h.value)
+	}
+	groups, err := s.groupHoldings(targets, holdings)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	investable := preview.Value.Mul(decimal.NewFromInt(1).Sub(constraints.CashBuffer))
+	sellAmounts := make(map[string]decimal.Decimal)
+	buyAmounts := make(map[string]decimal.Decimal)
+	pricedTickers := make(map[string]*holding)
+	for _, g := range groups {
+		current := g.value()
+		drift := Drift{
+			Name:         g.name,
+			CurrentValue: current,
+			TargetValue:  g.weight.Mul(investable).Round(ljlib.CashPlaces),
+			TargetWeight: g.weight,
+		}
+		if preview.Value.IsPositive() {
+			drift.CurrentWeight = current.Div(preview.Value).Round(WeightPlaces)
+			drift.Drift = current.Sub(drift.TargetValue).Div(preview.Value).Round(WeightPlaces)
+		}
+		drift.WithinBand = drift.Drift.Abs().LessThanOrEqual(constraints.DriftBand)
+		preview.Drifts = append(preview.Drifts, drift)
+		if drift.WithinBand {
+			continue
+		}
+
+		change := drift.TargetValue.Sub(current)
+		switch {
+		case change.IsNegative():
+			for _, h := range g.holdings {
+				amount := h.value
+				if drift.TargetValue.IsPositive() {
+					amount = change.Neg().Mul(h.value).Div(current)
+				}
+				sellAmounts[h.position.Ticker] = sellAmounts[h.position.Ticker].Add(amount)
+				pricedTickers[h.position.Ticker] = h
+			}
+		case len(g.ticker) > 0 && len(g.holdings) == 0:
+			h, err := s.newHolding(g.ticker, now)
+			if err != nil {
+				return Preview{}, err
+			}
+			buyAmounts[g.ticker] = change
+			pricedTickers[g.ticker] = h
+		case len(g.holdings) == 0:
+			preview.Warnings = append(preview.Warnings, fmt.Sprintf("nothing is held in sector [%s] to buy", g.name))
+		default:
+			for _, h := range g.holdings {
+				buyAmounts[h.position.Ticker] = buyAmounts[h.position.Ticker].Add(change.Mul(h.value).Div(current))
+				pricedTickers[h.position.Ticker] = h
+			}
+		}
+	}
+
+	var proceeds decimal.Decimal
+	for _, ticker := range sortedKeys(sellAmounts) {
+		h := pricedTickers[ticker]
+		trade, warning := sellTrade(h, sellAmounts[ticker], sellAmounts[ticker].GreaterThanOrEqual(h.value), constraints,
+			now)
+		if len(warning) > 0 {
+			preview.Warnings = append(preview.Warnings, warning)
+		}
+		if trade.Quantity.IsZero() || trade.Amount.LessThan(constraints.MinTradeAmount) {
+			continue
+		}
+		trade.Commission = s.fees.CommissionFor(ticker, trade.Quantity, trade.Price)
+		proceeds = proceeds.Add(trade.Amount).Sub(trade.Commission)
+		preview.Trades = append(preview.Trades, trade)
+	}
+
+	//the buys are funded by the buying power and the proceeds of the sells, less the cash buffer and their fill
+	//costs: the commissions of the full buys, which are never less than of the scaled down ones, are reserved first,
+	//and the rest pays for the buys at the price moved by the slippage, as the risk checks estimate it
+	available := account.BuyingPower.Add(proceeds).Sub(preview.Value.Mul(constraints.CashBuffer))
+	slipped := decimal.NewFromInt(1).Add(s.fees.SlippageBps.Div(decimal.NewFromInt(10000)))
+	var wanted decimal.Decimal
+	for ticker, amount := range buyAmounts {
+		h := pricedTickers[ticker]
+		wanted = wanted.Add(amount.Mul(slipped))
+		available = available.Sub(s.fees.CommissionFor(ticker, amount.Div(h.price), h.price))
+	}
+	scale := decimal.NewFromInt(1)
+	if wanted.GreaterThan(available) {
+		scale = decimal.Zero
+		if available.IsPositive() {
+			scale = available.Div(wanted)
+		}
+		preview.Warnings = append(preview.Warnings, "the buys are reduced to the cash available")
+	}
+	var spent decimal.Decimal
+	for _, ticker := range sortedKeys(buyAmounts) {
+		h := pricedTickers[ticker]
+		amount := buyAmounts[ticker].Mul(scale)
+		quantity := h.instrument.QuantityForAmount(amount, h.price)
+		trade := Trade{
+			Ticker:   ticker,
+			Side:     ljlib.OrderSideBuy,
+			Quantity: quantity,
+			Price:    h.price,
+			Amount:   quantity.Mul(h.price).Round(ljlib.CashPlaces),
+		}
+		if !quantity.IsPositive() || trade.Amount.LessThan(constraints.MinTradeAmount) {
+			continue
+		}
+		trade.Commission = s.fees.CommissionFor(ticker, quantity, h.price)
+		spent = spent.Add(trade.Amount).Add(trade.Commission)
+		preview.Trades = append(preview.Trades, trade)
+	}
+	preview.CashAfter = account.Cash.Add(proceeds).Sub(spent)
+
+	if request.PlaceOrders {
+		preview.Orders, warnings = s.placeOrders(userID, preview.Trades)
+		preview.Warnings = append(preview.Warnings, warnings...)
+	}
+	return preview, nil
+}
+
+func validateConstraints(constraints Constraints) error {
+	one := decimal.NewFromInt(1)
+	if constraints.DriftBand.IsNegative() || constraints.DriftBand.GreaterThanOrEqual(one) {
+		return ljlib.NewIllegalArgumentError("drift band must be at least 0 and less than 1")
+	}
+	if constraints.MinTradeAmount.IsNegative() {
+		return ljlib.NewIllegalArgumentError("minimum trade amount cannot be negative")
+	}
+	if constraints.CashBuffer.IsNegative() || constraints.CashBuffer.GreaterThanOrEqual(one) {
+		return ljlib.NewIllegalArgumentError("cash buffer must be at least 0 and less than 1")
+	}
+	return nil
+}
+
+// getHoldings returns the long equity positions valued at the current price, with the warnings about
+// the positions which are not rebalanced.
+func (s *Service) getHoldings(userID uuid.UUID, now time.Time) ([]*holding, []string, error) {
+	transactions, err := s.ledger.GetTransactions(userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get transactions: %w", err)
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].ExecutedAt.Before(transactions[j].ExecutedAt)
+	})
+	var holdings []*holding
+	var warnings []string
+	for _, position := range ljlib.BuildPositions(transactions) {
+		if position.Quantity.IsZero() {
+			continue
+		}
+		instrument, err := s.instruments.GetInstrument(position.Ticker)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot get instrument [%s]: %w", position.Ticker, err)
+		}
+		if position.Quantity.IsNegative() || instrument.AssetClass != ljlib.AssetClassEquity {
+			warnings = append(warnings, fmt.Sprintf("position in [%s] is not rebalanced", position.Ticker))
+			continue
+		}
+		h, err := s.newHolding(position.Ticker, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.position = position
+		h.value = position.Quantity.Mul(h.price).Round(ljlib.CashPlaces)
+		holdings = append(holdings, h)
+	}
+	return holdings, warnings, nil
+}
+
+// newHolding prices the ticker, without any position yet.
+func (s *Service) newHolding(ticker string, now time.Time) (*holding, error) {
+	instrument, err := s.getEquity(ticker)
+	if err != nil {
+		return nil, err
+	}
+	price, err := s.prices.GetPriceAt(ticker, now)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get price for ticker [%s]: %w", ticker, err)
+	}
+	return &holding{position: ljlib.Position{Ticker: ticker}, instrument: instrument, price: price}, nil
+}
+
+func (s *Service) getEquity(ticker string) (ljlib.Instrument, error) {
+	instrument, err := s.instruments.GetInstrument(ticker)
+	if errors.Is(err, ljlib.IllegalArgumentError{}) {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("unknown ticker [%s]", ticker)
+	}
+	if err != nil {
+		return ljlib.Instrument{}, fmt.Errorf("cannot get instrument [%s]: %w", ticker, err)
+	}
+	if instrument.AssetClass != ljlib.AssetClassEquity {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("only equities can be targeted, got [%s]", ticker)
+	}
+	return instrument, nil
+}
+
+// groupHoldings groups the holdings by the targets, ordered by name. The holdings without a target are in
+// the groups with the target weight of zero.
+func (s *Service) groupHoldings(targets ljlib.TargetAllocation, holdings []*holding) ([]*group, error) {
+	groups := make(map[string]*group)
+	for _, target := range targets.Targets {
+		groups[target.Name] = &group{name: target.Name, weight: target.Weight}
+		if targets.By == ljlib.TargetsByTicker {
+			groups[target.Name].ticker = target.Name
+		}
+	}
+	for _, h := range holdings {
+		name := h.position.Ticker
+		if targets.By == ljlib.TargetsBySector {
+			name = h.instrument.Sector
+		}
+		g, ok := groups[name]
+		if !ok {
+			g = &group{name: name}
+			groups[name] = g
+		}
+		g.holdings = append(g.holdings, h)
+	}
+	result := make([]*group, 0, len(groups))
+	for _, name := range sortedKeys(groups) {
+		result = append(result, groups[name])
+	}
+	return result, nil
+}
+
+// sellTrade sells the amount of the holding, or the whole of it, estimating the gain from its lots. When
+// the short-term gains are avoided, only the oldest lots which don't realize any are sold.
+func sellTrade(h *holding, amount decimal.Decimal, whole bool, constraints Constraints, now time.Time) (Trade,
+	string) {
+	quantity := h.position.Quantity
+	if !whole {
+		quantity = decimal.Min(amount.Div(h.price).Truncate(h.instrument.QuantityPrecision), quantity)
+	}
+	trade := Trade{Ticker: h.position.Ticker, Side: ljlib.OrderSideSell, Price: h.price}
+	var warning string
+	remaining := quantity
+	for _, lot := range h.position.Lots {
+		if !remaining.IsPositive() {
+			break
+		}
+		closed := decimal.Min(lot.Quantity, remaining)
+		gain := closed.Mul(h.price).Sub(lot.Cost.Mul(closed).Div(lot.Quantity))
+		shortTerm := now.Sub(lot.OpenedAt) < ShortTermPeriod && gain.IsPositive()
+		if shortTerm && constraints.AvoidShortTermGains {
+			trade.LimitedByShortTermGains = true
+			warning = fmt.Sprintf("sell of [%s] is limited not to realize short-term gains", h.position.Ticker)
+			break
+		}
+		trade.Quantity = trade.Quantity.Add(closed)
+		trade.EstimatedGain = trade.EstimatedGain.Add(gain)
+		if shortTerm {
+			trade.ShortTermGain = trade.ShortTermGain.Add(gain)
+		}
+		remaining = remaining.Sub(closed)
+	}
+	trade.Amount = trade.Quantity.Mul(h.price).Round(ljlib.CashPlaces)
+	trade.EstimatedGain = trade.EstimatedGain.Round(ljlib.CashPlaces)
+	trade.ShortTermGain = trade.ShortTermGain.Round(ljlib.CashPlaces)
+	return trade, warning
+}
+
+// placeOrders places the trades as market orders, in their order. The orders rejected by the risk checks
+// are returned as well, while the ones which couldn't be placed at all are reported as the warnings.
+func (s *Service) placeOrders(userID uuid.UUID, trades []Trade) ([]ljlib.Order, []string) {
+	var orders []ljlib.Order
+	var warnings []string
+	for _, trade := range trades {
+		order, err := s.orders.PlaceOrder(userID, trading.PlaceOrderRequest{
+			Ticker:      trade.Ticker,
+			Side:        trade.Side,
+			Type:        ljlib.OrderTypeMarket,
+			TimeInForce: ljlib.TimeInForceDay,
+			Quantity:    trade.Quantity,
+		})
+		var rejection ljlib.RejectionError
+		if err != nil && !errors.As(err, &rejection) {
+			warnings = append(warnings, fmt.Sprintf("cannot place order for [%s]: %s", trade.Ticker, err))
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, warnings
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,365 @@
+package rebalance_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/internal/rebalance"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+	testNow    = time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+)
+
+func TestService_SetTargets(t *testing.T) {
+	testCases := map[string]struct {
+		request       rebalance.SetTargetsRequest
+		expectedError bool
+	}{
+		"it should set the targets per ticker": {
+			request: rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+				target("msft", "0.4"), target("AAPL", "0.6"),
+			}},
+		},
+		"it should set the targets per sector, even the ones not held": {
+			request: rebalance.SetTargetsRequest{By: ljlib.TargetsBySector, Targets: []ljlib.Target{
+				target("Utilities", "0.5"),
+			}},
+		},
+		"it should return IllegalArgumentError for an unknown ticker": {
+			request: rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+				target("XXX", "0.5"),
+			}},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for the weights adding up to more than 1": {
+			request: rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+				target("AAPL", "0.6"), target("MSFT", "0.5"),
+			}},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for a duplicate target": {
+			request: rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+				target("AAPL", "0.2"), target("aapl", "0.2"),
+			}},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for a weight which isn't positive": {
+			request: rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+				target("AAPL", "0"),
+			}},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for an unknown grouping": {
+			request: rebalance.SetTargetsRequest{By: "industry", Targets: []ljlib.Target{
+				target("Software", "0.5"),
+			}},
+			expectedError: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			service, _ := newTestService(nil)
+
+			targets, err := service.SetTargets(testUserID, testCase.request)
+			if testCase.expectedError {
+				assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testNow, targets.UpdatedAt)
+
+			stored, err := service.GetTargets(testUserID)
+			require.NoError(t, err)
+			assert.Equal(t, targets, stored)
+			if testCase.request.By == ljlib.TargetsByTicker {
+				assert.Equal(t, "AAPL", stored.Targets[0].Name)
+				assert.Equal(t, "MSFT", stored.Targets[1].Name)
+			}
+		})
+	}
+}
+
+func TestService_Preview(t *testing.T) {
+	byTicker := rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+		target("AAPL", "0.5"), target("MSFT", "0.4"),
+	}}
+	//the portfolio is worth 8000: 6000 of AAPL, 1000 of XOM and 1000 of cash
+	testCases := map[string]struct {
+		targets          rebalance.SetTargetsRequest
+		constraints      rebalance.Constraints
+		expectedTrades   []string
+		expectedCash     string
+		expectedWarnings int
+	}{
+		"it should sell the overweight and the untargeted tickers, and buy the underweight ones": {
+			targets:        byTicker,
+			expectedTrades: []string{"sell 20 AAPL", "sell 20 XOM", "buy 16 MSFT"},
+			expectedCash:   "800",
+		},
+		"it should not trade the tickers within the drift band": {
+			targets:          byTicker,
+			constraints:      rebalance.Constraints{DriftBand: decimal.RequireFromString("0.2")},
+			expectedTrades:   []string{"sell 20 AAPL", "buy 15 MSFT"},
+			expectedCash:     "0",
+			expectedWarnings: 1,
+		},
+		"it should drop the trades below the minimum and reduce the buys to the cash available": {
+			targets:          byTicker,
+			constraints:      rebalance.Constraints{MinTradeAmount: decimal.NewFromInt(1500)},
+			expectedTrades:   []string{"sell 20 AAPL", "buy 15 MSFT"},
+			expectedCash:     "0",
+			expectedWarnings: 1,
+		},
+		"it should keep the cash buffer": {
+			targets:        byTicker,
+			constraints:    rebalance.Constraints{CashBuffer: decimal.RequireFromString("0.1")},
+			expectedTrades: []string{"sell 24 AAPL", "sell 20 XOM", "buy 14 MSFT"},
+			expectedCash:   "1600",
+		},
+		"it should only sell the lots without short-term gains": {
+			targets: rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker, Targets: []ljlib.Target{
+				target("AAPL", "0.1"), target("XOM", "0.125"),
+			}},
+			constraints:      rebalance.Constraints{AvoidShortTermGains: true},
+			expectedTrades:   []string{"sell 50 AAPL"},
+			expectedCash:     "6000",
+			expectedWarnings: 1,
+		},
+		"it should split the trades of a sector between its holdings": {
+			targets: rebalance.SetTargetsRequest{By: ljlib.TargetsBySector, Targets: []ljlib.Target{
+				target("Information Technology", "0.6"), target("Energy", "0.3"), target("Health Care", "0.1"),
+			}},
+			expectedTrades:   []string{"sell 12 AAPL", "buy 28 XOM"},
+			expectedCash:     "800",
+			expectedWarnings: 1,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			service, orders := newTestService(testTransactions())
+			_, err := service.SetTargets(testUserID, testCase.targets)
+			require.NoError(t, err)
+
+			preview, err := service.Preview(testUserID, rebalance.PreviewRequest{Constraints: testCase.constraints})
+			require.NoError(t, err)
+
+			assert.Equal(t, "8000", preview.Value.String())
+			assert.Equal(t, testCase.expectedTrades, describeTrades(preview.Trades))
+			assert.Equal(t, testCase.expectedCash, preview.CashAfter.String())
+			assert.Len(t, preview.Warnings, testCase.expectedWarnings, preview.Warnings)
+			assert.Empty(t, preview.Orders)
+			assert.Empty(t, orders.requests)
+		})
+	}
+}
+
+func TestService_Preview_FillCosts(t *testing.T) {
+	//the cash of 1500 includes the proceeds of a short sale worth 500 now, so the portfolio is still worth 8000
+	account := risk.Account{Cash: decimal.NewFromInt(1500), BuyingPower: decimal.NewFromInt(1000),
+		ShortMarketValue: decimal.NewFromInt(500)}
+	fees := trading.SimulatorConfig{Commission: trading.CommissionSchedule{Minimum: decimal.NewFromInt(1)}}
+	service, _ := newTestServiceWithFees(testTransactions(), account, fees)
+	_, err := service.SetTargets(testUserID, rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker,
+		Targets: []ljlib.Target{target("AAPL", "0.5"), target("MSFT", "0.4")}})
+	require.NoError(t, err)
+
+	preview, err := service.Preview(testUserID, rebalance.PreviewRequest{
+		Constraints: rebalance.Constraints{DriftBand: decimal.RequireFromString("0.2")},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "8000", preview.Value.String())
+	assert.Equal(t, "0.75", preview.Drifts[0].CurrentWeight.String())
+	//the buying power and the sell net of its commission make 2999, less than the 3200 wanted, and the buy
+	//has its commission reserved first, so 14 of MSFT are bought instead of 15
+	assert.Equal(t, []string{"sell 20 AAPL", "buy 14 MSFT"}, describeTrades(preview.Trades))
+	assert.Equal(t, "1", preview.Trades[0].Commission.String())
+	assert.Equal(t, "1", preview.Trades[1].Commission.String())
+	assert.Equal(t, "698", preview.CashAfter.String())
+}
+
+func TestService_Preview_Drifts(t *testing.T) {
+	service, _ := newTestService(testTransactions())
+	_, err := service.SetTargets(testUserID, rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker,
+		Targets: []ljlib.Target{target("AAPL", "0.5"), target("MSFT", "0.4")}})
+	require.NoError(t, err)
+
+	preview, err := service.Preview(testUserID, rebalance.PreviewRequest{})
+	require.NoError(t, err)
+
+	require.Len(t, preview.Drifts, 3)
+	assert.Equal(t, "AAPL", preview.Drifts[0].Name)
+	assert.Equal(t, "0.75", preview.Drifts[0].CurrentWeight.String())
+	assert.Equal(t, "0.25", preview.Drifts[0].Drift.String())
+	assert.Equal(t, "MSFT", preview.Drifts[1].Name)
+	assert.Equal(t, "-0.4", preview.Drifts[1].Drift.String())
+	assert.Equal(t, "XOM", preview.Drifts[2].Name)
+	assert.True(t, preview.Drifts[2].TargetWeight.IsZero())
+
+	//the sold AAPL comes from the oldest lot, bought at 80 more than a year ago
+	require.Len(t, preview.Trades, 3)
+	assert.Equal(t, "400", preview.Trades[0].EstimatedGain.String())
+	assert.True(t, preview.Trades[0].ShortTermGain.IsZero())
+	assert.Equal(t, "-200", preview.Trades[1].EstimatedGain.String())
+}
+
+func TestService_Preview_PlaceOrders(t *testing.T) {
+	service, orders := newTestService(testTransactions())
+	_, err := service.SetTargets(testUserID, rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker,
+		Targets: []ljlib.Target{target("AAPL", "0.5"), target("MSFT", "0.4")}})
+	require.NoError(t, err)
+
+	preview, err := service.Preview(testUserID, rebalance.PreviewRequest{PlaceOrders: true})
+	require.NoError(t, err)
+
+	require.Len(t, orders.requests, 3)
+	for i, request := range orders.requests {
+		assert.Equal(t, preview.Trades[i].Ticker, request.Ticker)
+		assert.Equal(t, preview.Trades[i].Side, request.Side)
+		assert.Equal(t, preview.Trades[i].Quantity, request.Quantity)
+		assert.Equal(t, ljlib.OrderTypeMarket, request.Type)
+	}
+	//the rejected order is returned as well
+	require.Len(t, preview.Orders, 3)
+	assert.Equal(t, ljlib.OrderStatusRejected, preview.Orders[2].Status)
+}
+
+func TestService_Preview_Errors(t *testing.T) {
+	service, _ := newTestService(testTransactions())
+
+	_, err := service.Preview(testUserID, rebalance.PreviewRequest{})
+	assert.ErrorIs(t, err, ljlib.NotFoundError{}, "the targets are not set")
+
+	_, err = service.SetTargets(testUserID, rebalance.SetTargetsRequest{By: ljlib.TargetsByTicker,
+		Targets: []ljlib.Target{target("AAPL", "1")}})
+	require.NoError(t, err)
+	_, err = service.Preview(testUserID, rebalance.PreviewRequest{
+		Constraints: rebalance.Constraints{DriftBand: decimal.NewFromInt(1)},
+	})
+	assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+	_, err = service.Preview(testUserID, rebalance.PreviewRequest{
+		Constraints: rebalance.Constraints{MinTradeAmount: decimal.NewFromInt(-1)},
+	})
+	assert.ErrorIs(t, err, ljlib.IllegalArgumentError{})
+}
+
+func newTestService(transactions mockLedger) (*rebalance.Service, *mockOrderPlacer) {
+	account := risk.Account{Cash: decimal.NewFromInt(1000), BuyingPower: decimal.NewFromInt(1000)}
+	return newTestServiceWithFees(transactions, account, trading.SimulatorConfig{})
+}
+
+func newTestServiceWithFees(transactions mockLedger, account risk.Account,
+	fees trading.SimulatorConfig) (*rebalance.Service, *mockOrderPlacer) {
+	orders := &mockOrderPlacer{}
+	prices := mockPriceSource{"AAPL": 100, "MSFT": 200, "XOM": 50}
+	instruments := mockInstrumentSource{
+		"AAPL": "Information Technology",
+		"MSFT": "Information Technology",
+		"XOM":  "Energy",
+	}
+	return rebalance.NewService(datasource.NewLocalTargetStorage(), transactions, mockAccountSource{account},
+		prices, instruments, orders, clock.NewVirtual(testNow), fees), orders
+}
+
+// testTransactions buy AAPL at a gain, both more and less than a year ago, and XOM at a loss.
+func testTransactions() mockLedger {
+	return mockLedger{
+		buy("AAPL", 50, 80, testNow.AddDate(-1, -6, 0)),
+		buy("AAPL", 10, 90, testNow.AddDate(0, -1, 0)),
+		buy("XOM", 20, 60, testNow.AddDate(0, -1, 0)),
+	}
+}
+
+func buy(ticker string, quantity int64, price int64, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Type:       ljlib.TransactionTypeBuy,
+		Ticker:     ticker,
+		Quantity:   decimal.NewFromInt(quantity),
+		Price:      decimal.NewFromInt(price),
+		ExecutedAt: at,
+	}
+}
+
+func target(name string, weight string) ljlib.Target {
+	return ljlib.Target{Name: name, Weight: decimal.RequireFromString(weight)}
+}
+
+func describeTrades(trades []rebalance.Trade) []string {
+	var result []string
+	for _, trade := range trades {
+		result = append(result, string(trade.Side)+" "+trade.Quantity.String()+" "+trade.Ticker)
+	}
+	return result
+}
+
+type mockLedger []ljlib.Transaction
+
+func (m mockLedger) GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error) {
+	return append([]ljlib.Transaction(nil), m...), nil
+}
+
+type mockAccountSource struct {
+	account risk.Account
+}
+
+func (m mockAccountSource) GetAccount(userID uuid.UUID, at time.Time) (risk.Account, error) {
+	return m.account, nil
+}
+
+type mockPriceSource map[string]float64
+
+func (m mockPriceSource) GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error) {
+	price, ok := m[ticker]
+	if !ok {
+		return decimal.Decimal{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return decimal.NewFromFloat(price), nil
+}
+
+// mockInstrumentSource is the sectors of the whole-share equities.
+type mockInstrumentSource map[string]string
+
+func (m mockInstrumentSource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	sector, ok := m[ticker]
+	if !ok {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return ljlib.Instrument{Ticker: ticker, AssetClass: ljlib.AssetClassEquity, Sector: sector}, nil
+}
+
+// mockOrderPlacer fills the orders, and rejects the buys worth more than 3000 at the price of 200.
+type mockOrderPlacer struct {
+	requests []trading.PlaceOrderRequest
+}
+
+func (m *mockOrderPlacer) PlaceOrder(userID uuid.UUID, request trading.PlaceOrderRequest) (ljlib.Order, error) {
+	m.requests = append(m.requests, request)
+	order := ljlib.Order{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Ticker:   request.Ticker,
+		Side:     request.Side,
+		Type:     request.Type,
+		Quantity: request.Quantity,
+		Status:   ljlib.OrderStatusFilled,
+	}
+	if request.Side == ljlib.OrderSideBuy && request.Quantity.Mul(decimal.NewFromInt(200)).GreaterThan(
+		decimal.NewFromInt(3000)) {
+		order.Status = ljlib.OrderStatusRejected
+		return order, ljlib.NewRejectionError(ljlib.RejectionInsufficientBuyingPower, "not enough cash")
+	}
+	return order, nil
+}
@@ -0,0 +1,364 @@
+// Package risk runs the pre-trade checks: an order is only accepted when the account can afford it,
+// and it stays within the configured limits.
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type Ledger interface {
+	GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error)
+}
+
+type OrderSource interface {
+	ListOrders(userID uuid.UUID) ([]ljlib.Order, error)
+}
+
+type PriceSource interface {
+	GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error)
+}
+
+type Limits struct {
+	//MaxOrderNotional and MaxOrderQuantity limit a single order. Zero means unlimited.
+	MaxOrderNotional decimal.Decimal
+	MaxOrderQuantity decimal.Decimal
+	//AllowShortSelling permits selling more shares than the account holds.
+	AllowShortSelling bool
+	//The proceeds of the short sales are held as collateral, and the short positions require InitialMargin
+	//of their value on top of them. The equity has to stay above MaintenanceMargin of the value of the short
+	//positions, otherwise the account is in a margin call.
+	InitialMargin     decimal.Decimal
+	MaintenanceMargin decimal.Decimal
+	//An account making DayTradeLimit day trades within DayTradeWindow business days is a pattern day trader,
+	//and cannot make any more day trades while its equity is below PatternDayTraderMinEquity.
+	DayTradeLimit             int
+	DayTradeWindow            int
+	PatternDayTraderMinEquity decimal.Decimal
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxOrderNotional:          decimal.NewFromInt(1000000),
+		MaxOrderQuantity:          decimal.NewFromInt(100000),
+		InitialMargin:             decimal.RequireFromString("0.5"),
+		MaintenanceMargin:         decimal.RequireFromString("0.3"),
+		DayTradeLimit:             4,
+		DayTradeWindow:            5,
+		PatternDayTraderMinEquity: decimal.NewFromInt(25000),
+	}
+}
+
+// Account is the state of the user's account the checks are based on.
+type Account struct {
+	Cash decimal.Decimal
+	//ReservedCash is held for the open buy orders, so it cannot be spent by the new ones.
+	ReservedCash decimal.Decimal
+	BuyingPower  decimal.Decimal
+	//Equity is the cash together with the positions valued at the market price, the short ones negatively.
+	Equity           decimal.Decimal
+	LongMarketValue  decimal.Decimal
+	ShortMarketValue decimal.Decimal
+	//InitialMargin and MaintenanceMargin are the requirements of the short positions.
+	InitialMargin     decimal.Decimal
+	MaintenanceMargin decimal.Decimal
+	//MarginExcess is the equity above the maintenance margin; the account is in a margin call when it's negative.
+	MarginExcess decimal.Decimal
+	MarginCall   bool
+	Positions    map[string]decimal.Decimal
+	//ReservedQuantities are held for the open sell orders, per ticker.
+	ReservedQuantities map[string]decimal.Decimal
+	DayTrades          int
+	PatternDayTrader   bool
+}
+
+func (a Account) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Cash              string `json:"cash"`
+		ReservedCash      string `json:"reserved_cash"`
+		BuyingPower       string `json:"buying_power"`
+		Equity            string `json:"equity"`
+		LongMarketValue   string `json:"long_market_value"`
+		ShortMarketValue  string `json:"short_market_value"`
+		InitialMargin     string `json:"initial_margin"`
+		MaintenanceMargin string `json:"maintenance_margin"`
+		MarginExcess      string `json:"margin_excess"`
+		MarginCall        bool   `json:"margin_call"`
+		DayTrades         int    `json:"day_trades"`
+		PatternDayTrader  bool   `json:"pattern_day_trader"`
+	}{
+		Cash:              a.Cash.StringFixed(2),
+		ReservedCash:      a.ReservedCash.StringFixed(2),
+		BuyingPower:       a.BuyingPower.StringFixed(2),
+		Equity:            a.Equity.StringFixed(2),
+		LongMarketValue:   a.LongMarketValue.StringFixed(2),
+		ShortMarketValue:  a.ShortMarketValue.StringFixed(2),
+		InitialMargin:     a.InitialMargin.StringFixed(2),
+		MaintenanceMargin: a.MaintenanceMargin.StringFixed(2),
+		MarginExcess:      a.MarginExcess.StringFixed(2),
+		MarginCall:        a.MarginCall,
+		DayTrades:         a.DayTrades,
+		PatternDayTrader:  a.PatternDayTrader,
+	})
+}
+
+type Checker struct {
+	//accountLocks has a lock per user, see LockAccount
+	mu           sync.Mutex
+	accountLocks map[uuid.UUID]*sync.Mutex
+	ledger       Ledger
+	orders       OrderSource
+	prices       PriceSource
+	limits       Limits
+	//fills has the slippage and the commissions the simulator charges, which the buys have to afford too
+	fills trading.SimulatorConfig
+}
+
+func NewChecker(ledger Ledger, orders OrderSource, prices PriceSource, limits Limits,
+	fills trading.SimulatorConfig) *Checker {
+	return &Checker{
+		accountLocks: make(map[uuid.UUID]*sync.Mutex),
+		ledger:       ledger,
+		orders:       orders,
+		prices:       prices,
+		limits:       limits,
+		fills:        fills,
+	}
+}
+
+// LockAccount locks the user's account until the returned function is called. Whatever spends its buying power,
+// like placing an order or withdrawing the cash, checks and spends it under the lock, so that two of them can't
+// both be allowed the same buying power.
+func (c *Checker) LockAccount(userID uuid.UUID) func() {
+	c.mu.Lock()
+	lock, ok := c.accountLocks[userID]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.accountLocks[userID] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// GetAccount computes the account from the user's ledger and open orders.
+func (c *Checker) GetAccount(userID uuid.UUID, at time.Time) (Account, error) {
+	return c.getAccount(userID, at, uuid.Nil)
+}
+
+// getAccount computes the account, leaving out the reservations of the given order, as it's being checked.
+func (c *Checker) getAccount(userID uuid.UUID, at time.Time, checkedOrderID uuid.UUID) (Account, error) {
+	transactions, err := c.ledger.GetTransactions(userID)
+	if err != nil {
+		return Account{}, fmt.Errorf("cannot get transactions: %w", err)
+	}
+	orders, err := c.orders.ListOrders(userID)
+	if err != nil {
+		return Account{}, fmt.Errorf("cannot list orders: %w", err)
+	}
+
+	account := Account{
+		Positions:          make(map[string]decimal.Decimal),
+		ReservedQuantities: make(map[string]decimal.Decimal),
+	}
+	for _, tx := range transactions {
+		//the cash in other currencies is held, but it cannot buy the instruments, which are traded in the base one
+		if tx.CashCurrency() == ljlib.BaseCurrency {
+			account.Cash = account.Cash.Add(tx.CashFlow())
+		}
+		if tx.IsTrade() {
+			account.Positions[tx.Ticker] = account.Positions[tx.Ticker].Add(tx.SignedQuantity())
+		}
+	}
+
+	for ticker, quantity := range account.Positions {
+		if quantity.IsZero() {
+			continue
+		}
+		price, err := c.prices.GetPriceAt(ticker, at)
+		if err != nil {
+			return Account{}, fmt.Errorf("cannot get price for ticker [%s]: %w", ticker, err)
+		}
+		value := quantity.Abs().Mul(price).Mul(ljlib.ContractMultiplier(ticker))
+		if quantity.IsNegative() {
+			account.ShortMarketValue = account.ShortMarketValue.Add(value)
+		} else {
+			account.LongMarketValue = account.LongMarketValue.Add(value)
+		}
+	}
+	account.Equity = account.Cash.Add(account.LongMarketValue).Sub(account.ShortMarketValue)
+	account.InitialMargin = account.ShortMarketValue.Mul(c.limits.InitialMargin)
+	account.MaintenanceMargin = account.ShortMarketValue.Mul(c.limits.MaintenanceMargin)
+	account.MarginExcess = account.Equity.Sub(account.MaintenanceMargin)
+	account.MarginCall = account.ShortMarketValue.IsPositive() && account.MarginExcess.IsNegative()
+
+	for _, order := range orders {
+		if !order.IsOpen() || order.ID == checkedOrderID {
+			continue
+		}
+		remaining := order.RemainingQuantity()
+		if order.Side == ljlib.OrderSideSell {
+			account.ReservedQuantities[order.Ticker] = account.ReservedQuantities[order.Ticker].Add(remaining)
+			continue
+		}
+		price, err := c.prices.GetPriceAt(order.Ticker, at)
+		if err != nil {
+			return Account{}, fmt.Errorf("cannot get price for ticker [%s]: %w", order.Ticker, err)
+		}
+		account.ReservedCash = account.ReservedCash.Add(c.estimatedCost(order, remaining, price))
+	}
+	//the proceeds of the short sales and the initial margin cannot be spent
+	account.BuyingPower = decimal.Max(account.Cash.Sub(account.ReservedCash).Sub(account.ShortMarketValue).
+		Sub(account.InitialMargin), decimal.Zero)
+
+	account.DayTrades = countDayTrades(transactions, windowStart(at, c.limits.DayTradeWindow))
+	account.PatternDayTrader = c.limits.DayTradeLimit > 0 && account.DayTrades >= c.limits.DayTradeLimit
+	return account, nil
+}
+
+// CheckOrder returns ljlib.RejectionError when the order must not be accepted. Amended orders are checked
+// as a whole, in place of their previous version.
+func (c *Checker) CheckOrder(order ljlib.Order, marketPrice decimal.Decimal, at time.Time) error {
+	quantity := order.RemainingQuantity()
+	notional := quantity.Mul(c.estimatedPrice(order, marketPrice)).Mul(ljlib.ContractMultiplier(order.Ticker))
+	if c.limits.MaxOrderQuantity.IsPositive() && quantity.GreaterThan(c.limits.MaxOrderQuantity) {
+		return ljlib.NewRejectionError(ljlib.RejectionQuantityLimit, "order quantity %s exceeds the limit of %s",
+			quantity, c.limits.MaxOrderQuantity)
+	}
+	if c.limits.MaxOrderNotional.IsPositive() && notional.GreaterThan(c.limits.MaxOrderNotional) {
+		return ljlib.NewRejectionError(ljlib.RejectionNotionalLimit, "order notional %s exceeds the limit of %s",
+			notional.StringFixed(2), c.limits.MaxOrderNotional.StringFixed(2))
+	}
+
+	account, err := c.getAccount(order.UserID, at, order.ID)
+	if err != nil {
+		return err
+	}
+	if order.Side == ljlib.OrderSideBuy {
+		//buying to cover a short position releases its collateral and initial margin
+		covered := decimal.Min(quantity, decimal.Max(account.Positions[order.Ticker].Neg(), decimal.Zero))
+		released := notional.Mul(covered).Div(quantity).Mul(decimal.NewFromInt(1).Add(c.limits.InitialMargin))
+		cost := c.estimatedCost(order, quantity, marketPrice)
+		if cost.Sub(released).GreaterThan(account.BuyingPower) {
+			return ljlib.NewRejectionError(ljlib.RejectionInsufficientBuyingPower,
+				"order cost %s, with the slippage and the commission, exceeds the buying power of %s",
+				cost.StringFixed(2), account.BuyingPower.StringFixed(2))
+		}
+	}
+	if order.Side == ljlib.OrderSideSell {
+		available := decimal.Max(account.Positions[order.Ticker].Sub(account.ReservedQuantities[order.Ticker]), decimal.Zero)
+		if quantity.GreaterThan(available) && !c.limits.AllowShortSelling {
+			return ljlib.NewRejectionError(ljlib.RejectionShortSellNotAllowed,
+				"cannot sell %s shares of %s, only %s are available", quantity, order.Ticker, available)
+		}
+		if quantity.GreaterThan(available) {
+			margin := notional.Mul(quantity.Sub(available)).Div(quantity).Mul(c.limits.InitialMargin)
+			if margin.GreaterThan(account.BuyingPower) {
+				return ljlib.NewRejectionError(ljlib.RejectionInsufficientMargin,
+					"short sale requires initial margin of %s, which exceeds the buying power of %s",
+					margin.StringFixed(2), account.BuyingPower.StringFixed(2))
+			}
+		}
+	}
+
+	if c.limits.DayTradeLimit > 0 && account.DayTrades+1 >= c.limits.DayTradeLimit &&
+		account.Equity.LessThan(c.limits.PatternDayTraderMinEquity) {
+		transactions, err := c.ledger.GetTransactions(order.UserID)
+		if err != nil {
+			return fmt.Errorf("cannot get transactions: %w", err)
+		}
+		if isDayTrade(order, transactions, at) {
+			return ljlib.NewRejectionError(ljlib.RejectionPatternDayTrader,
+				"the order would be day trade number %d within %d business days, which requires equity of at least %s",
+				account.DayTrades+1, c.limits.DayTradeWindow, c.limits.PatternDayTraderMinEquity.StringFixed(2))
+		}
+	}
+	return nil
+}
+
+// estimatedPrice is the price the order is expected to execute at: the limit price if any, as the slippage never
+// makes the fill worse than it, and the market price, or the stop price when the market is yet to rise to it,
+// otherwise, raised by the slippage for the buys.
+func (c *Checker) estimatedPrice(order ljlib.Order, marketPrice decimal.Decimal) decimal.Decimal {
+	if order.LimitPrice.IsPositive() {
+		return order.LimitPrice
+	}
+	price := decimal.Max(marketPrice, order.StopPrice)
+	if order.Side == ljlib.OrderSideBuy {
+		price = price.Add(price.Mul(c.fills.SlippageBps).Div(decimal.NewFromInt(10000))).Round(4)
+	}
+	return price
+}
+
+// estimatedCost is the cash the quantity of the buy order is expected to take: its notional at the estimated
+// price, and the commission of the fill.
+func (c *Checker) estimatedCost(order ljlib.Order, quantity decimal.Decimal, marketPrice decimal.Decimal) decimal.Decimal {
+	price := c.estimatedPrice(order, marketPrice)
+	return quantity.Mul(price).Mul(ljlib.ContractMultiplier(order.Ticker)).
+		Add(c.fills.CommissionFor(order.Ticker, quantity, price))
+}
+
+// windowStart returns the start of the day which is the given number of business days back, including today.
+func windowStart(at time.Time, businessDays int) time.Time {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	for counted := 0; ; day = day.AddDate(0, 0, -1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		counted++
+		if counted >= businessDays {
+			return day
+		}
+	}
+}
+
+// countDayTrades counts the days a ticker was both bought and sold on, since the given moment.
+func countDayTrades(transactions []ljlib.Transaction, since time.Time) int {
+	type dayTicker struct {
+		day    string
+		ticker string
+	}
+	sides := make(map[dayTicker]map[ljlib.TransactionType]bool)
+	for _, tx := range transactions {
+		if !tx.IsTrade() || tx.ExecutedAt.Before(since) {
+			continue
+		}
+		key := dayTicker{day: tx.ExecutedAt.UTC().Format(time.DateOnly), ticker: tx.Ticker}
+		if sides[key] == nil {
+			sides[key] = make(map[ljlib.TransactionType]bool)
+		}
+		sides[key][tx.Type] = true
+	}
+	var count int
+	for _, types := range sides {
+		if types[ljlib.TransactionTypeBuy] && types[ljlib.TransactionTypeSell] {
+			count++
+		}
+	}
+	return count
+}
+
+// isDayTrade reports whether the order would make a new day trade: the ticker was only traded the other way today.
+func isDayTrade(order ljlib.Order, transactions []ljlib.Transaction, at time.Time) bool {
+	side, opposite := ljlib.TransactionTypeBuy, ljlib.TransactionTypeSell
+	if order.Side == ljlib.OrderSideSell {
+		side, opposite = opposite, side
+	}
+	today := at.UTC().Format(time.DateOnly)
+	traded := make(map[ljlib.TransactionType]bool)
+	for _, tx := range transactions {
+		if tx.Ticker == order.Ticker && tx.ExecutedAt.UTC().Format(time.DateOnly) == today {
+			traded[tx.Type] = true
+		}
+	}
+	return traded[opposite] && !traded[side]
+}
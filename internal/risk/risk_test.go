@@ -0,0 +1,317 @@
+package risk_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+	//testNow is Thursday, so the window of 5 business days starts on the previous Friday
+	testNow = time.Date(2023, 7, 20, 15, 0, 0, 0, time.UTC)
+)
+
+func TestChecker_GetAccount(t *testing.T) {
+	ledger := mockLedger{
+		deposit(10000, testNow.AddDate(0, -1, 0)),
+		trade(ljlib.TransactionTypeBuy, "AAPL", 10, 100, testNow.AddDate(0, 0, -1)),
+		trade(ljlib.TransactionTypeSell, "AAPL", 5, 110, testNow.AddDate(0, 0, -1)),
+	}
+	orders := mockOrderSource{
+		order(ljlib.OrderSideBuy, "MSFT", 10, ljlib.OrderStatusAccepted, 50),
+		order(ljlib.OrderSideBuy, "MSFT", 10, ljlib.OrderStatusCancelled, 50),
+	}
+	checker := risk.NewChecker(ledger, orders, mockPriceSource{"AAPL": 120, "MSFT": 60}, risk.DefaultLimits(), trading.SimulatorConfig{})
+
+	account, err := checker.GetAccount(testUserID, testNow)
+	require.NoError(t, err)
+	//10000 - 10*100 - commission of 1 + 5*110 - commission of 1
+	assert.Equal(t, "9548.00", account.Cash.StringFixed(2))
+	assert.Equal(t, "500.00", account.ReservedCash.StringFixed(2))
+	assert.Equal(t, "9048.00", account.BuyingPower.StringFixed(2))
+	assert.Equal(t, "10148.00", account.Equity.StringFixed(2))
+	assert.Equal(t, 1, account.DayTrades)
+	assert.False(t, account.PatternDayTrader)
+}
+
+func TestChecker_GetAccount_ShortPosition(t *testing.T) {
+	ledger := mockLedger{
+		deposit(1000, testNow.AddDate(0, -1, 0)),
+		trade(ljlib.TransactionTypeSell, "AAPL", 10, 100, testNow.AddDate(0, 0, -1)),
+	}
+	checker := risk.NewChecker(ledger, mockOrderSource{}, mockPriceSource{"AAPL": 160}, risk.DefaultLimits(), trading.SimulatorConfig{})
+
+	account, err := checker.GetAccount(testUserID, testNow)
+	require.NoError(t, err)
+	//the proceeds of the short sale are held as collateral
+	assert.Equal(t, "1999.00", account.Cash.StringFixed(2))
+	assert.Equal(t, "1600.00", account.ShortMarketValue.StringFixed(2))
+	assert.Equal(t, "399.00", account.Equity.StringFixed(2))
+	assert.Equal(t, "800.00", account.InitialMargin.StringFixed(2))
+	assert.Equal(t, "480.00", account.MaintenanceMargin.StringFixed(2))
+	assert.Equal(t, "-81.00", account.MarginExcess.StringFixed(2))
+	assert.True(t, account.MarginCall)
+	assert.Equal(t, "0.00", account.BuyingPower.StringFixed(2))
+}
+
+func TestChecker_CheckOrder_FillCosts(t *testing.T) {
+	testCases := map[string]struct {
+		order            ljlib.Order
+		orders           mockOrderSource
+		expectedRejected bool
+	}{
+		"it should reject a market buy for exactly the cash, as the slippage and the commission are charged on top": {
+			order:            order(ljlib.OrderSideBuy, "AAPL", 10, ljlib.OrderStatusNew, 0),
+			expectedRejected: true,
+		},
+		"it should accept a market buy leaving enough cash for the slippage and the commission": {
+			//9*100.05 and the minimum commission of 1
+			order: order(ljlib.OrderSideBuy, "AAPL", 9, ljlib.OrderStatusNew, 0),
+		},
+		"it should reject a limit buy whose commission exceeds the cash left": {
+			order:            order(ljlib.OrderSideBuy, "AAPL", 10, ljlib.OrderStatusNew, 100),
+			expectedRejected: true,
+		},
+		"it should reserve the slippage and the commission of the open buys": {
+			//the open order reserves 5*100.05 and 1, leaving 498.75 for the new one
+			orders:           mockOrderSource{order(ljlib.OrderSideBuy, "AAPL", 5, ljlib.OrderStatusAccepted, 0)},
+			order:            order(ljlib.OrderSideBuy, "AAPL", 5, ljlib.OrderStatusNew, 0),
+			expectedRejected: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			checker := risk.NewChecker(mockLedger{deposit(1000, testNow)}, testCase.orders,
+				mockPriceSource{"AAPL": 100}, risk.DefaultLimits(), trading.DefaultSimulatorConfig())
+
+			err := checker.CheckOrder(testCase.order, decimal.NewFromInt(100), testNow)
+			if !testCase.expectedRejected {
+				assert.NoError(t, err)
+				return
+			}
+			var rejection ljlib.RejectionError
+			require.True(t, errors.As(err, &rejection), "expected rejection, got %v", err)
+			assert.Equal(t, ljlib.RejectionInsufficientBuyingPower, rejection.Reason())
+		})
+	}
+}
+
+func TestChecker_GetAccount_FillCosts(t *testing.T) {
+	orders := mockOrderSource{order(ljlib.OrderSideBuy, "AAPL", 10, ljlib.OrderStatusAccepted, 0)}
+	checker := risk.NewChecker(mockLedger{deposit(10000, testNow)}, orders, mockPriceSource{"AAPL": 100},
+		risk.DefaultLimits(), trading.DefaultSimulatorConfig())
+
+	account, err := checker.GetAccount(testUserID, testNow)
+	require.NoError(t, err)
+	//10*100.05 and the minimum commission of 1
+	assert.Equal(t, "1001.50", account.ReservedCash.StringFixed(2))
+	assert.Equal(t, "8998.50", account.BuyingPower.StringFixed(2))
+}
+
+func TestChecker_LockAccount(t *testing.T) {
+	checker := risk.NewChecker(mockLedger{}, mockOrderSource{}, mockPriceSource{}, risk.DefaultLimits(), trading.SimulatorConfig{})
+	unlock := checker.LockAccount(testUserID)
+
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		checker.LockAccount(testUserID)()
+	}()
+	//the other users' accounts are not locked
+	checker.LockAccount(uuid.New())()
+	select {
+	case <-locked:
+		t.Fatal("the account should stay locked until it's unlocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the account should be locked again once it's unlocked")
+	}
+}
+
+func TestChecker_CheckOrder(t *testing.T) {
+	dayTrades := mockLedger{deposit(10000, testNow.AddDate(0, -1, 0))}
+	for _, daysBack := range []int{1, 2, 3} {
+		at := testNow.AddDate(0, 0, -daysBack)
+		dayTrades = append(dayTrades,
+			trade(ljlib.TransactionTypeBuy, "AAPL", 1, 100, at),
+			trade(ljlib.TransactionTypeSell, "AAPL", 1, 100, at))
+	}
+	boughtToday := append(append(mockLedger{}, dayTrades...), trade(ljlib.TransactionTypeBuy, "MSFT", 10, 100, testNow))
+
+	testCases := map[string]struct {
+		ledger         mockLedger
+		orders         mockOrderSource
+		limits         func(limits *risk.Limits)
+		order          ljlib.Order
+		expectedReason string
+	}{
+		"it should accept an order within the buying power": {
+			ledger: mockLedger{deposit(1000, testNow)},
+			order:  order(ljlib.OrderSideBuy, "AAPL", 9, ljlib.OrderStatusNew, 0),
+		},
+		"it should reject an order exceeding the buying power": {
+			ledger:         mockLedger{deposit(1000, testNow)},
+			order:          order(ljlib.OrderSideBuy, "AAPL", 11, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionInsufficientBuyingPower,
+		},
+		"it should reject an order exceeding the buying power left by the open orders": {
+			ledger:         mockLedger{deposit(1000, testNow)},
+			orders:         mockOrderSource{order(ljlib.OrderSideBuy, "AAPL", 5, ljlib.OrderStatusAccepted, 0)},
+			order:          order(ljlib.OrderSideBuy, "AAPL", 6, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionInsufficientBuyingPower,
+		},
+		"it should value a limit order at its limit price": {
+			ledger:         mockLedger{deposit(1000, testNow)},
+			order:          order(ljlib.OrderSideBuy, "AAPL", 9, ljlib.OrderStatusNew, 120),
+			expectedReason: ljlib.RejectionInsufficientBuyingPower,
+		},
+		"it should reject an order exceeding the quantity limit": {
+			ledger:         mockLedger{deposit(1000000, testNow)},
+			limits:         func(limits *risk.Limits) { limits.MaxOrderQuantity = decimal.NewFromInt(100) },
+			order:          order(ljlib.OrderSideBuy, "AAPL", 101, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionQuantityLimit,
+		},
+		"it should reject an order exceeding the notional limit": {
+			ledger:         mockLedger{deposit(1000000, testNow)},
+			limits:         func(limits *risk.Limits) { limits.MaxOrderNotional = decimal.NewFromInt(5000) },
+			order:          order(ljlib.OrderSideBuy, "AAPL", 51, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionNotionalLimit,
+		},
+		"it should reject a short sell": {
+			ledger:         mockLedger{deposit(1000, testNow), trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testNow)},
+			order:          order(ljlib.OrderSideSell, "AAPL", 6, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionShortSellNotAllowed,
+		},
+		"it should reject a short sell of the shares held by the open orders": {
+			ledger:         mockLedger{deposit(1000, testNow), trade(ljlib.TransactionTypeBuy, "AAPL", 5, 100, testNow)},
+			orders:         mockOrderSource{order(ljlib.OrderSideSell, "AAPL", 3, ljlib.OrderStatusAccepted, 0)},
+			order:          order(ljlib.OrderSideSell, "AAPL", 3, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionShortSellNotAllowed,
+		},
+		"it should accept a short sell when permitted": {
+			ledger: mockLedger{deposit(1000, testNow)},
+			limits: func(limits *risk.Limits) { limits.AllowShortSelling = true },
+			order:  order(ljlib.OrderSideSell, "AAPL", 6, ljlib.OrderStatusNew, 0),
+		},
+		"it should reject a short sell requiring more initial margin than the buying power": {
+			ledger:         mockLedger{deposit(1000, testNow)},
+			limits:         func(limits *risk.Limits) { limits.AllowShortSelling = true },
+			order:          order(ljlib.OrderSideSell, "AAPL", 21, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionInsufficientMargin,
+		},
+		"it should accept buying to cover a short position with the collateral it releases": {
+			ledger: mockLedger{deposit(1000, testNow), trade(ljlib.TransactionTypeSell, "AAPL", 10, 100, testNow)},
+			limits: func(limits *risk.Limits) { limits.AllowShortSelling = true },
+			order:  order(ljlib.OrderSideBuy, "AAPL", 10, ljlib.OrderStatusNew, 0),
+		},
+		"it should reject the day trade of a pattern day trader below the minimum equity": {
+			ledger:         boughtToday,
+			order:          order(ljlib.OrderSideSell, "MSFT", 10, ljlib.OrderStatusNew, 0),
+			expectedReason: ljlib.RejectionPatternDayTrader,
+		},
+		"it should accept the trade of a pattern day trader which is not a day trade": {
+			ledger: boughtToday,
+			order:  order(ljlib.OrderSideBuy, "MSFT", 10, ljlib.OrderStatusNew, 0),
+		},
+		"it should accept the day trade of a pattern day trader with enough equity": {
+			ledger: boughtToday,
+			limits: func(limits *risk.Limits) { limits.PatternDayTraderMinEquity = decimal.NewFromInt(5000) },
+			order:  order(ljlib.OrderSideSell, "MSFT", 10, ljlib.OrderStatusNew, 0),
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			limits := risk.DefaultLimits()
+			if testCase.limits != nil {
+				testCase.limits(&limits)
+			}
+			checker := risk.NewChecker(testCase.ledger, testCase.orders, mockPriceSource{"AAPL": 100, "MSFT": 100}, limits,
+				trading.SimulatorConfig{})
+
+			err := checker.CheckOrder(testCase.order, decimal.NewFromInt(100), testNow)
+			if len(testCase.expectedReason) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var rejection ljlib.RejectionError
+			require.True(t, errors.As(err, &rejection), "expected rejection, got %v", err)
+			assert.Equal(t, testCase.expectedReason, rejection.Reason())
+		})
+	}
+}
+
+func deposit(amount int64, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Type:       ljlib.TransactionTypeDeposit,
+		Amount:     decimal.NewFromInt(amount),
+		ExecutedAt: at,
+	}
+}
+
+func trade(txType ljlib.TransactionType, ticker string, quantity int64, price int64, at time.Time) ljlib.Transaction {
+	return ljlib.Transaction{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Type:       txType,
+		Ticker:     ticker,
+		Quantity:   decimal.NewFromInt(quantity),
+		Price:      decimal.NewFromInt(price),
+		Commission: decimal.NewFromInt(1),
+		ExecutedAt: at,
+	}
+}
+
+func order(side ljlib.OrderSide, ticker string, quantity int64, status ljlib.OrderStatus, limitPrice int64) ljlib.Order {
+	orderType := ljlib.OrderTypeMarket
+	if limitPrice > 0 {
+		orderType = ljlib.OrderTypeLimit
+	}
+	return ljlib.Order{
+		ID:         uuid.New(),
+		UserID:     testUserID,
+		Ticker:     ticker,
+		Side:       side,
+		Type:       orderType,
+		Quantity:   decimal.NewFromInt(quantity),
+		LimitPrice: decimal.NewFromInt(limitPrice),
+		Status:     status,
+	}
+}
+
+type mockLedger []ljlib.Transaction
+
+func (m mockLedger) GetTransactions(userID uuid.UUID) ([]ljlib.Transaction, error) {
+	return m, nil
+}
+
+type mockOrderSource []ljlib.Order
+
+func (m mockOrderSource) ListOrders(userID uuid.UUID) ([]ljlib.Order, error) {
+	return m, nil
+}
+
+type mockPriceSource map[string]float64
+
+func (m mockPriceSource) GetPriceAt(ticker string, at time.Time) (decimal.Decimal, error) {
+	price, ok := m[ticker]
+	if !ok {
+		return decimal.Decimal{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return decimal.NewFromFloat(price), nil
+}
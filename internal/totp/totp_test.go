@@ -0,0 +1,81 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed from RFC 6238 Appendix B.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	//RFC 6238 test vectors, truncated to 6 digits
+	testCases := map[string]struct {
+		unixTime     int64
+		expectedCode string
+	}{
+		"it should match the RFC vector for T=59": {
+			unixTime:     59,
+			expectedCode: "287082",
+		},
+		"it should match the RFC vector for T=1111111109": {
+			unixTime:     1111111109,
+			expectedCode: "081804",
+		},
+		"it should match the RFC vector for T=2000000000": {
+			unixTime:     2000000000,
+			expectedCode: "279037",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			code, err := totp.Code(rfcSecret, time.Unix(testCase.unixTime, 0))
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedCode, code)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	testCases := map[string]struct {
+		codeAt        time.Time
+		code          string
+		expectedValid bool
+	}{
+		"it should accept the code for the current step": {
+			codeAt:        now,
+			expectedValid: true,
+		},
+		"it should accept the code for the previous step": {
+			codeAt:        now.Add(-totp.StepPeriod),
+			expectedValid: true,
+		},
+		"it should reject the code from two steps ago": {
+			codeAt: now.Add(-2 * totp.StepPeriod),
+		},
+		"it should reject malformed codes": {
+			code: "12345",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			code := testCase.code
+			if len(code) == 0 {
+				var err error
+				code, err = totp.Code(rfcSecret, testCase.codeAt)
+				require.NoError(t, err)
+			}
+			counter, ok := totp.Validate(rfcSecret, code, now)
+			assert.Equal(t, testCase.expectedValid, ok)
+			if ok {
+				assert.Equal(t, totp.Counter(testCase.codeAt), counter)
+			}
+		})
+	}
+}
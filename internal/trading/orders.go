@@ -0,0 +1,486 @@
+// Package trading implements the order lifecycle: validation, the order state machine, execution by
+// the execution engine, and posting of the fills into the user's ledger.
+package trading
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+type OrderStorage interface {
+	CreateOrder(order ljlib.Order) error
+	UpdateOrder(order ljlib.Order) error
+	GetOrder(orderID uuid.UUID) (*ljlib.Order, error)
+	ListOrders(userID uuid.UUID) ([]ljlib.Order, error)
+	ListOpenOrders() ([]ljlib.Order, error)
+}
+
+type Ledger interface {
+	PostTransaction(tx ljlib.Transaction) error
+}
+
+// averagePricePlaces is the precision the average fill price of the orders is rounded to.
+const averagePricePlaces = 6
+
+// InstrumentSource provides the reference data of the tickers, returning ljlib.IllegalArgumentError for unknown ones.
+type InstrumentSource interface {
+	GetInstrument(ticker string) (ljlib.Instrument, error)
+}
+
+// RiskChecker vets the orders before they are accepted, returning ljlib.RejectionError for the ones to be rejected.
+type RiskChecker interface {
+	CheckOrder(order ljlib.Order, marketPrice decimal.Decimal, at time.Time) error
+	//LockAccount locks the user's account, so that nothing else spends its buying power between the check
+	//of an order and its reservation, until the returned function is called.
+	LockAccount(userID uuid.UUID) func()
+}
+
+// AmendOrderRequest changes the quantity or the prices of an order, the zero values are left unchanged.
+type AmendOrderRequest struct {
+	//Version is the version of the order the amendment is based on.
+	Version    int64
+	Quantity   decimal.Decimal
+	LimitPrice decimal.Decimal
+	StopPrice  decimal.Decimal
+}
+
+type PlaceOrderRequest struct {
+	Ticker      string
+	Side        ljlib.OrderSide
+	Type        ljlib.OrderType
+	TimeInForce ljlib.TimeInForce
+	Quantity    decimal.Decimal
+	LimitPrice  decimal.Decimal
+	StopPrice   decimal.Decimal
+}
+
+// OrderService serializes all order mutations, so the state machine never races with itself.
+// Open orders are evaluated against the market since their last evaluation whenever they are read.
+type OrderService struct {
+	mu          sync.Mutex
+	orders      OrderStorage
+	ledger      Ledger
+	engine      ExecutionEngine
+	risk        RiskChecker
+	instruments InstrumentSource
+	clock       clock.Clock
+}
+
+func NewOrderService(orders OrderStorage, ledger Ledger, engine ExecutionEngine, risk RiskChecker,
+	instruments InstrumentSource, clock clock.Clock) *OrderService {
+	return &OrderService{
+		orders:      orders,
+		ledger:      ledger,
+		engine:      engine,
+		risk:        risk,
+		instruments: instruments,
+		clock:       clock,
+	}
+}
+
+// PlaceOrder validates, risk checks and executes the order. An order failing the risk checks is stored as rejected,
+// and returned together with ljlib.RejectionError.
+func (s *OrderService) PlaceOrder(userID uuid.UUID, request PlaceOrderRequest) (ljlib.Order, error) {
+	request.Ticker = strings.ToUpper(strings.TrimSpace(request.Ticker))
+	if len(request.TimeInForce) == 0 {
+		request.TimeInForce = ljlib.TimeInForceDay
+	}
+	instrument, err := s.getInstrument(request.Ticker)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	if err := validatePlaceOrderRequest(request, instrument); err != nil {
+		return ljlib.Order{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock := s.risk.LockAccount(userID)
+	defer unlock()
+
+	now := s.clock.Now()
+	if instrument.Option != nil && instrument.Option.IsExpiredAt(now) {
+		return ljlib.Order{}, ljlib.NewIllegalArgumentError("option [%s] has expired", request.Ticker)
+	}
+	marketPrice, err := s.engine.Quote(request.Ticker, now)
+	if err != nil {
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			return ljlib.Order{}, ljlib.NewIllegalArgumentError("unknown ticker [%s]", request.Ticker)
+		}
+		return ljlib.Order{}, fmt.Errorf("cannot get price for ticker [%s]: %w", request.Ticker, err)
+	}
+
+	order := ljlib.Order{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Ticker:      request.Ticker,
+		Side:        request.Side,
+		Type:        request.Type,
+		TimeInForce: request.TimeInForce,
+		Quantity:    request.Quantity,
+		LimitPrice:  request.LimitPrice,
+		StopPrice:   request.StopPrice,
+		Status:      ljlib.OrderStatusNew,
+		CreatedAt:   now,
+		EvaluatedAt: now,
+		PriorityAt:  now,
+	}
+	order.Record(ljlib.OrderEventCreated, now, nil)
+
+	//the order is only stored once it's checked, so that it's never left new, and unchecked, when the check fails
+	if err := s.risk.CheckOrder(order, marketPrice, now); err != nil {
+		var rejection ljlib.RejectionError
+		if !errors.As(err, &rejection) {
+			return ljlib.Order{}, fmt.Errorf("cannot check risk of order [%s]: %w", order.ID, err)
+		}
+		order.RejectReason = rejection.Reason()
+		if err := transition(&order, ljlib.OrderStatusRejected, now,
+			map[string]string{"reason": rejection.Reason(), "message": rejection.Error()}); err != nil {
+			return ljlib.Order{}, err
+		}
+		if err := s.orders.CreateOrder(order); err != nil {
+			return ljlib.Order{}, fmt.Errorf("cannot store order [%s]: %w", order.ID, err)
+		}
+		return order, rejection
+	}
+
+	if err := transition(&order, ljlib.OrderStatusAccepted, now, nil); err != nil {
+		return ljlib.Order{}, err
+	}
+	if err := s.orders.CreateOrder(order); err != nil {
+		return ljlib.Order{}, fmt.Errorf("cannot store order [%s]: %w", order.ID, err)
+	}
+	if err := s.execute(&order, now, now); err != nil {
+		return ljlib.Order{}, err
+	}
+	//whatever was not filled right away is cancelled for the immediate orders
+	if (order.TimeInForce == ljlib.TimeInForceIOC || order.TimeInForce == ljlib.TimeInForceFOK) && order.IsOpen() {
+		if err := transition(&order, ljlib.OrderStatusCancelled, now, nil); err != nil {
+			return ljlib.Order{}, err
+		}
+	}
+
+	if err := s.orders.UpdateOrder(order); err != nil {
+		return ljlib.Order{}, fmt.Errorf("cannot store order [%s]: %w", order.ID, err)
+	}
+	return order, nil
+}
+
+func (s *OrderService) CancelOrder(userID uuid.UUID, orderID uuid.UUID) (ljlib.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.getUserOrder(userID, orderID)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	now := s.clock.Now()
+	if err := s.evaluate(&order, now); err != nil {
+		return ljlib.Order{}, err
+	}
+	if !order.IsOpen() {
+		if err := s.orders.UpdateOrder(order); err != nil {
+			return ljlib.Order{}, fmt.Errorf("cannot store order [%s]: %w", order.ID, err)
+		}
+		return ljlib.Order{}, ljlib.NewConflictError("order [%s] is already %s", order.ID, order.Status)
+	}
+	if err := transition(&order, ljlib.OrderStatusCancelled, now, nil); err != nil {
+		return ljlib.Order{}, err
+	}
+	if err := s.orders.UpdateOrder(order); err != nil {
+		return ljlib.Order{}, fmt.Errorf("cannot store order [%s]: %w", order.ID, err)
+	}
+	return order, nil
+}
+
+// AmendOrder replaces the remaining quantity of an open order with the amended one: the fills are kept,
+// and only what's not filled yet gets the new quantity and prices. The amendment only applies to the version
+// of the order it's based on. It goes through the risk checks, and is returned with ljlib.RejectionError
+// when failing them, leaving the order unchanged.
+func (s *OrderService) AmendOrder(userID uuid.UUID, orderID uuid.UUID, request AmendOrderRequest) (ljlib.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock := s.risk.LockAccount(userID)
+	defer unlock()
+
+	order, err := s.getUserOrder(userID, orderID)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	//the order might have been filled since it was last read, which is taken into account before amending it
+	now := s.clock.Now()
+	if err := s.evaluateAndStore(&order, now); err != nil {
+		return ljlib.Order{}, err
+	}
+	if !order.IsOpen() {
+		return ljlib.Order{}, ljlib.NewConflictError("order [%s] is already %s", order.ID, order.Status)
+	}
+	if order.Version != request.Version {
+		return ljlib.Order{}, ljlib.NewPreconditionFailedError("order [%s] is at version %d, not %d",
+			order.ID, order.Version, request.Version)
+	}
+
+	instrument, err := s.getInstrument(order.Ticker)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	amended, err := amend(order, request, instrument)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	marketPrice, err := s.engine.Quote(order.Ticker, now)
+	if err != nil {
+		return ljlib.Order{}, fmt.Errorf("cannot get price for ticker [%s]: %w", order.Ticker, err)
+	}
+	if err := s.risk.CheckOrder(amended, marketPrice, now); err != nil {
+		var rejection ljlib.RejectionError
+		if !errors.As(err, &rejection) {
+			return ljlib.Order{}, fmt.Errorf("cannot check risk of order [%s]: %w", order.ID, err)
+		}
+		return order, rejection
+	}
+
+	details := map[string]string{
+		"previous_quantity": order.Quantity.String(),
+		"priority":          "kept",
+	}
+	if !order.LimitPrice.IsZero() {
+		details["previous_limit_price"] = order.LimitPrice.String()
+	}
+	if !order.StopPrice.IsZero() {
+		details["previous_stop_price"] = order.StopPrice.String()
+	}
+	//the order keeps its queue position only when the quantity is lowered
+	if amended.Quantity.GreaterThan(order.Quantity) || !amended.LimitPrice.Equal(order.LimitPrice) ||
+		!amended.StopPrice.Equal(order.StopPrice) {
+		amended.PriorityAt = now
+		details["priority"] = "lost"
+	}
+	amended.Record(ljlib.OrderEventAmended, now, details)
+
+	//the amended prices might be marketable right away
+	if err := s.execute(&amended, now, now); err != nil {
+		return ljlib.Order{}, err
+	}
+	if err := s.orders.UpdateOrder(amended); err != nil {
+		return ljlib.Order{}, fmt.Errorf("cannot store order [%s]: %w", amended.ID, err)
+	}
+	return amended, nil
+}
+
+func (s *OrderService) GetOrder(userID uuid.UUID, orderID uuid.UUID) (ljlib.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.getUserOrder(userID, orderID)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	if err := s.evaluateAndStore(&order, s.clock.Now()); err != nil {
+		return ljlib.Order{}, err
+	}
+	return order, nil
+}
+
+// ListOrders returns the user's orders, the most recent first.
+func (s *OrderService) ListOrders(userID uuid.UUID) ([]ljlib.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders, err := s.orders.ListOrders(userID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list orders: %w", err)
+	}
+	now := s.clock.Now()
+	for i := range orders {
+		if err := s.evaluateAndStore(&orders[i], now); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+// EvaluateOpenOrders tries to execute, or expires, the open orders of all users, so that they're filled
+// as the market moves, and not only once they're read. An order which cannot be evaluated is left for
+// the next run, without holding up the others.
+func (s *OrderService) EvaluateOpenOrders(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders, err := s.orders.ListOpenOrders()
+	if err != nil {
+		return fmt.Errorf("cannot list open orders: %w", err)
+	}
+	for i := range orders {
+		if err := s.evaluateAndStore(&orders[i], now); err != nil {
+			log.Printf("Cannot evaluate order [%s]: %s", orders[i].ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *OrderService) getInstrument(ticker string) (ljlib.Instrument, error) {
+	if len(ticker) == 0 {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("ticker is required")
+	}
+	instrument, err := s.instruments.GetInstrument(ticker)
+	if err != nil {
+		if errors.Is(err, ljlib.IllegalArgumentError{}) {
+			return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("unknown ticker [%s]", ticker)
+		}
+		return ljlib.Instrument{}, fmt.Errorf("cannot get instrument [%s]: %w", ticker, err)
+	}
+	return instrument, nil
+}
+
+func (s *OrderService) getUserOrder(userID uuid.UUID, orderID uuid.UUID) (ljlib.Order, error) {
+	order, err := s.orders.GetOrder(orderID)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	//other users' orders are reported as not found, not to disclose they exist
+	if order.UserID != userID {
+		return ljlib.Order{}, ljlib.NewNotFoundError("order not found: %s", orderID)
+	}
+	return *order, nil
+}
+
+func (s *OrderService) evaluateAndStore(order *ljlib.Order, now time.Time) error {
+	if !order.IsOpen() {
+		return nil
+	}
+	if err := s.evaluate(order, now); err != nil {
+		return err
+	}
+	if err := s.orders.UpdateOrder(*order); err != nil {
+		return fmt.Errorf("cannot store order [%s]: %w", order.ID, err)
+	}
+	return nil
+}
+
+// evaluate executes the order against the market since its last evaluation, and expires it
+// if its time in force is over.
+func (s *OrderService) evaluate(order *ljlib.Order, now time.Time) error {
+	if !order.IsOpen() {
+		return nil
+	}
+	//day orders are only executed until the last moment of the day they were placed on
+	endOfDay := endOfDay(order.CreatedAt)
+	expired := order.TimeInForce == ljlib.TimeInForceDay && !now.Before(endOfDay)
+	until := now
+	if expired {
+		until = endOfDay.Add(-time.Nanosecond)
+	}
+	if until.After(order.EvaluatedAt) {
+		if err := s.execute(order, order.EvaluatedAt, until); err != nil {
+			return err
+		}
+	}
+	if expired && order.IsOpen() {
+		return transition(order, ljlib.OrderStatusExpired, endOfDay, nil)
+	}
+	return nil
+}
+
+// execute applies to the order whatever the execution engine matched within the window.
+func (s *OrderService) execute(order *ljlib.Order, from time.Time, to time.Time) error {
+	match, err := s.engine.Match(*order, from, to)
+	if err != nil {
+		return fmt.Errorf("cannot match order [%s]: %w", order.ID, err)
+	}
+	if !match.TriggeredAt.IsZero() {
+		order.Triggered = true
+		order.Record(ljlib.OrderEventTriggered, match.TriggeredAt, nil)
+	}
+	for _, execution := range match.Executions {
+		if err := s.fill(order, execution.Quantity, execution.Price, execution.Commission, execution.At); err != nil {
+			return err
+		}
+	}
+	order.EvaluatedAt = to
+	return nil
+}
+
+// fill records the fill on the order and posts it into the user's ledger.
+func (s *OrderService) fill(order *ljlib.Order, quantity decimal.Decimal, price decimal.Decimal,
+	commission decimal.Decimal, at time.Time) error {
+	filled := order.FilledQuantity.Add(quantity)
+	next := ljlib.OrderStatusPartiallyFilled
+	if filled.Equal(order.Quantity) {
+		next = ljlib.OrderStatusFilled
+	}
+	if !order.Status.CanTransitionTo(next) {
+		return ljlib.NewConflictError("order [%s] cannot change status from %s to %s", order.ID, order.Status, next)
+	}
+
+	fill := ljlib.Fill{
+		ID:         uuid.New(),
+		Quantity:   quantity,
+		Price:      price,
+		Commission: commission,
+		ExecutedAt: at,
+	}
+	txType := ljlib.TransactionTypeBuy
+	if order.Side == ljlib.OrderSideSell {
+		txType = ljlib.TransactionTypeSell
+	}
+	instrument, err := s.getInstrument(order.Ticker)
+	if err != nil {
+		return err
+	}
+	err = s.ledger.PostTransaction(ljlib.Transaction{
+		ID:         fill.ID,
+		UserID:     order.UserID,
+		OrderID:    order.ID,
+		Type:       txType,
+		Ticker:     order.Ticker,
+		Quantity:   quantity,
+		Price:      price,
+		Commission: commission,
+		Currency:   instrument.Currency,
+		ExecutedAt: at,
+		SettlesAt:  instrument.SettlementDate(at),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot post fill of order [%s] to ledger: %w", order.ID, err)
+	}
+
+	order.FilledQuantity = filled
+	order.Fills = append(order.Fills, fill)
+	//average price is weighted by the quantity of each fill, and computed from all of them, so it never drifts
+	notional := decimal.Zero
+	for _, f := range order.Fills {
+		notional = notional.Add(f.Price.Mul(f.Quantity))
+	}
+	order.AveragePrice = notional.DivRound(filled, averagePricePlaces)
+	return transition(order, next, at, map[string]string{
+		"fill_id":    fill.ID.String(),
+		"quantity":   quantity.String(),
+		"price":      price.String(),
+		"commission": commission.String(),
+	})
+}
+
+// transition moves the order to the next status, recording the change in the order's history.
+func transition(order *ljlib.Order, next ljlib.OrderStatus, at time.Time, details map[string]string) error {
+	if !order.Status.CanTransitionTo(next) {
+		return ljlib.NewConflictError("order [%s] cannot change status from %s to %s", order.ID, order.Status, next)
+	}
+	order.Status = next
+	order.Record(ljlib.OrderEventType(next), at, details)
+	return nil
+}
+
+func endOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location()).AddDate(0, 0, 1)
+}
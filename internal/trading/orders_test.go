@@ -0,0 +1,454 @@
+package trading_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/datasource"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testUserID = uuid.MustParse("8a8d28aa-6c15-43be-8363-eb9862466063")
+	testStart  = time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)
+)
+
+func TestOrderService_PlaceOrder(t *testing.T) {
+	testCases := map[string]struct {
+		request                trading.PlaceOrderRequest
+		expectedError          bool
+		expectedStatus         ljlib.OrderStatus
+		expectedTransactions   int
+		expectedAveragePrice   string
+		expectedTriggeredOrder bool
+	}{
+		"it should return IllegalArgumentError for fractional quantity of an instrument traded in whole shares": {
+			request: trading.PlaceOrderRequest{Ticker: "BABA", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeMarket,
+				Quantity: decimal.RequireFromString("1.5")},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for quantity more precise than the instrument": {
+			request:       marketOrder(ljlib.OrderSideBuy, "0.1234567"),
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for an unknown ticker": {
+			request: trading.PlaceOrderRequest{Ticker: "XXX", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeMarket,
+				Quantity: decimal.NewFromInt(1)},
+			expectedError: true,
+		},
+		"it should fill a market order for fractional shares": {
+			request:              marketOrder(ljlib.OrderSideBuy, "0.123456"),
+			expectedStatus:       ljlib.OrderStatusFilled,
+			expectedTransactions: 1,
+			expectedAveragePrice: "150",
+		},
+		"it should keep a limit order for fractional shares open": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.RequireFromString("1.5"), LimitPrice: decimal.NewFromInt(160)},
+			expectedStatus: ljlib.OrderStatusAccepted,
+		},
+		"it should return IllegalArgumentError for limit price on a market order": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeMarket,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(100)},
+			expectedError: true,
+		},
+		"it should return IllegalArgumentError for immediate stop orders": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeStop,
+				TimeInForce: ljlib.TimeInForceIOC, Quantity: decimal.NewFromInt(1), StopPrice: decimal.NewFromInt(100)},
+			expectedError: true,
+		},
+		"it should fill a market order at the market price and post it to the ledger": {
+			request:              marketOrder(ljlib.OrderSideBuy, "10"),
+			expectedStatus:       ljlib.OrderStatusFilled,
+			expectedTransactions: 1,
+			expectedAveragePrice: "150",
+		},
+		"it should fill a marketable limit order at the better market price": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(160)},
+			expectedStatus:       ljlib.OrderStatusFilled,
+			expectedTransactions: 1,
+			expectedAveragePrice: "150",
+		},
+		"it should keep a non-marketable limit order open": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(160)},
+			expectedStatus: ljlib.OrderStatusAccepted,
+		},
+		"it should cancel a non-marketable IOC limit order": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeLimit,
+				TimeInForce: ljlib.TimeInForceIOC, Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(140)},
+			expectedStatus: ljlib.OrderStatusCancelled,
+		},
+		"it should trigger and fill a stop order once the price is reached": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeStop,
+				Quantity: decimal.NewFromInt(1), StopPrice: decimal.NewFromInt(155)},
+			expectedStatus:         ljlib.OrderStatusFilled,
+			expectedTransactions:   1,
+			expectedAveragePrice:   "150",
+			expectedTriggeredOrder: true,
+		},
+		"it should trigger a stop-limit order, but keep it open while the limit is not reached": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeStopLimit,
+				Quantity: decimal.NewFromInt(1), StopPrice: decimal.NewFromInt(145), LimitPrice: decimal.NewFromInt(148)},
+			expectedStatus:         ljlib.OrderStatusAccepted,
+			expectedTriggeredOrder: true,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ledger := &mockLedger{}
+			service := newTestOrderService(t, ledger, mockPriceSource{"AAPL": 150}, trading.SimulatorConfig{TickInterval: time.Minute},
+				clock.NewVirtual(testStart))
+			order, err := service.PlaceOrder(testUserID, testCase.request)
+			if testCase.expectedError {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ljlib.IllegalArgumentError{}))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedStatus, order.Status)
+			assert.Equal(t, testCase.expectedTriggeredOrder, order.Triggered)
+			assert.Len(t, ledger.transactions, testCase.expectedTransactions)
+			if len(testCase.expectedAveragePrice) > 0 {
+				assert.Equal(t, testCase.expectedAveragePrice, order.AveragePrice.String())
+				assert.True(t, order.FilledQuantity.Equal(order.Quantity))
+			}
+		})
+	}
+}
+
+func TestOrderService_PlaceOrderRejected(t *testing.T) {
+	simulator, err := trading.NewSimulator(mockPriceSource{"AAPL": 150}, trading.SimulatorConfig{TickInterval: time.Minute})
+	require.NoError(t, err)
+	ledger := &mockLedger{}
+	service := trading.NewOrderService(datasource.NewLocalOrderStorage(), ledger, simulator, mockRiskChecker{maxQuantity: 5},
+		testInstruments, clock.NewVirtual(testStart))
+
+	order, err := service.PlaceOrder(testUserID, marketOrder(ljlib.OrderSideBuy, "6"))
+	var rejection ljlib.RejectionError
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, ljlib.RejectionQuantityLimit, rejection.Reason())
+	assert.Equal(t, ljlib.OrderStatusRejected, order.Status)
+	assert.Equal(t, ljlib.RejectionQuantityLimit, order.RejectReason)
+	assert.Empty(t, ledger.transactions)
+
+	stored, err := service.GetOrder(testUserID, order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusRejected, stored.Status)
+}
+
+func TestOrderService_PlaceOrderRiskCheckFailed(t *testing.T) {
+	simulator, err := trading.NewSimulator(mockPriceSource{"AAPL": 150}, trading.SimulatorConfig{TickInterval: time.Minute})
+	require.NoError(t, err)
+	ledger := &mockLedger{}
+	service := trading.NewOrderService(datasource.NewLocalOrderStorage(), ledger, simulator,
+		mockRiskChecker{err: errors.New("cannot get transactions")}, testInstruments, clock.NewVirtual(testStart))
+
+	_, err = service.PlaceOrder(testUserID, marketOrder(ljlib.OrderSideBuy, "1"))
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &ljlib.RejectionError{}))
+
+	//the order which couldn't be checked is not stored, so it can't be filled later on
+	orders, err := service.ListOrders(testUserID)
+	require.NoError(t, err)
+	assert.Empty(t, orders)
+	require.NoError(t, service.EvaluateOpenOrders(testStart.Add(time.Hour)))
+	assert.Empty(t, ledger.transactions)
+}
+
+func TestOrderService_CancelOrder(t *testing.T) {
+	service := newTestOrderService(t, &mockLedger{}, mockPriceSource{"AAPL": 150}, trading.SimulatorConfig{TickInterval: time.Minute},
+		clock.NewVirtual(testStart))
+
+	open, err := service.PlaceOrder(testUserID, trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy,
+		Type: ljlib.OrderTypeLimit, TimeInForce: ljlib.TimeInForceGTC, Quantity: decimal.NewFromInt(1),
+		LimitPrice: decimal.NewFromInt(100)})
+	require.NoError(t, err)
+
+	_, err = service.CancelOrder(uuid.New(), open.ID)
+	assert.True(t, errors.Is(err, ljlib.NotFoundError{}), "other users should not be able to cancel the order")
+
+	cancelled, err := service.CancelOrder(testUserID, open.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusCancelled, cancelled.Status)
+
+	_, err = service.CancelOrder(testUserID, open.ID)
+	assert.True(t, errors.Is(err, ljlib.ConflictError{}), "a cancelled order should not be cancelled again")
+
+	filled, err := service.PlaceOrder(testUserID, marketOrder(ljlib.OrderSideBuy, "1"))
+	require.NoError(t, err)
+	_, err = service.CancelOrder(testUserID, filled.ID)
+	assert.True(t, errors.Is(err, ljlib.ConflictError{}), "a filled order should not be cancelled")
+}
+
+func TestOrderService_AmendOrder(t *testing.T) {
+	restingBuy := trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeLimit,
+		TimeInForce: ljlib.TimeInForceGTC, Quantity: decimal.NewFromInt(10), LimitPrice: decimal.NewFromInt(140)}
+	testCases := map[string]struct {
+		partialFills     bool
+		amendment        trading.AmendOrderRequest
+		expectedError    error
+		expectedStatus   ljlib.OrderStatus
+		expectedFilled   string
+		expectedPriority string
+	}{
+		"it should keep the queue position when lowering the quantity": {
+			amendment:        trading.AmendOrderRequest{Quantity: decimal.NewFromInt(5)},
+			expectedStatus:   ljlib.OrderStatusAccepted,
+			expectedFilled:   "0",
+			expectedPriority: "kept",
+		},
+		"it should lose the queue position when changing the price": {
+			amendment:        trading.AmendOrderRequest{LimitPrice: decimal.NewFromInt(145)},
+			expectedStatus:   ljlib.OrderStatusAccepted,
+			expectedFilled:   "0",
+			expectedPriority: "lost",
+		},
+		"it should fill the order right away when the amended price is marketable": {
+			amendment:        trading.AmendOrderRequest{LimitPrice: decimal.NewFromInt(155)},
+			expectedStatus:   ljlib.OrderStatusFilled,
+			expectedFilled:   "10",
+			expectedPriority: "lost",
+		},
+		"it should keep the fills of a partially filled order": {
+			partialFills:     true,
+			amendment:        trading.AmendOrderRequest{Quantity: decimal.NewFromInt(20), LimitPrice: decimal.NewFromInt(139)},
+			expectedStatus:   ljlib.OrderStatusPartiallyFilled,
+			expectedFilled:   "3",
+			expectedPriority: "lost",
+		},
+		"it should return IllegalArgumentError for quantity not above the filled quantity": {
+			partialFills:  true,
+			amendment:     trading.AmendOrderRequest{Quantity: decimal.NewFromInt(3), LimitPrice: decimal.NewFromInt(140)},
+			expectedError: ljlib.IllegalArgumentError{},
+		},
+		"it should return IllegalArgumentError for an amendment changing nothing": {
+			amendment:     trading.AmendOrderRequest{Quantity: decimal.NewFromInt(10)},
+			expectedError: ljlib.IllegalArgumentError{},
+		},
+		"it should return IllegalArgumentError for stop price on a limit order": {
+			amendment:     trading.AmendOrderRequest{StopPrice: decimal.NewFromInt(100)},
+			expectedError: ljlib.IllegalArgumentError{},
+		},
+		"it should return RejectionError for an amendment failing the risk checks": {
+			amendment:     trading.AmendOrderRequest{Quantity: decimal.NewFromInt(50)},
+			expectedError: ljlib.RejectionError{},
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			virtualClock := clock.NewVirtual(testStart)
+			//the price falls by a dollar a minute, reaching the limit price in 10 minutes
+			prices := mockPricePath{start: 150, peak: 150}
+			config := trading.SimulatorConfig{TickInterval: time.Minute}
+			if testCase.partialFills {
+				config.MaxQuantityPerTick = decimal.NewFromInt(3)
+			}
+			simulator, err := trading.NewSimulator(prices, config)
+			require.NoError(t, err)
+			service := trading.NewOrderService(datasource.NewLocalOrderStorage(), &mockLedger{}, simulator,
+				mockRiskChecker{maxQuantity: 20}, testInstruments, virtualClock)
+
+			placed, err := service.PlaceOrder(testUserID, restingBuy)
+			require.NoError(t, err)
+			if testCase.partialFills {
+				virtualClock.Advance(10 * time.Minute)
+				placed, err = service.GetOrder(testUserID, placed.ID)
+				require.NoError(t, err)
+				require.Equal(t, ljlib.OrderStatusPartiallyFilled, placed.Status)
+			} else {
+				virtualClock.Advance(time.Minute)
+			}
+
+			amendment := testCase.amendment
+			amendment.Version = placed.Version
+			amended, err := service.AmendOrder(testUserID, placed.ID, amendment)
+			if testCase.expectedError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, testCase.expectedError), "unexpected error: %v", err)
+				stored, err := service.GetOrder(testUserID, placed.ID)
+				require.NoError(t, err)
+				assert.Equal(t, placed.Version, stored.Version, "failed amendment should leave the order unchanged")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedStatus, amended.Status)
+			assert.Equal(t, testCase.expectedFilled, amended.FilledQuantity.String())
+			assert.Greater(t, amended.Version, placed.Version)
+
+			var amendedEvent *ljlib.OrderEvent
+			for i, event := range amended.Events {
+				assert.Equal(t, int64(i+1), event.Version)
+				if event.Type == ljlib.OrderEventAmended {
+					amendedEvent = &amended.Events[i]
+				}
+			}
+			require.NotNil(t, amendedEvent)
+			assert.Equal(t, testCase.expectedPriority, amendedEvent.Details["priority"])
+			assert.Equal(t, testCase.expectedPriority == "kept", amended.PriorityAt.Equal(placed.PriorityAt))
+		})
+	}
+}
+
+func TestOrderService_AmendOrderConcurrency(t *testing.T) {
+	service := newTestOrderService(t, &mockLedger{}, mockPriceSource{"AAPL": 150}, trading.SimulatorConfig{TickInterval: time.Minute},
+		clock.NewVirtual(testStart))
+	placed, err := service.PlaceOrder(testUserID, trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy,
+		Type: ljlib.OrderTypeLimit, TimeInForce: ljlib.TimeInForceGTC, Quantity: decimal.NewFromInt(10),
+		LimitPrice: decimal.NewFromInt(140)})
+	require.NoError(t, err)
+
+	_, err = service.AmendOrder(testUserID, placed.ID, trading.AmendOrderRequest{Version: placed.Version,
+		Quantity: decimal.NewFromInt(8)})
+	require.NoError(t, err)
+
+	_, err = service.AmendOrder(testUserID, placed.ID, trading.AmendOrderRequest{Version: placed.Version,
+		Quantity: decimal.NewFromInt(6)})
+	assert.True(t, errors.Is(err, ljlib.PreconditionFailedError{}), "amendment of a stale version should fail")
+
+	_, err = service.AmendOrder(uuid.New(), placed.ID, trading.AmendOrderRequest{Version: placed.Version + 1,
+		Quantity: decimal.NewFromInt(6)})
+	assert.True(t, errors.Is(err, ljlib.NotFoundError{}))
+
+	cancelled, err := service.CancelOrder(testUserID, placed.ID)
+	require.NoError(t, err)
+	_, err = service.AmendOrder(testUserID, placed.ID, trading.AmendOrderRequest{Version: cancelled.Version,
+		Quantity: decimal.NewFromInt(6)})
+	assert.True(t, errors.Is(err, ljlib.ConflictError{}), "cancelled order should not be amended")
+
+	var types []ljlib.OrderEventType
+	for _, event := range cancelled.Events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []ljlib.OrderEventType{ljlib.OrderEventCreated, ljlib.OrderEventType(ljlib.OrderStatusAccepted),
+		ljlib.OrderEventAmended, ljlib.OrderEventType(ljlib.OrderStatusCancelled)}, types)
+}
+
+func TestOrderStatus_CanTransitionTo(t *testing.T) {
+	assert.True(t, ljlib.OrderStatusNew.CanTransitionTo(ljlib.OrderStatusRejected))
+	assert.True(t, ljlib.OrderStatusPartiallyFilled.CanTransitionTo(ljlib.OrderStatusPartiallyFilled))
+	assert.False(t, ljlib.OrderStatusNew.CanTransitionTo(ljlib.OrderStatusFilled))
+	assert.False(t, ljlib.OrderStatusAccepted.CanTransitionTo(ljlib.OrderStatusRejected))
+	for _, terminal := range []ljlib.OrderStatus{ljlib.OrderStatusFilled, ljlib.OrderStatusCancelled,
+		ljlib.OrderStatusRejected, ljlib.OrderStatusExpired} {
+		assert.True(t, terminal.IsTerminal())
+		assert.False(t, terminal.CanTransitionTo(ljlib.OrderStatusCancelled))
+	}
+}
+
+func TestOrderService_EvaluateOpenOrders(t *testing.T) {
+	virtualClock := clock.NewVirtual(testStart)
+	ledger := &mockLedger{}
+	service := newTestOrderService(t, ledger, mockPricePath{start: 100, peak: 110},
+		trading.SimulatorConfig{TickInterval: time.Minute}, virtualClock)
+	placed, err := service.PlaceOrder(testUserID, trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell,
+		Type: ljlib.OrderTypeLimit, Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(105)})
+	require.NoError(t, err)
+
+	virtualClock.Advance(3 * time.Minute)
+	require.NoError(t, service.EvaluateOpenOrders(virtualClock.Now()))
+	assert.Empty(t, ledger.transactions, "the limit is not reached yet")
+
+	virtualClock.Advance(3 * time.Minute)
+	require.NoError(t, service.EvaluateOpenOrders(virtualClock.Now()))
+	//the fill is posted without the order being read
+	require.Len(t, ledger.transactions, 1)
+	assert.Equal(t, testStart.Add(5*time.Minute), ledger.transactions[0].ExecutedAt)
+
+	order, err := service.GetOrder(testUserID, placed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusFilled, order.Status)
+}
+
+func newTestOrderService(t *testing.T, ledger trading.Ledger, prices trading.IntradayPriceSource,
+	config trading.SimulatorConfig, clock clock.Clock) *trading.OrderService {
+	simulator, err := trading.NewSimulator(prices, config)
+	require.NoError(t, err)
+	return trading.NewOrderService(datasource.NewLocalOrderStorage(), ledger, simulator, mockRiskChecker{},
+		testInstruments, clock)
+}
+
+func marketOrder(side ljlib.OrderSide, quantity string) trading.PlaceOrderRequest {
+	return trading.PlaceOrderRequest{
+		Ticker:   "AAPL",
+		Side:     side,
+		Type:     ljlib.OrderTypeMarket,
+		Quantity: decimal.RequireFromString(quantity),
+	}
+}
+
+// testInstruments are traded in fractional shares, apart from BABA.
+var testInstruments = mockInstrumentSource{"AAPL": 6, "BABA": 0}
+
+type mockInstrumentSource map[string]int32
+
+func (m mockInstrumentSource) GetInstrument(ticker string) (ljlib.Instrument, error) {
+	precision, ok := m[ticker]
+	if !ok {
+		return ljlib.Instrument{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return ljlib.Instrument{Ticker: ticker, QuantityPrecision: precision}, nil
+}
+
+// mockRiskChecker rejects the orders for more than maxQuantity shares, when it's set, and fails to check
+// any of them with err.
+type mockRiskChecker struct {
+	maxQuantity int64
+	err         error
+}
+
+func (m mockRiskChecker) CheckOrder(order ljlib.Order, marketPrice decimal.Decimal, at time.Time) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.maxQuantity > 0 && order.Quantity.GreaterThan(decimal.NewFromInt(m.maxQuantity)) {
+		return ljlib.NewRejectionError(ljlib.RejectionQuantityLimit, "too many shares")
+	}
+	return nil
+}
+
+func (m mockRiskChecker) LockAccount(uuid.UUID) func() {
+	return func() {}
+}
+
+type mockLedger struct {
+	transactions []ljlib.Transaction
+}
+
+func (m *mockLedger) PostTransaction(tx ljlib.Transaction) error {
+	m.transactions = append(m.transactions, tx)
+	return nil
+}
+
+type mockPriceSource map[string]float64
+
+func (m mockPriceSource) GetIntradayPrice(ticker string, at time.Time) (decimal.Decimal, error) {
+	price, ok := m[ticker]
+	if !ok {
+		return decimal.Decimal{}, ljlib.NewIllegalArgumentError("invalid ticker")
+	}
+	return decimal.NewFromFloat(price), nil
+}
+
+// mockPricePath moves the price of every ticker by a dollar each minute since testStart, up to the peak, and back down.
+type mockPricePath struct {
+	start float64
+	peak  float64
+}
+
+func (m mockPricePath) GetIntradayPrice(ticker string, at time.Time) (decimal.Decimal, error) {
+	minutes := at.Sub(testStart).Minutes()
+	price := m.start + minutes
+	if price > m.peak {
+		price = 2*m.peak - price
+	}
+	return decimal.NewFromFloat(price), nil
+}
@@ -0,0 +1,222 @@
+package trading
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// ExecutionEngine decides when and at what price orders get executed.
+type ExecutionEngine interface {
+	//Quote returns the market price of the ticker at the given moment.
+	Quote(ticker string, at time.Time) (decimal.Decimal, error)
+	//Match checks the market in the window (from, to] against the order. When from equals to, only that moment is checked.
+	Match(order ljlib.Order, from time.Time, to time.Time) (Match, error)
+}
+
+// Match is what happened to an order within the checked window.
+type Match struct {
+	//TriggeredAt is set when the stop price of the order was reached within the window.
+	TriggeredAt time.Time
+	Executions  []Execution
+}
+
+type Execution struct {
+	Quantity   decimal.Decimal
+	Price      decimal.Decimal
+	Commission decimal.Decimal
+	At         time.Time
+}
+
+// MaxTicksPerMatch bounds the prices checked by a single match. A longer window, e.g. of an order resting
+// over days or of the service catching up after a downtime, is checked at a coarser step, a multiple
+// of the tick interval spread evenly over it.
+const MaxTicksPerMatch = 1440
+
+// IntradayPriceSource generates the price of a ticker at any moment of the day.
+type IntradayPriceSource interface {
+	GetIntradayPrice(ticker string, at time.Time) (decimal.Decimal, error)
+}
+
+// CommissionSchedule charges a fee per share and a rate of the notional, bounded by the minimum and maximum.
+// Zero maximum means the commission is not capped.
+type CommissionSchedule struct {
+	PerShare decimal.Decimal
+	Rate     decimal.Decimal
+	Minimum  decimal.Decimal
+	Maximum  decimal.Decimal
+}
+
+func (c CommissionSchedule) Calculate(quantity decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	commission := c.PerShare.Mul(quantity).Add(c.Rate.Mul(quantity).Mul(price))
+	if commission.LessThan(c.Minimum) {
+		commission = c.Minimum
+	}
+	if c.Maximum.IsPositive() && commission.GreaterThan(c.Maximum) {
+		commission = c.Maximum
+	}
+	return commission.Round(2)
+}
+
+type SimulatorConfig struct {
+	//SlippageBps moves the execution price against the order, in basis points of the market price.
+	SlippageBps decimal.Decimal
+	Commission  CommissionSchedule
+	//OptionCommission is charged for the fills of the option orders instead, with PerShare charged per contract.
+	OptionCommission CommissionSchedule
+	//TickInterval is the step of the generated intraday prices the resting orders are checked against.
+	TickInterval time.Duration
+	//MaxQuantityPerTick limits the liquidity available at each tick, producing partial fills. Zero means unlimited.
+	MaxQuantityPerTick decimal.Decimal
+}
+
+func DefaultSimulatorConfig() SimulatorConfig {
+	return SimulatorConfig{
+		SlippageBps: decimal.NewFromInt(5),
+		Commission: CommissionSchedule{
+			PerShare: decimal.RequireFromString("0.005"),
+			Minimum:  decimal.NewFromInt(1),
+		},
+		OptionCommission: CommissionSchedule{
+			PerShare: decimal.RequireFromString("0.65"),
+		},
+		TickInterval: time.Minute,
+	}
+}
+
+// Simulator executes orders against the generated intraday prices, without any real broker.
+// Given the same prices and the same window, it always produces the same executions.
+type Simulator struct {
+	prices IntradayPriceSource
+	config SimulatorConfig
+}
+
+func NewSimulator(prices IntradayPriceSource, config SimulatorConfig) (*Simulator, error) {
+	if config.TickInterval <= 0 {
+		return nil, fmt.Errorf("tick interval must be positive")
+	}
+	if config.SlippageBps.IsNegative() {
+		return nil, fmt.Errorf("slippage cannot be negative")
+	}
+	return &Simulator{
+		prices: prices,
+		config: config,
+	}, nil
+}
+
+func (s *Simulator) Quote(ticker string, at time.Time) (decimal.Decimal, error) {
+	return s.prices.GetIntradayPrice(ticker, at)
+}
+
+func (s *Simulator) Match(order ljlib.Order, from time.Time, to time.Time) (Match, error) {
+	var match Match
+	triggered := order.Triggered
+	remaining := order.RemainingQuantity()
+	for _, at := range s.ticks(from, to) {
+		if !remaining.IsPositive() {
+			break
+		}
+		marketPrice, err := s.prices.GetIntradayPrice(order.Ticker, at)
+		if err != nil {
+			return Match{}, fmt.Errorf("cannot get price for ticker [%s]: %w", order.Ticker, err)
+		}
+		if isStopOrder(order.Type) && !triggered {
+			if !stopReached(order, marketPrice) {
+				continue
+			}
+			triggered = true
+			match.TriggeredAt = at
+		}
+		price, ok := s.executionPrice(order, marketPrice)
+		if !ok {
+			continue
+		}
+
+		quantity := remaining
+		if s.config.MaxQuantityPerTick.IsPositive() && quantity.GreaterThan(s.config.MaxQuantityPerTick) {
+			//fill or kill orders are executed in full, or not at all
+			if order.TimeInForce == ljlib.TimeInForceFOK {
+				continue
+			}
+			quantity = s.config.MaxQuantityPerTick
+		}
+		match.Executions = append(match.Executions, Execution{
+			Quantity:   quantity,
+			Price:      price,
+			Commission: s.commission(order.Ticker, quantity, price),
+			At:         at,
+		})
+		remaining = remaining.Sub(quantity)
+	}
+	return match, nil
+}
+
+// ticks returns the moments aligned to the tick interval within (from, to], and to itself. At most
+// MaxTicksPerMatch of them are returned before to, the step growing with the window.
+func (s *Simulator) ticks(from time.Time, to time.Time) []time.Time {
+	if !from.Before(to) {
+		return []time.Time{to}
+	}
+	step := s.config.TickInterval
+	if count := int64(to.Sub(from) / step); count > MaxTicksPerMatch {
+		step *= time.Duration((count + MaxTicksPerMatch - 1) / MaxTicksPerMatch)
+	}
+	var ticks []time.Time
+	for at := from.Truncate(step).Add(step); at.Before(to); at = at.Add(step) {
+		ticks = append(ticks, at)
+	}
+	return append(ticks, to)
+}
+
+// executionPrice applies the slippage to the market price, and reports whether the order is executable at it.
+// Limit orders are executed once the market crosses the limit price, but never at a price worse than the limit.
+func (s *Simulator) executionPrice(order ljlib.Order, marketPrice decimal.Decimal) (decimal.Decimal, bool) {
+	slippage := marketPrice.Mul(s.config.SlippageBps).Div(decimal.NewFromInt(10000))
+	price := marketPrice.Add(slippage)
+	if order.Side == ljlib.OrderSideSell {
+		price = marketPrice.Sub(slippage)
+	}
+	price = price.Round(4)
+
+	switch order.Type {
+	case ljlib.OrderTypeLimit, ljlib.OrderTypeStopLimit:
+		if order.Side == ljlib.OrderSideBuy {
+			if marketPrice.GreaterThan(order.LimitPrice) {
+				return decimal.Decimal{}, false
+			}
+			return decimal.Min(price, order.LimitPrice), true
+		}
+		if marketPrice.LessThan(order.LimitPrice) {
+			return decimal.Decimal{}, false
+		}
+		return decimal.Max(price, order.LimitPrice), true
+	}
+	return price, true
+}
+
+// commission charges the fill according to the schedule of the traded instrument.
+func (s *Simulator) commission(ticker string, quantity decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	return s.config.CommissionFor(ticker, quantity, price)
+}
+
+// CommissionFor returns the commission of a fill according to the schedule of the traded instrument.
+func (c SimulatorConfig) CommissionFor(ticker string, quantity decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	if _, ok := ljlib.ParseOptionSymbol(ticker); ok {
+		return c.OptionCommission.Calculate(quantity, price)
+	}
+	return c.Commission.Calculate(quantity, price)
+}
+
+func isStopOrder(orderType ljlib.OrderType) bool {
+	return orderType == ljlib.OrderTypeStop || orderType == ljlib.OrderTypeStopLimit
+}
+
+// stopReached reports whether the market reached the stop price: rising to it for buys, falling to it for sells.
+func stopReached(order ljlib.Order, marketPrice decimal.Decimal) bool {
+	if order.Side == ljlib.OrderSideBuy {
+		return marketPrice.GreaterThanOrEqual(order.StopPrice)
+	}
+	return marketPrice.LessThanOrEqual(order.StopPrice)
+}
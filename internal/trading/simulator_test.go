@@ -0,0 +1,221 @@
+package trading_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/internal/clock"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommissionSchedule_Calculate(t *testing.T) {
+	schedule := trading.CommissionSchedule{
+		PerShare: decimal.RequireFromString("0.01"),
+		Rate:     decimal.RequireFromString("0.001"),
+		Minimum:  decimal.NewFromInt(1),
+		Maximum:  decimal.NewFromInt(20),
+	}
+	testCases := map[string]struct {
+		quantity           int64
+		price              int64
+		expectedCommission string
+	}{
+		"it should charge the minimum for small orders":      {quantity: 1, price: 100, expectedCommission: "1"},
+		"it should charge per share and the notional rate":   {quantity: 100, price: 50, expectedCommission: "6"},
+		"it should cap the commission at the maximum amount": {quantity: 1000, price: 100, expectedCommission: "20"},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			commission := schedule.Calculate(decimal.NewFromInt(testCase.quantity), decimal.NewFromInt(testCase.price))
+			assert.Equal(t, testCase.expectedCommission, commission.String())
+		})
+	}
+}
+
+func TestSimulator_MarketOrderSlippageAndCommission(t *testing.T) {
+	ledger := &mockLedger{}
+	config := trading.SimulatorConfig{
+		SlippageBps:  decimal.NewFromInt(10),
+		Commission:   trading.CommissionSchedule{PerShare: decimal.RequireFromString("0.01"), Minimum: decimal.NewFromInt(1)},
+		TickInterval: time.Minute,
+	}
+	service := newTestOrderService(t, ledger, mockPriceSource{"AAPL": 100}, config, clock.NewVirtual(testStart))
+
+	buy, err := service.PlaceOrder(testUserID, marketOrder(ljlib.OrderSideBuy, "500"))
+	require.NoError(t, err)
+	assert.Equal(t, "100.1", buy.AveragePrice.String())
+	assert.Equal(t, "5", buy.Fills[0].Commission.String())
+
+	sell, err := service.PlaceOrder(testUserID, marketOrder(ljlib.OrderSideSell, "10"))
+	require.NoError(t, err)
+	assert.Equal(t, "99.9", sell.AveragePrice.String())
+	assert.Equal(t, "1", sell.Fills[0].Commission.String())
+
+	require.Len(t, ledger.transactions, 2)
+	assert.Equal(t, "5", ledger.transactions[0].Commission.String())
+	assert.Equal(t, testStart, ledger.transactions[0].ExecutedAt)
+}
+
+func TestSimulator_RestingOrders(t *testing.T) {
+	testCases := map[string]struct {
+		request          trading.PlaceOrderRequest
+		prices           mockPricePath
+		advance          time.Duration
+		expectedStatus   ljlib.OrderStatus
+		expectedPrice    string
+		expectedFilledAt time.Duration
+	}{
+		"it should fill a limit order when the intraday price crosses the limit": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(105)},
+			prices:           mockPricePath{start: 100, peak: 110},
+			advance:          10 * time.Minute,
+			expectedStatus:   ljlib.OrderStatusFilled,
+			expectedPrice:    "105",
+			expectedFilledAt: 5 * time.Minute,
+		},
+		"it should keep a limit order open while the price does not reach the limit": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(115)},
+			prices:         mockPricePath{start: 100, peak: 110},
+			advance:        time.Hour,
+			expectedStatus: ljlib.OrderStatusAccepted,
+		},
+		"it should trigger a stop order when the price falls to the stop": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeStop,
+				Quantity: decimal.NewFromInt(1), StopPrice: decimal.NewFromInt(97)},
+			prices:           mockPricePath{start: 100, peak: 102},
+			advance:          time.Hour,
+			expectedStatus:   ljlib.OrderStatusFilled,
+			expectedPrice:    "97",
+			expectedFilledAt: 7 * time.Minute,
+		},
+		"it should expire a day order at the end of the day": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideBuy, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(50)},
+			prices:         mockPricePath{start: 100, peak: 2000},
+			advance:        24 * time.Hour,
+			expectedStatus: ljlib.OrderStatusExpired,
+		},
+		"it should not fill a day order at the prices of the next day": {
+			request: trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeLimit,
+				Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(950)},
+			prices:         mockPricePath{start: 100, peak: 2000},
+			advance:        24 * time.Hour,
+			expectedStatus: ljlib.OrderStatusExpired,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			virtualClock := clock.NewVirtual(testStart)
+			service := newTestOrderService(t, &mockLedger{}, testCase.prices, trading.SimulatorConfig{TickInterval: time.Minute},
+				virtualClock)
+
+			placed, err := service.PlaceOrder(testUserID, testCase.request)
+			require.NoError(t, err)
+			require.Equal(t, ljlib.OrderStatusAccepted, placed.Status)
+
+			virtualClock.Advance(testCase.advance)
+			order, err := service.GetOrder(testUserID, placed.ID)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedStatus, order.Status)
+			if len(testCase.expectedPrice) > 0 {
+				require.Len(t, order.Fills, 1)
+				assert.Equal(t, testCase.expectedPrice, order.Fills[0].Price.String())
+				assert.Equal(t, testStart.Add(testCase.expectedFilledAt), order.Fills[0].ExecutedAt)
+			}
+		})
+	}
+}
+
+func TestSimulator_PartialFills(t *testing.T) {
+	virtualClock := clock.NewVirtual(testStart)
+	config := trading.SimulatorConfig{TickInterval: time.Minute, MaxQuantityPerTick: decimal.NewFromInt(3)}
+	service := newTestOrderService(t, &mockLedger{}, mockPriceSource{"AAPL": 100}, config, virtualClock)
+
+	order, err := service.PlaceOrder(testUserID, marketOrder(ljlib.OrderSideBuy, "10"))
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusPartiallyFilled, order.Status)
+	assert.Equal(t, "3", order.FilledQuantity.String())
+
+	virtualClock.Advance(2 * time.Minute)
+	order, err = service.GetOrder(testUserID, order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusPartiallyFilled, order.Status)
+	assert.Equal(t, "9", order.FilledQuantity.String())
+
+	virtualClock.Advance(time.Minute)
+	order, err = service.GetOrder(testUserID, order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusFilled, order.Status)
+	assert.Len(t, order.Fills, 4)
+
+	fok := marketOrder(ljlib.OrderSideBuy, "10")
+	fok.TimeInForce = ljlib.TimeInForceFOK
+	order, err = service.PlaceOrder(testUserID, fok)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusCancelled, order.Status)
+	assert.True(t, order.FilledQuantity.IsZero())
+
+	ioc := marketOrder(ljlib.OrderSideBuy, "10")
+	ioc.TimeInForce = ljlib.TimeInForceIOC
+	order, err = service.PlaceOrder(testUserID, ioc)
+	require.NoError(t, err)
+	assert.Equal(t, ljlib.OrderStatusCancelled, order.Status)
+	assert.Equal(t, "3", order.FilledQuantity.String())
+}
+
+func TestSimulator_Deterministic(t *testing.T) {
+	run := func() []ljlib.Fill {
+		virtualClock := clock.NewVirtual(testStart)
+		config := trading.SimulatorConfig{SlippageBps: decimal.NewFromInt(3), TickInterval: time.Minute,
+			MaxQuantityPerTick: decimal.NewFromInt(2)}
+		service := newTestOrderService(t, &mockLedger{}, mockPricePath{start: 100, peak: 120}, config, virtualClock)
+		order, err := service.PlaceOrder(testUserID, trading.PlaceOrderRequest{Ticker: "AAPL", Side: ljlib.OrderSideSell,
+			Type: ljlib.OrderTypeLimit, TimeInForce: ljlib.TimeInForceGTC, Quantity: decimal.NewFromInt(7),
+			LimitPrice: decimal.NewFromInt(110)})
+		require.NoError(t, err)
+		virtualClock.Advance(7 * time.Minute)
+		_, err = service.GetOrder(testUserID, order.ID)
+		require.NoError(t, err)
+		virtualClock.Advance(time.Hour)
+		order, err = service.GetOrder(testUserID, order.ID)
+		require.NoError(t, err)
+		for i := range order.Fills {
+			order.Fills[i].ID = [16]byte{}
+		}
+		return order.Fills
+	}
+	fills := run()
+	assert.Len(t, fills, 4)
+	assert.Equal(t, fills, run())
+}
+
+func TestSimulator_Match_LongWindow(t *testing.T) {
+	prices := &countingPriceSource{prices: mockPricePath{start: 100, peak: 200000}}
+	simulator, err := trading.NewSimulator(prices, trading.SimulatorConfig{TickInterval: time.Minute})
+	require.NoError(t, err)
+	order := ljlib.Order{Ticker: "AAPL", Side: ljlib.OrderSideSell, Type: ljlib.OrderTypeLimit,
+		TimeInForce: ljlib.TimeInForceGTC, Quantity: decimal.NewFromInt(1), LimitPrice: decimal.NewFromInt(20000)}
+
+	match, err := simulator.Match(order, testStart, testStart.AddDate(0, 0, 30))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, prices.calls, trading.MaxTicksPerMatch+1, "the window of 43200 ticks is checked at a coarser step")
+	require.Len(t, match.Executions, 1)
+	assert.True(t, match.Executions[0].Price.GreaterThanOrEqual(order.LimitPrice))
+}
+
+// countingPriceSource counts the prices it's asked for.
+type countingPriceSource struct {
+	prices trading.IntradayPriceSource
+	calls  int
+}
+
+func (c *countingPriceSource) GetIntradayPrice(ticker string, at time.Time) (decimal.Decimal, error) {
+	c.calls++
+	return c.prices.GetIntradayPrice(ticker, at)
+}
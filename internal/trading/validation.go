@@ -0,0 +1,97 @@
+package trading
+
+import (
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+)
+
+// validatePlaceOrderRequest validates the order for the instrument, which can only be traded in the quantities
+// of its precision.
+func validatePlaceOrderRequest(request PlaceOrderRequest, instrument ljlib.Instrument) error {
+	if request.Side != ljlib.OrderSideBuy && request.Side != ljlib.OrderSideSell {
+		return ljlib.NewIllegalArgumentError("side must be one of: buy, sell")
+	}
+	switch request.TimeInForce {
+	case ljlib.TimeInForceDay, ljlib.TimeInForceGTC, ljlib.TimeInForceIOC, ljlib.TimeInForceFOK:
+	default:
+		return ljlib.NewIllegalArgumentError("time in force must be one of: DAY, GTC, IOC, FOK")
+	}
+	if err := instrument.ValidateQuantity(request.Quantity); err != nil {
+		return err
+	}
+
+	needsLimit, needsStop := false, false
+	switch request.Type {
+	case ljlib.OrderTypeMarket:
+	case ljlib.OrderTypeLimit:
+		needsLimit = true
+	case ljlib.OrderTypeStop:
+		needsStop = true
+	case ljlib.OrderTypeStopLimit:
+		needsLimit, needsStop = true, true
+	default:
+		return ljlib.NewIllegalArgumentError("type must be one of: market, limit, stop, stop_limit")
+	}
+	if err := validatePrice("limit price", request.LimitPrice, needsLimit, request.Type); err != nil {
+		return err
+	}
+	if err := validatePrice("stop price", request.StopPrice, needsStop, request.Type); err != nil {
+		return err
+	}
+
+	//a stop order waits for its trigger, so it cannot be executed immediately
+	if needsStop && (request.TimeInForce == ljlib.TimeInForceIOC || request.TimeInForce == ljlib.TimeInForceFOK) {
+		return ljlib.NewIllegalArgumentError("%s orders cannot have time in force %s", request.Type, request.TimeInForce)
+	}
+	return nil
+}
+
+func validatePrice(name string, price decimal.Decimal, required bool, orderType ljlib.OrderType) error {
+	if required && !price.IsPositive() {
+		return ljlib.NewIllegalArgumentError("%s must be positive for %s orders", name, orderType)
+	}
+	if !required && !price.IsZero() {
+		return ljlib.NewIllegalArgumentError("%s is not allowed for %s orders", name, orderType)
+	}
+	return nil
+}
+
+// amend returns the order with the amendment applied, as long as the amended order is valid.
+func amend(order ljlib.Order, request AmendOrderRequest, instrument ljlib.Instrument) (ljlib.Order, error) {
+	amended := order
+	if !request.Quantity.IsZero() {
+		amended.Quantity = request.Quantity
+	}
+	if !request.LimitPrice.IsZero() {
+		amended.LimitPrice = request.LimitPrice
+	}
+	if !request.StopPrice.IsZero() {
+		amended.StopPrice = request.StopPrice
+	}
+	if amended.Quantity.Equal(order.Quantity) && amended.LimitPrice.Equal(order.LimitPrice) &&
+		amended.StopPrice.Equal(order.StopPrice) {
+		return ljlib.Order{}, ljlib.NewIllegalArgumentError("amendment must change quantity, limit price or stop price")
+	}
+
+	err := validatePlaceOrderRequest(PlaceOrderRequest{
+		Ticker:      amended.Ticker,
+		Side:        amended.Side,
+		Type:        amended.Type,
+		TimeInForce: amended.TimeInForce,
+		Quantity:    amended.Quantity,
+		LimitPrice:  amended.LimitPrice,
+		StopPrice:   amended.StopPrice,
+	}, instrument)
+	if err != nil {
+		return ljlib.Order{}, err
+	}
+	if !amended.Quantity.GreaterThan(order.FilledQuantity) {
+		return ljlib.Order{}, ljlib.NewIllegalArgumentError("quantity must be greater than the filled quantity of %s",
+			order.FilledQuantity)
+	}
+	if order.Triggered && !amended.StopPrice.Equal(order.StopPrice) {
+		return ljlib.Order{}, ljlib.NewConflictError("stop price of order [%s] cannot be amended, as it's already triggered",
+			order.ID)
+	}
+	return amended, nil
+}
@@ -8,7 +8,7 @@ type NotFoundError struct {
 
 func NewNotFoundError(message string, a ...interface{}) NotFoundError {
 	return NotFoundError{
-		message: fmt.Sprintf(message, a),
+		message: fmt.Sprintf(message, a...),
 	}
 }
 
@@ -27,7 +27,7 @@ type IllegalArgumentError struct {
 
 func NewIllegalArgumentError(message string, a ...interface{}) IllegalArgumentError {
 	return IllegalArgumentError{
-		message: fmt.Sprintf(message, a),
+		message: fmt.Sprintf(message, a...),
 	}
 }
 
@@ -39,3 +39,79 @@ func (i IllegalArgumentError) Is(err error) bool {
 	_, ok := err.(IllegalArgumentError)
 	return ok
 }
+
+type ConflictError struct {
+	message string
+}
+
+func NewConflictError(message string, a ...interface{}) ConflictError {
+	return ConflictError{
+		message: fmt.Sprintf(message, a...),
+	}
+}
+
+func (c ConflictError) Error() string {
+	return c.message
+}
+
+func (c ConflictError) Is(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
+// PreconditionFailedError means the change was based on an outdated version of the resource.
+type PreconditionFailedError struct {
+	message string
+}
+
+func NewPreconditionFailedError(message string, a ...interface{}) PreconditionFailedError {
+	return PreconditionFailedError{
+		message: fmt.Sprintf(message, a...),
+	}
+}
+
+func (p PreconditionFailedError) Error() string {
+	return p.message
+}
+
+func (p PreconditionFailedError) Is(err error) bool {
+	_, ok := err.(PreconditionFailedError)
+	return ok
+}
+
+// Rejection reasons of the orders failing the pre-trade risk checks.
+const (
+	RejectionInsufficientBuyingPower = "insufficient_buying_power"
+	RejectionNotionalLimit           = "notional_limit_exceeded"
+	RejectionQuantityLimit           = "quantity_limit_exceeded"
+	RejectionShortSellNotAllowed     = "short_sell_not_allowed"
+	RejectionInsufficientMargin      = "insufficient_margin"
+	RejectionPatternDayTrader        = "pattern_day_trader"
+)
+
+// RejectionError tells why an order was rejected. The reason is one of the Rejection constants,
+// so it can be handled by the clients, while the message is human readable.
+type RejectionError struct {
+	reason  string
+	message string
+}
+
+func NewRejectionError(reason string, message string, a ...interface{}) RejectionError {
+	return RejectionError{
+		reason:  reason,
+		message: fmt.Sprintf(message, a...),
+	}
+}
+
+func (r RejectionError) Reason() string {
+	return r.reason
+}
+
+func (r RejectionError) Error() string {
+	return r.message
+}
+
+func (r RejectionError) Is(err error) bool {
+	_, ok := err.(RejectionError)
+	return ok
+}
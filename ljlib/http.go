@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 )
 
@@ -42,3 +43,32 @@ func ResponseHTTPForbidden(w http.ResponseWriter, message string) {
 func ResponseHTTPNotFound(w http.ResponseWriter, message string) {
 	ResponseHTTP(w, http.StatusNotFound, ErrorHTTP{Message: message})
 }
+
+func ResponseHTTPConflict(w http.ResponseWriter, message string) {
+	ResponseHTTP(w, http.StatusConflict, ErrorHTTP{Message: message})
+}
+
+func ResponseHTTPPreconditionFailed(w http.ResponseWriter, message string) {
+	ResponseHTTP(w, http.StatusPreconditionFailed, ErrorHTTP{Message: message})
+}
+
+func ResponseHTTPPreconditionRequired(w http.ResponseWriter, message string) {
+	ResponseHTTP(w, http.StatusPreconditionRequired, ErrorHTTP{Message: message})
+}
+
+func ResponseHTTPUnprocessableEntity(w http.ResponseWriter, message string) {
+	ResponseHTTP(w, http.StatusUnprocessableEntity, ErrorHTTP{Message: message})
+}
+
+func ResponseHTTPTooManyRequests(w http.ResponseWriter, message string) {
+	ResponseHTTP(w, http.StatusTooManyRequests, ErrorHTTP{Message: message})
+}
+
+// ClientIP returns the IP address of the peer. Proxy headers are not trusted, as the service is exposed directly.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,153 @@
+package ljlib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CashPlaces is the precision of the cash amounts: balances, trade amounts and commissions are in whole cents.
+const CashPlaces = 2
+
+// BaseCurrency is the currency of the account, which the instruments are traded and the buying power is computed in.
+const BaseCurrency = "USD"
+
+// The asset classes of the instruments.
+const (
+	AssetClassEquity = "equity"
+	AssetClassOption = "option"
+)
+
+// Instrument is the reference data of a tradable ticker.
+type Instrument struct {
+	Ticker string
+	//AssetClass is AssetClassEquity or AssetClassOption.
+	AssetClass string
+	//Sector and Industry classify the business of the company, and Country is where it's domiciled, as ISO 3166
+	//alpha-2. The options are classified like their underlying.
+	Sector   string
+	Industry string
+	Country  string
+	//QuantityPrecision is the number of decimal places the instrument can be traded in, 0 for whole shares only.
+	QuantityPrecision int32
+	Currency          string
+	//SettlementDays is the number of business days the cash of a trade takes to settle, e.g. 1 for T+1.
+	SettlementDays int
+	//BorrowRate is the annual fee for borrowing the shares sold short, charged on their market value.
+	BorrowRate decimal.Decimal
+	//Option is set for the option contracts, which are traded in contracts of OptionMultiplier shares.
+	Option *OptionContract
+}
+
+// SettlementDate returns when a trade executed at the given time settles: at the same time of the day,
+// SettlementDays business days later. Weekends are skipped, while the holidays are not considered.
+func (i Instrument) SettlementDate(executedAt time.Time) time.Time {
+	settlesAt := executedAt
+	for days := 0; days < i.SettlementDays; {
+		settlesAt = settlesAt.AddDate(0, 0, 1)
+		if weekday := settlesAt.Weekday(); weekday != time.Saturday && weekday != time.Sunday {
+			days++
+		}
+	}
+	return settlesAt
+}
+
+// ValidateQuantity checks the quantity is positive, and not more precise than the instrument can be traded in.
+func (i Instrument) ValidateQuantity(quantity decimal.Decimal) error {
+	if !quantity.IsPositive() {
+		return NewIllegalArgumentError("quantity must be positive")
+	}
+	if !quantity.Equal(quantity.Truncate(i.QuantityPrecision)) {
+		if i.Option != nil {
+			return NewIllegalArgumentError("quantity of %s must be a whole number of contracts", i.Ticker)
+		}
+		if i.QuantityPrecision == 0 {
+			return NewIllegalArgumentError("quantity of %s must be a whole number of shares", i.Ticker)
+		}
+		return NewIllegalArgumentError("quantity of %s cannot have more than %d decimal places", i.Ticker,
+			i.QuantityPrecision)
+	}
+	return nil
+}
+
+// QuantityForAmount returns the largest quantity the amount buys at the price, so the amount is never exceeded.
+func (i Instrument) QuantityForAmount(amount decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	if !price.IsPositive() {
+		return decimal.Zero
+	}
+	price = price.Mul(ContractMultiplier(i.Ticker))
+	quantity := amount.Div(price).Truncate(i.QuantityPrecision)
+	//the division is rounded at decimal.DivisionPrecision, which may round it up to the next step
+	if quantity.Mul(price).GreaterThan(amount) {
+		quantity = quantity.Sub(decimal.New(1, -i.QuantityPrecision))
+	}
+	return quantity
+}
+
+func (i Instrument) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Ticker            string          `json:"ticker"`
+		AssetClass        string          `json:"asset_class"`
+		Sector            string          `json:"sector"`
+		Industry          string          `json:"industry"`
+		Country           string          `json:"country"`
+		QuantityPrecision int32           `json:"quantity_precision"`
+		Currency          string          `json:"currency"`
+		SettlementDays    int             `json:"settlement_days"`
+		BorrowRate        string          `json:"borrow_rate"`
+		Multiplier        string          `json:"multiplier"`
+		Option            *OptionContract `json:"option,omitempty"`
+	}{
+		Ticker:            i.Ticker,
+		AssetClass:        i.AssetClass,
+		Sector:            i.Sector,
+		Industry:          i.Industry,
+		Country:           i.Country,
+		QuantityPrecision: i.QuantityPrecision,
+		Currency:          i.Currency,
+		SettlementDays:    i.SettlementDays,
+		BorrowRate:        i.BorrowRate.String(),
+		Multiplier:        ContractMultiplier(i.Ticker).String(),
+		Option:            i.Option,
+	})
+}
+
+// Benchmark is the reference data of a market index the portfolios and the tickers can be compared against.
+// It has a price history like the tickers, but cannot be traded.
+type Benchmark struct {
+	Ticker string
+	Name   string
+}
+
+func (b Benchmark) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Ticker string `json:"ticker"`
+		Name   string `json:"name"`
+	}{
+		Ticker: b.Ticker,
+		Name:   b.Name,
+	})
+}
+
+// FormatDecimal formats the value with at least minPlaces decimal places, and with all of its significant ones,
+// so that e.g. prices are shown in cents, but never get rounded.
+func FormatDecimal(value decimal.Decimal, minPlaces int32) string {
+	if -value.Exponent() > minPlaces {
+		formatted := value.String()
+		if places := decimalPlaces(formatted); places < minPlaces {
+			return value.StringFixed(minPlaces)
+		}
+		return formatted
+	}
+	return value.StringFixed(minPlaces)
+}
+
+func decimalPlaces(formatted string) int32 {
+	for i := len(formatted) - 1; i >= 0; i-- {
+		if formatted[i] == '.' {
+			return int32(len(formatted) - 1 - i)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package ljlib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument_QuantityForAmount(t *testing.T) {
+	testCases := map[string]struct {
+		precision        int32
+		amount           string
+		price            string
+		expectedQuantity string
+	}{
+		"it should round the quantity down to the precision": {
+			precision:        6,
+			amount:           "100",
+			price:            "150",
+			expectedQuantity: "0.666666",
+		},
+		"it should buy whole shares only for an instrument without fractional shares": {
+			precision:        0,
+			amount:           "1000",
+			price:            "150",
+			expectedQuantity: "6",
+		},
+		"it should buy nothing when the amount is below the smallest quantity": {
+			precision:        2,
+			amount:           "1",
+			price:            "150",
+			expectedQuantity: "0",
+		},
+		"it should never exceed the amount when the division is rounded up": {
+			precision:        16,
+			amount:           "1",
+			price:            "3",
+			expectedQuantity: "0.3333333333333333",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			instrument := ljlib.Instrument{Ticker: "AAPL", QuantityPrecision: testCase.precision}
+			amount := decimal.RequireFromString(testCase.amount)
+			price := decimal.RequireFromString(testCase.price)
+
+			quantity := instrument.QuantityForAmount(amount, price)
+			assert.Equal(t, testCase.expectedQuantity, quantity.String())
+			assert.False(t, quantity.Mul(price).GreaterThan(amount))
+		})
+	}
+}
+
+func TestInstrument_ValidateQuantity(t *testing.T) {
+	instrument := ljlib.Instrument{Ticker: "AAPL", QuantityPrecision: 4}
+	assert.NoError(t, instrument.ValidateQuantity(decimal.RequireFromString("0.0001")))
+	assert.NoError(t, instrument.ValidateQuantity(decimal.RequireFromString("1.50000")))
+	assert.ErrorIs(t, instrument.ValidateQuantity(decimal.RequireFromString("0.00001")), ljlib.IllegalArgumentError{})
+	assert.ErrorIs(t, instrument.ValidateQuantity(decimal.Zero), ljlib.IllegalArgumentError{})
+}
+
+func TestFormatDecimal(t *testing.T) {
+	testCases := map[string]string{
+		"150":        "150.00",
+		"150.1":      "150.10",
+		"150.1200":   "150.12",
+		"150.1234":   "150.1234",
+		"0.00012345": "0.00012345",
+	}
+	for value, expected := range testCases {
+		assert.Equal(t, expected, ljlib.FormatDecimal(decimal.RequireFromString(value), 2), "value %s", value)
+	}
+}
+
+func TestInstrument_SettlementDate(t *testing.T) {
+	testCases := map[string]struct {
+		settlementDays int
+		executedAt     time.Time
+		expected       time.Time
+	}{
+		"it should settle on the next business day": {
+			settlementDays: 1,
+			executedAt:     time.Date(2023, 7, 18, 15, 0, 0, 0, time.UTC),
+			expected:       time.Date(2023, 7, 19, 15, 0, 0, 0, time.UTC),
+		},
+		"it should skip the weekend": {
+			settlementDays: 1,
+			executedAt:     time.Date(2023, 7, 21, 15, 0, 0, 0, time.UTC),
+			expected:       time.Date(2023, 7, 24, 15, 0, 0, 0, time.UTC),
+		},
+		"it should count the business days only for T+2": {
+			settlementDays: 2,
+			executedAt:     time.Date(2023, 7, 20, 15, 0, 0, 0, time.UTC),
+			expected:       time.Date(2023, 7, 24, 15, 0, 0, 0, time.UTC),
+		},
+		"it should settle right away without a settlement cycle": {
+			settlementDays: 0,
+			executedAt:     time.Date(2023, 7, 20, 15, 0, 0, 0, time.UTC),
+			expected:       time.Date(2023, 7, 20, 15, 0, 0, 0, time.UTC),
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			instrument := ljlib.Instrument{Ticker: "AAPL", SettlementDays: testCase.settlementDays}
+			assert.Equal(t, testCase.expected, instrument.SettlementDate(testCase.executedAt))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package ljlib
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Lot is a part of a position opened by a single trade. Quantity is negative for the lots of short positions.
+type Lot struct {
+	OpenedAt time.Time
+	Quantity decimal.Decimal
+	Price    decimal.Decimal
+	//Cost is what opening the lot cost, commission included; it's negative for short lots, which bring cash in.
+	Cost decimal.Decimal
+}
+
+// Position is the holding of a ticker, made of the lots which are still open, the oldest first.
+type Position struct {
+	Ticker   string
+	Quantity decimal.Decimal
+	Lots     []Lot
+	//RealizedPnL is the profit of the closed lots, commissions included.
+	RealizedPnL decimal.Decimal
+}
+
+// CostBasis is the cost of the open lots, commissions included.
+func (p Position) CostBasis() decimal.Decimal {
+	cost := decimal.Zero
+	for _, lot := range p.Lots {
+		cost = cost.Add(lot.Cost)
+	}
+	return cost
+}
+
+// BuildPositions replays the trades of the ledger into positions, closing the lots first in, first out.
+// A trade closing more than the open quantity opens a lot on the other side with the rest of it.
+// Positions are returned in the order they were first opened, including the closed ones.
+func BuildPositions(transactions []Transaction) []Position {
+	var positions []Position
+	indexes := make(map[string]int)
+	for _, tx := range transactions {
+		if !tx.IsTrade() {
+			continue
+		}
+		i, ok := indexes[tx.Ticker]
+		if !ok {
+			i = len(positions)
+			indexes[tx.Ticker] = i
+			positions = append(positions, Position{Ticker: tx.Ticker})
+		}
+		positions[i].apply(tx)
+	}
+	return positions
+}
+
+func (p *Position) apply(tx Transaction) {
+	signed := tx.SignedQuantity()
+	remaining := tx.Quantity
+	for len(p.Lots) > 0 && remaining.IsPositive() && p.Lots[0].Quantity.Sign() != signed.Sign() {
+		lot := &p.Lots[0]
+		held := lot.Quantity.Abs()
+		closed := decimal.Min(held, remaining)
+		cost := share(lot.Cost, closed, held)
+		//the proceeds of selling a long lot, or the negative cost of buying back a short one
+		proceeds := share(tx.Notional(), closed, tx.Quantity).Mul(decimal.NewFromInt(int64(lot.Quantity.Sign())))
+		p.RealizedPnL = p.RealizedPnL.Add(proceeds).Sub(cost).Sub(share(tx.Commission, closed, tx.Quantity))
+
+		if closed.Equal(held) {
+			p.Lots = p.Lots[1:]
+		} else {
+			lot.Quantity = lot.Quantity.Sub(closed.Mul(decimal.NewFromInt(int64(lot.Quantity.Sign()))))
+			lot.Cost = lot.Cost.Sub(cost)
+		}
+		remaining = remaining.Sub(closed)
+	}
+
+	if remaining.IsPositive() {
+		quantity, notional := remaining, share(tx.Notional(), remaining, tx.Quantity)
+		if signed.IsNegative() {
+			quantity, notional = quantity.Neg(), notional.Neg()
+		}
+		p.Lots = append(p.Lots, Lot{
+			OpenedAt: tx.ExecutedAt,
+			Quantity: quantity,
+			Price:    tx.Price,
+			Cost:     notional.Add(share(tx.Commission, remaining, tx.Quantity)),
+		})
+	}
+	p.Quantity = p.Quantity.Add(signed)
+}
+
+// share is the part of the trade amount falling on the given quantity of the trade.
+func share(amount decimal.Decimal, quantity decimal.Decimal, total decimal.Decimal) decimal.Decimal {
+	if quantity.Equal(total) {
+		return amount
+	}
+	return amount.Mul(quantity).Div(total)
+}
@@ -0,0 +1,114 @@
+package ljlib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDay = time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)
+
+func TestBuildPositions(t *testing.T) {
+	testCases := map[string]struct {
+		transactions        []ljlib.Transaction
+		expectedQuantity    string
+		expectedLots        []string
+		expectedCostBasis   string
+		expectedRealizedPnL string
+	}{
+		"it should keep a lot per buy": {
+			transactions: []ljlib.Transaction{
+				trade(ljlib.TransactionTypeBuy, "10", "100", "1", 0),
+				trade(ljlib.TransactionTypeBuy, "0.5", "120", "1", 1),
+			},
+			expectedQuantity:    "10.5",
+			expectedLots:        []string{"10", "0.5"},
+			expectedCostBasis:   "1062",
+			expectedRealizedPnL: "0",
+		},
+		"it should close the oldest lots first": {
+			transactions: []ljlib.Transaction{
+				trade(ljlib.TransactionTypeBuy, "10", "100", "0", 0),
+				trade(ljlib.TransactionTypeBuy, "10", "120", "0", 1),
+				trade(ljlib.TransactionTypeSell, "12.5", "130", "0", 2),
+			},
+			expectedQuantity: "7.5",
+			expectedLots:     []string{"7.5"},
+			//all of the first lot and 2.5 shares of the second one are closed, with the profit of 300 + 25
+			expectedCostBasis:   "900",
+			expectedRealizedPnL: "325",
+		},
+		"it should include the commissions into the cost and the realized profit": {
+			transactions: []ljlib.Transaction{
+				trade(ljlib.TransactionTypeBuy, "10", "100", "2", 0),
+				trade(ljlib.TransactionTypeSell, "5", "110", "1", 1),
+			},
+			expectedQuantity:    "5",
+			expectedLots:        []string{"5"},
+			expectedCostBasis:   "501",
+			expectedRealizedPnL: "48",
+		},
+		"it should open a short lot with the quantity sold beyond the position": {
+			transactions: []ljlib.Transaction{
+				trade(ljlib.TransactionTypeBuy, "1", "100", "0", 0),
+				trade(ljlib.TransactionTypeSell, "3", "110", "0", 1),
+				trade(ljlib.TransactionTypeBuy, "1", "90", "0", 2),
+			},
+			expectedQuantity:    "-1",
+			expectedLots:        []string{"-1"},
+			expectedCostBasis:   "-110",
+			expectedRealizedPnL: "30",
+		},
+		"it should round the amounts of fractional trades to cents in favour of the broker": {
+			transactions: []ljlib.Transaction{
+				//0.333333 * 150 = 49.99995, paid as 50.00
+				trade(ljlib.TransactionTypeBuy, "0.333333", "150", "0", 0),
+			},
+			expectedQuantity:    "0.333333",
+			expectedLots:        []string{"0.333333"},
+			expectedCostBasis:   "50",
+			expectedRealizedPnL: "0",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			positions := ljlib.BuildPositions(testCase.transactions)
+			require.Len(t, positions, 1)
+			position := positions[0]
+
+			assert.Equal(t, testCase.expectedQuantity, position.Quantity.String())
+			var lots []string
+			for _, lot := range position.Lots {
+				lots = append(lots, lot.Quantity.String())
+			}
+			assert.Equal(t, testCase.expectedLots, lots)
+			assert.Equal(t, testCase.expectedCostBasis, position.CostBasis().String())
+			assert.Equal(t, testCase.expectedRealizedPnL, position.RealizedPnL.String())
+		})
+	}
+}
+
+func TestTransaction_Notional(t *testing.T) {
+	buy := trade(ljlib.TransactionTypeBuy, "0.1", "100.123", "1", 0)
+	assert.Equal(t, "10.02", buy.Notional().String())
+	assert.Equal(t, "-11.02", buy.CashFlow().String())
+
+	sell := trade(ljlib.TransactionTypeSell, "0.1", "100.129", "1", 0)
+	assert.Equal(t, "10.01", sell.Notional().String())
+	assert.Equal(t, "9.01", sell.CashFlow().String())
+}
+
+func trade(txType ljlib.TransactionType, quantity string, price string, commission string, day int) ljlib.Transaction {
+	return ljlib.Transaction{
+		Type:       txType,
+		Ticker:     "AAPL",
+		Quantity:   decimal.RequireFromString(quantity),
+		Price:      decimal.RequireFromString(price),
+		Commission: decimal.RequireFromString(commission),
+		ExecutedAt: testDay.AddDate(0, 0, day),
+	}
+}
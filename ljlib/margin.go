@@ -0,0 +1,79 @@
+package ljlib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// MarginCall is issued when the equity of the account falls below the maintenance margin of its short positions.
+type MarginCall struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	//Deficit is the cash which would have brought the equity back to the maintenance margin when the call was issued.
+	Deficit           decimal.Decimal
+	Equity            decimal.Decimal
+	MaintenanceMargin decimal.Decimal
+	IssuedAt          time.Time
+	//ResolvedAt is set once the equity is back above the maintenance margin, e.g. after a deposit or covering.
+	ResolvedAt time.Time
+}
+
+func (m MarginCall) IsOpen() bool {
+	return m.ResolvedAt.IsZero()
+}
+
+func (m MarginCall) MarshalJSON() ([]byte, error) {
+	var resolvedAt *string
+	if !m.ResolvedAt.IsZero() {
+		formatted := m.ResolvedAt.Format(time.RFC3339)
+		resolvedAt = &formatted
+	}
+	return json.Marshal(struct {
+		ID                string  `json:"id"`
+		Deficit           string  `json:"deficit"`
+		Equity            string  `json:"equity"`
+		MaintenanceMargin string  `json:"maintenance_margin"`
+		IssuedAt          string  `json:"issued_at"`
+		ResolvedAt        *string `json:"resolved_at"`
+	}{
+		ID:                m.ID.String(),
+		Deficit:           m.Deficit.StringFixed(CashPlaces),
+		Equity:            m.Equity.StringFixed(CashPlaces),
+		MaintenanceMargin: m.MaintenanceMargin.StringFixed(CashPlaces),
+		IssuedAt:          m.IssuedAt.Format(time.RFC3339),
+		ResolvedAt:        resolvedAt,
+	})
+}
+
+type NotificationType string
+
+const (
+	NotificationMarginCall         NotificationType = "margin_call"
+	NotificationMarginCallResolved NotificationType = "margin_call_resolved"
+)
+
+// Notification is a message to the user about an event on their account.
+type Notification struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Type      NotificationType
+	Message   string
+	CreatedAt time.Time
+}
+
+func (n Notification) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID        string           `json:"id"`
+		Type      NotificationType `json:"type"`
+		Message   string           `json:"message"`
+		CreatedAt string           `json:"created_at"`
+	}{
+		ID:        n.ID.String(),
+		Type:      n.Type,
+		Message:   n.Message,
+		CreatedAt: n.CreatedAt.Format(time.RFC3339),
+	})
+}
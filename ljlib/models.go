@@ -19,26 +19,126 @@ func (h HistoricalPrice) MarshalJSON() ([]byte, error) {
 		Price string `json:"price"`
 	}{
 		Date:  h.Date.Format(time.DateOnly),
-		Price: h.Price.StringFixed(2),
+		Price: FormatDecimal(h.Price, CashPlaces),
 	})
 }
 
 type TickerPrice struct {
-	Ticker string
-	Price  decimal.Decimal
+	Ticker   string
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+	//CostBasis is the cost of the open lots of the position, commissions included.
+	CostBasis decimal.Decimal
+}
+
+// MarketValue is the position valued at the price, in whole cents.
+func (t TickerPrice) MarketValue() decimal.Decimal {
+	return t.Quantity.Mul(t.Price).Mul(ContractMultiplier(t.Ticker)).Round(CashPlaces)
 }
 
 func (t TickerPrice) MarshalJSON() ([]byte, error) {
+	costBasis := t.CostBasis.Round(CashPlaces)
 	return json.Marshal(struct {
-		Ticker string `json:"ticker"`
-		Price  string `json:"price"`
+		Ticker        string `json:"ticker"`
+		Price         string `json:"price"`
+		Quantity      string `json:"quantity"`
+		MarketValue   string `json:"market_value"`
+		CostBasis     string `json:"cost_basis"`
+		UnrealizedPnL string `json:"unrealized_pnl"`
 	}{
-		Ticker: t.Ticker,
-		Price:  t.Price.StringFixed(2),
+		Ticker:        t.Ticker,
+		Price:         FormatDecimal(t.Price, CashPlaces),
+		Quantity:      t.Quantity.String(),
+		MarketValue:   t.MarketValue().StringFixed(CashPlaces),
+		CostBasis:     costBasis.StringFixed(CashPlaces),
+		UnrealizedPnL: t.MarketValue().Sub(costBasis).StringFixed(CashPlaces),
 	})
 }
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID       uuid.UUID
 	Username string
+	Email    string
+	Role     string
+	//PasswordHash is empty for the mocked users, who authenticate with an empty password.
+	PasswordHash string
+	Disabled     bool
+	CreatedAt    time.Time
+	//DeletedAt is set when the user deletes their account; the record itself is kept (soft delete).
+	DeletedAt *time.Time
+	//TOTPSecret is set on 2FA enrollment, while TOTPEnabled only after the first code is verified.
+	TOTPSecret  string
+	TOTPEnabled bool
+	//TOTPLastCounter is the time step of the last accepted code, used to prevent replays.
+	TOTPLastCounter    int64
+	RecoveryCodeHashes []string
+}
+
+// IsActive reports whether the user is allowed to authenticate.
+func (u User) IsActive() bool {
+	return !u.Disabled && u.DeletedAt == nil
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+func (u User) MarshalJSON() ([]byte, error) {
+	var deletedAt *string
+	if u.DeletedAt != nil {
+		formatted := u.DeletedAt.Format(time.RFC3339)
+		deletedAt = &formatted
+	}
+	return json.Marshal(struct {
+		ID               string  `json:"id"`
+		Username         string  `json:"username"`
+		Email            string  `json:"email"`
+		Role             string  `json:"role"`
+		Disabled         bool    `json:"disabled"`
+		TwoFactorEnabled bool    `json:"two_factor_enabled"`
+		CreatedAt        string  `json:"created_at"`
+		DeletedAt        *string `json:"deleted_at,omitempty"`
+	}{
+		ID:               u.ID.String(),
+		Username:         u.Username,
+		Email:            u.Email,
+		Role:             u.Role,
+		Disabled:         u.Disabled,
+		TwoFactorEnabled: u.TOTPEnabled,
+		CreatedAt:        u.CreatedAt.Format(time.RFC3339),
+		DeletedAt:        deletedAt,
+	})
+}
+
+// APIToken is a bearer token issued on login. Only the hash of the token is stored.
+type APIToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+func (t APIToken) IsValid(at time.Time) bool {
+	return t.RevokedAt == nil && at.Before(t.ExpiresAt)
+}
+
+func (t APIToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID        string `json:"id"`
+		CreatedAt string `json:"created_at"`
+		ExpiresAt string `json:"expires_at"`
+		Revoked   bool   `json:"revoked"`
+	}{
+		ID:        t.ID.String(),
+		CreatedAt: t.CreatedAt.Format(time.RFC3339),
+		ExpiresAt: t.ExpiresAt.Format(time.RFC3339),
+		Revoked:   t.RevokedAt != nil,
+	})
 }
@@ -0,0 +1,258 @@
+package ljlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type OptionType string
+
+const (
+	OptionTypeCall OptionType = "call"
+	OptionTypePut  OptionType = "put"
+)
+
+// OptionMultiplier is the number of shares of the underlying a contract is for. Option prices are quoted per share,
+// so a contract costs the price times the multiplier.
+const OptionMultiplier = 100
+
+// OptionExpiryHour is the time of the day the options expire at, in UTC: the close of the market on the expiry date.
+const OptionExpiryHour = 20
+
+// optionSymbolSuffixLength is the length of the expiry date, the type and the strike in an option symbol.
+const optionSymbolSuffixLength = 15
+
+// OptionContract is an equity option, traded under its OCC symbol, e.g. AAPL230818C00150000 for the call on AAPL
+// with the strike of 150, expiring on Aug 18, 2023.
+type OptionContract struct {
+	Underlying string
+	Expiry     time.Time
+	Strike     decimal.Decimal
+	Type       OptionType
+}
+
+// NewOptionContract returns the contract expiring at the close of the given date.
+func NewOptionContract(underlying string, expiryDate time.Time, strike decimal.Decimal, optionType OptionType) OptionContract {
+	year, month, day := expiryDate.Date()
+	return OptionContract{
+		Underlying: underlying,
+		Expiry:     time.Date(year, month, day, OptionExpiryHour, 0, 0, 0, time.UTC),
+		Strike:     strike,
+		Type:       optionType,
+	}
+}
+
+// Symbol is the OCC symbol of the contract: the underlying, the expiry date as YYMMDD, C or P, and the strike
+// in thousandths, padded to 8 digits.
+func (c OptionContract) Symbol() string {
+	optionType := "C"
+	if c.Type == OptionTypePut {
+		optionType = "P"
+	}
+	return fmt.Sprintf("%s%s%s%08d", c.Underlying, c.Expiry.Format("060102"), optionType,
+		c.Strike.Shift(3).IntPart())
+}
+
+// IsExpiredAt tells whether the contract can no longer be traded at the given time.
+func (c OptionContract) IsExpiredAt(at time.Time) bool {
+	return !at.Before(c.Expiry)
+}
+
+// ParseOptionSymbol parses the OCC symbol, returning false when the ticker is not an option.
+func ParseOptionSymbol(symbol string) (OptionContract, bool) {
+	if len(symbol) <= optionSymbolSuffixLength || len(symbol) > optionSymbolSuffixLength+6 {
+		return OptionContract{}, false
+	}
+	split := len(symbol) - optionSymbolSuffixLength
+	for _, c := range symbol[:split] {
+		if c < 'A' || c > 'Z' {
+			return OptionContract{}, false
+		}
+	}
+	expiryDate, err := time.Parse("060102", symbol[split:split+6])
+	if err != nil {
+		return OptionContract{}, false
+	}
+	var optionType OptionType
+	switch symbol[split+6] {
+	case 'C':
+		optionType = OptionTypeCall
+	case 'P':
+		optionType = OptionTypePut
+	default:
+		return OptionContract{}, false
+	}
+	strike, err := strconv.ParseInt(symbol[split+7:], 10, 64)
+	if err != nil || strike <= 0 {
+		return OptionContract{}, false
+	}
+	return NewOptionContract(symbol[:split], expiryDate, decimal.New(strike, -3), optionType), true
+}
+
+// ContractMultiplier is the number of shares a unit of the ticker is for: OptionMultiplier for options, 1 otherwise.
+func ContractMultiplier(ticker string) decimal.Decimal {
+	if _, ok := ParseOptionSymbol(ticker); ok {
+		return decimal.NewFromInt(OptionMultiplier)
+	}
+	return decimal.NewFromInt(1)
+}
+
+func (c OptionContract) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Symbol     string     `json:"symbol"`
+		Underlying string     `json:"underlying"`
+		Expiry     string     `json:"expiry"`
+		Strike     string     `json:"strike"`
+		Type       OptionType `json:"type"`
+	}{
+		Symbol:     c.Symbol(),
+		Underlying: c.Underlying,
+		Expiry:     c.Expiry.Format("2006-01-02"),
+		Strike:     FormatDecimal(c.Strike, CashPlaces),
+		Type:       c.Type,
+	})
+}
+
+// OptionQuote is the theoretical price of the contract, with its sensitivities (the Greeks). Theta is the change
+// of the price per calendar day, and vega and rho are per a percentage point of the volatility and the rate.
+type OptionQuote struct {
+	Contract OptionContract
+	Price    decimal.Decimal
+	Delta    decimal.Decimal
+	Gamma    decimal.Decimal
+	Theta    decimal.Decimal
+	Vega     decimal.Decimal
+	Rho      decimal.Decimal
+}
+
+// greekPlaces is the precision the Greeks are rounded to.
+const greekPlaces = 4
+
+// PriceOption prices the contract with the Black-Scholes model, from the price of the underlying, its annual
+// volatility and the annual risk-free rate, at the given moment. An expired contract is worth its intrinsic value.
+func PriceOption(contract OptionContract, underlyingPrice decimal.Decimal, volatility float64, rate float64,
+	at time.Time) OptionQuote {
+	spot := underlyingPrice.InexactFloat64()
+	strike := contract.Strike.InexactFloat64()
+	years := contract.Expiry.Sub(at).Hours() / 24 / 365
+	call := contract.Type == OptionTypeCall
+
+	if years <= 0 || volatility <= 0 || spot <= 0 {
+		intrinsic, delta := math.Max(spot-strike, 0), 0.0
+		if call && spot > strike {
+			delta = 1
+		}
+		if !call {
+			intrinsic = math.Max(strike-spot, 0)
+			if spot < strike {
+				delta = -1
+			}
+		}
+		return OptionQuote{
+			Contract: contract,
+			Price:    decimal.NewFromFloat(intrinsic).Round(CashPlaces),
+			Delta:    decimal.NewFromFloat(delta),
+		}
+	}
+
+	sqrtYears := math.Sqrt(years)
+	d1 := (math.Log(spot/strike) + (rate+volatility*volatility/2)*years) / (volatility * sqrtYears)
+	d2 := d1 - volatility*sqrtYears
+	discount := math.Exp(-rate * years)
+	density := math.Exp(-d1*d1/2) / math.Sqrt(2*math.Pi)
+
+	var price, delta, theta, rho float64
+	decay := -spot * density * volatility / (2 * sqrtYears)
+	if call {
+		price = spot*normalCDF(d1) - strike*discount*normalCDF(d2)
+		delta = normalCDF(d1)
+		theta = decay - rate*strike*discount*normalCDF(d2)
+		rho = strike * years * discount * normalCDF(d2)
+	} else {
+		price = strike*discount*normalCDF(-d2) - spot*normalCDF(-d1)
+		delta = normalCDF(d1) - 1
+		theta = decay + rate*strike*discount*normalCDF(-d2)
+		rho = -strike * years * discount * normalCDF(-d2)
+	}
+	return OptionQuote{
+		Contract: contract,
+		Price:    decimal.NewFromFloat(math.Max(price, 0)).Round(CashPlaces),
+		Delta:    decimal.NewFromFloat(delta).Round(greekPlaces),
+		Gamma:    decimal.NewFromFloat(density / (spot * volatility * sqrtYears)).Round(greekPlaces),
+		Theta:    decimal.NewFromFloat(theta / 365).Round(greekPlaces),
+		Vega:     decimal.NewFromFloat(spot * density * sqrtYears / 100).Round(greekPlaces),
+		Rho:      decimal.NewFromFloat(rho / 100).Round(greekPlaces),
+	}
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func (q OptionQuote) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Symbol string     `json:"symbol"`
+		Strike string     `json:"strike"`
+		Type   OptionType `json:"type"`
+		Price  string     `json:"price"`
+		Delta  string     `json:"delta"`
+		Gamma  string     `json:"gamma"`
+		Theta  string     `json:"theta"`
+		Vega   string     `json:"vega"`
+		Rho    string     `json:"rho"`
+	}{
+		Symbol: q.Contract.Symbol(),
+		Strike: FormatDecimal(q.Contract.Strike, CashPlaces),
+		Type:   q.Contract.Type,
+		Price:  FormatDecimal(q.Price, CashPlaces),
+		Delta:  q.Delta.String(),
+		Gamma:  q.Gamma.String(),
+		Theta:  q.Theta.String(),
+		Vega:   q.Vega.String(),
+		Rho:    q.Rho.String(),
+	})
+}
+
+// OptionChain is the contracts of the underlying expiring on the same day, calls and puts, by strike.
+type OptionChain struct {
+	Underlying      string
+	UnderlyingPrice decimal.Decimal
+	Volatility      float64
+	Expiry          time.Time
+	//Expiries are all the listed expiries of the underlying, the nearest first.
+	Expiries []time.Time
+	Quotes   []OptionQuote
+}
+
+func (c OptionChain) MarshalJSON() ([]byte, error) {
+	expiries := make([]string, 0, len(c.Expiries))
+	for _, expiry := range c.Expiries {
+		expiries = append(expiries, expiry.Format("2006-01-02"))
+	}
+	quotes := c.Quotes
+	if quotes == nil {
+		quotes = []OptionQuote{}
+	}
+	return json.Marshal(struct {
+		Underlying      string        `json:"underlying"`
+		UnderlyingPrice string        `json:"underlying_price"`
+		Volatility      float64       `json:"volatility"`
+		Expiry          string        `json:"expiry"`
+		Expiries        []string      `json:"expiries"`
+		Multiplier      int           `json:"multiplier"`
+		Options         []OptionQuote `json:"options"`
+	}{
+		Underlying:      c.Underlying,
+		UnderlyingPrice: FormatDecimal(c.UnderlyingPrice, CashPlaces),
+		Volatility:      c.Volatility,
+		Expiry:          c.Expiry.Format("2006-01-02"),
+		Expiries:        expiries,
+		Multiplier:      OptionMultiplier,
+		Options:         quotes,
+	})
+}
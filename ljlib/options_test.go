@@ -0,0 +1,92 @@
+package ljlib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptionSymbol(t *testing.T) {
+	testCases := map[string]struct {
+		symbol           string
+		expectedOption   bool
+		expectedContract ljlib.OptionContract
+	}{
+		"it should parse a call": {
+			symbol:         "AAPL230818C00150000",
+			expectedOption: true,
+			expectedContract: ljlib.NewOptionContract("AAPL", time.Date(2023, 8, 18, 0, 0, 0, 0, time.UTC),
+				decimal.NewFromInt(150), ljlib.OptionTypeCall),
+		},
+		"it should parse a put with a fractional strike": {
+			symbol:         "V230915P00202500",
+			expectedOption: true,
+			expectedContract: ljlib.NewOptionContract("V", time.Date(2023, 9, 15, 0, 0, 0, 0, time.UTC),
+				decimal.RequireFromString("202.5"), ljlib.OptionTypePut),
+		},
+		"it should not parse a stock ticker": {
+			symbol: "AAPL",
+		},
+		"it should not parse an invalid expiry date": {
+			symbol: "AAPL231318C00150000",
+		},
+		"it should not parse an invalid option type": {
+			symbol: "AAPL230818X00150000",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			contract, ok := ljlib.ParseOptionSymbol(testCase.symbol)
+			require.Equal(t, testCase.expectedOption, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, testCase.expectedContract.Underlying, contract.Underlying)
+			assert.Equal(t, testCase.expectedContract.Expiry, contract.Expiry)
+			assert.True(t, testCase.expectedContract.Strike.Equal(contract.Strike))
+			assert.Equal(t, testCase.expectedContract.Type, contract.Type)
+			assert.Equal(t, testCase.symbol, contract.Symbol())
+		})
+	}
+}
+
+func TestPriceOption(t *testing.T) {
+	at := time.Date(2023, 1, 1, ljlib.OptionExpiryHour, 0, 0, 0, time.UTC)
+	expiry := at.AddDate(1, 0, 0)
+	spot := decimal.NewFromInt(100)
+	call := ljlib.NewOptionContract("AAPL", expiry, decimal.NewFromInt(100), ljlib.OptionTypeCall)
+	put := ljlib.NewOptionContract("AAPL", expiry, decimal.NewFromInt(100), ljlib.OptionTypePut)
+
+	//the textbook example: at the money, a year to expiry, 20% volatility and 5% rate
+	callQuote := ljlib.PriceOption(call, spot, 0.2, 0.05, at)
+	putQuote := ljlib.PriceOption(put, spot, 0.2, 0.05, at)
+	assert.Equal(t, "10.45", callQuote.Price.String())
+	assert.Equal(t, "5.57", putQuote.Price.String())
+	assert.Equal(t, "0.6368", callQuote.Delta.String())
+	assert.Equal(t, "-0.3632", putQuote.Delta.String())
+	assert.Equal(t, "0.0188", callQuote.Gamma.String())
+	assert.Equal(t, callQuote.Gamma, putQuote.Gamma)
+	assert.Equal(t, "0.3752", callQuote.Vega.String())
+	assert.True(t, callQuote.Theta.IsNegative())
+	assert.True(t, callQuote.Rho.IsPositive())
+	assert.True(t, putQuote.Rho.IsNegative())
+
+	//an expired contract is worth its intrinsic value
+	expired := ljlib.PriceOption(put, decimal.NewFromInt(90), 0.2, 0.05, expiry.Add(time.Hour))
+	assert.Equal(t, "10", expired.Price.String())
+	assert.Equal(t, "-1", expired.Delta.String())
+}
+
+func TestContractMultiplier(t *testing.T) {
+	assert.Equal(t, "100", ljlib.ContractMultiplier("AAPL230818C00150000").String())
+	assert.Equal(t, "1", ljlib.ContractMultiplier("AAPL").String())
+
+	option := trade(ljlib.TransactionTypeBuy, "2", "1.505", "1.3", 0)
+	option.Ticker = "AAPL230818C00150000"
+	assert.Equal(t, "301", option.Notional().String())
+	assert.Equal(t, "-302.3", option.CashFlow().String())
+}
@@ -0,0 +1,347 @@
+package ljlib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type OrderSide string
+
+const (
+	OrderSideBuy  OrderSide = "buy"
+	OrderSideSell OrderSide = "sell"
+)
+
+type OrderType string
+
+const (
+	OrderTypeMarket    OrderType = "market"
+	OrderTypeLimit     OrderType = "limit"
+	OrderTypeStop      OrderType = "stop"
+	OrderTypeStopLimit OrderType = "stop_limit"
+)
+
+type TimeInForce string
+
+const (
+	//TimeInForceDay orders expire at the end of the day they were placed.
+	TimeInForceDay TimeInForce = "DAY"
+	//TimeInForceGTC orders stay open until filled or cancelled.
+	TimeInForceGTC TimeInForce = "GTC"
+	//TimeInForceIOC orders are filled as much as possible immediately, and the rest is cancelled.
+	TimeInForceIOC TimeInForce = "IOC"
+	//TimeInForceFOK orders are either filled completely immediately, or cancelled.
+	TimeInForceFOK TimeInForce = "FOK"
+)
+
+type OrderStatus string
+
+const (
+	OrderStatusNew             OrderStatus = "new"
+	OrderStatusAccepted        OrderStatus = "accepted"
+	OrderStatusPartiallyFilled OrderStatus = "partially_filled"
+	OrderStatusFilled          OrderStatus = "filled"
+	OrderStatusCancelled       OrderStatus = "cancelled"
+	OrderStatusRejected        OrderStatus = "rejected"
+	OrderStatusExpired         OrderStatus = "expired"
+)
+
+// orderTransitions is the order state machine: the statuses reachable from each status.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusNew:             {OrderStatusAccepted, OrderStatusRejected},
+	OrderStatusAccepted:        {OrderStatusPartiallyFilled, OrderStatusFilled, OrderStatusCancelled, OrderStatusExpired},
+	OrderStatusPartiallyFilled: {OrderStatusPartiallyFilled, OrderStatusFilled, OrderStatusCancelled, OrderStatusExpired},
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether the order can no longer change.
+func (s OrderStatus) IsTerminal() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+type Order struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Ticker      string
+	Side        OrderSide
+	Type        OrderType
+	TimeInForce TimeInForce
+	Quantity    decimal.Decimal
+	//LimitPrice is set for limit and stop-limit orders, StopPrice for stop and stop-limit orders.
+	LimitPrice decimal.Decimal
+	StopPrice  decimal.Decimal
+	//Triggered is set once the market reaches the stop price of a stop or stop-limit order.
+	Triggered      bool
+	Status         OrderStatus
+	FilledQuantity decimal.Decimal
+	AveragePrice   decimal.Decimal
+	Fills          []Fill
+	RejectReason   string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	//EvaluatedAt is the moment up to which the market was already checked against the order.
+	EvaluatedAt time.Time
+	//Version is incremented with every change of the order, which is recorded in Events.
+	Version int64
+	//PriorityAt is the moment the order took its queue position: amendments raising the quantity
+	//or changing a price lose the position, while the ones lowering the quantity keep it.
+	PriorityAt time.Time
+	Events     []OrderEvent
+}
+
+// Record applies the change to the order, bumping its version and appending the event to its history.
+func (o *Order) Record(eventType OrderEventType, at time.Time, details map[string]string) {
+	o.Version++
+	o.UpdatedAt = at
+	o.Events = append(o.Events, OrderEvent{
+		Version:        o.Version,
+		Type:           eventType,
+		Status:         o.Status,
+		Quantity:       o.Quantity,
+		FilledQuantity: o.FilledQuantity,
+		LimitPrice:     o.LimitPrice,
+		StopPrice:      o.StopPrice,
+		Details:        details,
+		At:             at,
+	})
+}
+
+func (o Order) RemainingQuantity() decimal.Decimal {
+	return o.Quantity.Sub(o.FilledQuantity)
+}
+
+func (o Order) IsOpen() bool {
+	return o.Status == OrderStatusAccepted || o.Status == OrderStatusPartiallyFilled
+}
+
+func (o Order) MarshalJSON() ([]byte, error) {
+	fills := o.Fills
+	if fills == nil {
+		fills = []Fill{}
+	}
+	return json.Marshal(struct {
+		ID             string      `json:"id"`
+		Ticker         string      `json:"ticker"`
+		Side           OrderSide   `json:"side"`
+		Type           OrderType   `json:"type"`
+		TimeInForce    TimeInForce `json:"time_in_force"`
+		Quantity       string      `json:"quantity"`
+		LimitPrice     *string     `json:"limit_price,omitempty"`
+		StopPrice      *string     `json:"stop_price,omitempty"`
+		Triggered      bool        `json:"triggered,omitempty"`
+		Status         OrderStatus `json:"status"`
+		FilledQuantity string      `json:"filled_quantity"`
+		AveragePrice   *string     `json:"average_price,omitempty"`
+		Fills          []Fill      `json:"fills"`
+		RejectReason   string      `json:"reject_reason,omitempty"`
+		Version        int64       `json:"version"`
+		CreatedAt      string      `json:"created_at"`
+		UpdatedAt      string      `json:"updated_at"`
+		PriorityAt     string      `json:"priority_at"`
+	}{
+		ID:             o.ID.String(),
+		Ticker:         o.Ticker,
+		Side:           o.Side,
+		Type:           o.Type,
+		TimeInForce:    o.TimeInForce,
+		Quantity:       o.Quantity.String(),
+		LimitPrice:     optionalPrice(o.LimitPrice),
+		StopPrice:      optionalPrice(o.StopPrice),
+		Triggered:      o.Triggered,
+		Status:         o.Status,
+		FilledQuantity: o.FilledQuantity.String(),
+		AveragePrice:   optionalPrice(o.AveragePrice),
+		Fills:          fills,
+		RejectReason:   o.RejectReason,
+		Version:        o.Version,
+		CreatedAt:      o.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      o.UpdatedAt.Format(time.RFC3339),
+		PriorityAt:     o.PriorityAt.Format(time.RFC3339),
+	})
+}
+
+// OrderEventType is either one of the types below, or the status the order changed to.
+type OrderEventType string
+
+const (
+	OrderEventCreated   OrderEventType = "created"
+	OrderEventTriggered OrderEventType = "triggered"
+	OrderEventAmended   OrderEventType = "amended"
+)
+
+// OrderEvent is a revision of the order: what happened to it, and the state it was left in.
+type OrderEvent struct {
+	Version        int64
+	Type           OrderEventType
+	Status         OrderStatus
+	Quantity       decimal.Decimal
+	FilledQuantity decimal.Decimal
+	LimitPrice     decimal.Decimal
+	StopPrice      decimal.Decimal
+	Details        map[string]string
+	At             time.Time
+}
+
+func (e OrderEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version        int64             `json:"version"`
+		Type           OrderEventType    `json:"type"`
+		Status         OrderStatus       `json:"status"`
+		Quantity       string            `json:"quantity"`
+		FilledQuantity string            `json:"filled_quantity"`
+		LimitPrice     *string           `json:"limit_price,omitempty"`
+		StopPrice      *string           `json:"stop_price,omitempty"`
+		Details        map[string]string `json:"details,omitempty"`
+		At             string            `json:"at"`
+	}{
+		Version:        e.Version,
+		Type:           e.Type,
+		Status:         e.Status,
+		Quantity:       e.Quantity.String(),
+		FilledQuantity: e.FilledQuantity.String(),
+		LimitPrice:     optionalPrice(e.LimitPrice),
+		StopPrice:      optionalPrice(e.StopPrice),
+		Details:        e.Details,
+		At:             e.At.Format(time.RFC3339),
+	})
+}
+
+type Fill struct {
+	ID         uuid.UUID
+	Quantity   decimal.Decimal
+	Price      decimal.Decimal
+	Commission decimal.Decimal
+	ExecutedAt time.Time
+}
+
+func (f Fill) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID         string `json:"id"`
+		Quantity   string `json:"quantity"`
+		Price      string `json:"price"`
+		Commission string `json:"commission"`
+		ExecutedAt string `json:"executed_at"`
+	}{
+		ID:         f.ID.String(),
+		Quantity:   f.Quantity.String(),
+		Price:      FormatDecimal(f.Price, CashPlaces),
+		Commission: FormatDecimal(f.Commission, CashPlaces),
+		ExecutedAt: f.ExecutedAt.Format(time.RFC3339),
+	})
+}
+
+type TransactionType string
+
+const (
+	TransactionTypeBuy        TransactionType = "buy"
+	TransactionTypeSell       TransactionType = "sell"
+	TransactionTypeDeposit    TransactionType = "deposit"
+	TransactionTypeWithdrawal TransactionType = "withdrawal"
+	TransactionTypeInterest   TransactionType = "interest"
+	//TransactionTypeBorrowFee is charged daily for the shares borrowed for the short positions.
+	TransactionTypeBorrowFee TransactionType = "borrow_fee"
+)
+
+// Transaction is an entry of the user's ledger. Holdings and cash are the sum of all the transactions.
+type Transaction struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	//OrderID is empty for the transactions not originating from an order, e.g. the generated opening positions.
+	OrderID uuid.UUID
+	Type    TransactionType
+	//Ticker, Quantity and Price are set for trades, Amount for deposits, withdrawals, interest and fees.
+	Ticker     string
+	Quantity   decimal.Decimal
+	Price      decimal.Decimal
+	Amount     decimal.Decimal
+	Commission decimal.Decimal
+	//Currency of the cash flow, empty for BaseCurrency.
+	Currency   string
+	ExecutedAt time.Time
+	//SettlesAt is when the cash of a trade settles, zero for the transactions settled right away.
+	SettlesAt time.Time
+}
+
+// CashCurrency is the currency the transaction's cash flow is in.
+func (t Transaction) CashCurrency() string {
+	if len(t.Currency) == 0 {
+		return BaseCurrency
+	}
+	return t.Currency
+}
+
+// IsSettledAt tells whether the cash of the transaction has settled by the given time.
+func (t Transaction) IsSettledAt(at time.Time) bool {
+	return !t.SettlesAt.After(at)
+}
+
+// SettledSince is when the cash of the transaction became settled.
+func (t Transaction) SettledSince() time.Time {
+	if t.SettlesAt.IsZero() {
+		return t.ExecutedAt
+	}
+	return t.SettlesAt
+}
+
+func (t Transaction) IsTrade() bool {
+	return t.Type == TransactionTypeBuy || t.Type == TransactionTypeSell
+}
+
+// SignedQuantity is positive for buys and negative for sells, and zero for cash transactions.
+func (t Transaction) SignedQuantity() decimal.Decimal {
+	switch t.Type {
+	case TransactionTypeBuy:
+		return t.Quantity
+	case TransactionTypeSell:
+		return t.Quantity.Neg()
+	}
+	return decimal.Zero
+}
+
+// Notional is the cash amount of a trade in whole cents, excluding commission, with the option prices quoted
+// per share multiplied by the size of the contract. Fractions of a cent are rounded
+// in favour of the broker: up for the buys, which the user pays, and down for the sells, which the user is paid.
+func (t Transaction) Notional() decimal.Decimal {
+	notional := t.Quantity.Mul(t.Price).Mul(ContractMultiplier(t.Ticker))
+	switch t.Type {
+	case TransactionTypeBuy:
+		return notional.RoundCeil(CashPlaces)
+	case TransactionTypeSell:
+		return notional.RoundFloor(CashPlaces)
+	}
+	return decimal.Zero
+}
+
+// CashFlow is the change of the cash balance caused by the transaction, commission included.
+func (t Transaction) CashFlow() decimal.Decimal {
+	switch t.Type {
+	case TransactionTypeBuy:
+		return t.Notional().Add(t.Commission).Neg()
+	case TransactionTypeSell:
+		return t.Notional().Sub(t.Commission)
+	case TransactionTypeDeposit, TransactionTypeInterest:
+		return t.Amount.Sub(t.Commission)
+	case TransactionTypeWithdrawal, TransactionTypeBorrowFee:
+		return t.Amount.Add(t.Commission).Neg()
+	}
+	return decimal.Zero
+}
+
+func optionalPrice(price decimal.Decimal) *string {
+	if price.IsZero() {
+		return nil
+	}
+	formatted := FormatDecimal(price, CashPlaces)
+	return &formatted
+}
@@ -0,0 +1,112 @@
+package ljlib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type PlanStatus string
+
+const (
+	PlanStatusActive PlanStatus = "active"
+	PlanStatusPaused PlanStatus = "paused"
+)
+
+// Plan is a recurring investment of a fixed amount into a ticker, following a cron-like schedule.
+type Plan struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Ticker string
+	//Amount is the cash invested with every run, converted to a fractional quantity at the current price.
+	Amount   decimal.Decimal
+	Schedule string
+	Status   PlanStatus
+	//NextRunAt is the next scheduled run, which is not set while the plan is paused.
+	NextRunAt  time.Time
+	Executions []PlanExecution
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (p Plan) MarshalJSON() ([]byte, error) {
+	var nextRunAt *string
+	if !p.NextRunAt.IsZero() {
+		formatted := p.NextRunAt.Format(time.RFC3339)
+		nextRunAt = &formatted
+	}
+	return json.Marshal(struct {
+		ID        string     `json:"id"`
+		Ticker    string     `json:"ticker"`
+		Amount    string     `json:"amount"`
+		Schedule  string     `json:"schedule"`
+		Status    PlanStatus `json:"status"`
+		NextRunAt *string    `json:"next_run_at"`
+		CreatedAt string     `json:"created_at"`
+		UpdatedAt string     `json:"updated_at"`
+	}{
+		ID:        p.ID.String(),
+		Ticker:    p.Ticker,
+		Amount:    p.Amount.StringFixed(2),
+		Schedule:  p.Schedule,
+		Status:    p.Status,
+		NextRunAt: nextRunAt,
+		CreatedAt: p.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: p.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+type PlanExecutionStatus string
+
+const (
+	//PlanExecutionExecuted means the order was placed and accepted.
+	PlanExecutionExecuted PlanExecutionStatus = "executed"
+	PlanExecutionSkipped  PlanExecutionStatus = "skipped"
+	//PlanExecutionFailed means no order was placed, or the order was rejected by the risk checks.
+	PlanExecutionFailed PlanExecutionStatus = "failed"
+)
+
+// PlanExecution is a scheduled run of the plan, whether it ended up with an order or not.
+type PlanExecution struct {
+	ScheduledAt time.Time
+	ExecutedAt  time.Time
+	Status      PlanExecutionStatus
+	//OrderID, Quantity and Price are set when the order was placed.
+	OrderID     uuid.UUID
+	OrderStatus OrderStatus
+	Quantity    decimal.Decimal
+	Price       decimal.Decimal
+	Message     string
+}
+
+func (e PlanExecution) MarshalJSON() ([]byte, error) {
+	var orderID *string
+	var quantity *string
+	if e.OrderID != uuid.Nil {
+		formatted := e.OrderID.String()
+		orderID = &formatted
+		formattedQuantity := e.Quantity.String()
+		quantity = &formattedQuantity
+	}
+	return json.Marshal(struct {
+		ScheduledAt string              `json:"scheduled_at"`
+		ExecutedAt  string              `json:"executed_at"`
+		Status      PlanExecutionStatus `json:"status"`
+		OrderID     *string             `json:"order_id,omitempty"`
+		OrderStatus OrderStatus         `json:"order_status,omitempty"`
+		Quantity    *string             `json:"quantity,omitempty"`
+		Price       *string             `json:"price,omitempty"`
+		Message     string              `json:"message,omitempty"`
+	}{
+		ScheduledAt: e.ScheduledAt.Format(time.RFC3339),
+		ExecutedAt:  e.ExecutedAt.Format(time.RFC3339),
+		Status:      e.Status,
+		OrderID:     orderID,
+		OrderStatus: e.OrderStatus,
+		Quantity:    quantity,
+		Price:       optionalPrice(e.Price),
+		Message:     e.Message,
+	})
+}
@@ -0,0 +1,58 @@
+package ljlib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// TargetsBy is what the target weights are set for.
+type TargetsBy string
+
+const (
+	TargetsByTicker TargetsBy = "ticker"
+	TargetsBySector TargetsBy = "sector"
+)
+
+// Target is the weight of the ticker, or the sector, the portfolio is rebalanced to.
+type Target struct {
+	Name   string
+	Weight decimal.Decimal
+}
+
+func (t Target) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name   string `json:"name"`
+		Weight string `json:"weight"`
+	}{
+		Name:   t.Name,
+		Weight: t.Weight.String(),
+	})
+}
+
+// TargetAllocation is the user's target weights of the portfolio, either per ticker or per sector. The weights
+// add up to at most 1, and the rest of the portfolio is held in cash.
+type TargetAllocation struct {
+	UserID    uuid.UUID
+	By        TargetsBy
+	Targets   []Target
+	UpdatedAt time.Time
+}
+
+func (t TargetAllocation) MarshalJSON() ([]byte, error) {
+	targets := t.Targets
+	if targets == nil {
+		targets = []Target{}
+	}
+	return json.Marshal(struct {
+		By        TargetsBy `json:"by"`
+		Targets   []Target  `json:"targets"`
+		UpdatedAt string    `json:"updated_at"`
+	}{
+		By:        t.By,
+		Targets:   targets,
+		UpdatedAt: t.UpdatedAt.Format(time.RFC3339),
+	})
+}
@@ -1,25 +1,41 @@
 package littlejohn
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/iliyaisd/littlejohn/internal/api"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/idempotency"
+	"github.com/iliyaisd/littlejohn/internal/ratelimit"
 	"github.com/iliyaisd/littlejohn/ljlib"
 )
 
 //Router puts together all the API endpoints and wires them to handlers (controllers) and middlewares.
 type Router struct {
-	controllers Controllers
-	authorizer  Authorizer
+	controllers      Controllers
+	authorizer       Authorizer
+	rateLimiters     map[string]*ratelimit.Limiter
+	idempotencyStore *idempotency.Store
+	auditLogger      api.AuditLogger
 }
 
-func NewRouter(controllers Controllers, authorizer Authorizer) Router {
+func NewRouter(controllers Controllers, authorizer Authorizer, rateLimiters map[string]*ratelimit.Limiter,
+	idempotencyStore *idempotency.Store, auditLogger api.AuditLogger) Router {
 	return Router{
-		controllers: controllers,
-		authorizer:  authorizer,
+		controllers:      controllers,
+		authorizer:       authorizer,
+		rateLimiters:     rateLimiters,
+		idempotencyStore: idempotencyStore,
+		auditLogger:      auditLogger,
 	}
 }
 
@@ -27,40 +43,170 @@ func (r Router) PrepareHandler() http.Handler {
 	router := mux.NewRouter()
 	router.Use(r.jsonMiddleware)
 
+	publicRoutes := router.PathPrefix("").Subrouter()
+	publicRoutes.Use(r.rateLimit(RateLimitGroupPublic))
+	publicRoutes.Use(r.idempotent)
+
+	r.controllers.HandlePublicRoutes(publicRoutes)
+
 	restrictedRoutes := router.PathPrefix("").Subrouter()
 	restrictedRoutes.Use(r.authorizeRequest)
+	restrictedRoutes.Use(r.requireScope)
+
+	//the groups below are split off the restricted routes, so that each route is limited by exactly one group
+	analyticsRoutes := restrictedRoutes.PathPrefix("").Subrouter()
+	analyticsRoutes.Use(r.rateLimit(RateLimitGroupAnalytics))
+	analyticsRoutes.Use(r.idempotent)
+
+	limitedRoutes := restrictedRoutes.PathPrefix("").Subrouter()
+	limitedRoutes.Use(r.rateLimit(RateLimitGroupRestricted))
+	limitedRoutes.Use(r.idempotent)
+
+	adminRoutes := limitedRoutes.PathPrefix("/admin").Subrouter()
+	adminRoutes.Use(r.requireAdmin)
 
-	r.controllers.HandleRestrictedRoutes(restrictedRoutes)
+	r.controllers.HandleAdminRoutes(adminRoutes)
+	r.controllers.HandleAnalyticsRoutes(analyticsRoutes)
+	r.controllers.HandleRestrictedRoutes(limitedRoutes)
 
 	routerCORS := handlers.CORS(
-		handlers.AllowedHeaders([]string{"X-Requested-With", "Authorization", "Content-Type"}),
+		handlers.AllowedHeaders([]string{"X-Requested-With", "Authorization", "Content-Type", api.OTPHeader,
+			idempotencyKeyHeader, "If-Match"}),
+		handlers.ExposedHeaders([]string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+			idempotentReplayedHeader, "ETag"}),
 		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"}),
+		handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
 	)(router)
 
 	return routerCORS
 }
 
 type Controllers struct {
-	portfolioController api.PortfolioController
+	portfolioController  api.PortfolioController
+	userController       api.UserController
+	twoFactorController  api.TwoFactorController
+	authController       api.AuthController
+	auditController      api.AuditController
+	orderController      api.OrderController
+	accountController    api.AccountController
+	planController       api.PlanController
+	instrumentController api.InstrumentController
+	cashController       api.CashController
+	optionController     api.OptionController
+	marginController     api.MarginController
+	analyticsController  api.AnalyticsController
+	rebalanceController  api.RebalanceController
+	backtestController   api.BacktestController
+}
+
+func (c Controllers) HandlePublicRoutes(router *mux.Router) {
+	router.HandleFunc("/users", c.userController.CreateUser).Methods("POST")
 }
 
 func (c Controllers) HandleRestrictedRoutes(router *mux.Router) {
 	router.HandleFunc("/tickers", c.portfolioController.GetTickers).Methods("GET")
+	router.HandleFunc("/instruments/{ticker}", c.instrumentController.GetInstrument).Methods("GET")
+	router.HandleFunc("/benchmarks", c.instrumentController.GetBenchmarks).Methods("GET")
+
+	router.HandleFunc("/users/me", c.userController.GetCurrentUser).Methods("GET")
+	router.HandleFunc("/users/me", c.userController.UpdateCurrentUser).Methods("PATCH")
+	router.HandleFunc("/users/me", c.userController.DeleteCurrentUser).Methods("DELETE")
+
+	router.HandleFunc("/users/me/2fa", c.twoFactorController.Enroll).Methods("POST")
+	router.HandleFunc("/users/me/2fa/verify", c.twoFactorController.Verify).Methods("POST")
+	router.HandleFunc("/users/me/2fa", c.twoFactorController.Disable).Methods("DELETE")
+
+	router.HandleFunc("/auth/tokens", c.authController.IssueToken).Methods("POST")
+	router.HandleFunc("/auth/tokens/{id}", c.authController.RevokeToken).Methods("DELETE")
+
+	router.HandleFunc("/orders", c.orderController.PlaceOrder).Methods("POST")
+	router.HandleFunc("/orders", c.orderController.GetOrders).Methods("GET")
+	router.HandleFunc("/orders/{id}", c.orderController.GetOrder).Methods("GET")
+	router.HandleFunc("/orders/{id}", c.orderController.CancelOrder).Methods("DELETE")
+	router.HandleFunc("/orders/{id}", c.orderController.AmendOrder).Methods("PATCH")
+	router.HandleFunc("/orders/{id}/events", c.orderController.GetOrderEvents).Methods("GET")
+	router.HandleFunc("/account", c.accountController.GetAccount).Methods("GET")
+	router.HandleFunc("/margin/calls", c.marginController.GetMarginCalls).Methods("GET")
+	router.HandleFunc("/notifications", c.marginController.GetNotifications).Methods("GET")
+
+	router.HandleFunc("/cash", c.cashController.GetCash).Methods("GET")
+	router.HandleFunc("/cash/deposits", c.cashController.Deposit).Methods("POST")
+	router.HandleFunc("/cash/withdrawals", c.cashController.Withdraw).Methods("POST")
+
+	router.HandleFunc("/plans", c.planController.CreatePlan).Methods("POST")
+	router.HandleFunc("/plans", c.planController.GetPlans).Methods("GET")
+	router.HandleFunc("/plans/{id}", c.planController.GetPlan).Methods("GET")
+	router.HandleFunc("/plans/{id}", c.planController.UpdatePlan).Methods("PATCH")
+	router.HandleFunc("/plans/{id}", c.planController.DeletePlan).Methods("DELETE")
+	router.HandleFunc("/plans/{id}/pause", c.planController.PausePlan).Methods("POST")
+	router.HandleFunc("/plans/{id}/resume", c.planController.ResumePlan).Methods("POST")
+	router.HandleFunc("/plans/{id}/skip", c.planController.SkipPlanRun).Methods("POST")
+	router.HandleFunc("/plans/{id}/executions", c.planController.GetPlanExecutions).Methods("GET")
+
+	router.HandleFunc("/portfolio/targets", c.rebalanceController.SetTargets).Methods("PUT")
+	router.HandleFunc("/portfolio/targets", c.rebalanceController.GetTargets).Methods("GET")
+	router.HandleFunc("/portfolio/rebalance/preview", c.rebalanceController.PreviewRebalance).Methods("POST")
+
+	router.HandleFunc("/backtests", c.backtestController.ListBacktests).Methods("GET")
+	router.HandleFunc("/backtests/{id}", c.backtestController.GetBacktest).Methods("GET")
+}
+
+func (c Controllers) HandleAnalyticsRoutes(router *mux.Router) {
 	router.HandleFunc("/tickers/{ticker}/history", c.portfolioController.GetTickerHistory).Methods("GET")
+	router.HandleFunc("/tickers/{ticker}/options", c.optionController.GetOptionChain).Methods("GET")
+	router.HandleFunc("/tickers/{ticker}/risk", c.analyticsController.GetTickerRisk).Methods("GET")
+	router.HandleFunc("/tickers/{ticker}/compare", c.analyticsController.GetTickerComparison).Methods("GET")
+	router.HandleFunc("/portfolio/history", c.analyticsController.GetPortfolioHistory).Methods("GET")
+	router.HandleFunc("/portfolio/performance", c.analyticsController.GetPortfolioPerformance).Methods("GET")
+	router.HandleFunc("/portfolio/risk", c.analyticsController.GetPortfolioRisk).Methods("GET")
+	router.HandleFunc("/portfolio/compare", c.analyticsController.GetPortfolioComparison).Methods("GET")
+	router.HandleFunc("/portfolio/allocation", c.analyticsController.GetPortfolioAllocation).Methods("GET")
+	router.HandleFunc("/portfolio/correlations", c.analyticsController.GetPortfolioCorrelations).Methods("GET")
+	router.HandleFunc("/portfolio/projection", c.analyticsController.GetPortfolioProjection).Methods("POST")
+	router.HandleFunc("/backtests", c.backtestController.CreateBacktest).Methods("POST")
+}
+
+func (c Controllers) HandleAdminRoutes(router *mux.Router) {
+	router.HandleFunc("/users", c.userController.ListUsers).Methods("GET")
+	router.HandleFunc("/users/{id}/disable", c.userController.DisableUser).Methods("POST")
+	router.HandleFunc("/users/{id}/enable", c.userController.EnableUser).Methods("POST")
+
+	router.HandleFunc("/audit", c.auditController.GetEvents).Methods("GET")
+	router.HandleFunc("/audit/verify", c.auditController.VerifyChain).Methods("GET")
 }
 
 type Authorizer interface {
 	Authorize(r *http.Request) (*http.Request, error)
 }
 
+// authorizeRequest authenticates the requester. The failed authentications are limited by the failed logins group,
+// both per client IP and per username, so that once either runs out of tokens, guessing the passwords or
+// the one-time codes gets 429 without the credentials being checked, whether from a single IP or from many.
+// A username out of tokens is refused to its legitimate user as well, until the bucket refills.
 func (r Router) authorizeRequest(next http.Handler) http.Handler {
+	limiter, limited := r.rateLimiters[RateLimitGroupFailedLogins]
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		failuresKeys := failedLoginKeys(req)
+		if limited {
+			for _, key := range failuresKeys {
+				if result := limiter.Peek(key, time.Now()); !result.Allowed {
+					responseRateLimited(w, result)
+					return
+				}
+			}
+		}
+
 		var reqWithUser = req
 		var err error
 		reqWithUser, err = r.authorizer.Authorize(reqWithUser)
 		if err != nil {
 			log.Printf("Unauthorized access by URI [%s]: %s", req.RequestURI, err)
+			api.RecordFailedLogin(r.auditLogger, req, err)
+			if limited {
+				for _, key := range failuresKeys {
+					limiter.Allow(key, time.Now())
+				}
+			}
 			ljlib.ResponseHTTPForbidden(w, "forbidden")
 			return
 		}
@@ -68,6 +214,156 @@ func (r Router) authorizeRequest(next http.Handler) http.Handler {
 	})
 }
 
+// rateLimit limits requests of the group per authenticated user, or per client IP for unauthenticated requests.
+func (r Router) rateLimit(group string) mux.MiddlewareFunc {
+	limiter, ok := r.rateLimiters[group]
+	if !ok {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			result := limiter.Allow(group+":"+requesterKey(req), time.Now())
+			if !result.Allowed {
+				responseRateLimited(w, result)
+				return
+			}
+			setRateLimitHeaders(w, result)
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, result ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+}
+
+func responseRateLimited(w http.ResponseWriter, result ratelimit.Result) {
+	setRateLimitHeaders(w, result)
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	ljlib.ResponseHTTPTooManyRequests(w, "too many requests")
+}
+
+// requesterKey identifies the authenticated user, or the client IP for unauthenticated requests.
+func requesterKey(req *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(req.Context()); ok {
+		return "user:" + principal.User.ID.String()
+	}
+	return "ip:" + ljlib.ClientIP(req)
+}
+
+// failedLoginKeys identify the client IP, and the username of the basic credentials, if there are any.
+func failedLoginKeys(req *http.Request) []string {
+	keys := []string{"ip:" + ljlib.ClientIP(req)}
+	if username, _, ok := req.BasicAuth(); ok && len(username) > 0 {
+		keys = append(keys, "username:"+username)
+	}
+	return keys
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// idempotent replays the first response to the mutating requests retried with the same Idempotency-Key header.
+// Keys are scoped per requester, and reusing a key for a different request is rejected with 422.
+// Server errors are not stored, so that such requests can be retried.
+func (r Router) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if r.idempotencyStore == nil || len(key) == 0 || req.Method == http.MethodGet ||
+			req.Method == http.MethodHead || req.Method == http.MethodOptions {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			ljlib.ResponseHTTPBadRequest(w, "idempotency key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, api.MaxRequestBodyBytes))
+		if err != nil {
+			ljlib.ResponseHTTPBadRequest(w, "cannot read request body")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		storeKey := requesterKey(req) + ":" + key
+		outcome, response := r.idempotencyStore.Begin(storeKey, fingerprint, time.Now())
+		switch outcome {
+		case idempotency.OutcomeReplay:
+			for name, values := range response.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(response.StatusCode)
+			if _, err := w.Write(response.Body); err != nil {
+				log.Printf("cannot write HTTP response: %s", err)
+			}
+			return
+		case idempotency.OutcomeMismatch:
+			ljlib.ResponseHTTPUnprocessableEntity(w, "idempotency key was already used with a different request")
+			return
+		case idempotency.OutcomeInProgress:
+			ljlib.ResponseHTTPConflict(w, "a request with the same idempotency key is in progress")
+			return
+		}
+
+		recorder := idempotency.NewRecorder(w)
+		defer func() {
+			response := recorder.Response()
+			if response.StatusCode >= http.StatusInternalServerError {
+				r.idempotencyStore.Release(storeKey)
+				return
+			}
+			r.idempotencyStore.Complete(storeKey, response)
+		}()
+		next.ServeHTTP(recorder, req)
+	})
+}
+
+// requireScope lets the principal read with the read scope, and change anything with the write one.
+func (r Router) requireScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		scope := auth.ScopeWrite
+		if req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions {
+			scope = auth.ScopeRead
+		}
+		principal, ok := auth.PrincipalFrom(req.Context())
+		if !ok || !principal.HasScope(scope) {
+			log.Printf("Access without the [%s] scope by URI [%s]", scope, req.RequestURI)
+			ljlib.ResponseHTTPForbidden(w, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (r Router) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		principal, ok := auth.PrincipalFrom(req.Context())
+		if !ok || !principal.HasRole(ljlib.RoleAdmin) || !principal.HasScope(auth.ScopeAdmin) {
+			log.Printf("Non-admin access by URI [%s]", req.RequestURI)
+			ljlib.ResponseHTTPForbidden(w, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
 func (r Router) jsonMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
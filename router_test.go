@@ -0,0 +1,131 @@
+package littlejohn_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iliyaisd/littlejohn"
+	"github.com/iliyaisd/littlejohn/internal/analytics"
+	"github.com/iliyaisd/littlejohn/internal/auth"
+	"github.com/iliyaisd/littlejohn/internal/cash"
+	"github.com/iliyaisd/littlejohn/internal/ratelimit"
+	"github.com/iliyaisd/littlejohn/internal/risk"
+	"github.com/iliyaisd/littlejohn/internal/trading"
+	"github.com/iliyaisd/littlejohn/ljlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_FailedLoginsRateLimit(t *testing.T) {
+	testCases := map[string]struct {
+		//requests are the logins and the client IPs they're sent from, the last one being checked
+		requests     [][2]string
+		expectedCode int
+	}{
+		"it should limit the failed logins per client IP, before the credentials are checked": {
+			requests:     [][2]string{{"non-existent:", "10.0.0.1"}, {"other:", "10.0.0.1"}, {"johndoe:", "10.0.0.1"}},
+			expectedCode: http.StatusTooManyRequests,
+		},
+		"it should limit the failed logins per username, across the client IPs": {
+			requests:     [][2]string{{"johndoe:wrong", "10.0.0.1"}, {"johndoe:wrong", "10.0.0.2"}, {"johndoe:", "10.0.0.3"}},
+			expectedCode: http.StatusTooManyRequests,
+		},
+		"it should not limit other usernames from other client IPs": {
+			requests:     [][2]string{{"johndoe:wrong", "10.0.0.1"}, {"johndoe:wrong", "10.0.0.2"}, {"jennifer:", "10.0.0.3"}},
+			expectedCode: http.StatusOK,
+		},
+		"it should not limit the successful logins": {
+			requests:     [][2]string{{"johndoe:", "10.0.0.1"}, {"johndoe:", "10.0.0.1"}, {"johndoe:", "10.0.0.1"}},
+			expectedCode: http.StatusOK,
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			handler := newTestHandler(t, map[string]ratelimit.Limit{
+				littlejohn.RateLimitGroupFailedLogins: {Requests: 2, Period: time.Minute},
+			})
+			var code int
+			for _, request := range testCase.requests {
+				req := httptest.NewRequest(http.MethodGet, "/tickers", nil)
+				req.RemoteAddr = request[1] + ":1234"
+				req.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(request[0])))
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				code = w.Code
+			}
+			assert.Equal(t, testCase.expectedCode, code)
+		})
+	}
+}
+
+func TestRouter_Scopes(t *testing.T) {
+	testCases := map[string]struct {
+		role   string
+		scopes []string
+		method string
+		path   string
+	}{
+		"it should not let read without the read scope": {
+			role:   ljlib.RoleUser,
+			scopes: []string{auth.ScopeWrite},
+			method: http.MethodGet,
+			path:   "/tickers",
+		},
+		"it should not let change anything without the write scope": {
+			role:   ljlib.RoleUser,
+			scopes: []string{auth.ScopeRead},
+			method: http.MethodPost,
+			path:   "/orders",
+		},
+		"it should not let an admin in without the admin scope": {
+			role:   ljlib.RoleAdmin,
+			scopes: []string{auth.ScopeRead, auth.ScopeWrite},
+			method: http.MethodGet,
+			path:   "/admin/users",
+		},
+		"it should not let a user in with the admin scope but not the role": {
+			role:   ljlib.RoleUser,
+			scopes: []string{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin},
+			method: http.MethodGet,
+			path:   "/admin/users",
+		},
+	}
+	for testName, testCase := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			principal := auth.NewPrincipal(&ljlib.User{Username: "johndoe", Role: testCase.role}, auth.MethodToken, "key")
+			principal.Scopes = testCase.scopes
+			//the controllers are never called, as the requests are refused before them
+			handler := littlejohn.NewRouter(littlejohn.Controllers{}, mockAuthorizer{principal: principal}, nil, nil,
+				nil).PrepareHandler()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(testCase.method, testCase.path, nil))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+		})
+	}
+}
+
+func newTestHandler(t *testing.T, rateLimits map[string]ratelimit.Limit) http.Handler {
+	app, err := littlejohn.BuildApp(littlejohn.Config{
+		RateLimits:        rateLimits,
+		Simulator:         trading.DefaultSimulatorConfig(),
+		RiskLimits:        risk.DefaultLimits(),
+		IdempotencyKeyTTL: littlejohn.DefaultIdempotencyKeyTTL,
+		PlansRunInterval:  littlejohn.DefaultPlansRunInterval,
+		Cash:              cash.DefaultConfig(),
+		Analytics:         analytics.DefaultConfig(),
+	})
+	require.NoError(t, err)
+	return app.MainHandler
+}
+
+type mockAuthorizer struct {
+	principal auth.Principal
+}
+
+func (m mockAuthorizer) Authorize(r *http.Request) (*http.Request, error) {
+	return r.WithContext(auth.WithPrincipal(r.Context(), m.principal)), nil
+}
@@ -1,12 +1,15 @@
 package tests
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"testing"
 
+	"github.com/google/uuid"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -14,6 +17,7 @@ import (
 const (
 	tickersPath    = "http://localhost:8080/tickers"
 	historyPathTpl = "http://localhost:8080/tickers/%s/history"
+	ordersPath     = "http://localhost:8080/orders"
 )
 
 func TestPortfolio(t *testing.T) {
@@ -122,3 +126,35 @@ func TestHistoricalPrices(t *testing.T) {
 		})
 	}
 }
+
+func TestIdempotencyKey(t *testing.T) {
+	key := uuid.NewString()
+	placeOrder := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ordersPath, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("johndoe:")))
+		req.Header.Add("Idempotency-Key", key)
+
+		client := http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	body := `{"ticker":"GOOG","side":"buy","type":"market","quantity":"1"}`
+
+	resp := placeOrder(body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var order map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&order))
+
+	//the retry should get the same order, instead of placing another one
+	retryResp := placeOrder(body)
+	require.Equal(t, http.StatusCreated, retryResp.StatusCode)
+	assert.Equal(t, "true", retryResp.Header.Get("Idempotent-Replayed"))
+	var retryOrder map[string]interface{}
+	require.NoError(t, json.NewDecoder(retryResp.Body).Decode(&retryOrder))
+	assert.Equal(t, order["id"], retryOrder["id"])
+
+	mismatchResp := placeOrder(`{"ticker":"GOOG","side":"buy","type":"market","quantity":"2"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatchResp.StatusCode)
+}